@@ -1,11 +1,12 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
+	"time"
 
 	"docker-socket-proxy/internal/cli"
 	"docker-socket-proxy/internal/logging"
@@ -18,20 +19,74 @@ import (
 func main() {
 	paths := management.NewSocketPaths()
 	var srv *server.Server
+	var watchdogInterval time.Duration
+	var watchConfigs bool
+	var drainTimeout time.Duration
+	var otelEndpoint string
+	var enforceStoragePerms bool
+	var allowInsecureDir bool
+	var configDir string
+	var baseConfig string
+	var dockerTLSCA, dockerTLSCert, dockerTLSKey string
+	var onError string
+	var managementListen, managementTLSCert, managementTLSKey, managementTLSClientCA string
 
 	var rootCmd = &cobra.Command{
 		Use:   "docker-socket-proxy",
 		Short: "A proxy for Docker socket management",
 	}
 
-	rootCmd.PersistentFlags().String("output", "yaml", "Output format (text|json|yaml|silent)")
+	rootCmd.PersistentFlags().String("output", "yaml", "Output format (text|json|yaml|table|silent)")
+	rootCmd.PersistentFlags().StringVar(&paths.BasePath, "management-base-path", os.Getenv(management.BasePathEnv),
+		"Path prefix for management API routes, e.g. /dsp (env "+management.BasePathEnv+")")
+	var managementTokenFile string
+	rootCmd.PersistentFlags().StringVar(&paths.Token, "management-token", os.Getenv(management.TokenEnv),
+		"Bearer token the management API requires, the daemon accepts requests without one when empty (env "+management.TokenEnv+")")
+	rootCmd.PersistentFlags().StringVar(&managementTokenFile, "management-token-file", os.Getenv(management.TokenFileEnv),
+		"File to read the management token from, which keeps it out of the process list (env "+management.TokenFileEnv+")")
 
 	var daemonCmd = &cobra.Command{
 		Use:   "daemon",
 		Short: "Run the proxy server daemon",
 		Run: func(cmd *cobra.Command, args []string) {
-			var err error
-			srv, err = server.NewServer(paths.Management, paths.Docker, paths.SocketDir)
+			opts := []server.Option{
+				server.WithWatchdog(watchdogInterval),
+				server.WithConfigWatch(watchConfigs),
+				server.WithManagementBasePath(paths.BasePath),
+				server.WithManagementToken(paths.Token),
+				server.WithEnforcedStoragePermissions(enforceStoragePerms),
+				server.WithAllowInsecureDir(allowInsecureDir),
+				server.WithConfigDir(configDir),
+				server.WithBaseConfig(baseConfig),
+				server.WithOnError(onError),
+				server.WithDrainTimeout(drainTimeout),
+			}
+			dockerTLS, err := server.DockerTLSConfig(dockerTLSCA, dockerTLSCert, dockerTLSKey)
+			if err != nil {
+				slog.Error("Failed to load Docker TLS config", "error", err)
+				os.Exit(1)
+			}
+			if dockerTLS != nil {
+				opts = append(opts, server.WithDockerTLSConfig(dockerTLS))
+			}
+			managementTLS, err := server.ManagementTLSConfig(managementTLSCert, managementTLSKey, managementTLSClientCA)
+			if err != nil {
+				slog.Error("Failed to load management TLS config", "error", err)
+				os.Exit(1)
+			}
+			if managementListen != "" || managementTLS != nil {
+				opts = append(opts, server.WithManagementListen(managementListen, managementTLS))
+			}
+			if otelEndpoint != "" {
+				tp, err := server.NewOTLPTracerProvider(context.Background(), otelEndpoint)
+				if err != nil {
+					slog.Error("Failed to create trace exporter", "error", err)
+					os.Exit(1)
+				}
+				opts = append(opts, server.WithTracerProvider(tp))
+			}
+
+			srv, err = server.NewServer(paths.Management, paths.Docker, paths.SocketDir, opts...)
 			if err != nil {
 				slog.Error("Failed to create server", "error", err)
 				os.Exit(1)
@@ -43,7 +98,40 @@ func main() {
 	daemonCmd.Flags().StringVar(&paths.Management, "management-socket",
 		management.DefaultManagementSocketPath, "Path to the management socket")
 	daemonCmd.Flags().StringVar(&paths.Docker, "docker-socket",
-		management.DefaultDockerSocketPath, "Path to the Docker daemon socket")
+		management.DefaultDockerSocketPath, "Path to the Docker daemon socket, or a tcp://, http:// or https:// daemon address")
+	daemonCmd.Flags().StringVar(&dockerTLSCA, "docker-tls-ca", "",
+		"CA certificate to verify a TCP Docker daemon with (defaults to the system roots)")
+	daemonCmd.Flags().StringVar(&dockerTLSCert, "docker-tls-cert", "",
+		"Client certificate to present to a TCP Docker daemon")
+	daemonCmd.Flags().StringVar(&dockerTLSKey, "docker-tls-key", "",
+		"Client key to present to a TCP Docker daemon")
+	daemonCmd.Flags().StringVar(&managementListen, "management-listen", "",
+		"Serve the management API on a unix:// path or a tcp:// address instead of the management socket, tcp:// requires the TLS flags")
+	daemonCmd.Flags().StringVar(&managementTLSCert, "tls-cert", "",
+		"Certificate to serve a tcp:// management address with")
+	daemonCmd.Flags().StringVar(&managementTLSKey, "tls-key", "",
+		"Key to serve a tcp:// management address with")
+	daemonCmd.Flags().StringVar(&managementTLSClientCA, "tls-client-ca", "",
+		"CA that management clients' certificates must be signed by on a tcp:// management address")
+	daemonCmd.Flags().DurationVar(&watchdogInterval, "watchdog-interval", 0,
+		"How often to check for and recreate missing proxy socket files (0 disables the watchdog)")
+	daemonCmd.Flags().BoolVar(&watchConfigs, "watch-configs", false,
+		"Reload socket configs when their files in the storage directory change")
+	daemonCmd.Flags().DurationVar(&drainTimeout, "drain-timeout", server.DefaultDrainTimeout,
+		"How long a deleted socket's in-flight requests are given to finish before their connections are closed")
+	daemonCmd.Flags().StringVar(&otelEndpoint, "otel-endpoint", "",
+		"OTLP/HTTP endpoint URL to export request traces to, e.g. http://localhost:4318 (empty disables tracing)")
+
+	daemonCmd.Flags().StringVar(&onError, "on-error", server.OnErrorDeny,
+		"What to do with a request whose rules fail to evaluate, deny or allow")
+	daemonCmd.Flags().BoolVar(&enforceStoragePerms, "enforce-storage-permissions", false,
+		"Restrict the config storage directory to mode 0700 at startup instead of only warning")
+	daemonCmd.Flags().BoolVar(&allowInsecureDir, "allow-insecure-dir", false,
+		"Start with a world-writable or foreign-owned socket or config directory, warning instead of refusing")
+	daemonCmd.Flags().StringVar(&configDir, "config-dir", "",
+		"Directory to store socket configs in (defaults to the socket directory)")
+	daemonCmd.Flags().StringVar(&baseConfig, "base-config", "",
+		"Config file whose rules are evaluated ahead of every socket's own rules")
 
 	var socketCmd = &cobra.Command{
 		Use:   "socket",
@@ -60,6 +148,46 @@ func main() {
 
 	// Add config file flag to create command
 	createCmd.Flags().StringP("config", "c", "", "Path to socket configuration file (yaml)")
+	createCmd.Flags().String("name", "", "Name for the socket file, <name>.sock (defaults to a generated docker-proxy-<uuid>.sock)")
+	createCmd.Flags().String("config-from-env", "", "Read the socket configuration (yaml or json) from an environment variable (defaults to "+cli.DefaultConfigEnv+" when given without a value)")
+	createCmd.Flags().Lookup("config-from-env").NoOptDefVal = cli.DefaultConfigEnv
+	createCmd.Flags().Bool("print-curl", false, "Print the equivalent curl command for the management API instead of creating the socket")
+	createCmd.Flags().Bool("check-upstream", false, "Verify the daemon can reach the Docker socket before creating the proxy socket")
+
+	var updateCmd = &cobra.Command{
+		Use:   "update [socket-name]",
+		Short: "Replace the configuration of a Docker proxy socket without recreating it",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cli.RunUpdate(cmd, args, paths)
+		},
+	}
+
+	updateCmd.Flags().StringP("config", "c", "", "Path to socket configuration file (yaml)")
+	updateCmd.Flags().String("config-from-env", "", "Read the socket configuration (yaml or json) from an environment variable (defaults to "+cli.DefaultConfigEnv+" when given without a value)")
+	updateCmd.Flags().Lookup("config-from-env").NoOptDefVal = cli.DefaultConfigEnv
+
+	var renameCmd = &cobra.Command{
+		Use:   "rename [socket-name] [new-name]",
+		Short: "Change the name of a Docker proxy socket, keeping its socket file",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			cli.RunRename(cmd, args, paths)
+		},
+	}
+
+	renameCmd.Flags().Bool("move", false, "Also rename the socket file after the new name, clients have to reconnect to the new path")
+
+	var statsCmd = &cobra.Command{
+		Use:   "stats [socket-name]",
+		Short: "Show or reset the request counters of proxy sockets",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cli.RunStats(cmd, args, paths)
+		},
+	}
+
+	statsCmd.Flags().Bool("reset", false, "Zero the allow, deny, rewrite and rule hit counters of the socket, or of every socket when none is named")
 
 	var deleteCmd = &cobra.Command{
 		Use:   "delete [socket-path]",
@@ -78,6 +206,8 @@ func main() {
 		},
 	}
 
+	listCmd.Flags().Bool("detail", false, "Include rule counts, creation time and last decision time")
+
 	var describeCmd = &cobra.Command{
 		Use:   "describe [socket-name]",
 		Short: "Show configuration for a Docker proxy socket",
@@ -87,6 +217,66 @@ func main() {
 		},
 	}
 
+	describeCmd.Flags().Bool("stats", false, "Include per-rule hit counters")
+	describeCmd.Flags().Bool("effective", false, "Show the rules as the proxy evaluates them, with the propagation rule and in priority order")
+	describeCmd.Flags().String("format", "", "Render the config using a Go template, e.g. '{{range .Rules}}{{.Match.Path}}{{end}}'")
+
+	var testCmd = &cobra.Command{
+		Use:   "test [socket-name]",
+		Short: "Show how a socket's rules decide a request, without sending it to Docker",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cli.RunTest(cmd, args, paths)
+		},
+	}
+
+	testCmd.Flags().String("method", "GET", "Method of the request")
+	testCmd.Flags().String("path", "", "Path of the request, including the API version and any query, e.g. /v1.42/containers/create")
+	testCmd.Flags().String("body", "", "Body of the request, or @file to read it from a file")
+	testCmd.Flags().StringArray("header", nil, "Header of the request as 'Name: value', may be repeated")
+
+	var logsCmd = &cobra.Command{
+		Use:   "logs [socket-name]",
+		Short: "Show the recent allow and deny decisions of a proxy socket",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cli.RunLogs(cmd, args, paths)
+		},
+	}
+
+	logsCmd.Flags().BoolP("follow", "f", false, "Keep streaming new decisions as they are made")
+	logsCmd.Flags().Int("tail", -1, "Number of recent decisions to show, all that are kept by default")
+
+	var exportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Print the name and configuration of every proxy socket",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			cli.RunExport(cmd, paths)
+		},
+	}
+
+	var importCmd = &cobra.Command{
+		Use:   "import [export-file]",
+		Short: "Create the proxy sockets of an export",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cli.RunImport(cmd, args, paths)
+		},
+	}
+
+	importCmd.Flags().Bool("overwrite", false, "Replace the configuration of sockets that already exist instead of skipping them")
+
+	var validateCmd = &cobra.Command{
+		Use:   "validate",
+		Short: "Check a socket configuration file without creating a socket",
+		Run: func(cmd *cobra.Command, args []string) {
+			cli.RunValidate(cmd)
+		},
+	}
+
+	validateCmd.Flags().StringP("config", "c", "", "Path to socket configuration file (yaml or json)")
+
 	var cleanCmd = &cobra.Command{
 		Use:   "clean",
 		Short: "Remove all proxy sockets",
@@ -95,24 +285,45 @@ func main() {
 		},
 	}
 
-	socketCmd.AddCommand(createCmd, deleteCmd, listCmd, describeCmd, cleanCmd)
-	rootCmd.AddCommand(daemonCmd, socketCmd)
+	socketCmd.AddCommand(createCmd, updateCmd, renameCmd, deleteCmd, listCmd, describeCmd, testCmd, logsCmd, statsCmd, exportCmd, importCmd, validateCmd, cleanCmd)
+
+	var logLevelCmd = &cobra.Command{
+		Use:   "loglevel [level]",
+		Short: "Change the running daemon's log level (debug, info, warn, error)",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cli.RunLogLevel(cmd, args, paths)
+		},
+	}
+
+	var schemaCmd = &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of socket configuration files",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			cli.RunSchema(cmd)
+		},
+	}
+
+	rootCmd.AddCommand(daemonCmd, socketCmd, logLevelCmd, schemaCmd)
 
 	var logLevel string
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info",
 		"Log level (debug, info, warn, error)")
 
 	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
-		level := slog.LevelInfo
-		switch strings.ToLower(logLevel) {
-		case "debug":
-			level = slog.LevelDebug
-		case "warn":
-			level = slog.LevelWarn
-		case "error":
-			level = slog.LevelError
+		level, err := logging.ParseLevel(logLevel)
+		if err != nil {
+			level = slog.LevelInfo
 		}
 		logging.SetLevel(level)
+
+		token, err := management.LoadToken(paths.Token, managementTokenFile)
+		if err != nil {
+			slog.Error("Failed to load management token", "error", err)
+			os.Exit(1)
+		}
+		paths.Token = token
 	}
 
 	if err := rootCmd.Execute(); err != nil {
@@ -0,0 +1,219 @@
+// Package audit writes an append-only JSON lines record of proxy decisions
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"docker-socket-proxy/internal/logging"
+)
+
+// DefaultMaxSize is the size an audit log may grow to before it is rotated
+const DefaultMaxSize int64 = 100 << 20
+
+// queueSize bounds how many entries may wait to be written. Entries logged
+// while the queue is full are dropped rather than holding up the request.
+const queueSize = 1024
+
+// Entry is one line of the audit log
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Socket string    `json:"socket"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	// Rule is the index of the rule that decided the request, -1 when the
+	// socket's default action decided it, and ActionIndex the index of the
+	// deciding action in the rule's actions, -1 along with Rule
+	Rule        int `json:"rule"`
+	ActionIndex int `json:"action_index"`
+	// Base is set when Rule is a rule of the daemon's base config
+	Base   bool   `json:"base,omitempty"`
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+	// ReasonCode is the code of the deny action that decided, if it has one
+	ReasonCode string `json:"reason_code,omitempty"`
+	// Shadow is set on a deny the socket forwarded anyway in shadow mode
+	Shadow bool `json:"shadow,omitempty"`
+	Peer   Peer `json:"peer"`
+}
+
+// Peer identifies the client that sent a request, as far as it is known
+type Peer struct {
+	Addr string  `json:"addr,omitempty"`
+	UID  *uint32 `json:"uid,omitempty"`
+	GID  *uint32 `json:"gid,omitempty"`
+	PID  *int32  `json:"pid,omitempty"`
+}
+
+// Logger appends entries to an audit log file from a background goroutine,
+// rotating the file to <path>.1 once it would grow beyond its maximum size
+type Logger struct {
+	path    string
+	maxSize int64
+	entries chan Entry
+	done    chan struct{}
+	dropped atomic.Uint64
+
+	// closeMu guards closed, so that no entry is queued after Close
+	closeMu sync.RWMutex
+	closed  bool
+
+	// mu guards the file, which Close flushes while the writer may be busy
+	mu   sync.Mutex
+	file *os.File
+	buf  *bufio.Writer
+	size int64
+}
+
+// Open opens an audit log for appending, creating it if needed. A maxSize of
+// zero or less uses DefaultMaxSize.
+func Open(path string, maxSize int64) (*Logger, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	l := &Logger{
+		path:    path,
+		maxSize: maxSize,
+		entries: make(chan Entry, queueSize),
+		done:    make(chan struct{}),
+	}
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+
+	go l.run()
+	return l, nil
+}
+
+// Log queues an entry to be written. It never blocks, if the writer has
+// fallen behind the entry is dropped and counted.
+func (l *Logger) Log(entry Entry) {
+	l.closeMu.RLock()
+	defer l.closeMu.RUnlock()
+
+	if l.closed {
+		l.dropped.Add(1)
+		return
+	}
+	select {
+	case l.entries <- entry:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+// Dropped returns how many entries could not be queued
+func (l *Logger) Dropped() uint64 {
+	return l.dropped.Load()
+}
+
+// Close writes the queued entries and closes the file
+func (l *Logger) Close() error {
+	l.closeMu.Lock()
+	if l.closed {
+		l.closeMu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.entries)
+	l.closeMu.Unlock()
+
+	<-l.done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to flush audit log: %w", err)
+	}
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log: %w", err)
+	}
+	return nil
+}
+
+// run writes entries until the queue is closed, flushing whenever it is empty
+func (l *Logger) run() {
+	defer close(l.done)
+
+	for entry := range l.entries {
+		if err := l.write(entry); err != nil {
+			logging.GetLogger().Error("Failed to write audit log", "path", l.path, "error", err)
+		}
+		if len(l.entries) == 0 {
+			l.mu.Lock()
+			err := l.buf.Flush()
+			l.mu.Unlock()
+			if err != nil {
+				logging.GetLogger().Error("Failed to flush audit log", "path", l.path, "error", err)
+			}
+		}
+	}
+}
+
+// write appends one entry, rotating first if it would not fit
+func (l *Logger) write(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.buf.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// rotate moves the current file to <path>.1, replacing any previous one, and
+// starts a new file. Callers hold l.mu.
+func (l *Logger) rotate() error {
+	if err := l.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to flush audit log: %w", err)
+	}
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log: %w", err)
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		// Keep appending to the current file rather than losing entries
+		if openErr := l.openFile(); openErr != nil {
+			return fmt.Errorf("failed to rotate audit log: %w (reopen: %v)", err, openErr)
+		}
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	return l.openFile()
+}
+
+// openFile opens the log file for appending. Callers hold l.mu, or are Open.
+func (l *Logger) openFile() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		if closeErr := file.Close(); closeErr != nil {
+			return fmt.Errorf("failed to stat audit log: %w (close: %v)", err, closeErr)
+		}
+		return fmt.Errorf("failed to stat audit log: %w", err)
+	}
+
+	l.file = file
+	l.buf = bufio.NewWriter(file)
+	l.size = info.Size()
+	return nil
+}
@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readEntries returns the entries of an audit log file
+func readEntries(t *testing.T, path string) []Entry {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			t.Errorf("Failed to close audit log: %v", err)
+		}
+	}()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("line %q is not a JSON entry: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestLogger(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-audit-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	path := filepath.Join(tmpDir, "audit.jsonl")
+	uid := uint32(1000)
+
+	// An existing log is appended to
+	if err := os.WriteFile(path, []byte(`{"socket":"earlier.sock","rule":0,"action":"allow"}`+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Open(path, 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	l.Log(Entry{
+		Time:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Socket: "ci.sock",
+		Method: "POST",
+		Path:   "/v1.42/containers/create",
+		Rule:   2,
+		Action: "deny",
+		Reason: "privileged containers are not allowed",
+		Peer:   Peer{UID: &uid},
+	})
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Entries logged after Close are dropped, not a panic
+	l.Log(Entry{Socket: "late.sock"})
+	if l.Dropped() != 1 {
+		t.Errorf("Dropped() = %d, want 1", l.Dropped())
+	}
+
+	entries := readEntries(t, path)
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2: %+v", len(entries), entries)
+	}
+	got := entries[1]
+	if got.Socket != "ci.sock" || got.Rule != 2 || got.Action != "deny" || got.Reason != "privileged containers are not allowed" {
+		t.Errorf("entry = %+v", got)
+	}
+	if got.Peer.UID == nil || *got.Peer.UID != uid || got.Peer.GID != nil {
+		t.Errorf("peer = %+v, want only uid %d", got.Peer, uid)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("audit log mode = %04o, want 0600", perm)
+	}
+}
+
+func TestLoggerRotation(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-audit-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	path := filepath.Join(tmpDir, "audit.jsonl")
+	line, err := json.Marshal(Entry{Socket: "ci.sock", Rule: -1, Action: "allow"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Room for two entries per file
+	l, err := Open(path, int64(2*(len(line)+1)))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		l.Log(Entry{Socket: "ci.sock", Rule: -1, Action: "allow"})
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Five entries: two rotated away and overwritten, two in .1, one current
+	if n := len(readEntries(t, path)); n != 1 {
+		t.Errorf("current log has %d entries, want 1", n)
+	}
+	if n := len(readEntries(t, path+".1")); n != 2 {
+		t.Errorf("rotated log has %d entries, want 2", n)
+	}
+}
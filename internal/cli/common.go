@@ -3,11 +3,12 @@ package cli
 import (
 	"context"
 	"docker-socket-proxy/internal/cli/output"
+	"docker-socket-proxy/internal/management"
 	"fmt"
-	"io"
-	"net"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -15,30 +16,46 @@ import (
 // For testing - allows us to override os.Exit
 var osExit = os.Exit
 
-// createClient creates an HTTP client that connects to the management socket
-func createClient(managementSocket string) *http.Client {
-	return &http.Client{
-		Transport: &http.Transport{
-			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
-				return net.Dial("unix", managementSocket)
-			},
-		},
+// commandContext returns the context of a command, commands run outside of
+// cobra's Execute have none
+func commandContext(cmd *cobra.Command) context.Context {
+	if ctx := cmd.Context(); ctx != nil {
+		return ctx
 	}
+	return context.Background()
 }
 
-// handleResponse handles common response processing and error handling
-func handleResponse(resp *http.Response, expectedStatus int) ([]byte, error) {
-	if resp.StatusCode != expectedStatus {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
+// curlCommand renders a management API request as an equivalent curl command
+// against the management socket. When a management token is configured the
+// command reads it from the token environment variable rather than printing it.
+func curlCommand(req *http.Request, paths *management.SocketPaths, body []byte) string {
+	args := []string{"curl", "--unix-socket", shellQuote(paths.Management), "-X", req.Method}
+	if paths.Token != "" {
+		args = append(args, "-H", `"Authorization: Bearer $`+management.TokenEnv+`"`)
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response: %w", err)
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range req.Header.Values(name) {
+			args = append(args, "-H", shellQuote(name+": "+value))
+		}
+	}
+
+	if len(body) > 0 {
+		args = append(args, "--data-binary", shellQuote(string(body)))
+	}
+
+	args = append(args, shellQuote(req.URL.String()))
+	return strings.Join(args, " ")
+}
 
-	return body, nil
+// shellQuote quotes a string for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
 
 // exitWithError prints an error message and exits with code 1
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"strings"
 	"testing"
@@ -43,57 +42,6 @@ func captureOutput(f func()) string {
 	return buf.String()
 }
 
-// mockResponseBody creates a mock http.Response with the given status code and body
-func mockResponseBody(statusCode int, body string) *http.Response {
-	return &http.Response{
-		StatusCode: statusCode,
-		Body:       io.NopCloser(strings.NewReader(body)),
-		Header:     make(http.Header),
-	}
-}
-
-func TestHandleResponse(t *testing.T) {
-	tests := []struct {
-		name           string
-		statusCode     int
-		responseBody   string
-		expectedStatus int
-		wantErr        bool
-	}{
-		{
-			name:           "successful response",
-			statusCode:     http.StatusOK,
-			responseBody:   "success",
-			expectedStatus: http.StatusOK,
-			wantErr:        false,
-		},
-		{
-			name:           "error response",
-			statusCode:     http.StatusBadRequest,
-			responseBody:   "error message",
-			expectedStatus: http.StatusOK,
-			wantErr:        true,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			resp := mockResponseBody(tt.statusCode, tt.responseBody)
-
-			body, err := handleResponse(resp, tt.expectedStatus)
-
-			if (err != nil) != tt.wantErr {
-				t.Errorf("handleResponse() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-
-			if !tt.wantErr && string(body) != tt.responseBody {
-				t.Errorf("handleResponse() body = %v, want %v", string(body), tt.responseBody)
-			}
-		})
-	}
-}
-
 func TestExitWithError(t *testing.T) {
 	// Save the original os.Exit function
 	origExit := osExit
@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"docker-socket-proxy/internal/management"
+	"docker-socket-proxy/internal/management/client"
+	"docker-socket-proxy/internal/proxy/config"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Import outcomes of a single socket
+const (
+	importCreated = "created"
+	importUpdated = "updated"
+	importSkipped = "skipped"
+	importFailed  = "failed"
+)
+
+// ImportResult is the outcome of importing a single socket
+type ImportResult struct {
+	Name   string `json:"name" yaml:"name"`
+	Status string `json:"status" yaml:"status"`
+	Error  string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// exportDocument is the document written by socket export and read by
+// socket import
+type exportDocument struct {
+	Sockets []struct {
+		Name   string              `json:"name" yaml:"name"`
+		Config config.SocketConfig `json:"config" yaml:"config"`
+	} `json:"sockets" yaml:"sockets"`
+}
+
+// RunExport executes the socket export command, printing the name and
+// config of every socket so that they can be imported elsewhere
+func RunExport(cmd *cobra.Command, paths *management.SocketPaths) {
+	out := getOutput(cmd)
+	errOut := getErrorOutput(cmd)
+
+	response, err := client.New(paths).ExportSockets(commandContext(cmd))
+	if err != nil {
+		errOut.Error(fmt.Errorf("failed to export sockets: %v", err))
+		osExit(1)
+		return
+	}
+
+	// Text output is JSON too, as there is no plainer form to import
+	if out.Text() {
+		data, err := json.MarshalIndent(response.Response, "", "  ")
+		if err != nil {
+			exitWithError("Failed to print output: %v", err)
+			return
+		}
+		if err := out.Print(string(data)); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
+		return
+	}
+	if err := out.Print(response.Response); err != nil {
+		exitWithError("Failed to print output: %v", err)
+	}
+}
+
+// RunImport executes the socket import command, creating every socket of an
+// export. A socket that already exists is skipped, or with --overwrite has
+// its config replaced. Every socket is attempted and the command fails if
+// any of them could not be imported.
+func RunImport(cmd *cobra.Command, args []string, paths *management.SocketPaths) {
+	out := getOutput(cmd)
+	errOut := getErrorOutput(cmd)
+
+	if len(args) == 0 {
+		errOut.Error(fmt.Errorf("error: export file is required"))
+		osExit(1)
+		return
+	}
+
+	// Read the export, YAML parsing accepts JSON exports as well
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		errOut.Error(fmt.Errorf("error reading export: %v", err))
+		osExit(1)
+		return
+	}
+	var doc exportDocument
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		errOut.Error(fmt.Errorf("error parsing export: %v", err))
+		osExit(1)
+		return
+	}
+
+	overwrite, _ := cmd.Flags().GetBool("overwrite")
+	api := client.New(paths)
+
+	results := make([]ImportResult, 0, len(doc.Sockets))
+	failed := false
+	for _, socket := range doc.Sockets {
+		result := importSocket(commandContext(cmd), api, socket.Name, &socket.Config, overwrite)
+		if result.Status == importFailed {
+			failed = true
+		}
+		results = append(results, result)
+	}
+
+	// Print in requested format
+	if out.Text() {
+		for _, result := range results {
+			line := fmt.Sprintf("%s: %s", result.Name, result.Status)
+			if result.Error != "" {
+				line += ": " + result.Error
+			}
+			if err := out.Print(line); err != nil {
+				exitWithError("Failed to print output: %v", err)
+			}
+		}
+	} else {
+		if err := out.Print(results); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
+	}
+
+	if failed {
+		osExit(1)
+	}
+}
+
+// importSocket creates a single socket, replacing the config of an existing
+// one when overwrite is set
+func importSocket(ctx context.Context, api *client.Client, name string, socketConfig *config.SocketConfig, overwrite bool) ImportResult {
+	result := ImportResult{Name: name}
+	if name == "" {
+		result.Status = importFailed
+		result.Error = "socket name is missing"
+		return result
+	}
+
+	// A socket that already exists is refused with a conflict
+	_, err := api.CreateSocket(ctx, socketConfig, client.CreateOptions{Name: name})
+	var apiErr *client.Error
+	switch {
+	case err == nil:
+		result.Status = importCreated
+		return result
+	case !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict:
+		result.Status = importFailed
+		result.Error = err.Error()
+		return result
+	case !overwrite:
+		result.Status = importSkipped
+		return result
+	}
+
+	// The socket exists, replace its config
+	if _, err := api.UpdateSocket(ctx, name, socketConfig); err != nil {
+		result.Status = importFailed
+		result.Error = err.Error()
+		return result
+	}
+	result.Status = importUpdated
+	return result
+}
@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"fmt"
+
+	"docker-socket-proxy/internal/management"
+	"docker-socket-proxy/internal/management/client"
+
+	"github.com/spf13/cobra"
+)
+
+// RunLogLevel executes the loglevel command, changing the daemon's log level
+func RunLogLevel(cmd *cobra.Command, args []string, paths *management.SocketPaths) {
+	out := getOutput(cmd)
+	errOut := getErrorOutput(cmd)
+
+	if len(args) == 0 {
+		errOut.Error(fmt.Errorf("error: log level is required"))
+		osExit(1)
+	}
+
+	response, err := client.New(paths).SetLogLevel(commandContext(cmd), args[0])
+	if err != nil {
+		errOut.Error(fmt.Errorf("failed to set log level: %v", err))
+		osExit(1)
+	}
+
+	// Print in requested format
+	if out.Text() {
+		if err := out.Print(response.Response.Level); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
+	} else {
+		if err := out.Print(response.Response); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"docker-socket-proxy/internal/management"
+
+	"github.com/spf13/cobra"
+)
+
+func TestRunLogLevel(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	socketPath := filepath.Join(tmpDir, "test.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("Expected POST request, got %s", r.Method)
+		}
+		if r.URL.Path != "/loglevel" {
+			t.Errorf("Expected /loglevel path, got %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("level") != "debug" {
+			t.Errorf("Expected level=debug, got %q", r.URL.RawQuery)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		response := management.Response[management.LogLevelResponse]{
+			Status:   "success",
+			Response: management.LogLevelResponse{Level: "DEBUG"},
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("output", "text", "")
+	paths := &management.SocketPaths{
+		Management: socketPath,
+	}
+
+	output := captureOutput(func() {
+		RunLogLevel(cmd, []string{"debug"}, paths)
+	})
+
+	if !strings.Contains(output, "DEBUG") {
+		t.Errorf("Expected output to contain the new level, got: %s", output)
+	}
+}
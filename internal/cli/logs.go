@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"docker-socket-proxy/internal/cli/output"
+	"docker-socket-proxy/internal/management"
+	"docker-socket-proxy/internal/management/client"
+
+	"github.com/spf13/cobra"
+)
+
+// RunLogs executes the socket logs command, printing the recent allow and
+// deny decisions of a socket and, with --follow, new ones as they are made
+func RunLogs(cmd *cobra.Command, args []string, paths *management.SocketPaths) {
+	out := getOutput(cmd)
+	errOut := getErrorOutput(cmd)
+
+	tail, _ := cmd.Flags().GetInt("tail")
+	follow, _ := cmd.Flags().GetBool("follow")
+	stream, err := client.New(paths).Logs(commandContext(cmd), args[0], tail, follow)
+	if err != nil {
+		errOut.Error(fmt.Errorf("failed to get logs: %v", err))
+		osExit(1)
+		return
+	}
+	defer func() {
+		if err := stream.Close(); err != nil {
+			exitWithError("Failed to close response body: %v", err)
+		}
+	}()
+
+	// Print each entry as it arrives, the stream only ends when following
+	// stops on the daemon's side
+	decoder := json.NewDecoder(stream)
+	for {
+		var entry management.LogEntry
+		if err := decoder.Decode(&entry); err != nil {
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			errOut.Error(fmt.Errorf("failed to read logs: %v", err))
+			osExit(1)
+			return
+		}
+
+		if err := printLogEntry(cmd, out, entry); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
+	}
+}
+
+// printLogEntry prints a decision in the requested format
+func printLogEntry(cmd *cobra.Command, out *output.Output, entry management.LogEntry) error {
+	switch format, _ := cmd.Flags().GetString("output"); format {
+	case "text", "table":
+		line := fmt.Sprintf("%s %-5s %s %s", entry.Time.Format(time.RFC3339), strings.ToUpper(entry.Decision), entry.Method, entry.Path)
+		if entry.Reason != "" {
+			line += ": " + entry.Reason
+		}
+		return out.Print(line)
+	case "yaml":
+		// Print each entry as a list item, so the stream is a single YAML list
+		return out.Print([]management.LogEntry{entry})
+	default:
+		return out.Print(entry)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"text/tabwriter"
 
 	"gopkg.in/yaml.v3"
 )
@@ -16,6 +17,7 @@ const (
 	FormatText   Format = "text"
 	FormatJSON   Format = "json"
 	FormatYAML   Format = "yaml"
+	FormatTable  Format = "table"
 	FormatSilent Format = "silent"
 )
 
@@ -42,13 +44,41 @@ func (o *Output) Print(data any) error {
 		return json.NewEncoder(o.writer).Encode(data)
 	case FormatYAML:
 		return yaml.NewEncoder(o.writer).Encode(data)
-	case FormatText:
+	case FormatText, FormatTable:
 		return o.PrintText(data.(string))
 	default:
 		return fmt.Errorf("unsupported output format: %s", o.format)
 	}
 }
 
+// Text reports whether the output is meant to be read rather than parsed.
+// Commands without a tabular form print the table format as text.
+func (o *Output) Text() bool {
+	return o.format == FormatText || o.format == FormatTable
+}
+
+// Table reports whether rows should be printed as aligned columns
+func (o *Output) Table() bool {
+	return o.format == FormatTable
+}
+
+// PrintTable prints rows as aligned columns under a header
+func (o *Output) PrintTable(header []string, rows [][]string) error {
+	if o.format == FormatSilent {
+		return nil
+	}
+	tw := tabwriter.NewWriter(o.writer, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, strings.Join(header, "\t")); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if _, err := fmt.Fprintln(tw, strings.Join(row, "\t")); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
 // Error prints error messages
 func (o *Output) Error(err error) {
 	if o.format == FormatSilent {
@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"encoding/json"
+
+	"docker-socket-proxy/internal/proxy/config"
+
+	"github.com/spf13/cobra"
+)
+
+// RunSchema executes the schema command, printing the JSON Schema of socket
+// config files. It is always printed as JSON, as editors and validators
+// expect a schema to be.
+func RunSchema(cmd *cobra.Command) {
+	out := getOutput(cmd)
+
+	data, err := json.MarshalIndent(config.Schema(), "", "  ")
+	if err != nil {
+		exitWithError("Failed to encode schema: %v", err)
+		return
+	}
+	if err := out.PrintText(string(data)); err != nil {
+		exitWithError("Failed to print output: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"encoding/json"
+	"testing"
+
+	"docker-socket-proxy/internal/proxy/config"
+
+	"github.com/spf13/cobra"
+)
+
+func TestRunSchema(t *testing.T) {
+	// The schema is JSON whatever the output format
+	for _, format := range []string{"yaml", "json", "text"} {
+		t.Run(format, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			cmd.Flags().String("output", format, "")
+
+			output := captureOutput(func() {
+				RunSchema(cmd)
+			})
+
+			var schema map[string]any
+			if err := json.Unmarshal([]byte(output), &schema); err != nil {
+				t.Fatalf("schema is not JSON: %v\n%s", err, output)
+			}
+			if schema["$schema"] != config.SchemaDialect {
+				t.Errorf("$schema = %v, want %s", schema["$schema"], config.SchemaDialect)
+			}
+			if _, ok := schema["$defs"].(map[string]any)["Action"]; !ok {
+				t.Errorf("schema has no Action definition")
+			}
+		})
+	}
+}
@@ -1,77 +1,154 @@
 package cli
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
 
 	"docker-socket-proxy/internal/management"
+	"docker-socket-proxy/internal/management/client"
 	"docker-socket-proxy/internal/proxy/config"
 
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
 )
 
+// DefaultConfigEnv is the environment variable read by create --config-from-env
+const DefaultConfigEnv = "DSP_SOCKET_CONFIG"
+
 // RunCreate executes the socket create command
 func RunCreate(cmd *cobra.Command, paths *management.SocketPaths) {
 	out := getOutput(cmd)
 	errOut := getErrorOutput(cmd)
 
-	configPath, _ := cmd.Flags().GetString("config")
+	socketConfig, err := loadSocketConfig(cmd)
+	if err != nil {
+		errOut.Error(err)
+		osExit(1)
+	}
 
-	var socketConfig *config.SocketConfig
-	if configPath != "" {
-		var err error
-		socketConfig, err = config.LoadSocketConfig(configPath)
+	// Pass the requested name and upstream check with the request
+	opts := client.CreateOptions{}
+	opts.Name, _ = cmd.Flags().GetString("name")
+	opts.CheckUpstream, _ = cmd.Flags().GetBool("check-upstream")
+	api := client.New(paths)
+
+	// Print the equivalent API call instead of sending it
+	if printCurl, _ := cmd.Flags().GetBool("print-curl"); printCurl {
+		req, err := api.CreateSocketRequest(commandContext(cmd), socketConfig, opts)
 		if err != nil {
-			errOut.Error(fmt.Errorf("error loading configuration: %v", err))
+			errOut.Error(err)
 			osExit(1)
+			return
 		}
-	}
-
-	// Encode the config as JSON
-	var body io.Reader
-	if socketConfig != nil {
-		configJSON, err := json.Marshal(socketConfig)
+		body, err := requestBody(req)
 		if err != nil {
-			errOut.Error(fmt.Errorf("error encoding configuration: %v", err))
+			errOut.Error(err)
 			osExit(1)
+			return
+		}
+		if _, err := fmt.Fprintln(out.Writer(), curlCommand(req, paths, body)); err != nil {
+			exitWithError("Failed to print output: %v", err)
 		}
-		body = bytes.NewReader(configJSON)
+		return
 	}
 
-	// Create the client
-	client := createClient(paths.Management)
+	response, err := api.CreateSocket(commandContext(cmd), socketConfig, opts)
+	if err != nil {
+		errOut.Error(fmt.Errorf("failed to create socket: %v", err))
+		osExit(1)
+	}
 
-	// Send the request
-	resp, err := client.Post("http://localhost/socket/create", "application/json", body)
+	// Print in requested format
+	if out.Text() {
+		if err := out.Print(response.Response.Socket); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
+	} else {
+		if err := out.Print(response.Response); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
+	}
+}
+
+// requestBody returns a copy of the body of a request that has not been sent
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.GetBody == nil {
+		return nil, nil
+	}
+	body, err := req.GetBody()
 	if err != nil {
-		exitWithError("Failed to create socket: %v", err)
+		return nil, fmt.Errorf("error reading request body: %v", err)
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			exitWithError("Failed to close response body: %v", err)
+	return io.ReadAll(body)
+}
+
+// loadSocketConfig loads the socket configuration given by the --config or
+// --config-from-env flags, returning nil when neither is set
+func loadSocketConfig(cmd *cobra.Command) (*config.SocketConfig, error) {
+	configPath, _ := cmd.Flags().GetString("config")
+	configEnv, _ := cmd.Flags().GetString("config-from-env")
+
+	switch {
+	case configPath != "" && configEnv != "":
+		return nil, fmt.Errorf("--config and --config-from-env cannot be used together")
+	case configEnv != "":
+		data, ok := os.LookupEnv(configEnv)
+		if !ok || strings.TrimSpace(data) == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", configEnv)
 		}
-	}()
+		socketConfig, err := config.ParseSocketConfig([]byte(data))
+		if err != nil {
+			return nil, fmt.Errorf("error loading configuration from %s: %v", configEnv, err)
+		}
+		return socketConfig, nil
+	case configPath != "":
+		socketConfig, err := config.LoadSocketConfig(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("error loading configuration: %v", err)
+		}
+		return socketConfig, nil
+	}
+
+	return nil, nil
+}
+
+// RunUpdate executes the socket update command, replacing the configuration
+// of an existing socket without recreating it
+func RunUpdate(cmd *cobra.Command, args []string, paths *management.SocketPaths) {
+	out := getOutput(cmd)
+	errOut := getErrorOutput(cmd)
+
+	if len(args) == 0 {
+		errOut.Error(fmt.Errorf("error: socket name is required"))
+		osExit(1)
+	}
 
-	// Handle the response
-	responseBody, err := handleResponse(resp, http.StatusOK)
+	socketConfig, err := loadSocketConfig(cmd)
 	if err != nil {
-		errOut.Error(fmt.Errorf("server error: %v", err))
+		errOut.Error(err)
+		osExit(1)
+	}
+	if socketConfig == nil {
+		errOut.Error(fmt.Errorf("error: --config or --config-from-env is required"))
 		osExit(1)
 	}
 
-	// Parse the JSON response
-	var response management.Response[management.CreateResponse]
-	if err := json.Unmarshal(responseBody, &response); err != nil {
-		errOut.Error(fmt.Errorf("failed to parse response: %v", err))
+	response, err := client.New(paths).UpdateSocket(commandContext(cmd), args[0], socketConfig)
+	if err != nil {
+		errOut.Error(fmt.Errorf("failed to update socket: %v", err))
 		osExit(1)
 	}
 
 	// Print in requested format
-	if format, _ := cmd.Flags().GetString("output"); format == "text" {
+	if out.Text() {
 		if err := out.Print(response.Response.Socket); err != nil {
 			exitWithError("Failed to print output: %v", err)
 		}
@@ -82,64 +159,53 @@ func RunCreate(cmd *cobra.Command, paths *management.SocketPaths) {
 	}
 }
 
-// RunDelete executes the socket delete command
-func RunDelete(cmd *cobra.Command, args []string, paths *management.SocketPaths) {
+// RunRename executes the socket rename command
+func RunRename(cmd *cobra.Command, args []string, paths *management.SocketPaths) {
 	out := getOutput(cmd)
 	errOut := getErrorOutput(cmd)
 
-	if len(args) == 0 {
-		errOut.Error(fmt.Errorf("error: socket path is required"))
+	if len(args) != 2 {
+		errOut.Error(fmt.Errorf("error: the socket and its new name are required"))
 		osExit(1)
 	}
+	move, _ := cmd.Flags().GetBool("move")
 
-	socketPath := args[0]
-
-	// Create the client
-	client := createClient(paths.Management)
-
-	// Create the delete request
-	req, err := http.NewRequest("DELETE", "http://localhost/socket/delete", nil)
+	response, err := client.New(paths).RenameSocket(commandContext(cmd), args[0], args[1], move)
 	if err != nil {
-		errOut.Error(fmt.Errorf("error creating request: %v", err))
+		errOut.Error(fmt.Errorf("failed to rename socket: %v", err))
 		osExit(1)
 	}
 
-	// Add the socket path as a query parameter
-	q := req.URL.Query()
-	q.Add("socket", socketPath)
-	req.URL.RawQuery = q.Encode()
+	// Print in requested format
+	if out.Text() {
+		if err := out.Print(response.Response.Socket); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
+	} else {
+		if err := out.Print(response.Response); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
+	}
+}
 
-	// Add the Socket-Path header for backward compatibility
-	req.Header.Set("Socket-Path", socketPath)
+// RunDelete executes the socket delete command
+func RunDelete(cmd *cobra.Command, args []string, paths *management.SocketPaths) {
+	out := getOutput(cmd)
+	errOut := getErrorOutput(cmd)
 
-	// Send the request
-	resp, err := client.Do(req)
-	if err != nil {
-		errOut.Error(fmt.Errorf("error sending request: %v", err))
+	if len(args) == 0 {
+		errOut.Error(fmt.Errorf("error: socket path is required"))
 		osExit(1)
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			exitWithError("Failed to close response body: %v", err)
-		}
-	}()
 
-	// Handle the response
-	responseBody, err := handleResponse(resp, http.StatusOK)
+	response, err := client.New(paths).DeleteSocket(commandContext(cmd), args[0])
 	if err != nil {
 		errOut.Error(fmt.Errorf("failed to delete socket: %v", err))
 		osExit(1)
 	}
 
-	// Parse the JSON response
-	var response management.Response[management.DeleteResponse]
-	if err := json.Unmarshal(responseBody, &response); err != nil {
-		errOut.Error(fmt.Errorf("failed to parse response: %v", err))
-		osExit(1)
-	}
-
 	// Print in requested format
-	if format, _ := cmd.Flags().GetString("output"); format == "text" {
+	if out.Text() {
 		if err := out.Print(response.Response.Message); err != nil {
 			exitWithError("Failed to print output: %v", err)
 		}
@@ -160,55 +226,34 @@ func RunDescribe(cmd *cobra.Command, args []string, paths *management.SocketPath
 		osExit(1)
 	}
 
-	socketName := args[0]
-
-	// Create the client
-	client := createClient(paths.Management)
-
-	// Create the describe request
-	req, err := http.NewRequest("GET", "http://localhost/socket/describe", nil)
-	if err != nil {
-		errOut.Error(fmt.Errorf("error creating request: %v", err))
-		osExit(1)
-	}
-
-	// Add the socket name as a query parameter
-	q := req.URL.Query()
-	q.Add("socket", socketName)
-	req.URL.RawQuery = q.Encode()
-
-	// Send the request
-	resp, err := client.Do(req)
-	if err != nil {
-		errOut.Error(fmt.Errorf("error sending request: %v", err))
-		osExit(1)
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			exitWithError("Failed to close response body: %v", err)
-		}
-	}()
-
-	// Handle the response
-	responseBody, err := handleResponse(resp, http.StatusOK)
+	stats, _ := cmd.Flags().GetBool("stats")
+	effective, _ := cmd.Flags().GetBool("effective")
+	response, err := client.New(paths).DescribeSocket(commandContext(cmd), args[0], client.DescribeOptions{Stats: stats, Effective: effective})
 	if err != nil {
 		errOut.Error(fmt.Errorf("failed to describe socket: %v", err))
 		osExit(1)
 	}
 
-	// Parse the JSON response
-	var response management.Response[management.DescribeResponse]
-	if err := json.Unmarshal(responseBody, &response); err != nil {
-		errOut.Error(fmt.Errorf("error parsing response: %v", err))
-		osExit(1)
+	// A go-template takes precedence over the output format
+	if tmpl, _ := cmd.Flags().GetString("format"); tmpl != "" {
+		if err := renderConfigTemplate(out.Writer(), tmpl, response.Response.Config); err != nil {
+			errOut.Error(err)
+			osExit(1)
+		}
+		return
 	}
 
 	// Print in requested format
-	if format, _ := cmd.Flags().GetString("output"); format == "text" {
+	if out.Text() {
 		if err := yaml.NewEncoder(out.Writer()).Encode(response.Response.Config); err != nil {
 			errOut.Error(fmt.Errorf("failed to encode config: %v", err))
 			osExit(1)
 		}
+		if stats && response.Response.Stats != nil {
+			if err := printRuleStats(out.Writer(), response.Response.Stats); err != nil {
+				exitWithError("Failed to print output: %v", err)
+			}
+		}
 	} else {
 		if err := out.Print(response.Response); err != nil {
 			exitWithError("Failed to print output: %v", err)
@@ -216,54 +261,88 @@ func RunDescribe(cmd *cobra.Command, args []string, paths *management.SocketPath
 	}
 }
 
-// RunList executes the list command
-func RunList(cmd *cobra.Command, paths *management.SocketPaths) {
-	out := getOutput(cmd)
-	errOut := getErrorOutput(cmd)
+// printRuleStats renders the per-rule hit counters of a socket as a table
+func printRuleStats(w io.Writer, stats *management.SocketStats) error {
+	if _, err := fmt.Fprintf(w, "\nAllowed: %d  Denied: %d  Shadow denied: %d  Rewritten: %d\n", stats.Allowed, stats.Denied, stats.ShadowDenied, stats.Rewritten); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "\nRule hits since %s\n", stats.Since.Format(time.RFC3339)); err != nil {
+		return err
+	}
 
-	// Create the client
-	client := createClient(paths.Management)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "RULE\tMETHOD\tPATH\tHITS"); err != nil {
+		return err
+	}
+	for _, rule := range stats.Rules {
+		method := rule.Method
+		if method == "" {
+			method = "*"
+		}
+		if _, err := fmt.Fprintf(tw, "%d\t%s\t%s\t%d\n", rule.Index, method, rule.Path, rule.Hits); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
 
-	// Create the list request
-	req, err := http.NewRequest("GET", "http://localhost/socket/list", nil)
+// renderConfigTemplate renders a socket config through a Go text/template,
+// using the SocketConfig structure as the data context
+func renderConfigTemplate(w io.Writer, format string, rawConfig any) error {
+	tmpl, err := template.New("format").Parse(format)
 	if err != nil {
-		errOut.Error(fmt.Errorf("error creating request: %v", err))
-		osExit(1)
+		return fmt.Errorf("invalid format template: %v", err)
 	}
 
-	// Send the request
-	resp, err := client.Do(req)
+	// Round-trip through JSON so the template sees the typed config
+	data, err := json.Marshal(rawConfig)
 	if err != nil {
-		errOut.Error(fmt.Errorf("error sending request: %v", err))
-		osExit(1)
+		return fmt.Errorf("failed to encode config: %v", err)
+	}
+	var socketConfig config.SocketConfig
+	if err := json.Unmarshal(data, &socketConfig); err != nil {
+		return fmt.Errorf("failed to decode config: %v", err)
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			exitWithError("Failed to close response body: %v", err)
-		}
-	}()
 
-	// Handle the response
-	responseBody, err := handleResponse(resp, http.StatusOK)
-	if err != nil {
-		errOut.Error(fmt.Errorf("failed to list sockets: %v", err))
-		osExit(1)
+	if err := tmpl.Execute(w, socketConfig); err != nil {
+		return fmt.Errorf("failed to execute format template: %v", err)
 	}
+	_, err = fmt.Fprintln(w)
+	return err
+}
+
+// RunList executes the list command
+func RunList(cmd *cobra.Command, paths *management.SocketPaths) {
+	out := getOutput(cmd)
+	errOut := getErrorOutput(cmd)
 
-	// Parse the response
-	var response management.Response[management.ListResponse]
-	if err := json.Unmarshal(responseBody, &response); err != nil {
-		errOut.Error(fmt.Errorf("error parsing response: %v", err))
+	detail, _ := cmd.Flags().GetBool("detail")
+	response, err := client.New(paths).ListSockets(commandContext(cmd), detail)
+	if err != nil {
+		errOut.Error(fmt.Errorf("failed to list sockets: %v", err))
 		osExit(1)
 	}
 
 	// Print in requested format
-	if format, _ := cmd.Flags().GetString("output"); format == "text" {
+	format, _ := cmd.Flags().GetString("output")
+	if out.Text() && detail {
+		if err := printSocketDetails(out.Writer(), response.Response.Details); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
+	} else if out.Table() {
+		if err := out.PrintTable([]string{"NAME", "PATH", "RULES"}, socketRows(socketList(response.Response))); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
+	} else if out.Text() {
 		for _, socket := range response.Response.Sockets {
 			if err := out.Print(socket); err != nil {
 				exitWithError("Failed to print output: %v", err)
 			}
 		}
+	} else if format == "json" {
+		if err := out.Print(socketList(response.Response)); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
 	} else {
 		if err := out.Print(response.Response); err != nil {
 			exitWithError("Failed to print output: %v", err)
@@ -271,36 +350,70 @@ func RunList(cmd *cobra.Command, paths *management.SocketPaths) {
 	}
 }
 
-// RunClean executes the clean command
-func RunClean(cmd *cobra.Command, paths *management.SocketPaths) {
-	out := getOutput(cmd)
-	errOut := getErrorOutput(cmd)
+// socketList converts a list response into the stable machine-readable schema.
+// Daemons that predate the schema only report names, so the other fields are
+// left empty for them.
+func socketList(resp management.ListResponse) management.SocketList {
+	list := management.SocketList{
+		SchemaVersion: management.ListSchemaVersion,
+		Sockets:       make([]management.SocketEntry, 0, len(resp.Sockets)),
+	}
 
-	// Create the client
-	client := createClient(paths.Management)
+	if resp.SchemaVersion > 0 {
+		list.Sockets = append(list.Sockets, resp.Entries...)
+		return list
+	}
 
-	// Create the clean request
-	req, err := http.NewRequest("DELETE", "http://localhost/socket/clean", nil)
-	if err != nil {
-		errOut.Error(fmt.Errorf("error creating request: %v", err))
-		osExit(1)
+	for _, name := range resp.Sockets {
+		list.Sockets = append(list.Sockets, management.SocketEntry{Name: name})
 	}
+	return list
+}
 
-	// Send the request
-	resp, err := client.Do(req)
-	if err != nil {
-		errOut.Error(fmt.Errorf("error sending request: %v", err))
-		osExit(1)
+// socketRows converts a socket list into the rows of list --output table,
+// naming sockets by the name they were created with where they have one.
+// Daemons that predate the list schema only report names, so their path
+// and rule count are printed as "-".
+func socketRows(list management.SocketList) [][]string {
+	rows := make([][]string, 0, len(list.Sockets))
+	for _, socket := range list.Sockets {
+		name, path, rules := socket.Name, socket.Address, strconv.Itoa(socket.Rules)
+		if socket.DisplayName != "" {
+			name = socket.DisplayName
+		}
+		if path == "" {
+			path, rules = "-", "-"
+		}
+		rows = append(rows, []string{name, path, rules})
+	}
+	return rows
+}
+
+// printSocketDetails renders detailed socket information as a table
+func printSocketDetails(w io.Writer, details []management.SocketDetail) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "NAME\tRULES\tCREATED\tLAST DECISION"); err != nil {
+		return err
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			exitWithError("Failed to close response body: %v", err)
+	for _, d := range details {
+		lastDecision := "never"
+		if d.LastDecision != nil {
+			lastDecision = d.LastDecision.Format(time.RFC3339)
 		}
-	}()
+		if _, err := fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n",
+			d.Name, d.Rules, d.CreatedAt.Format(time.RFC3339), lastDecision); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
 
-	// Handle the response
-	_, err = handleResponse(resp, http.StatusOK)
-	if err != nil {
+// RunClean executes the clean command
+func RunClean(cmd *cobra.Command, paths *management.SocketPaths) {
+	out := getOutput(cmd)
+	errOut := getErrorOutput(cmd)
+
+	if err := client.New(paths).CleanSockets(commandContext(cmd)); err != nil {
 		errOut.Error(fmt.Errorf("failed to clean sockets: %v", err))
 		osExit(1)
 	}
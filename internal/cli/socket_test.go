@@ -8,8 +8,10 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"docker-socket-proxy/internal/management"
 	"docker-socket-proxy/internal/proxy/config"
@@ -96,6 +98,135 @@ func TestRunCreate(t *testing.T) {
 	}
 }
 
+func TestRunCreateQueryFlags(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	socketPath := filepath.Join(tmpDir, "test.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("check_upstream") != "true" {
+			t.Errorf("Expected check_upstream=true query, got %q", r.URL.RawQuery)
+		}
+		if r.URL.Query().Get("name") != "ci" {
+			t.Errorf("Expected name=ci query, got %q", r.URL.RawQuery)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		response := management.Response[management.CreateResponse]{
+			Status: "success",
+			Response: management.CreateResponse{
+				Socket: "/var/run/docker-proxy/test-socket.sock",
+			},
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("config", "", "")
+	cmd.Flags().String("output", "text", "")
+	cmd.Flags().Bool("check-upstream", true, "")
+	cmd.Flags().String("name", "ci", "")
+	paths := &management.SocketPaths{
+		Management: socketPath,
+	}
+
+	output := captureOutput(func() {
+		RunCreate(cmd, paths)
+	})
+
+	if !strings.Contains(output, "/var/run/docker-proxy/test-socket.sock") {
+		t.Errorf("Expected output to contain socket path, got: %s", output)
+	}
+}
+
+func TestRunCreateConfigFromEnv(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	t.Setenv("TEST_SOCKET_CONFIG", `
+rules:
+  - match:
+      path: "/v1.*/containers/json"
+      method: "GET"
+    actions:
+      - action: "allow"
+        reason: "from env"
+`)
+
+	socketPath := filepath.Join(tmpDir, "test.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received config.SocketConfig
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Failed to decode request body: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		response := management.Response[management.CreateResponse]{
+			Status: "success",
+			Response: management.CreateResponse{
+				Socket: "/var/run/docker-proxy/test-socket.sock",
+			},
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("config", "", "")
+	cmd.Flags().String("config-from-env", "TEST_SOCKET_CONFIG", "")
+	cmd.Flags().String("output", "text", "")
+	paths := &management.SocketPaths{
+		Management: socketPath,
+	}
+
+	output := captureOutput(func() {
+		RunCreate(cmd, paths)
+	})
+
+	if !strings.Contains(output, "/var/run/docker-proxy/test-socket.sock") {
+		t.Errorf("Expected output to contain socket path, got: %s", output)
+	}
+	if len(received.Rules) != 1 {
+		t.Fatalf("Expected 1 rule to be sent, got %d", len(received.Rules))
+	}
+	if received.Rules[0].Match.Path != "/v1.*/containers/json" || received.Rules[0].Actions[0].Reason != "from env" {
+		t.Errorf("Unexpected rule sent: %+v", received.Rules[0])
+	}
+}
+
 func TestRunDelete(t *testing.T) {
 	// Create a temporary directory for the test socket
 	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
@@ -163,6 +294,71 @@ func TestRunDelete(t *testing.T) {
 	}
 }
 
+func TestRunRename(t *testing.T) {
+	// Create a temporary directory for the test socket
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	// Create a mock Unix socket server
+	socketPath := filepath.Join(tmpDir, "test.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a test server
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("Expected POST request, got %s", r.Method)
+		}
+		if r.URL.Path != "/socket/rename" {
+			t.Errorf("Expected /socket/rename path, got %s", r.URL.Path)
+		}
+		query := r.URL.Query()
+		if query.Get("socket") != "ci" || query.Get("name") != "deploy" || query.Get("move") != "true" {
+			t.Errorf("Unexpected query %q", r.URL.RawQuery)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		response := management.Response[management.RenameResponse]{
+			Status: "success",
+			Response: management.RenameResponse{
+				Socket: "/var/run/docker-proxy/deploy.sock",
+				Name:   "deploy",
+			},
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	// Set up test command and arguments
+	cmd := &cobra.Command{}
+	cmd.Flags().String("output", "text", "")
+	cmd.Flags().Bool("move", true, "")
+	paths := &management.SocketPaths{
+		Management: socketPath,
+	}
+
+	output := captureOutput(func() {
+		RunRename(cmd, []string{"ci", "deploy"}, paths)
+	})
+
+	if !strings.Contains(output, "/var/run/docker-proxy/deploy.sock") {
+		t.Errorf("Expected output to contain the new socket path, got: %s", output)
+	}
+}
+
 func TestRunList(t *testing.T) {
 	// Create a temporary directory for the test socket
 	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
@@ -329,8 +525,8 @@ func TestRunClean(t *testing.T) {
 
 	// Create a test server
 	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "DELETE" {
-			t.Errorf("Expected DELETE request, got %s", r.Method)
+		if r.Method != "POST" {
+			t.Errorf("Expected POST request, got %s", r.Method)
 		}
 		if r.URL.Path != "/socket/clean" {
 			t.Errorf("Expected /socket/clean path, got %s", r.URL.Path)
@@ -362,3 +558,976 @@ func TestRunClean(t *testing.T) {
 		t.Errorf("Expected output to contain success message, got: %s", output)
 	}
 }
+
+func TestRunListDetail(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	socketPath := filepath.Join(tmpDir, "test.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("detail") != "true" {
+			t.Errorf("Expected detail query param to be true, got %s", r.URL.Query().Get("detail"))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		response := management.Response[management.ListResponse]{
+			Status: "success",
+			Response: management.ListResponse{
+				Sockets: []string{"socket1.sock"},
+				Details: []management.SocketDetail{
+					{Name: "socket1.sock", Rules: 3, CreatedAt: created},
+				},
+			},
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("output", "text", "")
+	cmd.Flags().Bool("detail", true, "")
+	paths := &management.SocketPaths{
+		Management: socketPath,
+	}
+
+	output := captureOutput(func() {
+		RunList(cmd, paths)
+	})
+
+	for _, want := range []string{"NAME", "RULES", "socket1.sock", "3", "2024-01-02T03:04:05Z", "never"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected output to contain %q, got: %s", want, output)
+		}
+	}
+}
+
+func TestRunListTable(t *testing.T) {
+	tests := []struct {
+		name     string
+		response management.ListResponse
+		want     []string
+	}{
+		{
+			name: "columns from the list schema",
+			response: management.ListResponse{
+				Sockets:       []string{"socket1.sock"},
+				SchemaVersion: management.ListSchemaVersion,
+				Entries: []management.SocketEntry{
+					{Name: "socket1.sock", Type: "unix", Address: "/var/run/socket1.sock", Rules: 3},
+				},
+			},
+			want: []string{
+				"NAME          PATH                   RULES",
+				"socket1.sock  /var/run/socket1.sock  3",
+			},
+		},
+		{
+			name: "sockets are shown by the name they were created with",
+			response: management.ListResponse{
+				Sockets:       []string{"ci.sock", "docker-proxy-2f1c.sock"},
+				SchemaVersion: management.ListSchemaVersion,
+				Entries: []management.SocketEntry{
+					{Name: "ci.sock", DisplayName: "ci", Type: "unix", Address: "/var/run/ci.sock", Rules: 1},
+					{Name: "docker-proxy-2f1c.sock", Type: "unix", Address: "/var/run/docker-proxy-2f1c.sock", Rules: 2},
+				},
+			},
+			want: []string{
+				"NAME                    PATH                             RULES",
+				"ci                      /var/run/ci.sock                 1",
+				"docker-proxy-2f1c.sock  /var/run/docker-proxy-2f1c.sock  2",
+			},
+		},
+		{
+			name: "older daemons only report names",
+			response: management.ListResponse{
+				Sockets: []string{"socket1.sock"},
+			},
+			want: []string{
+				"NAME          PATH  RULES",
+				"socket1.sock  -     -",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := os.RemoveAll(tmpDir); err != nil {
+					t.Errorf("Failed to remove temporary directory: %v", err)
+				}
+			}()
+
+			socketPath := filepath.Join(tmpDir, "test.sock")
+			l, err := net.Listen("unix", socketPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				response := management.Response[management.ListResponse]{
+					Status:   "success",
+					Response: tt.response,
+				}
+				if err := json.NewEncoder(w).Encode(response); err != nil {
+					t.Errorf("Failed to write response: %v", err)
+				}
+			}))
+			server.Listener = l
+			server.Start()
+			defer server.Close()
+
+			cmd := &cobra.Command{}
+			cmd.Flags().String("output", "table", "")
+			cmd.Flags().Bool("detail", false, "")
+			paths := &management.SocketPaths{
+				Management: socketPath,
+			}
+
+			output := captureOutput(func() {
+				RunList(cmd, paths)
+			})
+
+			lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+			if !reflect.DeepEqual(lines, tt.want) {
+				t.Errorf("Expected table %q, got %q", tt.want, lines)
+			}
+		})
+	}
+}
+
+func TestRunDescribeFormat(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	socketPath := filepath.Join(tmpDir, "test.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		response := management.Response[management.DescribeResponse]{
+			Status: "success",
+			Response: management.DescribeResponse{
+				Config: &config.SocketConfig{
+					Rules: []config.Rule{
+						{
+							Match:   config.Match{Path: "/v1.*/containers/json", Method: "GET"},
+							Actions: []config.Action{{Action: "allow"}},
+						},
+						{
+							Match:   config.Match{Path: "/.*"},
+							Actions: []config.Action{{Action: "deny", Reason: "default"}},
+						},
+					},
+				},
+			},
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("output", "yaml", "")
+	cmd.Flags().String("format", `{{range .Rules}}{{.Match.Path}}={{(index .Actions 0).Action}};{{end}}`, "")
+	paths := &management.SocketPaths{
+		Management: socketPath,
+	}
+
+	output := captureOutput(func() {
+		RunDescribe(cmd, []string{"test-socket.sock"}, paths)
+	})
+
+	if want := "/v1.*/containers/json=allow;/.*=deny;\n"; output != want {
+		t.Errorf("Expected output %q, got %q", want, output)
+	}
+}
+
+func TestRenderConfigTemplate_Invalid(t *testing.T) {
+	var buf strings.Builder
+
+	if err := renderConfigTemplate(&buf, "{{range .Rules}", map[string]any{}); err == nil {
+		t.Error("expected an error for a malformed template")
+	}
+
+	if err := renderConfigTemplate(&buf, "{{.Missing}}", map[string]any{}); err == nil {
+		t.Error("expected an error for a template referencing an unknown field")
+	}
+}
+
+func TestSocketListSchema(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	entry := func(name string) management.SocketEntry {
+		return management.SocketEntry{
+			Name:      name,
+			Type:      "unix",
+			Address:   "/var/run/docker-proxy/" + name,
+			Rules:     2,
+			CreatedAt: created,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		response management.ListResponse
+		want     string
+	}{
+		{
+			name:     "zero sockets",
+			response: management.ListResponse{Sockets: []string{}, SchemaVersion: 1, Entries: nil},
+			want:     `{"schema_version":1,"sockets":[]}`,
+		},
+		{
+			name: "one socket",
+			response: management.ListResponse{
+				Sockets:       []string{"a.sock"},
+				SchemaVersion: 1,
+				Entries:       []management.SocketEntry{entry("a.sock")},
+			},
+			want: `{"schema_version":1,"sockets":[` +
+				`{"name":"a.sock","display_name":"","type":"unix","address":"/var/run/docker-proxy/a.sock","rules":2,"created_at":"2024-01-02T03:04:05Z"}]}`,
+		},
+		{
+			name: "many sockets",
+			response: management.ListResponse{
+				Sockets:       []string{"a.sock", "b.sock"},
+				SchemaVersion: 1,
+				Entries:       []management.SocketEntry{entry("a.sock"), entry("b.sock")},
+			},
+			want: `{"schema_version":1,"sockets":[` +
+				`{"name":"a.sock","display_name":"","type":"unix","address":"/var/run/docker-proxy/a.sock","rules":2,"created_at":"2024-01-02T03:04:05Z"},` +
+				`{"name":"b.sock","display_name":"","type":"unix","address":"/var/run/docker-proxy/b.sock","rules":2,"created_at":"2024-01-02T03:04:05Z"}]}`,
+		},
+		{
+			name:     "daemon without schema",
+			response: management.ListResponse{Sockets: []string{"a.sock"}},
+			want: `{"schema_version":1,"sockets":[` +
+				`{"name":"a.sock","display_name":"","type":"","address":"","rules":0,"created_at":"0001-01-01T00:00:00Z"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(socketList(tt.response))
+			if err != nil {
+				t.Fatalf("Failed to marshal socket list: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("socketList() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunDescribeStats(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	socketPath := filepath.Join(tmpDir, "test.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	since := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("stats") != "true" {
+			t.Errorf("Expected stats query param to be true, got %s", r.URL.Query().Get("stats"))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		response := management.Response[management.DescribeResponse]{
+			Status: "success",
+			Response: management.DescribeResponse{
+				Config: &config.SocketConfig{
+					Rules: []config.Rule{
+						{
+							Match:   config.Match{Path: "/v1.*/containers/json", Method: "GET"},
+							Actions: []config.Action{{Action: "allow"}},
+						},
+					},
+				},
+				Stats: &management.SocketStats{
+					Since: since,
+					Rules: []management.RuleStat{
+						{Index: 0, Path: "/v1.*/containers/json", Method: "GET", Hits: 42},
+					},
+				},
+			},
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("output", "text", "")
+	cmd.Flags().String("format", "", "")
+	cmd.Flags().Bool("stats", true, "")
+	paths := &management.SocketPaths{
+		Management: socketPath,
+	}
+
+	output := captureOutput(func() {
+		RunDescribe(cmd, []string{"test-socket.sock"}, paths)
+	})
+
+	for _, want := range []string{"Rule hits since 2024-01-02T03:04:05Z", "RULE", "HITS", "/v1.*/containers/json", "42"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected output to contain %q, got: %s", want, output)
+		}
+	}
+}
+
+func TestRunCreatePrintCurl(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	content := `
+rules:
+  - match:
+      path: "/.*"
+      method: "DELETE"
+    actions:
+      - action: "deny"
+        reason: "don't delete"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing listens on the management socket, so a request would fail
+	managementSocket := filepath.Join(tmpDir, "management.sock")
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("config", configPath, "")
+	cmd.Flags().String("output", "text", "")
+	cmd.Flags().String("name", "ci", "")
+	cmd.Flags().Bool("print-curl", true, "")
+	paths := &management.SocketPaths{
+		Management: managementSocket,
+		BasePath:   "/dsp",
+	}
+
+	output := captureOutput(func() {
+		RunCreate(cmd, paths)
+	})
+
+	wantPrefix := "curl --unix-socket '" + managementSocket + "' -X POST -H 'Content-Type: application/json' --data-binary '"
+	if !strings.HasPrefix(output, wantPrefix) {
+		t.Fatalf("Expected output to start with %q, got: %s", wantPrefix, output)
+	}
+	if want := " 'http://localhost/dsp/socket/create?name=ci'\n"; !strings.HasSuffix(output, want) {
+		t.Errorf("Expected output to end with %q, got: %s", want, output)
+	}
+
+	// The body is the config that would have been sent, with quotes escaped for the shell
+	body := strings.TrimPrefix(output, wantPrefix)
+	body = body[:strings.LastIndex(body, "' '")]
+	body = strings.ReplaceAll(body, `'\''`, "'")
+
+	var sent config.SocketConfig
+	if err := json.Unmarshal([]byte(body), &sent); err != nil {
+		t.Fatalf("Failed to decode printed body %q: %v", body, err)
+	}
+	if len(sent.Rules) != 1 || sent.Rules[0].Match.Method != "DELETE" || sent.Rules[0].Actions[0].Reason != "don't delete" {
+		t.Errorf("Printed body does not reflect the config: %+v", sent)
+	}
+
+	// With a token the command authenticates from the environment, without printing the token
+	paths.Token = "s3cret"
+	output = captureOutput(func() {
+		RunCreate(cmd, paths)
+	})
+	wantAuth := "curl --unix-socket '" + managementSocket + "' -X POST -H \"Authorization: Bearer $DSP_MANAGEMENT_TOKEN\" -H 'Content-Type: application/json'"
+	if !strings.HasPrefix(output, wantAuth) {
+		t.Errorf("Expected output to start with %q, got: %s", wantAuth, output)
+	}
+	if strings.Contains(output, "s3cret") {
+		t.Errorf("Expected the token to be left out of the output, got: %s", output)
+	}
+}
+
+func TestRunUpdate(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	content := `
+rules:
+  - match:
+      path: "/v1.*/containers/json"
+      method: "GET"
+    actions:
+      - action: "allow"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	socketPath := filepath.Join(tmpDir, "test.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			t.Errorf("Expected PUT request, got %s", r.Method)
+		}
+		if r.URL.Path != "/socket/update" {
+			t.Errorf("Expected /socket/update path, got %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("socket") != "ci.sock" {
+			t.Errorf("Expected socket=ci.sock query, got %q", r.URL.RawQuery)
+		}
+
+		var cfg config.SocketConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			t.Errorf("Failed to decode request body: %v", err)
+		}
+		if len(cfg.Rules) != 1 || cfg.Rules[0].Match.Path != "/v1.*/containers/json" {
+			t.Errorf("Unexpected config sent: %+v", cfg)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		response := management.Response[management.UpdateResponse]{
+			Status: "success",
+			Response: management.UpdateResponse{
+				Socket: "/var/run/docker-proxy/ci.sock",
+			},
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("config", configPath, "")
+	cmd.Flags().String("output", "text", "")
+	paths := &management.SocketPaths{
+		Management: socketPath,
+	}
+
+	output := captureOutput(func() {
+		RunUpdate(cmd, []string{"ci.sock"}, paths)
+	})
+
+	if !strings.Contains(output, "/var/run/docker-proxy/ci.sock") {
+		t.Errorf("Expected output to contain socket path, got: %s", output)
+	}
+}
+
+func TestRunStatsReset(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	socketPath := filepath.Join(tmpDir, "test.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("Expected POST request, got %s", r.Method)
+		}
+		if r.URL.Path != "/socket/stats/reset" {
+			t.Errorf("Expected /socket/stats/reset path, got %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("socket") != "ci.sock" {
+			t.Errorf("Expected socket=ci.sock query, got %q", r.URL.RawQuery)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		response := management.Response[management.StatsResetResponse]{
+			Status: "success",
+			Response: management.StatsResetResponse{
+				Sockets: []string{"/var/run/docker-proxy/ci.sock"},
+			},
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().Bool("reset", true, "")
+	cmd.Flags().String("output", "text", "")
+	paths := &management.SocketPaths{
+		Management: socketPath,
+	}
+
+	output := captureOutput(func() {
+		RunStats(cmd, []string{"ci.sock"}, paths)
+	})
+
+	if !strings.Contains(output, "Reset stats for ci.sock") {
+		t.Errorf("Expected output to name the reset socket, got: %s", output)
+	}
+}
+
+func TestRunStats(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	socketPath := filepath.Join(tmpDir, "test.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastRequest := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("Expected GET request, got %s", r.Method)
+		}
+		if r.URL.Path != "/socket/stats" {
+			t.Errorf("Expected /socket/stats path, got %s", r.URL.Path)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		response := management.Response[management.StatsResponse]{
+			Status: "success",
+			Response: management.StatsResponse{
+				Sockets: []management.SocketCounters{
+					{Name: "ci.sock", Requests: 15, Allowed: 10, Denied: 2, ShadowDenied: 3, Rewritten: 1, LastRequest: &lastRequest},
+					{Name: "idle.sock"},
+				},
+			},
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().Bool("reset", false, "")
+	cmd.Flags().String("output", "table", "")
+	paths := &management.SocketPaths{
+		Management: socketPath,
+	}
+
+	output := captureOutput(func() {
+		RunStats(cmd, nil, paths)
+	})
+
+	want := []string{
+		"NAME       REQUESTS  ALLOWED  DENIED  SHADOW DENIED  REWRITTEN  LAST REQUEST",
+		"ci.sock    15        10       2       3              1          2024-01-02T03:04:05Z",
+		"idle.sock  0         0        0       0              0          never",
+	}
+	if lines := strings.Split(strings.TrimRight(output, "\n"), "\n"); !reflect.DeepEqual(lines, want) {
+		t.Errorf("Expected table %q, got %q", want, lines)
+	}
+}
+
+func TestRunLogs(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	socketPath := filepath.Join(tmpDir, "test.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("Expected GET request, got %s", r.Method)
+		}
+		if r.URL.Path != "/socket/logs" {
+			t.Errorf("Expected /socket/logs path, got %s", r.URL.Path)
+		}
+		if got := r.URL.RawQuery; got != "follow=true&socket=ci.sock&tail=5" {
+			t.Errorf("Expected follow, socket and tail query, got %q", got)
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		entries := []management.LogEntry{
+			{Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Method: "GET", Path: "/v1.42/containers/json", Decision: "allow"},
+			{Time: time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC), Method: "POST", Path: "/v1.42/containers/create", Decision: "deny", Reason: "no privileged"},
+		}
+		for _, entry := range entries {
+			if err := json.NewEncoder(w).Encode(entry); err != nil {
+				t.Errorf("Failed to write entry: %v", err)
+			}
+		}
+	}))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().Bool("follow", true, "")
+	cmd.Flags().Int("tail", 5, "")
+	cmd.Flags().String("output", "text", "")
+	paths := &management.SocketPaths{
+		Management: socketPath,
+	}
+
+	output := captureOutput(func() {
+		RunLogs(cmd, []string{"ci.sock"}, paths)
+	})
+
+	for _, want := range []string{
+		"2024-01-02T03:04:05Z ALLOW GET /v1.42/containers/json\n",
+		"2024-01-02T03:04:06Z DENY  POST /v1.42/containers/create: no privileged\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected output to contain %q, got: %s", want, output)
+		}
+	}
+}
+
+func TestRunExport(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	socketPath := filepath.Join(tmpDir, "test.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("Expected GET request, got %s", r.Method)
+		}
+		if r.URL.Path != "/socket/export" {
+			t.Errorf("Expected /socket/export path, got %s", r.URL.Path)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		response := management.Response[management.ExportResponse]{
+			Status: "success",
+			Response: management.ExportResponse{
+				Sockets: []management.ExportedSocket{
+					{Name: "ci.sock", Config: map[string]any{"rules": []any{map[string]any{"match": map[string]any{"path": "/_ping"}}}}},
+				},
+			},
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("output", "json", "")
+	paths := &management.SocketPaths{
+		Management: socketPath,
+	}
+
+	output := captureOutput(func() {
+		RunExport(cmd, paths)
+	})
+
+	want := `{"sockets":[{"name":"ci.sock","config":{"rules":[{"match":{"path":"/_ping"}}]}}]}`
+	if strings.TrimSpace(output) != want {
+		t.Errorf("Expected output %s, got: %s", want, output)
+	}
+}
+
+func TestRunImport(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	exportPath := filepath.Join(tmpDir, "sockets.json")
+	export := `{"sockets":[` +
+		`{"name":"new.sock","config":{"rules":[{"match":{"path":"/_ping"},"actions":[{"action":"allow"}]}]}},` +
+		`{"name":"existing.sock","config":{"rules":[{"match":{"path":"/_ping"},"actions":[{"action":"allow"}]}]}},` +
+		`{"name":"broken.sock","config":{"rules":[]}}]}`
+	if err := os.WriteFile(exportPath, []byte(export), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	socketPath := filepath.Join(tmpDir, "test.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var updated []string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var cfg config.SocketConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			t.Errorf("Failed to decode request body: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/socket/create":
+			switch r.URL.Query().Get("name") {
+			case "existing.sock":
+				w.WriteHeader(http.StatusConflict)
+				_, _ = w.Write([]byte(`{"status":"error","response":{"error":"socket already exists"}}`))
+				return
+			case "broken.sock":
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"status":"error","response":{"error":"at least one rule is required"}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"status":"success","response":{"socket":"/var/run/docker-proxy/new.sock"}}`))
+		case r.Method == "PUT" && r.URL.Path == "/socket/update":
+			updated = append(updated, r.URL.Query().Get("socket"))
+			_, _ = w.Write([]byte(`{"status":"success","response":{"socket":"/var/run/docker-proxy/existing.sock"}}`))
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		overwrite   bool
+		want        []string
+		wantUpdated []string
+	}{
+		{
+			name: "existing sockets are skipped",
+			want: []string{
+				"new.sock: created\n",
+				"existing.sock: skipped\n",
+				"broken.sock: failed: unexpected status code: 400, error: at least one rule is required\n",
+			},
+		},
+		{
+			name:        "existing sockets are updated with overwrite",
+			overwrite:   true,
+			want:        []string{"new.sock: created\n", "existing.sock: updated\n", "broken.sock: failed"},
+			wantUpdated: []string{"existing.sock"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated = nil
+
+			exitCode := 0
+			origExit := osExit
+			defer func() { osExit = origExit }()
+			osExit = func(code int) { exitCode = code }
+
+			cmd := &cobra.Command{}
+			cmd.Flags().Bool("overwrite", tt.overwrite, "")
+			cmd.Flags().String("output", "text", "")
+			paths := &management.SocketPaths{
+				Management: socketPath,
+			}
+
+			output := captureOutput(func() {
+				RunImport(cmd, []string{exportPath}, paths)
+			})
+
+			for _, want := range tt.want {
+				if !strings.Contains(output, want) {
+					t.Errorf("Expected output to contain %q, got: %s", want, output)
+				}
+			}
+			if exitCode != 1 {
+				t.Errorf("exit code = %d, want 1 as broken.sock failed", exitCode)
+			}
+			if !reflect.DeepEqual(updated, tt.wantUpdated) {
+				t.Errorf("updated sockets = %v, want %v", updated, tt.wantUpdated)
+			}
+		})
+	}
+}
+
+func TestRunTest(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	bodyFile := filepath.Join(tmpDir, "body.json")
+	if err := os.WriteFile(bodyFile, []byte(`{"Image":"alpine"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	socketPath := filepath.Join(tmpDir, "test.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("Expected POST request, got %s", r.Method)
+		}
+		if r.URL.Path != "/socket/test" {
+			t.Errorf("Expected /socket/test path, got %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("socket") != "ci.sock" {
+			t.Errorf("Expected socket=ci.sock query, got %q", r.URL.RawQuery)
+		}
+
+		var testRequest management.TestRequest
+		if err := json.NewDecoder(r.Body).Decode(&testRequest); err != nil {
+			t.Errorf("Failed to decode test request: %v", err)
+		}
+		want := management.TestRequest{
+			Method:  "POST",
+			Path:    "/v1.42/containers/create",
+			Headers: map[string]string{"User-Agent": "compose"},
+			Body:    `{"Image":"alpine"}`,
+		}
+		if !reflect.DeepEqual(testRequest, want) {
+			t.Errorf("test request = %+v, want %+v", testRequest, want)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		response := management.Response[management.TestResponse]{
+			Status: "success",
+			Response: management.TestResponse{
+				Allowed: true,
+				Status:  http.StatusOK,
+				Rule:    2,
+				Matched: []int{0, 2},
+				Body:    `{"Image":"alpine","Labels":{"proxied":"true"}}`,
+			},
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("method", "POST", "")
+	cmd.Flags().String("path", "/v1.42/containers/create", "")
+	cmd.Flags().String("body", "@"+bodyFile, "")
+	cmd.Flags().StringArray("header", []string{"User-Agent: compose"}, "")
+	cmd.Flags().String("output", "text", "")
+	paths := &management.SocketPaths{
+		Management: socketPath,
+	}
+
+	output := captureOutput(func() {
+		RunTest(cmd, []string{"ci.sock"}, paths)
+	})
+
+	want := []string{
+		"allowed by rule 2 (status 200)",
+		"matched rules: 0, 2",
+		`rewritten body: {"Image":"alpine","Labels":{"proxied":"true"}}`,
+	}
+	if lines := strings.Split(strings.TrimRight(output, "\n"), "\n"); !reflect.DeepEqual(lines, want) {
+		t.Errorf("output = %q, want %q", lines, want)
+	}
+}
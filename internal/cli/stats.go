@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"docker-socket-proxy/internal/management"
+	"docker-socket-proxy/internal/management/client"
+
+	"github.com/spf13/cobra"
+)
+
+// RunStats executes the socket stats command, printing the request counters
+// of one socket or, without a name, of all of them. With --reset the
+// counters are zeroed instead.
+func RunStats(cmd *cobra.Command, args []string, paths *management.SocketPaths) {
+	if reset, _ := cmd.Flags().GetBool("reset"); !reset {
+		showStats(cmd, args, paths)
+		return
+	}
+
+	out := getOutput(cmd)
+	errOut := getErrorOutput(cmd)
+
+	// Reset a single socket when one is named
+	socket := ""
+	if len(args) > 0 {
+		socket = args[0]
+	}
+	response, err := client.New(paths).ResetStats(commandContext(cmd), socket)
+	if err != nil {
+		errOut.Error(fmt.Errorf("failed to reset stats: %v", err))
+		osExit(1)
+	}
+
+	// Print in requested format
+	if out.Text() {
+		for _, socket := range response.Response.Sockets {
+			if err := out.Print(fmt.Sprintf("Reset stats for %s", filepath.Base(socket))); err != nil {
+				exitWithError("Failed to print output: %v", err)
+			}
+		}
+	} else {
+		if err := out.Print(response.Response); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
+	}
+}
+
+// showStats prints the request counters of the named socket, or of every
+// socket when none is named
+func showStats(cmd *cobra.Command, args []string, paths *management.SocketPaths) {
+	out := getOutput(cmd)
+	errOut := getErrorOutput(cmd)
+
+	// Show a single socket when one is named
+	socket := ""
+	if len(args) > 0 {
+		socket = args[0]
+	}
+	response, err := client.New(paths).Stats(commandContext(cmd), socket)
+	if err != nil {
+		errOut.Error(fmt.Errorf("failed to get stats: %v", err))
+		osExit(1)
+		return
+	}
+
+	// Print in requested format, text is a table as well
+	if out.Text() {
+		if err := out.PrintTable([]string{"NAME", "REQUESTS", "ALLOWED", "DENIED", "SHADOW DENIED", "REWRITTEN", "LAST REQUEST"}, statsRows(response.Response.Sockets)); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
+	} else {
+		if err := out.Print(response.Response); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
+	}
+}
+
+// statsRows converts socket counters into the rows of the stats table
+func statsRows(sockets []management.SocketCounters) [][]string {
+	rows := make([][]string, 0, len(sockets))
+	for _, socket := range sockets {
+		lastRequest := "never"
+		if socket.LastRequest != nil {
+			lastRequest = socket.LastRequest.Format(time.RFC3339)
+		}
+		rows = append(rows, []string{
+			socket.Name,
+			strconv.FormatUint(socket.Requests, 10),
+			strconv.FormatUint(socket.Allowed, 10),
+			strconv.FormatUint(socket.Denied, 10),
+			strconv.FormatUint(socket.ShadowDenied, 10),
+			strconv.FormatUint(socket.Rewritten, 10),
+			lastRequest,
+		})
+	}
+	return rows
+}
@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"docker-socket-proxy/internal/cli/output"
+	"docker-socket-proxy/internal/management"
+	"docker-socket-proxy/internal/management/client"
+
+	"github.com/spf13/cobra"
+)
+
+// RunTest executes the socket test command, running a hypothetical request
+// through a socket's rules without forwarding it to the Docker daemon
+func RunTest(cmd *cobra.Command, args []string, paths *management.SocketPaths) {
+	out := getOutput(cmd)
+	errOut := getErrorOutput(cmd)
+
+	if len(args) == 0 {
+		errOut.Error(fmt.Errorf("error: socket name is required"))
+		osExit(1)
+		return
+	}
+
+	testRequest, err := buildTestRequest(cmd)
+	if err != nil {
+		errOut.Error(err)
+		osExit(1)
+		return
+	}
+
+	response, err := client.New(paths).TestSocket(commandContext(cmd), args[0], testRequest)
+	if err != nil {
+		errOut.Error(fmt.Errorf("failed to test request: %v", err))
+		osExit(1)
+		return
+	}
+
+	// Print in requested format
+	if out.Text() {
+		if err := printTestResult(out, response.Response); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
+	} else {
+		if err := out.Print(response.Response); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
+	}
+}
+
+// buildTestRequest reads the request to test from the command's flags. A
+// body starting with @ names a file to read it from.
+func buildTestRequest(cmd *cobra.Command) (management.TestRequest, error) {
+	method, _ := cmd.Flags().GetString("method")
+	path, _ := cmd.Flags().GetString("path")
+	body, _ := cmd.Flags().GetString("body")
+	headers, _ := cmd.Flags().GetStringArray("header")
+
+	testRequest := management.TestRequest{Method: method, Path: path, Body: body}
+	if path == "" {
+		return testRequest, fmt.Errorf("error: --path is required")
+	}
+
+	if strings.HasPrefix(body, "@") {
+		data, err := os.ReadFile(strings.TrimPrefix(body, "@"))
+		if err != nil {
+			return testRequest, fmt.Errorf("error reading body: %v", err)
+		}
+		testRequest.Body = string(data)
+	}
+
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return testRequest, fmt.Errorf("error: invalid header %q, expected Name: value", header)
+		}
+		if testRequest.Headers == nil {
+			testRequest.Headers = make(map[string]string)
+		}
+		testRequest.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	return testRequest, nil
+}
+
+// printTestResult renders the decision for a test request, one fact per line
+func printTestResult(out *output.Output, result management.TestResponse) error {
+	decision := "denied"
+	if result.Allowed {
+		decision = "allowed"
+	}
+	rule := "the default action"
+	if result.Rule >= 0 {
+		rule = "rule " + strconv.Itoa(result.Rule)
+	}
+	if result.Rule >= 0 && result.Base {
+		rule = "base rule " + strconv.Itoa(result.Rule)
+	}
+
+	lines := []string{fmt.Sprintf("%s by %s (status %d)", decision, rule, result.Status)}
+	if result.Reason != "" {
+		lines = append(lines, "reason: "+result.Reason)
+	}
+	if result.Code != "" {
+		lines = append(lines, "code: "+result.Code)
+	}
+	matched := make([]string, 0, len(result.Matched))
+	for _, i := range result.Matched {
+		matched = append(matched, strconv.Itoa(i))
+	}
+	if len(matched) == 0 {
+		matched = append(matched, "none")
+	}
+	lines = append(lines, "matched rules: "+strings.Join(matched, ", "))
+	if result.Path != "" {
+		lines = append(lines, "rewritten path: "+result.Path)
+	}
+	if result.Body != "" {
+		lines = append(lines, "rewritten body: "+result.Body)
+	}
+
+	for _, line := range lines {
+		if err := out.PrintText(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
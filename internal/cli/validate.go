@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"fmt"
+
+	"docker-socket-proxy/internal/cli/output"
+	"docker-socket-proxy/internal/proxy/config"
+
+	"github.com/spf13/cobra"
+)
+
+// validateReport is the outcome of validating a socket configuration file
+type validateReport struct {
+	Valid    bool                      `json:"valid" yaml:"valid"`
+	Errors   []*config.ValidationError `json:"errors" yaml:"errors"`
+	Warnings []*config.ValidationError `json:"warnings" yaml:"warnings"`
+}
+
+// RunValidate executes the validate command, checking a socket configuration
+// locally without contacting the management socket
+func RunValidate(cmd *cobra.Command) {
+	out := getOutput(cmd)
+	errOut := getErrorOutput(cmd)
+
+	configPath, _ := cmd.Flags().GetString("config")
+	if configPath == "" {
+		errOut.Error(fmt.Errorf("error: --config is required"))
+		osExit(1)
+	}
+
+	report := validateReport{
+		Errors:   []*config.ValidationError{},
+		Warnings: []*config.ValidationError{},
+	}
+
+	socketConfig, err := config.ReadSocketConfig(configPath)
+	if err != nil {
+		report.Errors = append(report.Errors, &config.ValidationError{Rule: -1, Action: -1, Message: err.Error()})
+	} else {
+		report.Errors = append(report.Errors, config.ValidationErrors(socketConfig)...)
+		report.Warnings = append(report.Warnings, config.ValidationWarnings(socketConfig)...)
+	}
+	report.Valid = len(report.Errors) == 0
+
+	// Print in requested format
+	if out.Text() {
+		if err := printValidateReport(out, configPath, report); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
+	} else {
+		if err := out.Print(report); err != nil {
+			exitWithError("Failed to print output: %v", err)
+		}
+	}
+
+	if !report.Valid {
+		osExit(1)
+	}
+}
+
+// printValidateReport renders a validation report one problem per line
+func printValidateReport(out *output.Output, configPath string, report validateReport) error {
+	for _, e := range report.Errors {
+		if err := out.PrintText("error: " + e.Error()); err != nil {
+			return err
+		}
+	}
+	for _, w := range report.Warnings {
+		if err := out.PrintText("warning: " + w.Error()); err != nil {
+			return err
+		}
+	}
+
+	if !report.Valid {
+		return out.PrintText(fmt.Sprintf("%s is invalid: %d error(s), %d warning(s)", configPath, len(report.Errors), len(report.Warnings)))
+	}
+	return out.PrintText(fmt.Sprintf("%s is valid: %d warning(s)", configPath, len(report.Warnings)))
+}
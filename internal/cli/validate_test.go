@@ -0,0 +1,182 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestRunValidate(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	tests := []struct {
+		name         string
+		content      string
+		wantExit     int
+		wantValid    bool
+		wantErrors   []string
+		wantWarnings []string
+	}{
+		{
+			name: "valid config",
+			content: `
+rules:
+  - match:
+      path: "/v1.*/containers/json"
+      method: "GET"
+    actions:
+      - action: "allow"
+`,
+			wantValid: true,
+		},
+		{
+			name: "every problem is reported",
+			content: `
+rules:
+  - match:
+      path: "/v1.*/containers/json"
+    actions:
+      - action: "deny"
+  - match:
+      path: ""
+    actions:
+      - action: "allow"
+      - action: "block"
+`,
+			wantExit: 1,
+			wantErrors: []string{
+				"rule 0, action 0: deny action requires a reason",
+				"rule 1: path is required",
+				"rule 1, action 1: invalid action: block",
+			},
+		},
+		{
+			name: "suspicious pattern warns",
+			content: `
+rules:
+  - match:
+      path: ".*"
+      method: ".*"
+    actions:
+      - action: "allow"
+`,
+			wantValid: true,
+			wantWarnings: []string{
+				`rule 0: path pattern ".*" matches every request`,
+				`rule 0: method pattern ".*" matches every method`,
+			},
+		},
+		{
+			name:       "unparseable file",
+			content:    "rules: [",
+			wantExit:   1,
+			wantErrors: []string{"failed to parse YAML config file"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(tmpDir, "config.yaml")
+			if err := os.WriteFile(configPath, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			origExit := osExit
+			defer func() { osExit = origExit }()
+			exitCode := 0
+			osExit = func(code int) { exitCode = code }
+
+			cmd := &cobra.Command{}
+			cmd.Flags().String("output", "json", "")
+			cmd.Flags().String("config", configPath, "")
+
+			output := captureOutput(func() {
+				RunValidate(cmd)
+			})
+
+			if exitCode != tt.wantExit {
+				t.Errorf("exit code = %d, want %d", exitCode, tt.wantExit)
+			}
+
+			var report validateReport
+			if err := json.Unmarshal([]byte(output), &report); err != nil {
+				t.Fatalf("Failed to parse report %q: %v", output, err)
+			}
+			if report.Valid != tt.wantValid {
+				t.Errorf("valid = %v, want %v", report.Valid, tt.wantValid)
+			}
+
+			if len(report.Errors) != len(tt.wantErrors) {
+				t.Fatalf("got %d errors %v, want %d", len(report.Errors), report.Errors, len(tt.wantErrors))
+			}
+			for i, want := range tt.wantErrors {
+				if !strings.Contains(report.Errors[i].Error(), want) {
+					t.Errorf("error %d = %q, want it to contain %q", i, report.Errors[i].Error(), want)
+				}
+			}
+
+			if len(report.Warnings) != len(tt.wantWarnings) {
+				t.Fatalf("got %d warnings %v, want %d", len(report.Warnings), report.Warnings, len(tt.wantWarnings))
+			}
+			for i, want := range tt.wantWarnings {
+				if !strings.Contains(report.Warnings[i].Error(), want) {
+					t.Errorf("warning %d = %q, want it to contain %q", i, report.Warnings[i].Error(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunValidateText(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	content := `
+rules:
+  - match:
+      path: "/.*"
+    actions:
+      - action: "deny"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	origExit := osExit
+	defer func() { osExit = origExit }()
+	osExit = func(code int) {}
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("output", "text", "")
+	cmd.Flags().String("config", configPath, "")
+
+	output := captureOutput(func() {
+		RunValidate(cmd)
+	})
+
+	for _, want := range []string{"error: rule 0, action 0: deny action requires a reason", "is invalid: 1 error(s)"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected output to contain %q, got: %s", want, output)
+		}
+	}
+}
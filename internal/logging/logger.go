@@ -1,25 +1,74 @@
 package logging
 
 import (
+	"io"
 	"log/slog"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 var (
+	// Current level, shared by every logger built here so it can be changed at runtime
+	level = new(slog.LevelVar)
+
 	// Default logger instance
-	logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
+	logger atomic.Pointer[slog.Logger]
+
+	// toggleMu guards the level to restore when debug logging is toggled off
+	toggleMu     sync.Mutex
+	toggledFrom  slog.Level
+	debugToggled bool
 )
 
-// SetLevel changes the logging level
-func SetLevel(level slog.Level) {
-	logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+func init() {
+	SetOutput(os.Stdout)
+}
+
+// SetLevel changes the logging level, it is safe to call while logging
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// Level returns the current logging level
+func Level() slog.Level {
+	return level.Level()
+}
+
+// ParseLevel parses a level name such as debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(strings.TrimSpace(s)))
+	return l, err
+}
+
+// ToggleDebug switches to debug logging, or back to the level that was in
+// effect before the previous toggle, and returns the new level
+func ToggleDebug() slog.Level {
+	toggleMu.Lock()
+	defer toggleMu.Unlock()
+
+	if debugToggled {
+		debugToggled = false
+		level.Set(toggledFrom)
+		return toggledFrom
+	}
+
+	debugToggled = true
+	toggledFrom = level.Level()
+	level.Set(slog.LevelDebug)
+	return slog.LevelDebug
+}
+
+// SetOutput changes where log records are written
+func SetOutput(w io.Writer) {
+	logger.Store(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
 		Level: level,
-	}))
+	})))
 }
 
 // GetLogger returns the configured logger
 func GetLogger() *slog.Logger {
-	return logger
+	return logger.Load()
 }
@@ -0,0 +1,97 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSetLevelAtRuntime(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stdout)
+	defer SetLevel(slog.LevelInfo)
+
+	SetLevel(slog.LevelInfo)
+	GetLogger().Debug("hidden before")
+
+	SetLevel(slog.LevelDebug)
+	GetLogger().Debug("shown after")
+
+	SetLevel(slog.LevelWarn)
+	GetLogger().Info("hidden again")
+
+	logs := buf.String()
+	if strings.Contains(logs, "hidden before") || strings.Contains(logs, "hidden again") {
+		t.Errorf("expected records below the level to be dropped, got: %s", logs)
+	}
+	if !strings.Contains(logs, "shown after") {
+		t.Errorf("expected debug record after raising the level, got: %s", logs)
+	}
+}
+
+func TestSetLevelConcurrent(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stdout)
+	defer SetLevel(slog.LevelInfo)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			SetLevel(slog.LevelDebug)
+			SetLevel(slog.LevelInfo)
+		}()
+		go func() {
+			defer wg.Done()
+			GetLogger().Debug("concurrent")
+		}()
+	}
+	wg.Wait()
+}
+
+func TestToggleDebug(t *testing.T) {
+	defer SetLevel(slog.LevelInfo)
+
+	SetLevel(slog.LevelWarn)
+	if got := ToggleDebug(); got != slog.LevelDebug {
+		t.Errorf("ToggleDebug() = %v, want %v", got, slog.LevelDebug)
+	}
+	if Level() != slog.LevelDebug {
+		t.Errorf("Level() = %v, want %v", Level(), slog.LevelDebug)
+	}
+	if got := ToggleDebug(); got != slog.LevelWarn {
+		t.Errorf("ToggleDebug() = %v, want %v", got, slog.LevelWarn)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    slog.Level
+		wantErr bool
+	}{
+		{input: "debug", want: slog.LevelDebug},
+		{input: "INFO", want: slog.LevelInfo},
+		{input: "warn", want: slog.LevelWarn},
+		{input: "error", want: slog.LevelError},
+		{input: "verbose", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseLevel(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLevel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseLevel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
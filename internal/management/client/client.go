@@ -0,0 +1,346 @@
+// Package client calls the management API of a docker-socket-proxy daemon
+// over its management socket, for the CLI and for Go tools that manage
+// sockets of their own.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"docker-socket-proxy/internal/management"
+	"docker-socket-proxy/internal/proxy/config"
+)
+
+// Client sends requests to the management API
+type Client struct {
+	paths *management.SocketPaths
+	http  *http.Client
+}
+
+// New creates a client for the management socket, base path and token of paths
+func New(paths *management.SocketPaths) *Client {
+	var transport http.RoundTripper = &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", paths.Management)
+		},
+	}
+	if paths.Token != "" {
+		transport = &tokenTransport{token: paths.Token, next: transport}
+	}
+	return &Client{paths: paths, http: &http.Client{Transport: transport}}
+}
+
+// tokenTransport adds the management token to every request as a bearer token
+type tokenTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.next.RoundTrip(req)
+}
+
+// Error is a request the management API answered with an unexpected status
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("unexpected status code: %d, error: %s", e.StatusCode, e.Message)
+}
+
+// CreateOptions are the optional parameters of a socket create
+type CreateOptions struct {
+	// Name is the socket's name, one is generated when empty
+	Name string
+	// CheckUpstream refuses the create when the Docker daemon is unreachable
+	CheckUpstream bool
+}
+
+// CreateSocket creates a socket, with the default config when socketConfig is nil
+func (c *Client) CreateSocket(ctx context.Context, socketConfig *config.SocketConfig, opts CreateOptions) (management.Response[management.CreateResponse], error) {
+	var response management.Response[management.CreateResponse]
+	req, err := c.CreateSocketRequest(ctx, socketConfig, opts)
+	if err != nil {
+		return response, err
+	}
+	return response, c.do(req, &response)
+}
+
+// CreateSocketRequest builds the request CreateSocket sends, without sending it
+func (c *Client) CreateSocketRequest(ctx context.Context, socketConfig *config.SocketConfig, opts CreateOptions) (*http.Request, error) {
+	query := url.Values{}
+	if opts.Name != "" {
+		query.Set("name", opts.Name)
+	}
+	if opts.CheckUpstream {
+		query.Set("check_upstream", "true")
+	}
+
+	var body []byte
+	if socketConfig != nil {
+		var err error
+		if body, err = json.Marshal(socketConfig); err != nil {
+			return nil, fmt.Errorf("error encoding configuration: %v", err)
+		}
+	}
+	return c.newRequest(ctx, "POST", "/socket/create", query, body)
+}
+
+// UpdateSocket replaces the config of an existing socket without recreating it
+func (c *Client) UpdateSocket(ctx context.Context, socket string, socketConfig *config.SocketConfig) (management.Response[management.UpdateResponse], error) {
+	var response management.Response[management.UpdateResponse]
+	body, err := json.Marshal(socketConfig)
+	if err != nil {
+		return response, fmt.Errorf("error encoding configuration: %v", err)
+	}
+	req, err := c.newRequest(ctx, "PUT", "/socket/update", url.Values{"socket": {socket}}, body)
+	if err != nil {
+		return response, err
+	}
+	return response, c.do(req, &response)
+}
+
+// RenameSocket gives a socket a new name, with move its file is renamed too
+func (c *Client) RenameSocket(ctx context.Context, socket, name string, move bool) (management.Response[management.RenameResponse], error) {
+	var response management.Response[management.RenameResponse]
+	query := url.Values{"socket": {socket}, "name": {name}}
+	if move {
+		query.Set("move", "true")
+	}
+	req, err := c.newRequest(ctx, "POST", "/socket/rename", query, nil)
+	if err != nil {
+		return response, err
+	}
+	return response, c.do(req, &response)
+}
+
+// DeleteSocket deletes a socket
+func (c *Client) DeleteSocket(ctx context.Context, socket string) (management.Response[management.DeleteResponse], error) {
+	var response management.Response[management.DeleteResponse]
+	req, err := c.newRequest(ctx, "DELETE", "/socket/delete", url.Values{"socket": {socket}}, nil)
+	if err != nil {
+		return response, err
+	}
+	// Add the Socket-Path header for backward compatibility
+	req.Header.Set("Socket-Path", socket)
+	return response, c.do(req, &response)
+}
+
+// ListSockets lists the sockets, with detail their rule counts and activity too
+func (c *Client) ListSockets(ctx context.Context, detail bool) (management.Response[management.ListResponse], error) {
+	var response management.Response[management.ListResponse]
+	query := url.Values{}
+	if detail {
+		query.Set("detail", "true")
+	}
+	req, err := c.newRequest(ctx, "GET", "/socket/list", query, nil)
+	if err != nil {
+		return response, err
+	}
+	return response, c.do(req, &response)
+}
+
+// DescribeOptions are the optional parameters of a socket describe
+type DescribeOptions struct {
+	// Stats adds the socket's rule hit counters
+	Stats bool
+	// Effective returns the rules as the proxy evaluates them, with the
+	// propagation rule and in priority order
+	Effective bool
+}
+
+// DescribeSocket returns the config of a socket
+func (c *Client) DescribeSocket(ctx context.Context, socket string, opts DescribeOptions) (management.Response[management.DescribeResponse], error) {
+	var response management.Response[management.DescribeResponse]
+	query := url.Values{"socket": {socket}}
+	if opts.Stats {
+		query.Set("stats", "true")
+	}
+	if opts.Effective {
+		query.Set("effective", "true")
+	}
+	req, err := c.newRequest(ctx, "GET", "/socket/describe", query, nil)
+	if err != nil {
+		return response, err
+	}
+	return response, c.do(req, &response)
+}
+
+// CleanSockets deletes every socket
+func (c *Client) CleanSockets(ctx context.Context) error {
+	req, err := c.newRequest(ctx, "POST", "/socket/clean", nil, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, nil)
+}
+
+// ExportSockets returns the name and config of every socket
+func (c *Client) ExportSockets(ctx context.Context) (management.Response[management.ExportResponse], error) {
+	var response management.Response[management.ExportResponse]
+	req, err := c.newRequest(ctx, "GET", "/socket/export", nil, nil)
+	if err != nil {
+		return response, err
+	}
+	return response, c.do(req, &response)
+}
+
+// TestSocket runs a hypothetical request through a socket's rules without
+// forwarding it to the Docker daemon
+func (c *Client) TestSocket(ctx context.Context, socket string, testRequest management.TestRequest) (management.Response[management.TestResponse], error) {
+	var response management.Response[management.TestResponse]
+	body, err := json.Marshal(testRequest)
+	if err != nil {
+		return response, fmt.Errorf("error encoding test request: %v", err)
+	}
+	req, err := c.newRequest(ctx, "POST", "/socket/test", url.Values{"socket": {socket}}, body)
+	if err != nil {
+		return response, err
+	}
+	return response, c.do(req, &response)
+}
+
+// Stats returns the request counters of a socket, or of every socket when
+// socket is empty
+func (c *Client) Stats(ctx context.Context, socket string) (management.Response[management.StatsResponse], error) {
+	var response management.Response[management.StatsResponse]
+	req, err := c.newRequest(ctx, "GET", "/socket/stats", socketQuery(socket), nil)
+	if err != nil {
+		return response, err
+	}
+	return response, c.do(req, &response)
+}
+
+// ResetStats zeroes the counters of a socket, or of every socket when
+// socket is empty
+func (c *Client) ResetStats(ctx context.Context, socket string) (management.Response[management.StatsResetResponse], error) {
+	var response management.Response[management.StatsResetResponse]
+	req, err := c.newRequest(ctx, "POST", "/socket/stats/reset", socketQuery(socket), nil)
+	if err != nil {
+		return response, err
+	}
+	return response, c.do(req, &response)
+}
+
+// SetLogLevel changes the daemon's log level
+func (c *Client) SetLogLevel(ctx context.Context, level string) (management.Response[management.LogLevelResponse], error) {
+	var response management.Response[management.LogLevelResponse]
+	req, err := c.newRequest(ctx, "POST", "/loglevel", url.Values{"level": {level}}, nil)
+	if err != nil {
+		return response, err
+	}
+	return response, c.do(req, &response)
+}
+
+// Logs streams the recent decisions of a socket as JSON lines, the last tail
+// of them when tail is not negative. With follow the stream stays open for
+// new decisions. The caller closes the stream.
+func (c *Client) Logs(ctx context.Context, socket string, tail int, follow bool) (io.ReadCloser, error) {
+	query := url.Values{"socket": {socket}}
+	if tail >= 0 {
+		query.Set("tail", strconv.Itoa(tail))
+	}
+	if follow {
+		query.Set("follow", "true")
+	}
+	req, err := c.newRequest(ctx, "GET", "/socket/logs", query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		err := responseError(resp)
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			return nil, fmt.Errorf("error closing response body: %v", closeErr)
+		}
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// socketQuery names a socket, or none when socket is empty
+func socketQuery(socket string) url.Values {
+	if socket == "" {
+		return nil
+	}
+	return url.Values{"socket": {socket}}
+}
+
+// newRequest builds a request for a management route, a body is sent as JSON
+func (c *Client) newRequest(ctx context.Context, method, route string, query url.Values, body []byte) (*http.Request, error) {
+	target := c.paths.URL(route)
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	if len(body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// do sends a request and decodes the response into response, unless it is nil
+func (c *Client) do(req *http.Request, response any) (err error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("error closing response body: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+	if response == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, response); err != nil {
+		return fmt.Errorf("failed to parse response: %v", err)
+	}
+	return nil
+}
+
+// responseError reads the error of a response with an unexpected status
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	return &Error{StatusCode: resp.StatusCode, Message: errorMessage(body)}
+}
+
+// errorMessage returns the message of a management API error body, or the
+// body itself when it is not in the error envelope
+func errorMessage(body []byte) string {
+	var response management.Response[management.ErrorResponse]
+	if err := json.Unmarshal(body, &response); err == nil && response.Status == "error" && response.Response.Error != "" {
+		return response.Response.Error
+	}
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,244 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"docker-socket-proxy/internal/management"
+	"docker-socket-proxy/internal/proxy/config"
+)
+
+// newTestServer serves handler on a management socket in a temporary
+// directory and returns the paths to reach it
+func newTestServer(t *testing.T, handler http.Handler) *management.SocketPaths {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	})
+
+	socketPath := filepath.Join(tmpDir, "management.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = l
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return &management.SocketPaths{Management: socketPath}
+}
+
+func TestClientRequests(t *testing.T) {
+	type request struct {
+		method string
+		path   string
+		query  string
+		body   string
+	}
+
+	socketConfig := &config.SocketConfig{Rules: []config.Rule{
+		{Match: config.Match{Path: "/.*"}, Actions: []config.Action{{Action: "allow"}}},
+	}}
+	configJSON, err := json.Marshal(socketConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		call     func(c *Client) (any, error)
+		response string
+		want     request
+		wantResp any
+	}{
+		{
+			name: "create",
+			call: func(c *Client) (any, error) {
+				resp, err := c.CreateSocket(context.Background(), socketConfig, CreateOptions{Name: "ci", CheckUpstream: true})
+				return resp.Response, err
+			},
+			response: `{"status":"success","response":{"socket":"/var/run/docker-proxy/ci.sock"}}`,
+			want:     request{method: "POST", path: "/dsp/socket/create", query: "check_upstream=true&name=ci", body: string(configJSON)},
+			wantResp: management.CreateResponse{Socket: "/var/run/docker-proxy/ci.sock"},
+		},
+		{
+			name: "delete",
+			call: func(c *Client) (any, error) {
+				resp, err := c.DeleteSocket(context.Background(), "ci")
+				return resp.Response, err
+			},
+			response: `{"status":"success","response":{"message":"Socket ci deleted"}}`,
+			want:     request{method: "DELETE", path: "/dsp/socket/delete", query: "socket=ci"},
+			wantResp: management.DeleteResponse{Message: "Socket ci deleted"},
+		},
+		{
+			name: "list",
+			call: func(c *Client) (any, error) {
+				resp, err := c.ListSockets(context.Background(), true)
+				return len(resp.Response.Sockets), err
+			},
+			response: `{"status":"success","response":{"sockets":["a.sock","b.sock"]}}`,
+			want:     request{method: "GET", path: "/dsp/socket/list", query: "detail=true"},
+			wantResp: 2,
+		},
+		{
+			name: "describe",
+			call: func(c *Client) (any, error) {
+				resp, err := c.DescribeSocket(context.Background(), "ci", DescribeOptions{Effective: true})
+				return resp.Response.Stats == nil && resp.Response.Config != nil, err
+			},
+			response: `{"status":"success","response":{"config":{"rules":[]}}}`,
+			want:     request{method: "GET", path: "/dsp/socket/describe", query: "effective=true&socket=ci"},
+			wantResp: true,
+		},
+		{
+			name: "clean",
+			call: func(c *Client) (any, error) {
+				return nil, c.CleanSockets(context.Background())
+			},
+			response: `{"status":"success","message":"Deleted 2 sockets"}`,
+			want:     request{method: "POST", path: "/dsp/socket/clean"},
+		},
+		{
+			name: "rename",
+			call: func(c *Client) (any, error) {
+				resp, err := c.RenameSocket(context.Background(), "ci", "build", true)
+				return resp.Response, err
+			},
+			response: `{"status":"success","response":{"socket":"/var/run/docker-proxy/build.sock","name":"build"}}`,
+			want:     request{method: "POST", path: "/dsp/socket/rename", query: "move=true&name=build&socket=ci"},
+			wantResp: management.RenameResponse{Socket: "/var/run/docker-proxy/build.sock", Name: "build"},
+		},
+		{
+			name: "stats of every socket",
+			call: func(c *Client) (any, error) {
+				resp, err := c.Stats(context.Background(), "")
+				return len(resp.Response.Sockets), err
+			},
+			response: `{"status":"success","response":{"sockets":[{"name":"ci","requests":3}]}}`,
+			want:     request{method: "GET", path: "/dsp/socket/stats"},
+			wantResp: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(chan request, 1)
+			paths := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Errorf("Failed to read request body: %v", err)
+				}
+				got <- request{method: r.Method, path: r.URL.Path, query: r.URL.RawQuery, body: string(body)}
+				if _, err := io.WriteString(w, tt.response); err != nil {
+					t.Errorf("Failed to write response: %v", err)
+				}
+			}))
+			paths.BasePath = "dsp"
+
+			resp, err := tt.call(New(paths))
+			if err != nil {
+				t.Fatalf("call error = %v", err)
+			}
+			if req := <-got; req != tt.want {
+				t.Errorf("request = %+v, want %+v", req, tt.want)
+			}
+			if resp != tt.wantResp {
+				t.Errorf("response = %+v, want %+v", resp, tt.wantResp)
+			}
+		})
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		statusCode  int
+		body        string
+		wantMessage string
+	}{
+		{
+			name:        "plain body",
+			statusCode:  http.StatusBadRequest,
+			body:        "error message\n",
+			wantMessage: "unexpected status code: 400, error: error message",
+		},
+		{
+			name:        "error envelope",
+			statusCode:  http.StatusNotFound,
+			body:        `{"status":"error","response":{"error":"socket not found"}}`,
+			wantMessage: "unexpected status code: 404, error: socket not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCode)
+				if _, err := io.WriteString(w, tt.body); err != nil {
+					t.Errorf("Failed to write response: %v", err)
+				}
+			}))
+
+			_, err := New(paths).DescribeSocket(context.Background(), "missing", DescribeOptions{})
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("error = %v, want an *Error", err)
+			}
+			if apiErr.StatusCode != tt.statusCode {
+				t.Errorf("StatusCode = %d, want %d", apiErr.StatusCode, tt.statusCode)
+			}
+			if err.Error() != tt.wantMessage {
+				t.Errorf("error = %q, want %q", err, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestClientToken(t *testing.T) {
+	authorization := make(chan string, 1)
+	paths := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization <- r.Header.Get("Authorization")
+		if _, err := io.WriteString(w, `{"status":"success","response":{"sockets":[]}}`); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{name: "no token", token: "", want: ""},
+		{name: "token", token: "s3cret", want: "Bearer s3cret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenPaths := *paths
+			tokenPaths.Token = tt.token
+			if _, err := New(&tokenPaths).ListSockets(context.Background(), false); err != nil {
+				t.Fatalf("ListSockets() error = %v", err)
+			}
+			if got := <-authorization; got != tt.want {
+				t.Errorf("Authorization = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
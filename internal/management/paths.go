@@ -3,18 +3,29 @@ package management
 import (
 	"fmt"
 	"os"
+	"strings"
 )
 
 const (
 	DefaultManagementSocketPath = "/var/run/docker-proxy.sock"
 	DefaultSocketDir            = "/var/run/docker-proxy/"
 	DefaultDockerSocketPath     = "/var/run/docker.sock"
+
+	// BasePathEnv names the environment variable holding the management API base path
+	BasePathEnv = "DSP_MANAGEMENT_BASE_PATH"
+
+	// TokenEnv names the environment variable holding the management API token
+	TokenEnv = "DSP_MANAGEMENT_TOKEN"
+	// TokenFileEnv names the environment variable holding a file to read the token from
+	TokenFileEnv = "DSP_MANAGEMENT_TOKEN_FILE"
 )
 
 type SocketPaths struct {
 	Management string
 	Docker     string
 	SocketDir  string // Directory for storing socket files
+	BasePath   string // Prefix for management API routes, empty for none
+	Token      string // Bearer token for the management API, empty for none
 }
 
 func NewSocketPaths() *SocketPaths {
@@ -43,3 +54,40 @@ func (p *SocketPaths) Validate() error {
 
 	return nil
 }
+
+// URL returns the management API URL for a route, honouring the base path
+func (p *SocketPaths) URL(route string) string {
+	return "http://localhost" + NormalizeBasePath(p.BasePath) + route
+}
+
+// NormalizeBasePath returns the base path with a leading slash and no
+// trailing slash, or an empty string when no prefix is configured
+func NormalizeBasePath(basePath string) string {
+	basePath = strings.Trim(basePath, "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + basePath
+}
+
+// LoadToken returns the management token, read from file when one is given.
+// A token and a token file together are refused, as is an empty token file,
+// which would otherwise silently leave the API open.
+func LoadToken(token, file string) (string, error) {
+	if file == "" {
+		return token, nil
+	}
+	if token != "" {
+		return "", fmt.Errorf("a management token and a management token file cannot both be set")
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read management token file: %w", err)
+	}
+	token = strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("management token file %s is empty", file)
+	}
+	return token, nil
+}
@@ -109,3 +109,74 @@ func validateSocketPaths(paths *SocketPaths) error {
 	}
 	return nil
 }
+
+func TestSocketPathsURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		basePath string
+		want     string
+	}{
+		{name: "no prefix", basePath: "", want: "http://localhost/socket/list"},
+		{name: "prefix", basePath: "/dsp", want: "http://localhost/dsp/socket/list"},
+		{name: "prefix without leading slash", basePath: "dsp", want: "http://localhost/dsp/socket/list"},
+		{name: "prefix with trailing slash", basePath: "/dsp/", want: "http://localhost/dsp/socket/list"},
+		{name: "root prefix", basePath: "/", want: "http://localhost/socket/list"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := &SocketPaths{BasePath: tt.basePath}
+			if got := paths.URL("/socket/list"); got != tt.want {
+				t.Errorf("URL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadToken(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	tokenFile := filepath.Join(tmpDir, "token")
+	if err := os.WriteFile(tokenFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	emptyFile := filepath.Join(tmpDir, "empty")
+	if err := os.WriteFile(emptyFile, []byte(" \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		file    string
+		want    string
+		wantErr bool
+	}{
+		{name: "no token", want: ""},
+		{name: "token", token: "s3cret", want: "s3cret"},
+		{name: "token file", file: tokenFile, want: "s3cret"},
+		{name: "token and token file", token: "s3cret", file: tokenFile, wantErr: true},
+		{name: "empty token file", file: emptyFile, wantErr: true},
+		{name: "missing token file", file: filepath.Join(tmpDir, "missing"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadToken(tt.token, tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("LoadToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
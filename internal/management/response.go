@@ -1,5 +1,7 @@
 package management
 
+import "time"
+
 // Response represents the standard API response structure
 type Response[T any] struct {
 	Status   string `json:"status"`
@@ -11,22 +13,175 @@ type CreateResponse struct {
 	Socket string `json:"socket"`
 }
 
+// UpdateResponse represents the response from updating a socket's configuration
+type UpdateResponse struct {
+	Socket string `json:"socket"`
+}
+
+// RenameResponse represents the response from renaming a socket, Socket is
+// its path afterwards, which only changes when the socket file was moved
+type RenameResponse struct {
+	Socket string `json:"socket" yaml:"socket"`
+	Name   string `json:"name" yaml:"name"`
+}
+
 // DeleteResponse represents the response from socket deletion
 type DeleteResponse struct {
 	Message string `json:"message"`
 }
 
+// ListSchemaVersion is the version of the SocketList schema. It only changes
+// when a field is removed or changes meaning.
+const ListSchemaVersion = 1
+
 // ListResponse represents the response from listing sockets
 type ListResponse struct {
-	Sockets []string `json:"sockets"`
+	Sockets       []string       `json:"sockets"`
+	Details       []SocketDetail `json:"details,omitempty"`
+	SchemaVersion int            `json:"schema_version,omitempty"`
+	Entries       []SocketEntry  `json:"entries,omitempty"`
+}
+
+// SocketList is the stable document printed by list --output json
+type SocketList struct {
+	SchemaVersion int           `json:"schema_version" yaml:"schema_version"`
+	Sockets       []SocketEntry `json:"sockets" yaml:"sockets"`
+}
+
+// SocketEntry describes a socket in the stable listing schema, every field
+// is always present
+type SocketEntry struct {
+	Name string `json:"name" yaml:"name"`
+	// DisplayName is the name the socket was created with, empty for a
+	// socket with a generated name
+	DisplayName string    `json:"display_name" yaml:"display_name"`
+	Type        string    `json:"type" yaml:"type"`
+	Address     string    `json:"address" yaml:"address"`
+	Rules       int       `json:"rules" yaml:"rules"`
+	CreatedAt   time.Time `json:"created_at" yaml:"created_at"`
+}
+
+// SocketDetail describes a socket in a detailed listing
+type SocketDetail struct {
+	Name         string     `json:"name" yaml:"name"`
+	Rules        int        `json:"rules" yaml:"rules"`
+	CreatedAt    time.Time  `json:"created_at" yaml:"created_at"`
+	LastDecision *time.Time `json:"last_decision,omitempty" yaml:"last_decision,omitempty"`
 }
 
 // DescribeResponse represents the response from describing a socket
 type DescribeResponse struct {
-	Config any `json:"config"`
+	Config any          `json:"config"`
+	Stats  *SocketStats `json:"stats,omitempty"`
+}
+
+// ExportResponse represents the response from exporting every socket
+type ExportResponse struct {
+	Sockets []ExportedSocket `json:"sockets" yaml:"sockets"`
+}
+
+// ExportedSocket is a socket's name and config, as exported for import
+// into another daemon
+type ExportedSocket struct {
+	Name   string `json:"name" yaml:"name"`
+	Config any    `json:"config" yaml:"config"`
+}
+
+// TestRequest is a hypothetical request to run through a socket's rules.
+// Path may include a query string.
+type TestRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// TestResponse is the decision a socket's rules make for a test request.
+// Rule is the index of the deciding rule, -1 when the default action decided,
+// and Matched every rule of the socket that matched. Base is set when Rule is
+// a rule of the daemon's base config. Path and Body are only set when a
+// rewrite changed them.
+type TestResponse struct {
+	Allowed bool   `json:"allowed" yaml:"allowed"`
+	Status  int    `json:"status" yaml:"status"`
+	Reason  string `json:"reason,omitempty" yaml:"reason,omitempty"`
+	Code    string `json:"code,omitempty" yaml:"code,omitempty"`
+	Rule    int    `json:"rule" yaml:"rule"`
+	Base    bool   `json:"base,omitempty" yaml:"base,omitempty"`
+	Matched []int  `json:"matched" yaml:"matched"`
+	Path    string `json:"path,omitempty" yaml:"path,omitempty"`
+	Body    string `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+// SocketStats holds the decision and per-rule hit counters of a socket. Rule
+// hits are counted since Since, when the socket's config was last set or its
+// stats were reset.
+type SocketStats struct {
+	Since        time.Time  `json:"since" yaml:"since"`
+	Allowed      uint64     `json:"allowed" yaml:"allowed"`
+	Denied       uint64     `json:"denied" yaml:"denied"`
+	Rewritten    uint64     `json:"rewritten" yaml:"rewritten"`
+	ShadowDenied uint64     `json:"shadow_denied" yaml:"shadow_denied"`
+	Rules        []RuleStat `json:"rules" yaml:"rules"`
+}
+
+// LogEntry is a decision made by a socket, as streamed by the logs route.
+// Shadow is set on a deny that was forwarded anyway in shadow mode.
+type LogEntry struct {
+	Time     time.Time `json:"time" yaml:"time"`
+	Method   string    `json:"method" yaml:"method"`
+	Path     string    `json:"path" yaml:"path"`
+	Decision string    `json:"decision" yaml:"decision"`
+	Reason   string    `json:"reason,omitempty" yaml:"reason,omitempty"`
+	Shadow   bool      `json:"shadow,omitempty" yaml:"shadow,omitempty"`
+}
+
+// RuleStat is the hit counter of a single rule
+type RuleStat struct {
+	Index  int    `json:"index" yaml:"index"`
+	Path   string `json:"path" yaml:"path"`
+	Method string `json:"method,omitempty" yaml:"method,omitempty"`
+	Hits   uint64 `json:"hits" yaml:"hits"`
+}
+
+// StatsResponse represents the response from the stats route
+type StatsResponse struct {
+	Sockets []SocketCounters `json:"sockets" yaml:"sockets"`
+}
+
+// SocketCounters are the decision counters of a socket, counted since it was
+// created or its stats were last reset. Requests is every allowed or denied
+// request. ShadowDenied counts the denies forwarded anyway in shadow mode,
+// which Denied leaves out, and LastRequest is unset until the socket has
+// made a decision.
+type SocketCounters struct {
+	Name         string     `json:"name" yaml:"name"`
+	Requests     uint64     `json:"requests" yaml:"requests"`
+	Allowed      uint64     `json:"allowed" yaml:"allowed"`
+	Denied       uint64     `json:"denied" yaml:"denied"`
+	Rewritten    uint64     `json:"rewritten" yaml:"rewritten"`
+	ShadowDenied uint64     `json:"shadow_denied" yaml:"shadow_denied"`
+	LastRequest  *time.Time `json:"last_request,omitempty" yaml:"last_request,omitempty"`
+}
+
+// StatsResetResponse represents the response from resetting socket stats
+type StatsResetResponse struct {
+	Sockets []string `json:"sockets" yaml:"sockets"`
+}
+
+// HealthResponse represents the response from a passing health check
+type HealthResponse struct {
+	Status   string `json:"status" yaml:"status"`
+	Upstream string `json:"upstream" yaml:"upstream"`
+}
+
+// LogLevelResponse represents the response from changing the log level
+type LogLevelResponse struct {
+	Level string `json:"level" yaml:"level"`
 }
 
-// ErrorResponse represents an error response
+// ErrorResponse represents an error response, Code repeats its HTTP status
 type ErrorResponse struct {
 	Error string `json:"error"`
+	Code  int    `json:"code"`
 }
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// APIVersion is a Docker API version such as 1.41
+type APIVersion struct {
+	Major int
+	Minor int
+}
+
+func (v APIVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// Less reports whether v is older than o
+func (v APIVersion) Less(o APIVersion) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	return v.Minor < o.Minor
+}
+
+// ParseAPIVersion parses a version given as MAJOR.MINOR
+func ParseAPIVersion(s string) (APIVersion, error) {
+	major, minor, ok := strings.Cut(s, ".")
+	if !ok {
+		return APIVersion{}, fmt.Errorf("invalid API version %q, expected MAJOR.MINOR", s)
+	}
+	var v APIVersion
+	var err error
+	if v.Major, err = parseVersionNumber(major); err != nil {
+		return APIVersion{}, fmt.Errorf("invalid API version %q, expected MAJOR.MINOR", s)
+	}
+	if v.Minor, err = parseVersionNumber(minor); err != nil {
+		return APIVersion{}, fmt.Errorf("invalid API version %q, expected MAJOR.MINOR", s)
+	}
+	return v, nil
+}
+
+// parseVersionNumber parses one part of a version, which is only digits
+func parseVersionNumber(s string) (int, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid version number %q", s)
+	}
+	return strconv.Atoi(s)
+}
+
+// PathAPIVersion returns the API version that a request path starts with, as
+// in /v1.41/containers/json. ok is false for a path without a version, and a
+// first segment that starts like a version but is not one is an error.
+func PathAPIVersion(path string) (version APIVersion, ok bool, err error) {
+	segment, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if len(segment) < 2 || segment[0] != 'v' || segment[1] < '0' || segment[1] > '9' {
+		return APIVersion{}, false, nil
+	}
+	version, err = ParseAPIVersion(segment[1:])
+	if err != nil {
+		return APIVersion{}, false, fmt.Errorf("invalid API version in path %q", path)
+	}
+	return version, true, nil
+}
+
+// checkAPIVersions validates the API version range of a config
+func (c ConfigSet) checkAPIVersions() error {
+	var minVersion, maxVersion APIVersion
+	var err error
+	if c.MinAPIVersion != "" {
+		if minVersion, err = ParseAPIVersion(c.MinAPIVersion); err != nil {
+			return fmt.Errorf("min_api_version: %w", err)
+		}
+	}
+	if c.MaxAPIVersion != "" {
+		if maxVersion, err = ParseAPIVersion(c.MaxAPIVersion); err != nil {
+			return fmt.Errorf("max_api_version: %w", err)
+		}
+	}
+	if c.MinAPIVersion != "" && c.MaxAPIVersion != "" && maxVersion.Less(minVersion) {
+		return fmt.Errorf("max_api_version %s is older than min_api_version %s", c.MaxAPIVersion, c.MinAPIVersion)
+	}
+	if c.RequireAPIVersion && c.MinAPIVersion == "" && c.MaxAPIVersion == "" {
+		return fmt.Errorf("require_api_version needs min_api_version or max_api_version")
+	}
+	return nil
+}
+
+// CheckAPIVersion returns why a request path is outside the config's API
+// version range, or an empty string when it is within it. Paths without a
+// version pass unless require_api_version is set.
+func (c ConfigSet) CheckAPIVersion(path string) string {
+	if c.MinAPIVersion == "" && c.MaxAPIVersion == "" {
+		return ""
+	}
+
+	version, ok, err := PathAPIVersion(path)
+	if err != nil {
+		return err.Error()
+	}
+	if !ok {
+		if c.RequireAPIVersion {
+			return "request path has no API version, this socket requires one"
+		}
+		return ""
+	}
+
+	if minVersion, err := ParseAPIVersion(c.MinAPIVersion); err == nil && version.Less(minVersion) {
+		return fmt.Sprintf("API version %s is older than the minimum %s", version, minVersion)
+	}
+	if maxVersion, err := ParseAPIVersion(c.MaxAPIVersion); err == nil && maxVersion.Less(version) {
+		return fmt.Sprintf("API version %s is newer than the maximum %s", version, maxVersion)
+	}
+	return ""
+}
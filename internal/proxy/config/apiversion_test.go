@@ -0,0 +1,106 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPathAPIVersion(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    APIVersion
+		wantOK  bool
+		wantErr bool
+	}{
+		{path: "/v1.41/containers/json", want: APIVersion{1, 41}, wantOK: true},
+		{path: "/v1.9/info", want: APIVersion{1, 9}, wantOK: true},
+		{path: "/v2.0", want: APIVersion{2, 0}, wantOK: true},
+		{path: "/containers/json", wantOK: false},
+		{path: "/version", wantOK: false},
+		{path: "/volumes/create", wantOK: false},
+		{path: "/", wantOK: false},
+		{path: "", wantOK: false},
+		{path: "/v1/containers/json", wantErr: true},
+		{path: "/v1./containers/json", wantErr: true},
+		{path: "/v1.x/containers/json", wantErr: true},
+		{path: "/v1.41.2/containers/json", wantErr: true},
+		{path: "/v1.-1/containers/json", wantErr: true},
+		{path: "/v1.4a/containers/json", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok, err := PathAPIVersion(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PathAPIVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("PathAPIVersion() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCheckAPIVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     ConfigSet
+		path       string
+		wantReason string
+	}{
+		{name: "no range", config: ConfigSet{}, path: "/v1.12/info"},
+		{name: "within the range", config: ConfigSet{MinAPIVersion: "1.41", MaxAPIVersion: "1.45"}, path: "/v1.43/info"},
+		{name: "minimum is inclusive", config: ConfigSet{MinAPIVersion: "1.41"}, path: "/v1.41/info"},
+		{name: "older than the minimum", config: ConfigSet{MinAPIVersion: "1.41"}, path: "/v1.24/info", wantReason: "API version 1.24 is older than the minimum 1.41"},
+		{name: "minor versions compare as numbers", config: ConfigSet{MinAPIVersion: "1.9"}, path: "/v1.10/info"},
+		{name: "newer than the maximum", config: ConfigSet{MaxAPIVersion: "1.43"}, path: "/v1.44/info", wantReason: "API version 1.44 is newer than the maximum 1.43"},
+		{name: "unversioned passes", config: ConfigSet{MinAPIVersion: "1.41"}, path: "/_ping"},
+		{name: "unversioned required", config: ConfigSet{MinAPIVersion: "1.41", RequireAPIVersion: true}, path: "/_ping", wantReason: "has no API version"},
+		{name: "malformed version", config: ConfigSet{MinAPIVersion: "1.41"}, path: "/v1.x/info", wantReason: "invalid API version"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := tt.config.CheckAPIVersion(tt.path)
+			if tt.wantReason == "" {
+				if reason != "" {
+					t.Errorf("CheckAPIVersion() = %q, want none", reason)
+				}
+				return
+			}
+			if !strings.Contains(reason, tt.wantReason) {
+				t.Errorf("CheckAPIVersion() = %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestValidateAPIVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ConfigSet
+		wantErr string
+	}{
+		{name: "range", config: ConfigSet{MinAPIVersion: "1.41", MaxAPIVersion: "1.45", RequireAPIVersion: true}},
+		{name: "invalid minimum", config: ConfigSet{MinAPIVersion: "v1.41"}, wantErr: "min_api_version"},
+		{name: "invalid maximum", config: ConfigSet{MaxAPIVersion: "1"}, wantErr: "max_api_version"},
+		{name: "inverted range", config: ConfigSet{MinAPIVersion: "1.45", MaxAPIVersion: "1.41"}, wantErr: "older than min_api_version"},
+		{name: "required without a range", config: ConfigSet{RequireAPIVersion: true}, wantErr: "require_api_version"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &SocketConfig{Config: tt.config, Rules: []Rule{{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}}}}
+			err := ValidateConfig(cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateConfig() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateConfig() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -4,34 +4,381 @@ import (
 	"docker-socket-proxy/internal/logging"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"net"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // SocketConfig represents the socket configuration
 type SocketConfig struct {
+	// Name is the name the socket was created with, kept with its stored
+	// config so that it survives restarts. Sockets created without a name
+	// have a generated file name and no Name.
+	Name   string    `json:"name,omitempty" yaml:"name,omitempty"`
 	Config ConfigSet `json:"config" yaml:"config"`
-	Rules  []Rule    `json:"rules" yaml:"rules"`
+	// Profiles names built-in rule bundles that are expanded ahead of Rules
+	// when the config is loaded
+	Profiles []string `json:"profiles,omitempty" yaml:"profiles,omitempty"`
+	// Includes names files whose rules are spliced ahead of Rules when the
+	// config file is read, relative paths resolving against that file
+	Includes []string `json:"includes,omitempty" yaml:"includes,omitempty"`
+	Rules    []Rule   `json:"rules" yaml:"rules"`
 }
 
 type ConfigSet struct {
 	PropagateSocket string `json:"propagate_socket" yaml:"propagate_socket"`
+	// AllowLogSampleRate caps allow log lines per second for the socket at info, 0 logs allows at debug only
+	AllowLogSampleRate int `json:"allow_log_sample_rate,omitempty" yaml:"allow_log_sample_rate,omitempty"`
+	// MaxConnections caps simultaneously open client connections, 0 means unlimited
+	MaxConnections int `json:"max_connections,omitempty" yaml:"max_connections,omitempty"`
+	// DefaultAction decides requests that no allow or deny action decides,
+	// "allow" (the default) or "deny"
+	DefaultAction string `json:"default_action,omitempty" yaml:"default_action,omitempty"`
+	// Mode is "enforce" (the default), or "shadow" to log the requests the
+	// rules deny and forward them to the Docker daemon anyway
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty"`
+	// AuditLog is the path of a JSON lines file every decision is appended
+	// to, rotated to <path>.1 once it reaches AuditLogMaxSize bytes
+	AuditLog        string `json:"audit_log,omitempty" yaml:"audit_log,omitempty"`
+	AuditLogMaxSize int64  `json:"audit_log_max_size,omitempty" yaml:"audit_log_max_size,omitempty"`
+	// AccessLog logs the status, size and duration of every response the
+	// socket sends, whether the proxy or the Docker daemon wrote it
+	AccessLog bool `json:"access_log,omitempty" yaml:"access_log,omitempty"`
+	// SocketMode is the octal permission mode of the socket file, 0660 by
+	// default, and SocketGroup the group name or gid that owns it
+	SocketMode  string `json:"socket_mode,omitempty" yaml:"socket_mode,omitempty"`
+	SocketGroup string `json:"socket_group,omitempty" yaml:"socket_group,omitempty"`
+	// Profile names a built-in access profile whose allow rules are expanded
+	// ahead of the config's rules, with everything else denied by default
+	Profile string `json:"profile,omitempty" yaml:"profile,omitempty"`
+	// DebugHeaders adds the index of the deciding rule to denied responses
+	DebugHeaders bool `json:"debug_headers,omitempty" yaml:"debug_headers,omitempty"`
+	// UpstreamTimeout bounds connecting to the Docker daemon and waiting for
+	// its response headers, as a duration such as "30s"
+	UpstreamTimeout string `json:"upstream_timeout,omitempty" yaml:"upstream_timeout,omitempty"`
+	// UpstreamRetries retries GET and HEAD requests that could not connect
+	// to the Docker daemon up to this many times, with a growing backoff,
+	// so that a daemon restart does not fail them. 0 never retries.
+	UpstreamRetries int `json:"upstream_retries,omitempty" yaml:"upstream_retries,omitempty"`
+	// TTL deletes the socket once it has existed this long, and IdleTimeout
+	// once it has gone this long without a request, both as durations such
+	// as "2h". Either left empty never expires the socket.
+	TTL         string `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	IdleTimeout string `json:"idle_timeout,omitempty" yaml:"idle_timeout,omitempty"`
+	// InjectSocketHeader sets a header naming the proxy socket on requests
+	// forwarded to the Docker daemon. SocketHeaderName overrides the header,
+	// and OverwriteSocketHeader replaces a value the client already sent.
+	InjectSocketHeader    bool   `json:"inject_socket_header,omitempty" yaml:"inject_socket_header,omitempty"`
+	SocketHeaderName      string `json:"socket_header_name,omitempty" yaml:"socket_header_name,omitempty"`
+	OverwriteSocketHeader bool   `json:"overwrite_socket_header,omitempty" yaml:"overwrite_socket_header,omitempty"`
+	// Listen serves the socket on a TCP address, tcp://host:port, instead of
+	// a socket file
+	Listen string `json:"listen,omitempty" yaml:"listen,omitempty"`
+	// MinAPIVersion and MaxAPIVersion bound the Docker API version that
+	// request paths may use, as MAJOR.MINOR. Paths without a version pass
+	// unless RequireAPIVersion is set.
+	MinAPIVersion     string `json:"min_api_version,omitempty" yaml:"min_api_version,omitempty"`
+	MaxAPIVersion     string `json:"max_api_version,omitempty" yaml:"max_api_version,omitempty"`
+	RequireAPIVersion bool   `json:"require_api_version,omitempty" yaml:"require_api_version,omitempty"`
+	// MaxBodyBytes caps the size of request bodies that are read to match or
+	// rewrite them, DefaultMaxBodyBytes when 0
+	MaxBodyBytes int64 `json:"max_body_bytes,omitempty" yaml:"max_body_bytes,omitempty"`
+	// BodyMethods are the request methods whose bodies are read to match or
+	// rewrite them, DefaultBodyMethods when empty
+	BodyMethods []string `json:"body_methods,omitempty" yaml:"body_methods,omitempty"`
+	// TrustedProxies are the CIDRs of proxies in front of a TCP socket whose
+	// X-Forwarded-For header source_cidr believes
+	TrustedProxies []string `json:"trusted_proxies,omitempty" yaml:"trusted_proxies,omitempty"`
+}
+
+// ListenAddress returns the host:port of a tcp:// listen address, or an
+// empty string when the socket is served from a socket file
+func (c ConfigSet) ListenAddress() (string, error) {
+	if c.Listen == "" {
+		return "", nil
+	}
+	address, ok := strings.CutPrefix(c.Listen, "tcp://")
+	if !ok {
+		return "", fmt.Errorf("invalid listen address %q, expected tcp://host:port", c.Listen)
+	}
+	if _, port, err := net.SplitHostPort(address); err != nil || port == "" {
+		return "", fmt.Errorf("invalid listen address %q, expected tcp://host:port", c.Listen)
+	}
+	return address, nil
+}
+
+// DefaultMaxBodyBytes is the request body limit when max_body_bytes is not set
+const DefaultMaxBodyBytes = 4 << 20
+
+// MaxBodySize returns the largest request body that is read for inspection
+func (c ConfigSet) MaxBodySize() int64 {
+	if c.MaxBodyBytes <= 0 {
+		return DefaultMaxBodyBytes
+	}
+	return c.MaxBodyBytes
+}
+
+// DefaultBodyMethods are the methods whose bodies are read when
+// body_methods is not set
+var DefaultBodyMethods = []string{"POST", "PUT"}
+
+// ReadsBody reports whether the bodies of requests with a method are read
+// to match or rewrite them
+func (c ConfigSet) ReadsBody(method string) bool {
+	methods := c.BodyMethods
+	if len(methods) == 0 {
+		methods = DefaultBodyMethods
+	}
+	return slices.Contains(methods, method)
+}
+
+// DefaultSocketHeaderName is the header naming the proxy socket when
+// socket_header_name is not set
+const DefaultSocketHeaderName = "X-Docker-Proxy-Socket"
+
+// SocketHeader returns the name of the header injected into forwarded requests
+func (c ConfigSet) SocketHeader() string {
+	if c.SocketHeaderName == "" {
+		return DefaultSocketHeaderName
+	}
+	return c.SocketHeaderName
+}
+
+// validHeaderName reports whether name is a valid HTTP header field name
+func validHeaderName(name string) bool {
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", r):
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+// DefaultUpstreamTimeout is the upstream timeout when upstream_timeout is not set
+const DefaultUpstreamTimeout = 30 * time.Second
+
+// UpstreamTimeoutDuration returns the parsed upstream timeout, or the default
+// when it is not set or not a valid duration
+func (c ConfigSet) UpstreamTimeoutDuration() time.Duration {
+	timeout, err := time.ParseDuration(c.UpstreamTimeout)
+	if err != nil || timeout <= 0 {
+		return DefaultUpstreamTimeout
+	}
+	return timeout
+}
+
+// TTLDuration returns the parsed ttl, or zero when the socket does not expire
+func (c ConfigSet) TTLDuration() time.Duration {
+	return optionalDuration(c.TTL)
+}
+
+// IdleTimeoutDuration returns the parsed idle_timeout, or zero when the
+// socket does not expire however long it goes unused
+func (c ConfigSet) IdleTimeoutDuration() time.Duration {
+	return optionalDuration(c.IdleTimeout)
+}
+
+// optionalDuration parses a duration that is off when empty or not positive
+func optionalDuration(value string) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0
+	}
+	return duration
+}
+
+// Default actions
+const (
+	DefaultActionAllow = "allow"
+	DefaultActionDeny  = "deny"
+)
+
+// Socket modes
+const (
+	ModeEnforce = "enforce"
+	ModeShadow  = "shadow"
+)
+
+// Shadow reports whether the socket forwards the requests its rules deny
+func (c ConfigSet) Shadow() bool {
+	return c.Mode == ModeShadow
+}
+
+// DeniesByDefault reports whether requests that no rule decides are denied
+func (c *SocketConfig) DeniesByDefault() bool {
+	return c != nil && c.Config.DefaultAction == DefaultActionDeny
+}
+
+// denyByDefault reports whether the config denies by default once its
+// access profile, which defaults it to deny, is expanded
+func (c *SocketConfig) denyByDefault() bool {
+	return c.DeniesByDefault() || (c.Config.DefaultAction == "" && c.Config.Profile != "")
+}
+
+// RuleOrder returns the indexes of the rules in the order they are
+// evaluated, by descending priority and then by position
+func (c *SocketConfig) RuleOrder() []int {
+	if c == nil {
+		return nil
+	}
+	order := make([]int, len(c.Rules))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return c.Rules[order[a]].Priority > c.Rules[order[b]].Priority
+	})
+	return order
+}
+
+// EffectiveRule is a rule as the proxy evaluates it. Index is its position
+// in the config's rules, or -1 for a rule the proxy adds itself, such as the
+// propagation rule, which only rewrites and never decides a request. Base is
+// set for a rule of the daemon's base config, Index is then its position there.
+type EffectiveRule struct {
+	Rule
+	Index int
+	Base  bool
+}
+
+// EffectiveRules returns the rules the proxy evaluates for a request, in the
+// order it evaluates them: the propagation rules, then the config's rules by
+// priority. Profiles are expanded when a config is loaded, so their rules are
+// among the config's.
+func (c *SocketConfig) EffectiveRules() []EffectiveRule {
+	return c.EffectiveRulesWithBase(nil)
+}
+
+// EffectiveRulesWithBase is EffectiveRules with the rules of a base config,
+// by their own priority, between the propagation rules and the config's.
+// Priorities never move a rule of the config ahead of the base rules.
+func (c *SocketConfig) EffectiveRulesWithBase(base *SocketConfig) []EffectiveRule {
+	if c == nil {
+		return nil
+	}
+	propagation := c.GetPropagationRules()
+	var baseRules []Rule
+	if base != nil {
+		baseRules = base.Rules
+	}
+	rules := make([]EffectiveRule, 0, len(propagation)+len(baseRules)+len(c.Rules))
+	for _, rule := range propagation {
+		rules = append(rules, EffectiveRule{Rule: rule, Index: -1})
+	}
+	if base != nil {
+		for _, i := range base.RuleOrder() {
+			rules = append(rules, EffectiveRule{Rule: base.Rules[i], Index: i, Base: true})
+		}
+	}
+	for _, i := range c.RuleOrder() {
+		rules = append(rules, EffectiveRule{Rule: c.Rules[i], Index: i})
+	}
+	return rules
+}
+
+// EffectiveConfig returns a copy of the config as the proxy evaluates it,
+// with its rules in EffectiveRules order and the default action spelled out
+func (c *SocketConfig) EffectiveConfig() *SocketConfig {
+	return c.EffectiveConfigWithBase(nil)
+}
+
+// EffectiveConfigWithBase is EffectiveConfig with the rules of a base config
+// in front of the config's, see EffectiveRulesWithBase
+func (c *SocketConfig) EffectiveConfigWithBase(base *SocketConfig) *SocketConfig {
+	if c == nil {
+		return nil
+	}
+	effective := *c
+	rules := c.EffectiveRulesWithBase(base)
+	effective.Rules = make([]Rule, 0, len(rules))
+	for _, rule := range rules {
+		effective.Rules = append(effective.Rules, rule.Rule)
+	}
+	effective.Config.DefaultAction = DefaultActionAllow
+	if c.DeniesByDefault() {
+		effective.Config.DefaultAction = DefaultActionDeny
+	}
+	return &effective
 }
 
 // Rule represents a rule in the new format
 type Rule struct {
 	Match   Match    `json:"match" yaml:"match"`
 	Actions []Action `json:"actions" yaml:"actions"`
+	// Priority orders rules before they are evaluated, higher first. Rules
+	// of the same priority, including the default 0, keep their file order.
+	Priority int `json:"priority,omitempty" yaml:"priority,omitempty"`
+}
+
+// NeedsBody reports whether evaluating the rule reads the request body, to
+// match its fields or to rewrite them
+func (r Rule) NeedsBody() bool {
+	if r.Match.NeedsBody() {
+		return true
+	}
+	for _, action := range r.Actions {
+		if action.Phase == PhaseResponse {
+			continue
+		}
+		switch action.Action {
+		case "upsert", "replace", "delete":
+			return true
+		case "deny":
+			if len(action.Contains) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NeedsBody reports whether the match reads the request body, through
+// contains, image or body_regex
+func (m Match) NeedsBody() bool {
+	return len(m.Contains) > 0 || m.Image != "" || m.BodyRegex != ""
 }
 
 // Match represents a match criteria
 type Match struct {
-	Path     string         `json:"path" yaml:"path"`
-	Method   string         `json:"method" yaml:"method"`
-	Contains map[string]any `json:"contains,omitempty" yaml:"contains,omitempty"`
+	Path   string `json:"path" yaml:"path"`
+	Method string `json:"method" yaml:"method"`
+	// MatchMode selects how Path and Method are read, as regexes (the
+	// default) or as globs that must match the whole path or method
+	MatchMode string         `json:"match_mode,omitempty" yaml:"match_mode,omitempty"`
+	Contains  map[string]any `json:"contains,omitempty" yaml:"contains,omitempty"`
+	// RequireIdentity matches requests that arrived without any caller identity
+	RequireIdentity bool `json:"require_identity,omitempty" yaml:"require_identity,omitempty"`
+	// PeerUID and PeerGID match the unix peer credentials of the caller. A
+	// request without peer credentials never matches a rule that sets them.
+	PeerUID *uint32 `json:"peer_uid,omitempty" yaml:"peer_uid,omitempty"`
+	PeerGID *uint32 `json:"peer_gid,omitempty" yaml:"peer_gid,omitempty"`
+	// SourceCIDR matches TCP clients whose address is within one of the
+	// CIDRs. It does not restrict requests over a unix socket.
+	SourceCIDR []string `json:"source_cidr,omitempty" yaml:"source_cidr,omitempty"`
+	// Image is a regex matched against the image of a container create or image pull
+	Image string `json:"image,omitempty" yaml:"image,omitempty"`
+	// Headers maps header names to regexes that the header value must match
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// Query maps query parameters to a regex for their value, or to a
+	// structure matched against the parameter's JSON value
+	Query map[string]any `json:"query,omitempty" yaml:"query,omitempty"`
+	// RawQuery is a regex matched against the whole decoded query string
+	RawQuery string `json:"raw_query,omitempty" yaml:"raw_query,omitempty"`
+	// BodyRegex is a regex matched against the raw request body, whatever
+	// its content type, such as the tar stream of a build context
+	BodyRegex string `json:"body_regex,omitempty" yaml:"body_regex,omitempty"`
 }
 
 // Action represents an action to take
@@ -40,15 +387,118 @@ type Action struct {
 	Reason   string         `json:"reason,omitempty" yaml:"reason,omitempty"`
 	Contains map[string]any `json:"contains,omitempty" yaml:"contains,omitempty"`
 	Update   map[string]any `json:"update,omitempty" yaml:"update,omitempty"`
+	// Template sets fields of the body from values computed for each request,
+	// see RenderTemplate. Only upsert and replace actions use it.
+	Template map[string]string `json:"template,omitempty" yaml:"template,omitempty"`
+	// ArrayKey names the field that identifies objects in arrays the update
+	// or template merges into, such as Target for HostConfig.Mounts, so that
+	// an object with the same key is replaced rather than added again
+	ArrayKey string `json:"array_key,omitempty" yaml:"array_key,omitempty"`
+	// Phase selects whether a rewrite applies to the request (default) or the response body
+	Phase string `json:"phase,omitempty" yaml:"phase,omitempty"`
+	// Limit and Window configure a ratelimit action, allowing Limit requests
+	// per Window (a duration such as "1m")
+	Limit  int    `json:"limit,omitempty" yaml:"limit,omitempty"`
+	Window string `json:"window,omitempty" yaml:"window,omitempty"`
+	// Pattern and Replacement configure a rewrite-path action, replacing
+	// matches of the Pattern regex in the request path. Replacement may refer
+	// to capture groups as $1 or ${name}.
+	Pattern     string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	Replacement string `json:"replacement,omitempty" yaml:"replacement,omitempty"`
+	// StatusCode overrides the 403 a deny action responds with, e.g. 404 to
+	// hide that an endpoint exists
+	StatusCode int `json:"status_code,omitempty" yaml:"status_code,omitempty"`
+	// Code is a stable identifier for a deny, such as PRIVILEGED_DENIED, sent
+	// back with the denial so that clients need not match on the reason
+	Code string `json:"code,omitempty" yaml:"code,omitempty"`
+	// Log writes a log line whenever the action applies to a request, with
+	// LogMessage as its message at LogLevel (info by default)
+	Log        bool   `json:"log,omitempty" yaml:"log,omitempty"`
+	LogMessage string `json:"log_message,omitempty" yaml:"log_message,omitempty"`
+	LogLevel   string `json:"log_level,omitempty" yaml:"log_level,omitempty"`
 }
 
+// reasonCodePattern is what a deny action's code must look like, so that it
+// is safe in a header and easy to group by
+var reasonCodePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,63}$`)
+
+// DefaultActionLogMessage is the message of an action's log line when it
+// does not set one
+const DefaultActionLogMessage = "Rule action applied"
+
+// ActionLogLevel returns the level of the action's log line, info when it
+// is not set or not a valid level
+func (a Action) ActionLogLevel() slog.Level {
+	if a.LogLevel == "" {
+		return slog.LevelInfo
+	}
+	level, err := logging.ParseLevel(a.LogLevel)
+	if err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// RateWindow returns the parsed window of a ratelimit action, or zero if it
+// is not a valid duration
+func (a Action) RateWindow() time.Duration {
+	window, err := time.ParseDuration(a.Window)
+	if err != nil {
+		return 0
+	}
+	return window
+}
+
+// Action phases
+const (
+	PhaseRequest  = "request"
+	PhaseResponse = "response"
+)
+
 // LoadSocketConfig loads a socket configuration from a file
 func LoadSocketConfig(configPath string) (*SocketConfig, error) {
+	config, err := ReadSocketConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ValidateConfig(config); err != nil {
+		return nil, fmt.Errorf("validating config: %w", err)
+	}
+	if err := ExpandProfiles(config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// ReadSocketConfig parses a socket configuration file without validating
+// it, resolving the files it includes
+func ReadSocketConfig(configPath string) (*SocketConfig, error) {
+	config, err := parseConfigFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := resolveIncludes(config, configPath, nil); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// parseConfigFile parses a single socket configuration file, once its
+// environment variable placeholders are resolved
+func parseConfigFile(configPath string) (*SocketConfig, error) {
 	data, err := os.ReadFile(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("reading config file: %w", err)
 	}
 
+	// Placeholders are resolved in the text, so they work in JSON and YAML alike
+	data, err = interpolateEnv(data, os.LookupEnv)
+	if err != nil {
+		return nil, fmt.Errorf("interpolating environment variables in %s: %w", configPath, err)
+	}
+
 	var config SocketConfig
 
 	// Determine if the file is YAML or JSON based on extension
@@ -66,58 +516,306 @@ func LoadSocketConfig(configPath string) (*SocketConfig, error) {
 		return nil, fmt.Errorf("unsupported config file extension: %s", filepath.Ext(configPath))
 	}
 
+	return &config, nil
+}
+
+// ParseSocketConfig parses and validates a socket configuration given as
+// JSON or YAML, for configs that do not come from a file
+func ParseSocketConfig(data []byte) (*SocketConfig, error) {
+	var config SocketConfig
+
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON config: %w", err)
+		}
+	} else {
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("failed to parse YAML config: %w", err)
+		}
+	}
+
 	if err := ValidateConfig(&config); err != nil {
 		return nil, fmt.Errorf("validating config: %w", err)
 	}
+	if err := ExpandProfiles(&config); err != nil {
+		return nil, err
+	}
 
 	return &config, nil
 }
 
-// ValidateConfig validates the socket configuration
+// ValidationError describes a single problem with a socket configuration.
+// Rule and Action are -1 when the problem is not specific to one.
+type ValidationError struct {
+	Rule    int    `json:"rule" yaml:"rule"`
+	Action  int    `json:"action" yaml:"action"`
+	Message string `json:"message" yaml:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	switch {
+	case e.Rule >= 0 && e.Action >= 0:
+		return fmt.Sprintf("rule %d, action %d: %s", e.Rule, e.Action, e.Message)
+	case e.Rule >= 0:
+		return fmt.Sprintf("rule %d: %s", e.Rule, e.Message)
+	default:
+		return e.Message
+	}
+}
+
+// configError returns a problem with the config as a whole
+func configError(format string, args ...any) *ValidationError {
+	return &ValidationError{Rule: -1, Action: -1, Message: fmt.Sprintf(format, args...)}
+}
+
+// ruleError returns a problem with a rule
+func ruleError(rule int, format string, args ...any) *ValidationError {
+	return &ValidationError{Rule: rule, Action: -1, Message: fmt.Sprintf(format, args...)}
+}
+
+// actionError returns a problem with an action of a rule
+func actionError(rule, action int, format string, args ...any) *ValidationError {
+	return &ValidationError{Rule: rule, Action: action, Message: fmt.Sprintf(format, args...)}
+}
+
+// ValidateConfig validates the socket configuration, returning the first problem found
 func ValidateConfig(config *SocketConfig) error {
+	if errs := ValidationErrors(config); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// ValidationErrors returns every problem with the socket configuration, in
+// rule and action order
+func ValidationErrors(config *SocketConfig) []*ValidationError {
 	if config == nil {
-		return fmt.Errorf("config is nil")
+		return []*ValidationError{configError("config is nil")}
+	}
+
+	var errs []*ValidationError
+
+	if config.Config.AllowLogSampleRate < 0 {
+		errs = append(errs, configError("allow_log_sample_rate cannot be negative"))
+	}
+	if config.Config.MaxConnections < 0 {
+		errs = append(errs, configError("max_connections cannot be negative"))
+	}
+	switch config.Config.DefaultAction {
+	case "", DefaultActionAllow, DefaultActionDeny:
+	default:
+		errs = append(errs, configError("invalid default_action: %s (must be allow or deny)", config.Config.DefaultAction))
+	}
+	switch config.Config.Mode {
+	case "", ModeEnforce, ModeShadow:
+	default:
+		errs = append(errs, configError("invalid mode: %s (must be enforce or shadow)", config.Config.Mode))
+	}
+	if config.Config.AuditLog != "" && !filepath.IsAbs(config.Config.AuditLog) {
+		errs = append(errs, configError("audit_log must be an absolute path"))
+	}
+	if len(config.Includes) > 0 {
+		errs = append(errs, configError("includes are only supported in config files"))
+	}
+	if config.Config.MaxBodyBytes < 0 {
+		errs = append(errs, configError("max_body_bytes cannot be negative"))
+	}
+	for _, method := range config.Config.BodyMethods {
+		if !validHeaderName(method) || method != strings.ToUpper(method) {
+			errs = append(errs, configError("invalid body_methods entry %q, expected an upper case HTTP method", method))
+		}
+	}
+	if _, err := ParseCIDRs(config.Config.TrustedProxies); err != nil {
+		errs = append(errs, configError("invalid trusted_proxies: %v", err))
+	}
+	if config.Config.AuditLogMaxSize < 0 {
+		errs = append(errs, configError("audit_log_max_size cannot be negative"))
+	}
+	if config.Config.UpstreamRetries < 0 {
+		errs = append(errs, configError("upstream_retries cannot be negative"))
+	}
+	for _, setting := range []struct{ name, value string }{
+		{"upstream_timeout", config.Config.UpstreamTimeout},
+		{"ttl", config.Config.TTL},
+		{"idle_timeout", config.Config.IdleTimeout},
+	} {
+		if setting.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(setting.value)
+		if err != nil {
+			errs = append(errs, configError("invalid %s %q: %v", setting.name, setting.value, err))
+		} else if duration <= 0 {
+			errs = append(errs, configError("%s must be positive", setting.name))
+		}
+	}
+	if name := config.Config.SocketHeaderName; name != "" && !validHeaderName(name) {
+		errs = append(errs, configError("invalid socket_header_name %q", name))
+	}
+	if err := config.Config.checkAPIVersions(); err != nil {
+		errs = append(errs, configError("%v", err))
+	}
+	if config.Config.Listen != "" {
+		if _, err := config.Config.ListenAddress(); err != nil {
+			errs = append(errs, configError("%v", err))
+		}
+		if config.Config.SocketMode != "" || config.Config.SocketGroup != "" {
+			errs = append(errs, configError("socket_mode and socket_group do not apply to a socket with a listen address"))
+		}
+		// Anything that can reach the port can use the socket, so it may
+		// only let through what its rules allow
+		if !config.denyByDefault() {
+			errs = append(errs, configError("a socket with a listen address must set default_action: deny"))
+		}
+	}
+	if _, err := config.Config.SocketFileMode(); err != nil {
+		errs = append(errs, configError("%v", err))
+	}
+	if _, _, err := config.Config.SocketGroupID(); err != nil {
+		errs = append(errs, configError("%v", err))
+	}
+
+	for _, name := range config.Profiles {
+		if _, ok := profiles[name]; !ok {
+			errs = append(errs, configError("%v", unknownProfileError(name)))
+		}
 	}
 
-	// Validate rules
-	if len(config.Rules) == 0 {
-		return fmt.Errorf("at least one rule is required")
+	if name := config.Config.Profile; name != "" {
+		if _, ok := accessProfiles[name]; !ok {
+			errs = append(errs, configError("%v", unknownAccessProfileError(name)))
+		}
+	}
+
+	// Validate rules, a config may consist of profiles alone
+	if len(config.Rules) == 0 && len(config.Profiles) == 0 && config.Config.Profile == "" {
+		errs = append(errs, configError("at least one rule is required"))
 	}
 
 	// Validate each rule
 	for i, rule := range config.Rules {
-		if err := validateRule(i, rule); err != nil {
-			return err
-		}
+		errs = append(errs, validateRule(i, rule)...)
 	}
 
-	return nil
+	return errs
 }
 
 // validateRule validates a rule
-func validateRule(index int, rule Rule) error {
+func validateRule(index int, rule Rule) []*ValidationError {
+	var errs []*ValidationError
+
 	// Validate match
+	switch rule.Match.MatchMode {
+	case "", MatchModeRegex, MatchModeGlob:
+	default:
+		errs = append(errs, ruleError(index, "invalid match_mode: %s (must be regex or glob)", rule.Match.MatchMode))
+	}
+	// Globs are always valid, only regexes need compiling
+	regexMode := rule.Match.MatchMode != MatchModeGlob
 	if rule.Match.Path == "" {
-		return fmt.Errorf("rule %d: path is required", index)
+		errs = append(errs, ruleError(index, "path is required"))
+	} else if _, err := regexp.Compile(rule.Match.Path); regexMode && err != nil {
+		errs = append(errs, ruleError(index, "invalid path regex: %v", err))
+	}
+	if rule.Match.Method != "" && regexMode {
+		if _, err := regexp.Compile(rule.Match.Method); err != nil {
+			errs = append(errs, ruleError(index, "invalid method regex: %v", err))
+		}
+	}
+	if rule.Match.Image != "" {
+		if _, err := regexp.Compile(rule.Match.Image); err != nil {
+			errs = append(errs, ruleError(index, "invalid image pattern: %v", err))
+		}
+	}
+	for _, name := range sortedKeys(rule.Match.Headers) {
+		if _, err := regexp.Compile(rule.Match.Headers[name]); err != nil {
+			errs = append(errs, ruleError(index, "invalid pattern for header %s: %v", name, err))
+		}
+	}
+	for _, key := range sortedKeys(rule.Match.Query) {
+		expr, ok := rule.Match.Query[key].(string)
+		if !ok {
+			continue
+		}
+		if _, err := regexp.Compile(expr); err != nil {
+			errs = append(errs, ruleError(index, "invalid pattern for query parameter %s: %v", key, err))
+		}
+	}
+
+	if rule.Match.RawQuery != "" {
+		if _, err := regexp.Compile(rule.Match.RawQuery); err != nil {
+			errs = append(errs, ruleError(index, "invalid raw_query pattern: %v", err))
+		}
+	}
+	if _, err := ParseCIDRs(rule.Match.SourceCIDR); err != nil {
+		errs = append(errs, ruleError(index, "invalid source_cidr: %v", err))
+	}
+	if err := checkContainsPatterns(rule.Match.Contains); err != nil {
+		errs = append(errs, ruleError(index, "invalid contains: %v", err))
 	}
 
 	// Validate actions
 	if len(rule.Actions) == 0 {
-		return fmt.Errorf("rule %d: at least one action is required", index)
+		errs = append(errs, ruleError(index, "at least one action is required"))
 	}
 
 	// Validate each action
 	for i, action := range rule.Actions {
 		if err := validateAction(index, i, action); err != nil {
-			return err
+			errs = append(errs, err)
 		}
 	}
 
-	return nil
+	return errs
 }
 
 // validateAction validates an action
-func validateAction(ruleIndex, actionIndex int, action Action) error {
+func validateAction(ruleIndex, actionIndex int, action Action) *ValidationError {
+	// Validate phase
+	switch action.Phase {
+	case "", PhaseRequest:
+	case PhaseResponse:
+		if action.Action != "upsert" && action.Action != "replace" && action.Action != "delete" {
+			return actionError(ruleIndex, actionIndex, "%s action cannot run in the response phase", action.Action)
+		}
+	default:
+		return actionError(ruleIndex, actionIndex, "invalid phase: %s", action.Phase)
+	}
+
+	// Templates are merged into request bodies like an update
+	if len(action.Template) > 0 {
+		if action.Action != "upsert" && action.Action != "replace" {
+			return actionError(ruleIndex, actionIndex, "template is only supported by upsert and replace actions")
+		}
+		if action.Phase == PhaseResponse {
+			return actionError(ruleIndex, actionIndex, "template is only supported in the request phase")
+		}
+		if err := checkTemplate(action.Template); err != nil {
+			return actionError(ruleIndex, actionIndex, "invalid template: %v", err)
+		}
+	}
+
+	if action.ArrayKey != "" && action.Action != "upsert" && action.Action != "replace" {
+		return actionError(ruleIndex, actionIndex, "array_key is only supported by upsert and replace actions")
+	}
+
+	// Log settings only mean something for an action that logs
+	if (action.LogMessage != "" || action.LogLevel != "") && !action.Log {
+		return actionError(ruleIndex, actionIndex, "log_message and log_level require log: true")
+	}
+	if action.LogLevel != "" {
+		if _, err := logging.ParseLevel(action.LogLevel); err != nil {
+			return actionError(ruleIndex, actionIndex, "invalid log_level %q (must be debug, info, warn or error)", action.LogLevel)
+		}
+	}
+	if action.Log && action.Phase == PhaseResponse {
+		return actionError(ruleIndex, actionIndex, "log is only supported in the request phase")
+	}
+
+	if action.Code != "" && action.Action != "deny" {
+		return actionError(ruleIndex, actionIndex, "code is only supported by deny actions")
+	}
+
 	// Validate action type
 	switch action.Action {
 	case "allow":
@@ -125,25 +823,154 @@ func validateAction(ruleIndex, actionIndex int, action Action) error {
 	case "deny":
 		// Deny actions require a reason
 		if action.Reason == "" {
-			return fmt.Errorf("rule %d, action %d: deny action requires a reason", ruleIndex, actionIndex)
+			return actionError(ruleIndex, actionIndex, "deny action requires a reason")
+		}
+		// A deny has to look like an error to the client
+		if action.StatusCode != 0 && (action.StatusCode < 400 || action.StatusCode > 599) {
+			return actionError(ruleIndex, actionIndex, "deny action has invalid status_code %d (must be 4xx or 5xx)", action.StatusCode)
+		}
+		if action.Code != "" && !reasonCodePattern.MatchString(action.Code) {
+			return actionError(ruleIndex, actionIndex, "deny action has invalid code %q (must be letters, digits and underscores, starting with a letter)", action.Code)
+		}
+	case "ratelimit":
+		// Rate limits need a positive number of requests per window
+		if action.Limit <= 0 {
+			return actionError(ruleIndex, actionIndex, "ratelimit action requires a positive limit")
+		}
+		window, err := time.ParseDuration(action.Window)
+		if err != nil {
+			return actionError(ruleIndex, actionIndex, "ratelimit action has invalid window %q: %v", action.Window, err)
+		}
+		if window <= 0 {
+			return actionError(ruleIndex, actionIndex, "ratelimit action requires a positive window")
+		}
+	case "rewrite-path":
+		// Path rewrites need a pattern to replace
+		if action.Pattern == "" {
+			return actionError(ruleIndex, actionIndex, "rewrite-path action requires a pattern")
+		}
+		if _, err := regexp.Compile(action.Pattern); err != nil {
+			return actionError(ruleIndex, actionIndex, "rewrite-path action has invalid pattern: %v", err)
 		}
 	case "upsert", "replace", "delete":
 		// Rewrite actions require contains and/or update fields
-		if action.Action != "delete" && len(action.Update) == 0 {
-			return fmt.Errorf("rule %d, action %d: %s action requires update field",
-				ruleIndex, actionIndex, action.Action)
+		if action.Action != "delete" && len(action.Update) == 0 && len(action.Template) == 0 {
+			return actionError(ruleIndex, actionIndex, "%s action requires update or template field", action.Action)
 		}
 		if len(action.Contains) == 0 && action.Action != "upsert" {
-			return fmt.Errorf("rule %d, action %d: %s action requires contains field",
-				ruleIndex, actionIndex, action.Action)
+			return actionError(ruleIndex, actionIndex, "%s action requires contains field", action.Action)
+		}
+		if err := checkContainsPatterns(action.Contains); err != nil {
+			return actionError(ruleIndex, actionIndex, "invalid contains: %v", err)
 		}
 	default:
-		return fmt.Errorf("rule %d, action %d: invalid action: %s", ruleIndex, actionIndex, action.Action)
+		return actionError(ruleIndex, actionIndex, "invalid action: %s", action.Action)
 	}
 
 	return nil
 }
 
+// ValidationWarnings returns patterns that are valid but probably not what
+// was intended, such as a path that matches every request
+func ValidationWarnings(config *SocketConfig) []*ValidationError {
+	if config == nil {
+		return nil
+	}
+
+	var warnings []*ValidationError
+	for i, rule := range config.Rules {
+		if rule.Match.MatchMode == MatchModeGlob {
+			continue
+		}
+		if matchesEverything(rule.Match.Path) {
+			warnings = append(warnings, ruleError(i,
+				"path pattern %q matches every request, anchor it (e.g. \"^/.*\") if that is intended", rule.Match.Path))
+		}
+		if rule.Match.Method != "" && matchesEverything(rule.Match.Method) {
+			warnings = append(warnings, ruleError(i,
+				"method pattern %q matches every method, leave it empty if that is intended", rule.Match.Method))
+		}
+	}
+	return append(warnings, duplicateMatchWarnings(config)...)
+}
+
+// duplicateMatchWarnings reports rules whose match is identical to that of a
+// rule evaluated before them, since the first match wins when the earlier
+// rule allows or denies
+func duplicateMatchWarnings(config *SocketConfig) []*ValidationError {
+	var warnings []*ValidationError
+	order := config.RuleOrder()
+	for k, i := range order {
+		for _, earlier := range order[:k] {
+			if !reflect.DeepEqual(config.Rules[earlier].Match, config.Rules[i].Match) {
+				continue
+			}
+			if decidesEveryMatch(config.Rules[earlier]) {
+				warnings = append(warnings, ruleError(i,
+					"match is identical to rule %d, which is evaluated first and always allows or denies, so this rule never applies", earlier))
+			} else {
+				warnings = append(warnings, ruleError(i,
+					"match is identical to rule %d, which is evaluated first", earlier))
+			}
+			break
+		}
+	}
+	return warnings
+}
+
+// decidesEveryMatch reports whether a rule allows or denies every request it
+// matches, so that no later rule sees them
+func decidesEveryMatch(rule Rule) bool {
+	for _, action := range rule.Actions {
+		if action.Phase == PhaseResponse {
+			continue
+		}
+		switch action.Action {
+		case "allow":
+			return true
+		case "deny":
+			if len(action.Contains) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesEverything reports whether an unanchored pattern matches the empty
+// string, and so matches any input
+func matchesEverything(pattern string) bool {
+	if pattern == "" || strings.HasPrefix(pattern, "^") {
+		return false
+	}
+	re, err := regexp.Compile(pattern)
+	return err == nil && re.MatchString("")
+}
+
+// sortedKeys returns the keys of a map in a stable order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// NeedsBody reports whether any rule of the socket, including its
+// propagation rule, reads request bodies
+func (c *SocketConfig) NeedsBody() bool {
+	if c.Config.PropagateSocket != "" {
+		return true
+	}
+	for _, rule := range c.Rules {
+		if rule.NeedsBody() {
+			return true
+		}
+	}
+	return false
+}
+
 // GetPropagationRules returns rules for socket propagation if enabled
 func (c *SocketConfig) GetPropagationRules() []Rule {
 	if c.Config.PropagateSocket == "" {
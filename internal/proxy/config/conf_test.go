@@ -1,7 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -60,13 +63,679 @@ func TestValidateConfig(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "response phase delete",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{
+						Match: Match{Path: "/test"},
+						Actions: []Action{
+							{Action: "delete", Phase: PhaseResponse, Contains: map[string]any{"Env": []any{"SECRET=.*"}}},
+							{Action: "allow"},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "response phase allow",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{
+						Match:   Match{Path: "/test"},
+						Actions: []Action{{Action: "allow", Phase: PhaseResponse}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid header pattern",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{
+						Match:   Match{Path: "/test", Headers: map[string]string{"X-Sidecar": "("}},
+						Actions: []Action{{Action: "allow"}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid query pattern",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{
+						Match:   Match{Path: "/build", Query: map[string]any{"dockerfile": "("}},
+						Actions: []Action{{Action: "allow"}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "deny by default",
+			config: &SocketConfig{
+				Config: ConfigSet{DefaultAction: DefaultActionDeny},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid default action",
+			config: &SocketConfig{
+				Config: ConfigSet{DefaultAction: "block"},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "shadow mode",
+			config: &SocketConfig{
+				Config: ConfigSet{Mode: ModeShadow},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid mode",
+			config: &SocketConfig{
+				Config: ConfigSet{Mode: "observe"},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "rate limit",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{
+						Match:   Match{Path: "/v1.*/containers/create", Method: "POST"},
+						Actions: []Action{{Action: "ratelimit", Limit: 10, Window: "1m"}, {Action: "allow"}},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "rate limit without limit",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{Match: Match{Path: "/test"}, Actions: []Action{{Action: "ratelimit", Window: "1m"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "rate limit with invalid window",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{Match: Match{Path: "/test"}, Actions: []Action{{Action: "ratelimit", Limit: 10, Window: "a minute"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "rewrite path",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{Match: Match{Path: "^/v"}, Actions: []Action{{Action: "rewrite-path", Pattern: `^/v[0-9.]+/`, Replacement: "/v1.41/"}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "rewrite path without pattern",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{Match: Match{Path: "^/v"}, Actions: []Action{{Action: "rewrite-path", Replacement: "/v1.41/"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "rewrite path with invalid pattern",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{Match: Match{Path: "^/v"}, Actions: []Action{{Action: "rewrite-path", Pattern: "^/v(", Replacement: "/v1.41/"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "rewrite path in the response phase",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{Match: Match{Path: "^/v"}, Actions: []Action{{Action: "rewrite-path", Phase: PhaseResponse, Pattern: "^/v", Replacement: "/v"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "rate limit with zero window",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{Match: Match{Path: "/test"}, Actions: []Action{{Action: "ratelimit", Limit: 10, Window: "0s"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "not wrapping a map",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{Match: Match{Path: "/test", Contains: map[string]any{"Labels": map[string]any{"$not": "team"}}}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "numeric comparison",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{Match: Match{Path: "/test", Contains: map[string]any{"HostConfig": map[string]any{"Memory": map[string]any{"$gt": 2147483648, "$lte": 8589934592.0}}}}, Actions: []Action{{Action: "deny", Reason: "too much memory"}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "comparison against a string",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{Match: Match{Path: "/test", Contains: map[string]any{"Memory": map[string]any{"$gt": "2g"}}}, Actions: []Action{{Action: "deny", Reason: "too much memory"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "comparison mixed with fields",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{Match: Match{Path: "/test", Contains: map[string]any{"HostConfig": map[string]any{"$gt": 1, "Memory": 0}}}, Actions: []Action{{Action: "deny", Reason: "too much memory"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "key match",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{Match: Match{Path: "/test", Contains: map[string]any{"Labels": map[string]any{"$keyMatch": `^com\.mycorp\.internal\.`}}}, Actions: []Action{{Action: "deny", Reason: "internal labels"}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "key match with an invalid regex",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{Match: Match{Path: "/test", Contains: map[string]any{"Labels": map[string]any{"$keyMatch": "[invalid"}}}, Actions: []Action{{Action: "deny", Reason: "internal labels"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "key match mixed with fields",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{Match: Match{Path: "/test", Contains: map[string]any{"Labels": map[string]any{"$keyMatch": "^team$", "owner": "ci"}}}, Actions: []Action{{Action: "deny", Reason: "internal labels"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "key match that is not a string",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{Match: Match{Path: "/test", Contains: map[string]any{"Labels": map[string]any{"$keyMatch": []any{"^team$"}}}}, Actions: []Action{{Action: "deny", Reason: "internal labels"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "not in a delete action",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{Match: Match{Path: "/test"}, Actions: []Action{{Action: "delete", Contains: map[string]any{"$not": []any{"a"}}}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "octal socket mode and numeric group",
+			config: &SocketConfig{
+				Config: ConfigSet{SocketMode: "0640", SocketGroup: "0"},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "non-octal socket mode",
+			config: &SocketConfig{
+				Config: ConfigSet{SocketMode: "0689"},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "socket mode beyond permission bits",
+			config: &SocketConfig{
+				Config: ConfigSet{SocketMode: "4755"},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid upstream timeout",
+			config: &SocketConfig{
+				Config: ConfigSet{UpstreamTimeout: "2m"},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "unparseable upstream timeout",
+			config: &SocketConfig{
+				Config: ConfigSet{UpstreamTimeout: "soon"},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative upstream timeout",
+			config: &SocketConfig{
+				Config: ConfigSet{UpstreamTimeout: "-1s"},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid ttl and idle timeout",
+			config: &SocketConfig{
+				Config: ConfigSet{TTL: "2h", IdleTimeout: "30m"},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "unparseable ttl",
+			config: &SocketConfig{
+				Config: ConfigSet{TTL: "tomorrow"},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "zero idle timeout",
+			config: &SocketConfig{
+				Config: ConfigSet{IdleTimeout: "0s"},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "array key on an upsert",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{Match: Match{Path: "/containers/create"}, Actions: []Action{{Action: "upsert", ArrayKey: "Target", Update: map[string]any{"Labels": map[string]any{"a": "b"}}}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "array key on an allow",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow", ArrayKey: "Target"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "custom socket header",
+			config: &SocketConfig{
+				Config: ConfigSet{InjectSocketHeader: true, SocketHeaderName: "X-Proxy-Via"},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid socket header name",
+			config: &SocketConfig{
+				Config: ConfigSet{InjectSocketHeader: true, SocketHeaderName: "X Proxy: Via"},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "max body bytes",
+			config: &SocketConfig{
+				Config: ConfigSet{MaxBodyBytes: 1 << 20},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "negative upstream retries",
+			config: &SocketConfig{
+				Config: ConfigSet{UpstreamRetries: -1},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "body methods",
+			config: &SocketConfig{
+				Config: ConfigSet{BodyMethods: []string{"POST", "PUT", "PATCH"}},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid body method",
+			config: &SocketConfig{
+				Config: ConfigSet{BodyMethods: []string{"patch"}},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "trusted proxies",
+			config: &SocketConfig{
+				Config: ConfigSet{TrustedProxies: []string{"10.0.0.0/8", "fd00::1"}},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid trusted proxy",
+			config: &SocketConfig{
+				Config: ConfigSet{TrustedProxies: []string{"10.0.0.0/33"}},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative max body bytes",
+			config: &SocketConfig{
+				Config: ConfigSet{MaxBodyBytes: -1},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tcp listen",
+			config: &SocketConfig{
+				Config: ConfigSet{Listen: "tcp://127.0.0.1:3000", DefaultAction: DefaultActionDeny},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "tcp listen with an access profile",
+			config: &SocketConfig{
+				Config: ConfigSet{Listen: "tcp://127.0.0.1:3000", Profile: "read-only-access"},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "tcp listen allowing by default",
+			config: &SocketConfig{
+				Config: ConfigSet{Listen: "tcp://127.0.0.1:3000"},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tcp listen without a port",
+			config: &SocketConfig{
+				Config: ConfigSet{Listen: "tcp://127.0.0.1", DefaultAction: DefaultActionDeny},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "listen on another scheme",
+			config: &SocketConfig{
+				Config: ConfigSet{Listen: "udp://127.0.0.1:3000", DefaultAction: DefaultActionDeny},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tcp listen with a socket mode",
+			config: &SocketConfig{
+				Config: ConfigSet{Listen: "tcp://127.0.0.1:3000", DefaultAction: DefaultActionDeny, SocketMode: "0600"},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown socket group",
+			config: &SocketConfig{
+				Config: ConfigSet{SocketGroup: "no-such-group-docker-socket-proxy"},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "relative audit log path",
+			config: &SocketConfig{
+				Config: ConfigSet{AuditLog: "audit.jsonl"},
+				Rules: []Rule{
+					{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid phase",
+			config: &SocketConfig{
+				Rules: []Rule{
+					{
+						Match:   Match{Path: "/test"},
+						Actions: []Action{{Action: "upsert", Phase: "later", Update: map[string]any{"a": "b"}}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateConfig(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	cfg := &SocketConfig{
+		Config: ConfigSet{MaxConnections: -1},
+		Rules: []Rule{
+			{
+				Match:   Match{Path: "/test", Image: "("},
+				Actions: []Action{{Action: "allow"}, {Action: "deny"}},
+			},
+			{
+				Match: Match{},
+			},
+		},
+	}
+
+	want := []ValidationError{
+		{Rule: -1, Action: -1, Message: "max_connections cannot be negative"},
+		{Rule: 0, Action: -1, Message: "invalid image pattern: error parsing regexp: missing closing ): `(`"},
+		{Rule: 0, Action: 1, Message: "deny action requires a reason"},
+		{Rule: 1, Action: -1, Message: "path is required"},
+		{Rule: 1, Action: -1, Message: "at least one action is required"},
+	}
+
+	errs := ValidationErrors(cfg)
+	if len(errs) != len(want) {
+		t.Fatalf("ValidationErrors() returned %d errors %v, want %d", len(errs), errs, len(want))
+	}
+	for i := range want {
+		if *errs[i] != want[i] {
+			t.Errorf("error %d = %+v, want %+v", i, *errs[i], want[i])
+		}
+	}
+
+	// ValidateConfig reports the first problem in the established format
+	if err := ValidateConfig(cfg); err == nil || err.Error() != "max_connections cannot be negative" {
+		t.Errorf("ValidateConfig() error = %v", err)
+	}
+	if got := errs[2].Error(); got != "rule 0, action 1: deny action requires a reason" {
+		t.Errorf("Error() = %q", got)
+	}
+}
+
+func TestValidationWarnings(t *testing.T) {
+	tests := []struct {
+		name  string
+		match Match
+		want  int
+	}{
+		{name: "specific path", match: Match{Path: "/v1.*/containers/json", Method: "GET"}, want: 0},
+		{name: "explicit catch-all", match: Match{Path: "^/.*"}, want: 0},
+		{name: "rooted catch-all", match: Match{Path: "/.*"}, want: 0},
+		{name: "unanchored wildcard path", match: Match{Path: ".*"}, want: 1},
+		{name: "unanchored wildcard path and method", match: Match{Path: ".*", Method: ".*"}, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &SocketConfig{Rules: []Rule{{Match: tt.match, Actions: []Action{{Action: "allow"}}}}}
+			if got := ValidationWarnings(cfg); len(got) != tt.want {
+				t.Errorf("ValidationWarnings() = %v, want %d warnings", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDuplicateMatchWarnings(t *testing.T) {
+	match := Match{Path: "^/v1.*/containers/create$", Method: "POST"}
+	tests := []struct {
+		name  string
+		rules []Rule
+		want  []string
+	}{
+		{
+			name: "different matches",
+			rules: []Rule{
+				{Match: match, Actions: []Action{{Action: "allow"}}},
+				{Match: Match{Path: "^/v1.*/containers/create$", Method: "GET"}, Actions: []Action{{Action: "deny", Reason: "no"}}},
+			},
+		},
+		{
+			name: "allow shadows a deny",
+			rules: []Rule{
+				{Match: match, Actions: []Action{{Action: "allow"}}},
+				{Match: match, Actions: []Action{{Action: "deny", Reason: "no"}}},
+			},
+			want: []string{"rule 1: match is identical to rule 0, which is evaluated first and always allows or denies, so this rule never applies"},
+		},
+		{
+			name: "conditional deny falls through",
+			rules: []Rule{
+				{Match: match, Actions: []Action{{Action: "deny", Reason: "no", Contains: map[string]any{"Privileged": true}}}},
+				{Match: match, Actions: []Action{{Action: "allow"}}},
+			},
+			want: []string{"rule 1: match is identical to rule 0, which is evaluated first"},
+		},
+		{
+			name: "priority decides which comes first",
+			rules: []Rule{
+				{Match: match, Actions: []Action{{Action: "allow"}}},
+				{Match: match, Priority: 1, Actions: []Action{{Action: "deny", Reason: "no"}}},
+			},
+			want: []string{"rule 0: match is identical to rule 1, which is evaluated first and always allows or denies, so this rule never applies"},
+		},
+		{
+			name: "reported once against the first duplicate",
+			rules: []Rule{
+				{Match: match, Actions: []Action{{Action: "allow"}}},
+				{Match: match, Actions: []Action{{Action: "allow"}}},
+				{Match: match, Actions: []Action{{Action: "allow"}}},
+			},
+			want: []string{
+				"rule 1: match is identical to rule 0, which is evaluated first and always allows or denies, so this rule never applies",
+				"rule 2: match is identical to rule 0, which is evaluated first and always allows or denies, so this rule never applies",
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := ValidateConfig(tt.config)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("ValidateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			var got []string
+			for _, w := range ValidationWarnings(&SocketConfig{Rules: tt.rules}) {
+				got = append(got, w.Error())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidationWarnings() = %q, want %q", got, tt.want)
 			}
 		})
 	}
@@ -385,3 +1054,558 @@ func TestContainsValue(t *testing.T) {
 		})
 	}
 }
+
+func TestParseSocketConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		wantRules int
+		wantErr   bool
+	}{
+		{
+			name:      "json",
+			data:      `{"rules":[{"match":{"path":"/.*","method":"GET"},"actions":[{"action":"allow"}]}]}`,
+			wantRules: 1,
+		},
+		{
+			name: "yaml",
+			data: `
+rules:
+  - match:
+      path: "/.*"
+      method: "GET"
+    actions:
+      - action: "allow"
+  - match:
+      path: "/.*"
+    actions:
+      - action: "deny"
+        reason: "read only"
+`,
+			wantRules: 2,
+		},
+		{
+			name:    "invalid json",
+			data:    `{"rules":`,
+			wantErr: true,
+		},
+		{
+			name:    "fails validation",
+			data:    `rules: []`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ParseSocketConfig([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSocketConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(cfg.Rules) != tt.wantRules {
+				t.Errorf("ParseSocketConfig() got %d rules, want %d", len(cfg.Rules), tt.wantRules)
+			}
+		})
+	}
+}
+
+func TestExpandProfiles(t *testing.T) {
+	userRule := Rule{Match: Match{Path: "/v1.*/containers/json", Method: "GET"}, Actions: []Action{{Action: "allow"}}}
+
+	tests := []struct {
+		name      string
+		profiles  []string
+		wantRules int
+		wantErr   bool
+	}{
+		{name: "no profiles", wantRules: 1},
+		{name: "read-only", profiles: []string{"read-only"}, wantRules: 2},
+		{name: "no-privileged", profiles: []string{"no-privileged"}, wantRules: 3},
+		{name: "no-host-namespaces", profiles: []string{"no-host-namespaces"}, wantRules: 6},
+		{name: "no-docker-socket", profiles: []string{"no-docker-socket"}, wantRules: 2},
+		{name: "no-exec", profiles: []string{"no-exec"}, wantRules: 2},
+		{name: "combined", profiles: []string{"no-privileged", "no-docker-socket"}, wantRules: 4},
+		{name: "unknown", profiles: []string{"no-privileged", "everything"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &SocketConfig{Profiles: tt.profiles, Rules: []Rule{userRule}}
+			err := ExpandProfiles(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExpandProfiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !strings.Contains(err.Error(), "read-only") {
+					t.Errorf("error %q should list the available profiles", err)
+				}
+				return
+			}
+
+			if len(cfg.Rules) != tt.wantRules {
+				t.Fatalf("expanded to %d rules, want %d", len(cfg.Rules), tt.wantRules)
+			}
+			if cfg.Profiles != nil {
+				t.Errorf("Profiles = %v after expansion, want none", cfg.Profiles)
+			}
+
+			// Profile rules come first, in the order the profiles are listed
+			last := cfg.Rules[len(cfg.Rules)-1]
+			if last.Match.Path != userRule.Match.Path {
+				t.Errorf("last rule = %+v, want the user rule", last.Match)
+			}
+			offset := 0
+			for _, name := range tt.profiles {
+				profileRules, _ := ProfileRules(name)
+				for i, rule := range profileRules {
+					if got := cfg.Rules[offset+i]; got.Actions[0].Reason != rule.Actions[0].Reason {
+						t.Errorf("rule %d = %q, want %q from %s", offset+i, got.Actions[0].Reason, rule.Actions[0].Reason, name)
+					}
+				}
+				offset += len(profileRules)
+			}
+
+			// Expanded configs are valid and expanding again changes nothing
+			if err := ValidateConfig(cfg); err != nil {
+				t.Errorf("ValidateConfig() after expansion error = %v", err)
+			}
+			if err := ExpandProfiles(cfg); err != nil || len(cfg.Rules) != tt.wantRules {
+				t.Errorf("second ExpandProfiles() = %v with %d rules, want a no-op", err, len(cfg.Rules))
+			}
+		})
+	}
+}
+
+func TestExpandProfilesPriority(t *testing.T) {
+	cfg := &SocketConfig{
+		Profiles: []string{"read-only"},
+		Rules: []Rule{
+			{Match: Match{Path: "^/"}, Actions: []Action{{Action: "allow"}}, Priority: 10},
+			{Match: Match{Path: "^/"}, Actions: []Action{{Action: "allow"}}},
+		},
+	}
+	if err := ExpandProfiles(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// The profile rule must still be evaluated before the priority 10 rule
+	if got := cfg.RuleOrder()[0]; got != 0 || cfg.Rules[got].Priority != 10 {
+		t.Errorf("first evaluated rule = %d with priority %d, want the profile rule with priority 10", got, cfg.Rules[got].Priority)
+	}
+}
+
+func TestRuleOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		priorities []int
+		want       []int
+	}{
+		{name: "no rules", want: []int{}},
+		{name: "no priorities keeps file order", priorities: []int{0, 0, 0}, want: []int{0, 1, 2}},
+		{name: "higher priority first", priorities: []int{1, 5, 3}, want: []int{1, 2, 0}},
+		{name: "ties keep file order", priorities: []int{2, 0, 2, 0}, want: []int{0, 2, 1, 3}},
+		{name: "negative priority goes last", priorities: []int{-1, 0, 0}, want: []int{1, 2, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &SocketConfig{}
+			for _, priority := range tt.priorities {
+				cfg.Rules = append(cfg.Rules, Rule{Priority: priority})
+			}
+			if got := cfg.RuleOrder(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RuleOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	cfg := &SocketConfig{
+		Config: ConfigSet{PropagateSocket: "/var/run/docker-proxy/ci.sock"},
+		Rules: []Rule{
+			{Match: Match{Path: "/containers/json"}, Actions: []Action{{Action: "allow"}}},
+			{Match: Match{Path: "/.*", Method: "DELETE"}, Actions: []Action{{Action: "deny", Reason: "no deletes"}}, Priority: 5},
+		},
+	}
+
+	rules := cfg.EffectiveRules()
+	if len(rules) != 3 {
+		t.Fatalf("EffectiveRules() returned %d rules, want 3", len(rules))
+	}
+	if rules[0].Index != -1 || rules[0].Match.Path != "/v1.*/containers/create" {
+		t.Errorf("first effective rule = %+v, want the propagation rule", rules[0])
+	}
+	if rules[1].Index != 1 || rules[2].Index != 0 {
+		t.Errorf("effective rule indexes = %d, %d, want 1, 0", rules[1].Index, rules[2].Index)
+	}
+
+	effective := cfg.EffectiveConfig()
+	if effective.Config.DefaultAction != DefaultActionAllow {
+		t.Errorf("DefaultAction = %q, want %q", effective.Config.DefaultAction, DefaultActionAllow)
+	}
+	if len(effective.Rules) != 3 || effective.Rules[1].Match.Method != "DELETE" {
+		t.Errorf("effective rules = %+v", effective.Rules)
+	}
+
+	// The stored config is left as it was
+	if len(cfg.Rules) != 2 || cfg.Config.DefaultAction != "" {
+		t.Errorf("EffectiveConfig() changed the config: %+v", cfg)
+	}
+
+	if got := (&SocketConfig{Config: ConfigSet{DefaultAction: DefaultActionDeny}}).EffectiveConfig(); got.Config.DefaultAction != DefaultActionDeny || len(got.Rules) != 0 {
+		t.Errorf("EffectiveConfig() of a deny by default config = %+v", got)
+	}
+
+	// Base rules follow the propagation rule, by their own priority, and
+	// come before the socket's rules whatever their priority
+	base := &SocketConfig{Rules: []Rule{
+		{Match: Match{Path: "/.*", Method: "POST"}, Actions: []Action{{Action: "deny"}}},
+		{Match: Match{Path: "/_ping"}, Actions: []Action{{Action: "allow"}}, Priority: 1},
+	}}
+	rules = cfg.EffectiveRulesWithBase(base)
+	var got []string
+	for _, rule := range rules {
+		got = append(got, fmt.Sprintf("%v:%d", rule.Base, rule.Index))
+	}
+	if want := []string{"false:-1", "true:1", "true:0", "false:1", "false:0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("EffectiveRulesWithBase() = %v, want %v", got, want)
+	}
+	if effective := cfg.EffectiveConfigWithBase(base); len(effective.Rules) != 5 || effective.Rules[1].Match.Path != "/_ping" {
+		t.Errorf("EffectiveConfigWithBase() rules = %+v", effective.Rules)
+	}
+}
+
+func TestValidateConfigProfiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *SocketConfig
+		wantErr bool
+	}{
+		{name: "profiles alone", config: &SocketConfig{Profiles: []string{"read-only"}}},
+		{name: "unknown profile", config: &SocketConfig{Profiles: []string{"read-mostly"}}, wantErr: true},
+		{name: "access profile alone", config: &SocketConfig{Config: ConfigSet{Profile: "watchtower"}}},
+		{name: "unknown access profile", config: &SocketConfig{Config: ConfigSet{Profile: "kubernetes"}}, wantErr: true},
+		{name: "access profile listed as a profile", config: &SocketConfig{Profiles: []string{"read-only-access"}}, wantErr: true},
+		{name: "profile set as the access profile", config: &SocketConfig{Config: ConfigSet{Profile: "read-only"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateConfig(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateConfigPatterns(t *testing.T) {
+	allow := []Action{{Action: "allow"}}
+	tests := []struct {
+		name    string
+		match   Match
+		wantErr string
+	}{
+		{name: "valid patterns", match: Match{Path: "^/v1\\.[0-9]+/containers", Method: "GET|POST"}},
+		{name: "malformed path", match: Match{Path: "/containers/(json"}, wantErr: "rule 0: invalid path regex"},
+		{name: "malformed method", match: Match{Path: "/containers", Method: "GE[T"}, wantErr: "rule 0: invalid method regex"},
+		{
+			name:    "malformed contains string",
+			match:   Match{Path: "/containers/create", Contains: map[string]any{"Image": "nginx:*+"}},
+			wantErr: "rule 0: invalid contains: invalid pattern",
+		},
+		{
+			name:    "malformed contains string in an array",
+			match:   Match{Path: "/containers/create", Contains: map[string]any{"Env": []any{"DEBUG=(1"}}},
+			wantErr: "rule 0: invalid contains: invalid pattern",
+		},
+		{name: "glob patterns are not compiled", match: Match{Path: "/containers/(json", Method: "GE[T", MatchMode: MatchModeGlob}},
+		{name: "unknown match mode", match: Match{Path: "/containers", MatchMode: "wildcard"}, wantErr: "rule 0: invalid match_mode"},
+		{name: "source cidrs", match: Match{Path: "/containers", SourceCIDR: []string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.1"}}},
+		{name: "malformed source cidr", match: Match{Path: "/containers", SourceCIDR: []string{"10.0.0.0/33"}}, wantErr: "rule 0: invalid source_cidr"},
+		{
+			name:  "env globs are not regexes",
+			match: Match{Path: "/containers/create", Contains: map[string]any{"Env": map[string]any{"$env": "SECRET_*"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateConfig(&SocketConfig{Rules: []Rule{{Match: tt.match, Actions: allow}}})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateConfig() unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateConfig() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateDenyStatusCode(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		wantErr    bool
+	}{
+		{name: "unset", statusCode: 0},
+		{name: "not found", statusCode: 404},
+		{name: "server error", statusCode: 503},
+		{name: "success", statusCode: 200, wantErr: true},
+		{name: "redirect", statusCode: 302, wantErr: true},
+		{name: "out of range", statusCode: 600, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &SocketConfig{Rules: []Rule{{
+				Match:   Match{Path: "/swarm"},
+				Actions: []Action{{Action: "deny", Reason: "hidden", StatusCode: tt.statusCode}},
+			}}}
+			if err := ValidateConfig(cfg); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateDenyCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  Action
+		wantErr string
+	}{
+		{name: "unset", action: Action{Action: "deny", Reason: "privileged"}},
+		{name: "upper snake case", action: Action{Action: "deny", Reason: "privileged", Code: "PRIVILEGED_DENIED"}},
+		{name: "mixed case and digits", action: Action{Action: "deny", Reason: "host network", Code: "hostNet2"}},
+		{name: "spaces", action: Action{Action: "deny", Reason: "privileged", Code: "PRIVILEGED DENIED"}, wantErr: "invalid code"},
+		{name: "leading digit", action: Action{Action: "deny", Reason: "privileged", Code: "1_DENIED"}, wantErr: "invalid code"},
+		{name: "header injection", action: Action{Action: "deny", Reason: "privileged", Code: "X\r\nSet-Cookie: a"}, wantErr: "invalid code"},
+		{name: "too long", action: Action{Action: "deny", Reason: "privileged", Code: "A" + strings.Repeat("B", 64)}, wantErr: "invalid code"},
+		{name: "allow", action: Action{Action: "allow", Code: "ALLOWED"}, wantErr: "code is only supported by deny actions"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &SocketConfig{Rules: []Rule{{Match: Match{Path: "/containers/create"}, Actions: []Action{tt.action}}}}
+			err := ValidateConfig(cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateConfig() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateConfig() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateActionLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  Action
+		wantErr bool
+	}{
+		{name: "log", action: Action{Action: "deny", Reason: "privileged", Log: true}},
+		{name: "log with message and level", action: Action{Action: "allow", Log: true, LogMessage: "Swarm call", LogLevel: "warn"}},
+		{name: "message without log", action: Action{Action: "allow", LogMessage: "Swarm call"}, wantErr: true},
+		{name: "invalid level", action: Action{Action: "allow", Log: true, LogLevel: "loud"}, wantErr: true},
+		{name: "response phase", action: Action{Action: "delete", Phase: PhaseResponse, Contains: map[string]any{"Env": "*"}, Log: true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &SocketConfig{Rules: []Rule{{Match: Match{Path: "/swarm"}, Actions: []Action{tt.action}}}}
+			if err := ValidateConfig(cfg); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProfileNamesAreValid(t *testing.T) {
+	for _, name := range ProfileNames() {
+		rules, _ := ProfileRules(name)
+		if err := ValidateConfig(&SocketConfig{Rules: rules}); err != nil {
+			t.Errorf("profile %s is invalid: %v", name, err)
+		}
+		for i, rule := range rules {
+			if err := rule.Match.CheckPatterns(); err != nil {
+				t.Errorf("profile %s rule %d: %v", name, i, err)
+			}
+			for _, action := range rule.Actions {
+				if action.Action != "deny" {
+					t.Errorf("profile %s rule %d has a %s action, profiles may only deny", name, i, action.Action)
+				}
+			}
+		}
+	}
+}
+
+func TestExpandAccessProfile(t *testing.T) {
+	userRule := Rule{Match: Match{Path: "^/v1.42/volumes$"}, Actions: []Action{{Action: "allow"}}}
+
+	tests := []struct {
+		name              string
+		config            SocketConfig
+		wantDefaultAction string
+		wantErr           bool
+	}{
+		{
+			name:              "denies by default",
+			config:            SocketConfig{Config: ConfigSet{Profile: "read-only-access"}, Rules: []Rule{userRule}},
+			wantDefaultAction: DefaultActionDeny,
+		},
+		{
+			name:              "keeps an explicit default action",
+			config:            SocketConfig{Config: ConfigSet{Profile: "read-only-access", DefaultAction: DefaultActionAllow}, Rules: []Rule{userRule}},
+			wantDefaultAction: DefaultActionAllow,
+		},
+		{
+			name:              "follows hardening profiles",
+			config:            SocketConfig{Config: ConfigSet{Profile: "ci-builder"}, Profiles: []string{"no-privileged"}, Rules: []Rule{userRule}},
+			wantDefaultAction: DefaultActionDeny,
+		},
+		{
+			name:    "unknown",
+			config:  SocketConfig{Config: ConfigSet{Profile: "kubernetes"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.config
+			hardening := cfg.Profiles
+			err := ExpandProfiles(&cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExpandProfiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !strings.Contains(err.Error(), "watchtower") {
+					t.Errorf("error %q should list the available access profiles", err)
+				}
+				return
+			}
+
+			if cfg.Config.Profile != "" {
+				t.Errorf("Profile = %q after expansion, want none", cfg.Config.Profile)
+			}
+			if cfg.Config.DefaultAction != tt.wantDefaultAction {
+				t.Errorf("DefaultAction = %q, want %q", cfg.Config.DefaultAction, tt.wantDefaultAction)
+			}
+
+			// Hardening rules, then the access profile's, then the config's own
+			var want []Rule
+			for _, name := range hardening {
+				rules, _ := ProfileRules(name)
+				want = append(want, rules...)
+			}
+			want = append(want, accessProfiles[tt.config.Config.Profile]...)
+			want = append(want, userRule)
+			if len(cfg.Rules) != len(want) {
+				t.Fatalf("expanded to %d rules, want %d", len(cfg.Rules), len(want))
+			}
+			for i := range want {
+				if cfg.Rules[i].Match.Path != want[i].Match.Path || cfg.Rules[i].Actions[0].Reason != want[i].Actions[0].Reason {
+					t.Errorf("rule %d = %+v, want %+v", i, cfg.Rules[i].Match, want[i].Match)
+				}
+			}
+
+			// Expanding again changes nothing
+			rules := len(cfg.Rules)
+			if err := ExpandProfiles(&cfg); err != nil || len(cfg.Rules) != rules {
+				t.Errorf("second ExpandProfiles() = %v with %d rules, want a no-op", err, len(cfg.Rules))
+			}
+		})
+	}
+}
+
+func TestProfileNamesDoNotClash(t *testing.T) {
+	for _, name := range AccessProfileNames() {
+		if _, ok := profiles[name]; ok {
+			t.Errorf("%s is both a profile and an access profile", name)
+		}
+	}
+
+	// A name in the wrong field says where it belongs
+	if err := unknownProfileError("read-only-access"); !strings.Contains(err.Error(), "config.profile") {
+		t.Errorf("error %q should point at config.profile", err)
+	}
+	if err := unknownAccessProfileError("read-only"); !strings.Contains(err.Error(), "profiles") {
+		t.Errorf("error %q should point at profiles", err)
+	}
+}
+
+func TestAccessProfileNamesAreValid(t *testing.T) {
+	for _, name := range AccessProfileNames() {
+		rules := accessProfiles[name]
+		if err := ValidateConfig(&SocketConfig{Rules: rules}); err != nil {
+			t.Errorf("access profile %s is invalid: %v", name, err)
+		}
+		for i, rule := range rules {
+			if err := rule.Match.CheckPatterns(); err != nil {
+				t.Errorf("access profile %s rule %d: %v", name, i, err)
+			}
+			for _, action := range rule.Actions {
+				if action.Action != "allow" {
+					t.Errorf("access profile %s rule %d has a %s action, access profiles may only allow", name, i, action.Action)
+				}
+			}
+		}
+	}
+}
+
+func TestRuleNeedsBody(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		want bool
+	}{
+		{name: "route only", rule: Rule{Match: Match{Path: "/build"}, Actions: []Action{{Action: "allow"}}}, want: false},
+		{name: "contains match", rule: Rule{Match: Match{Contains: map[string]any{"Image": "alpine"}}, Actions: []Action{{Action: "allow"}}}, want: true},
+		{name: "image match", rule: Rule{Match: Match{Image: "alpine:*"}, Actions: []Action{{Action: "allow"}}}, want: true},
+		{name: "deny with contains", rule: Rule{Actions: []Action{{Action: "deny", Contains: map[string]any{"Privileged": true}}}}, want: true},
+		{name: "plain deny", rule: Rule{Actions: []Action{{Action: "deny"}}}, want: false},
+		{name: "request rewrite", rule: Rule{Actions: []Action{{Action: "upsert", Update: map[string]any{"User": "1000"}}}}, want: true},
+		{name: "response rewrite", rule: Rule{Actions: []Action{{Action: "delete", Phase: PhaseResponse, Contains: map[string]any{"Env": "*"}}}}, want: false},
+		{name: "rewrite path", rule: Rule{Actions: []Action{{Action: "rewrite-path", Pattern: "^/v1.41", Replacement: "/v1.42"}}}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.NeedsBody(); got != tt.want {
+				t.Errorf("NeedsBody() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSocketConfigNeedsBody(t *testing.T) {
+	routeOnly := Rule{Match: Match{Path: "/build"}, Actions: []Action{{Action: "allow"}}}
+	inspects := Rule{Match: Match{Path: "/containers/create", Contains: map[string]any{"Privileged": true}}, Actions: []Action{{Action: "deny"}}}
+
+	tests := []struct {
+		name   string
+		config SocketConfig
+		want   bool
+	}{
+		{name: "no rules", config: SocketConfig{}, want: false},
+		{name: "route rules only", config: SocketConfig{Rules: []Rule{routeOnly}}, want: false},
+		{name: "a rule inspects the body", config: SocketConfig{Rules: []Rule{routeOnly, inspects}}, want: true},
+		{name: "socket propagation", config: SocketConfig{Config: ConfigSet{PropagateSocket: "/tmp/docker.sock"}, Rules: []Rule{routeOnly}}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.NeedsBody(); got != tt.want {
+				t.Errorf("NeedsBody() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// interpolateEnv replaces ${VAR} placeholders in a config file with the value
+// of the environment variable, before the file is parsed. ${VAR:-default}
+// falls back to the default when VAR is unset or empty, and the default may
+// hold placeholders of its own. $${ is a literal ${. A variable that is not
+// set and has no default is an error rather than an empty value, so that a
+// missing path or label cannot quietly loosen a rule.
+//
+// Only upper case names are variables. Anything else, such as the ${name}
+// and ${1} capture groups of a rewrite-path replacement, is left alone.
+func interpolateEnv(data []byte, lookup func(string) (string, bool)) ([]byte, error) {
+	if !bytes.Contains(data, []byte("${")) {
+		return data, nil
+	}
+
+	expanded, err := expandEnvText(string(data), lookup)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(expanded), nil
+}
+
+// expandEnvText expands the placeholders in a piece of text
+func expandEnvText(text string, lookup func(string) (string, bool)) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch {
+		case strings.HasPrefix(text[i:], "$${"):
+			out.WriteString("${")
+			i += 2
+		case strings.HasPrefix(text[i:], "${"):
+			end := closingBrace(text, i+2)
+			if end < 0 {
+				if name := envPlaceholderName(text[i+2:]); validEnvName(name) {
+					return "", fmt.Errorf("line %d: unclosed ${%s placeholder", lineOf(text, i), name)
+				}
+				out.WriteByte(text[i])
+				continue
+			}
+			placeholder := text[i+2 : end]
+			if !validEnvName(envPlaceholderName(placeholder)) {
+				out.WriteByte(text[i])
+				continue
+			}
+			value, err := resolveEnvPlaceholder(placeholder, lookup)
+			if err != nil {
+				return "", fmt.Errorf("line %d: %w", lineOf(text, i), err)
+			}
+			out.WriteString(value)
+			i = end
+		default:
+			out.WriteByte(text[i])
+		}
+	}
+	return out.String(), nil
+}
+
+// resolveEnvPlaceholder returns the value of the inside of a placeholder,
+// VAR or VAR:-default
+func resolveEnvPlaceholder(placeholder string, lookup func(string) (string, bool)) (string, error) {
+	name, fallback, hasDefault := strings.Cut(placeholder, ":-")
+	value, ok := lookup(name)
+	if ok && (value != "" || !hasDefault) {
+		return value, nil
+	}
+	if !hasDefault {
+		return "", fmt.Errorf("environment variable %s is not set, use ${%s:-default} to fall back to a default", name, name)
+	}
+	return expandEnvText(fallback, lookup)
+}
+
+// closingBrace returns the index of the } that closes a placeholder whose
+// contents start at start, skipping over placeholders nested in a default
+func closingBrace(text string, start int) int {
+	depth := 0
+	for i := start; i < len(text); i++ {
+		switch {
+		case strings.HasPrefix(text[i:], "${"):
+			depth++
+			i++
+		case text[i] == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case text[i] == '\n':
+			// A placeholder never spans lines
+			return -1
+		}
+	}
+	return -1
+}
+
+// envPlaceholderName returns the variable name a placeholder starts with
+func envPlaceholderName(placeholder string) string {
+	name, _, _ := strings.Cut(placeholder, ":-")
+	if end := strings.IndexAny(name, "}\n"); end >= 0 {
+		name = name[:end]
+	}
+	return name
+}
+
+// validEnvName reports whether name is an upper case variable name
+func validEnvName(name string) bool {
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+// lineOf returns the line number of an offset in text, counting from 1
+func lineOf(text string, offset int) int {
+	return strings.Count(text[:offset], "\n") + 1
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInterpolateEnv(t *testing.T) {
+	env := map[string]string{
+		"DOCKER_SOCK":    "/var/run/docker.sock",
+		"REGISTRY_LABEL": "registry.example.com",
+		"EMPTY":          "",
+		"INNER":          "inner",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{name: "no placeholders", input: `path: "^/v1.*/containers/json$"`, want: `path: "^/v1.*/containers/json$"`},
+		{name: "variable", input: "propagate_socket: ${DOCKER_SOCK}", want: "propagate_socket: /var/run/docker.sock"},
+		{name: "several on a line", input: `label: "${REGISTRY_LABEL}/${INNER}"`, want: `label: "registry.example.com/inner"`},
+		{name: "default when unset", input: "mode: ${SOCKET_MODE:-0660}", want: "mode: 0660"},
+		{name: "default when empty", input: "mode: ${EMPTY:-0660}", want: "mode: 0660"},
+		{name: "empty without a default", input: "mode: '${EMPTY}'", want: "mode: ''"},
+		{name: "set value beats the default", input: "${INNER:-outer}", want: "inner"},
+		{name: "empty default", input: "x: '${MISSING:-}'", want: "x: ''"},
+		{name: "nested default", input: "${MISSING:-${INNER}}", want: "inner"},
+		{name: "nested default falls through", input: "${MISSING:-${ALSO_MISSING:-last}}", want: "last"},
+		{name: "escaped", input: "$${DOCKER_SOCK}", want: "${DOCKER_SOCK}"},
+		{name: "undefined", input: "a: 1\nb: ${MISSING}", wantErr: "line 2: environment variable MISSING is not set"},
+		{name: "undefined in a default", input: "${MISSING:-${ALSO_MISSING}}", wantErr: "ALSO_MISSING is not set"},
+		{name: "unclosed", input: "a: ${DOCKER_SOCK\nb: }", wantErr: "line 1: unclosed ${DOCKER_SOCK placeholder"},
+		{name: "capture groups are left alone", input: `replacement: "/v1.41/${rest}${1}"`, want: `replacement: "/v1.41/${rest}${1}"`},
+		{name: "lower case default is left alone", input: "${name:-x}", want: "${name:-x}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := interpolateEnv([]byte(tt.input), lookup)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("interpolateEnv() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("interpolateEnv() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("interpolateEnv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadSocketConfigEnv(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "config-env-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	t.Setenv("DSP_TEST_SOCK", "/var/run/docker.sock")
+	t.Setenv("DSP_TEST_REGISTRY", "registry.example.com")
+
+	// Included files are interpolated too, and JSON works like YAML
+	files := map[string]string{
+		"registry.json": `{"rules": [{"match": {"path": "/images/create", "image": "^${DSP_TEST_REGISTRY}/"}, "actions": [{"action": "allow"}]}]}`,
+		"ci.yaml": `
+includes: [registry.json]
+config:
+  propagate_socket: "${DSP_TEST_SOCK}"
+  default_action: "${DSP_TEST_DEFAULT:-deny}"
+rules:
+  - match: {path: "/containers/json"}
+    actions: [{action: allow}]
+`,
+		"missing.yaml": `
+config:
+  audit_log: ${DSP_TEST_AUDIT_LOG}
+rules: []
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg, err := ReadSocketConfig(filepath.Join(tmpDir, "ci.yaml"))
+	if err != nil {
+		t.Fatalf("ReadSocketConfig() error = %v", err)
+	}
+	if cfg.Config.PropagateSocket != "/var/run/docker.sock" {
+		t.Errorf("propagate_socket = %q", cfg.Config.PropagateSocket)
+	}
+	if cfg.Config.DefaultAction != DefaultActionDeny {
+		t.Errorf("default_action = %q, want the default deny", cfg.Config.DefaultAction)
+	}
+	if len(cfg.Rules) != 2 || cfg.Rules[0].Match.Image != "^registry.example.com/" {
+		t.Errorf("rules = %+v, want the included registry rule first", cfg.Rules)
+	}
+
+	_, err = ReadSocketConfig(filepath.Join(tmpDir, "missing.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "line 3: environment variable DSP_TEST_AUDIT_LOG is not set") {
+		t.Errorf("ReadSocketConfig() error = %v, want the undefined variable", err)
+	}
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"context"
+	"net/http"
+)
+
+// Identity describes who is on the other end of a proxy connection
+type Identity struct {
+	UID uint32
+	GID uint32
+	PID int32
+}
+
+type identityContextKey struct{}
+
+// ContextWithIdentity returns a context carrying the connection identity
+func ContextWithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, id)
+}
+
+// IdentityFromContext returns the connection identity stored in the context, if any
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityContextKey{}).(*Identity)
+	return id, ok && id != nil
+}
+
+// HasIdentity reports whether the request arrived with any caller identity,
+// either unix peer credentials or a verified TLS client certificate
+func HasIdentity(r *http.Request) bool {
+	if _, ok := IdentityFromContext(r.Context()); ok {
+		return true
+	}
+	return r.TLS != nil && len(r.TLS.PeerCertificates) > 0
+}
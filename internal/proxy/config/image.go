@@ -0,0 +1,54 @@
+package config
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// ImageReference returns the image a request operates on. Container creates
+// carry it in the Image field of the body, image pulls in the fromImage and
+// tag query parameters. Requests to other endpoints have no image.
+func ImageReference(r *http.Request, body map[string]any) (string, bool) {
+	if r.Method != http.MethodPost {
+		return "", false
+	}
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/containers/create"):
+		image, ok := body["Image"].(string)
+		return image, ok && image != ""
+
+	case strings.HasSuffix(r.URL.Path, "/images/create"):
+		query := r.URL.Query()
+		image := query.Get("fromImage")
+		if image == "" {
+			return "", false
+		}
+		if tag := query.Get("tag"); tag != "" {
+			if strings.HasPrefix(tag, "sha256:") {
+				return image + "@" + tag, true
+			}
+			return image + ":" + tag, true
+		}
+		return image, true
+	}
+
+	return "", false
+}
+
+// MatchesImage checks the image criteria of a match. A match with an Image
+// pattern only applies to requests whose image reference matches it.
+func MatchesImage(r *http.Request, body map[string]any, match Match) bool {
+	if match.Image == "" {
+		return true
+	}
+
+	image, ok := ImageReference(r, body)
+	if !ok {
+		return false
+	}
+
+	matched, err := regexp.MatchString(match.Image, image)
+	return err == nil && matched
+}
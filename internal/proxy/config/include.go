@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// resolveIncludes splices the rules of the files a config includes ahead of
+// its own rules, in the order they are listed. Included files may include
+// others in turn, but may not set anything besides rules. chain holds the
+// files being resolved, so that a file including itself is reported.
+func resolveIncludes(config *SocketConfig, configPath string, chain []string) error {
+	path, err := filepath.Abs(configPath)
+	if err != nil {
+		return fmt.Errorf("resolving %s: %w", configPath, err)
+	}
+	for i, parent := range chain {
+		if parent == path {
+			cycle := append(append([]string{}, chain[i:]...), path)
+			return fmt.Errorf("include cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	chain = append(chain, path)
+
+	var rules []Rule
+	for _, include := range config.Includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		included, err := parseConfigFile(include)
+		if err != nil {
+			return fmt.Errorf("including %s: %w", include, err)
+		}
+		if !reflect.DeepEqual(included.Config, ConfigSet{}) || len(included.Profiles) > 0 {
+			return fmt.Errorf("including %s: an included file may only contain rules and includes", include)
+		}
+		if err := resolveIncludes(included, include, chain); err != nil {
+			return err
+		}
+		rules = append(rules, included.Rules...)
+	}
+
+	if len(config.Includes) > 0 {
+		config.Rules = append(rules, config.Rules...)
+		config.Includes = nil
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadSocketConfigIncludes(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "config-include-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	files := map[string]string{
+		"common/deny.yaml": `
+includes: [network.yaml]
+rules:
+  - match: {path: "/containers/create", contains: {HostConfig: {Privileged: true}}}
+    actions: [{action: deny, reason: privileged}]
+`,
+		"common/network.yaml": `
+rules:
+  - match: {path: "/containers/create", contains: {HostConfig: {NetworkMode: host}}}
+    actions: [{action: deny, reason: host network}]
+`,
+		"ci.yaml": `
+includes: [common/deny.yaml]
+rules:
+  - match: {path: "/"}
+    actions: [{action: allow}]
+`,
+		"cycle-a.yaml": `
+includes: [cycle-b.yaml]
+rules: []
+`,
+		"cycle-b.yaml": `
+includes: [cycle-a.yaml]
+rules: []
+`,
+		"settings.yaml": `
+includes: [with-config.yaml]
+rules: []
+`,
+		"with-config.yaml": `
+config: {default_action: deny}
+rules: []
+`,
+		"missing.yaml": `
+includes: [nope.yaml]
+rules: []
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		file        string
+		wantReasons []string
+		wantErr     string
+	}{
+		{name: "nested includes come first", file: "ci.yaml", wantReasons: []string{"host network", "privileged", ""}},
+		{name: "cycle", file: "cycle-a.yaml", wantErr: "include cycle"},
+		{name: "included config settings", file: "settings.yaml", wantErr: "may only contain rules and includes"},
+		{name: "missing include", file: "missing.yaml", wantErr: "nope.yaml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ReadSocketConfig(filepath.Join(tmpDir, tt.file))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ReadSocketConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadSocketConfig() error = %v", err)
+			}
+			if len(cfg.Includes) != 0 {
+				t.Errorf("Includes = %v, want them resolved", cfg.Includes)
+			}
+			var reasons []string
+			for _, rule := range cfg.Rules {
+				reasons = append(reasons, rule.Actions[0].Reason)
+			}
+			if strings.Join(reasons, ",") != strings.Join(tt.wantReasons, ",") {
+				t.Errorf("rule reasons = %q, want %q", reasons, tt.wantReasons)
+			}
+			if err := ValidateConfig(cfg); err != nil {
+				t.Errorf("ValidateConfig() error = %v", err)
+			}
+		})
+	}
+
+	// A config that did not come from a file has nothing to resolve against
+	if err := ValidateConfig(&SocketConfig{Includes: []string{"common/deny.yaml"}}); err == nil {
+		t.Error("ValidateConfig() accepted unresolved includes")
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"reflect"
@@ -20,6 +21,21 @@ func MatchValue(expected, actual any) bool {
 		return false
 	}
 
+	// Environment-style key globs
+	if pattern, ok := envGlobPattern(expected); ok {
+		return matchEnvGlobValue(pattern, actual)
+	}
+
+	// Numeric comparisons
+	if bounds, ok := comparisonPattern(expected); ok {
+		return matchComparison(bounds, actual)
+	}
+
+	// Regexes for the keys of a map
+	if pattern, ok := keyMatchPattern(expected); ok {
+		return matchKeys(pattern, actual)
+	}
+
 	// Handle different types
 	switch exp := expected.(type) {
 	case string:
@@ -105,6 +121,18 @@ func matchArrayValue(expected, actual []any) bool {
 
 // findItemInArray looks for an item in an array
 func findItemInArray(expected any, actual []any) bool {
+	if pattern, ok := envGlobPattern(expected); ok {
+		return matchEnvGlobValue(pattern, actual)
+	}
+	if bounds, ok := comparisonPattern(expected); ok {
+		for _, actItem := range actual {
+			if matchComparison(bounds, actItem) {
+				return true
+			}
+		}
+		return false
+	}
+
 	expStr, isExpStr := expected.(string)
 	for _, actItem := range actual {
 		actStr, isActStr := actItem.(string)
@@ -119,9 +147,261 @@ func findItemInArray(expected any, actual []any) bool {
 	return false
 }
 
-// matchMapValue handles map matching
+// envGlobKey marks a KEY=VALUE glob pattern, e.g. {"$env": "SECRET_*"}
+const envGlobKey = "$env"
+
+// envGlobPattern returns the pattern if the value is an env glob wrapper
+func envGlobPattern(v any) (string, bool) {
+	m, ok := v.(map[string]any)
+	if !ok || len(m) != 1 {
+		return "", false
+	}
+	pattern, ok := m[envGlobKey].(string)
+	return pattern, ok
+}
+
+// matchEnvGlobValue matches an env glob against a KEY=VALUE string or any element of an array
+func matchEnvGlobValue(pattern string, actual any) bool {
+	switch act := actual.(type) {
+	case string:
+		return matchEnvGlob(pattern, act)
+	case []any:
+		for _, item := range act {
+			if str, ok := item.(string); ok && matchEnvGlob(pattern, str) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchEnvGlob matches a KEY or KEY=VALUE glob against a KEY=VALUE entry.
+// A pattern without "=" matches on the key alone, whatever the value.
+func matchEnvGlob(pattern, entry string) bool {
+	key, value, hasValue := strings.Cut(entry, "=")
+	patternKey, patternValue, patternHasValue := strings.Cut(pattern, "=")
+
+	if !matchGlob(patternKey, key) {
+		return false
+	}
+	if !patternHasValue {
+		return true
+	}
+	return hasValue && matchGlob(patternValue, value)
+}
+
+// matchGlob matches a string against a glob where * matches any run of
+// characters and ? matches exactly one
+func matchGlob(pattern, s string) bool {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	matched, err := regexp.MatchString("^"+expr+"$", s)
+	return err == nil && matched
+}
+
+// Comparison operators, e.g. {"$gt": 2147483648}
+const (
+	gtKey  = "$gt"
+	gteKey = "$gte"
+	ltKey  = "$lt"
+	lteKey = "$lte"
+)
+
+// isComparisonKey reports whether a key is a comparison operator
+func isComparisonKey(key string) bool {
+	return key == gtKey || key == gteKey || key == ltKey || key == lteKey
+}
+
+// comparisonPattern returns the bounds if the value is a map of comparison
+// operators, all of which must hold. A map that mixes operators and fields
+// is not a comparison.
+func comparisonPattern(v any) (map[string]float64, bool) {
+	m, ok := v.(map[string]any)
+	if !ok || len(m) == 0 {
+		return nil, false
+	}
+	bounds := make(map[string]float64, len(m))
+	for key, value := range m {
+		if !isComparisonKey(key) {
+			return nil, false
+		}
+		bound, ok := toFloat(value)
+		if !ok {
+			return nil, false
+		}
+		bounds[key] = bound
+	}
+	return bounds, true
+}
+
+// matchComparison checks a number, or the length of an array, against
+// comparison bounds. Any other value never matches.
+func matchComparison(bounds map[string]float64, actual any) bool {
+	value, ok := toFloat(actual)
+	if !ok {
+		arr, isArray := actual.([]any)
+		if !isArray {
+			return false
+		}
+		value = float64(len(arr))
+	}
+
+	for op, bound := range bounds {
+		switch op {
+		case gtKey:
+			ok = value > bound
+		case gteKey:
+			ok = value >= bound
+		case ltKey:
+			ok = value < bound
+		case lteKey:
+			ok = value <= bound
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// toFloat converts the number types a JSON or YAML config, or a request
+// body, decodes to into a float64
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// checkComparison reports a map of comparison operators that cannot be used,
+// because one of them is not a number or it mixes operators and fields
+func checkComparison(m map[string]any) error {
+	hasOperator, hasField := false, false
+	for _, key := range sortedKeys(m) {
+		if !isComparisonKey(key) {
+			hasField = true
+			continue
+		}
+		hasOperator = true
+		if _, ok := toFloat(m[key]); !ok {
+			return fmt.Errorf("%s must be a number, got %v", key, m[key])
+		}
+	}
+	if hasOperator && hasField {
+		return fmt.Errorf("comparison operators cannot be mixed with fields")
+	}
+	return nil
+}
+
+// keyMatchKey marks a regex that any key of a map must match, e.g.
+// {"Labels": {"$keyMatch": "^com\\.example\\."}}
+const keyMatchKey = "$keyMatch"
+
+// keyMatchPattern returns the regex if the value is a key match wrapper
+func keyMatchPattern(v any) (string, bool) {
+	m, ok := v.(map[string]any)
+	if !ok || len(m) != 1 {
+		return "", false
+	}
+	pattern, ok := m[keyMatchKey].(string)
+	return pattern, ok
+}
+
+// matchKeys reports whether any key of a map matches a regex. A value that
+// is not a map, or a regex that does not compile, never matches.
+func matchKeys(pattern string, actual any) bool {
+	m, ok := actual.(map[string]any)
+	if !ok {
+		return false
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false
+	}
+	for key := range m {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// notKey marks a structure that must not match, e.g. {"$not": {"Labels": {"team": ".*"}}}
+const notKey = "$not"
+
+// checkContainsPatterns reports a $not anywhere in a structure that does not
+// wrap a map, which would match everything, comparisons and key matches that
+// cannot be used and string patterns that are not valid regular expressions
+func checkContainsPatterns(v any) error {
+	switch val := v.(type) {
+	case string:
+		if _, err := regexp.Compile(val); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", val, err)
+		}
+	case map[string]any:
+		// Env globs are not regular expressions
+		if _, ok := envGlobPattern(val); ok {
+			return nil
+		}
+		if err := checkComparison(val); err != nil {
+			return err
+		}
+		if _, ok := val[keyMatchKey]; ok {
+			if _, ok := keyMatchPattern(val); !ok {
+				return fmt.Errorf("%s must be the only key of its map and hold a regex", keyMatchKey)
+			}
+		}
+		for _, key := range sortedKeys(val) {
+			if key == notKey {
+				if _, ok := val[key].(map[string]any); !ok {
+					return fmt.Errorf("%s must wrap a map of fields", notKey)
+				}
+			}
+			if err := checkContainsPatterns(val[key]); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range val {
+			if err := checkContainsPatterns(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matchMapValue handles map matching. A $not key holds a structure that the
+// map itself must not match, and like every other key it must hold.
 func matchMapValue(expected, actual map[string]any) bool {
 	for key, expValue := range expected {
+		if key == notKey {
+			if MatchValue(expValue, actual) {
+				return false
+			}
+			continue
+		}
+
 		actValue, exists := actual[key]
 		if !exists || !MatchValue(expValue, actValue) {
 			return false
@@ -141,27 +421,72 @@ func matchRegex(pattern, s string) bool {
 	return re.MatchString(s)
 }
 
-// MatchesRule checks if a request matches a rewrite rule
-func MatchesRule(r *http.Request, match Match) bool {
-	// Check path match
-	if match.Path != "" {
-		pathMatched, err := regexp.MatchString(match.Path, r.URL.Path)
-		if err != nil || !pathMatched {
-			return false
+// CheckPatterns compiles every regex of a match, so that a pattern that
+// cannot be used is reported as an error instead of silently never matching
+func (m Match) CheckPatterns() error {
+	patterns := [][2]string{
+		{"image", m.Image},
+		{"raw_query", m.RawQuery},
+		{"body_regex", m.BodyRegex},
+	}
+	if m.MatchMode != MatchModeGlob {
+		patterns = append(patterns, [2]string{"path", m.Path}, [2]string{"method", m.Method})
+	}
+	for _, name := range sortedKeys(m.Headers) {
+		patterns = append(patterns, [2]string{"header " + name, m.Headers[name]})
+	}
+	for _, key := range sortedKeys(m.Query) {
+		if expr, ok := m.Query[key].(string); ok {
+			patterns = append(patterns, [2]string{"query parameter " + key, expr})
 		}
 	}
 
-	// Check method match
-	if match.Method != "" {
-		methodMatched, err := regexp.MatchString(match.Method, r.Method)
-		if err != nil || !methodMatched {
-			return false
+	for _, p := range patterns {
+		field, pattern := p[0], p[1]
+		if pattern == "" {
+			continue
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid %s pattern: %w", field, err)
 		}
 	}
+	return nil
+}
+
+// Match modes of a match's path and method
+const (
+	MatchModeRegex = "regex"
+	MatchModeGlob  = "glob"
+)
+
+// MatchPattern matches a path or method against one of the match's patterns.
+// A regex may match anywhere in s, while a glob has to match all of it.
+func (m Match) MatchPattern(pattern, s string) (bool, error) {
+	if m.MatchMode == MatchModeGlob {
+		return matchGlob(pattern, s), nil
+	}
+	return regexp.MatchString(pattern, s)
+}
+
+// MatchMethod matches a request method against the match's method pattern.
+// Unlike a path regex, a method regex has to match the whole method, so
+// that GET does not also match any method that merely contains it, while
+// alternations such as GET|HEAD keep working.
+func (m Match) MatchMethod(method string) (bool, error) {
+	if m.MatchMode == MatchModeGlob {
+		return matchGlob(m.Method, method), nil
+	}
+	return regexp.MatchString("^(?:"+m.Method+")$", method)
+}
 
-	// Check contains criteria
-	if len(match.Contains) > 0 {
-		// Read and restore the body
+// MatchesRule checks if a request matches a rule, reading the body when the
+// match needs it and leaving it readable afterwards. A pattern that does not
+// compile never matches.
+func MatchesRule(r *http.Request, match Match) bool {
+	// Read and restore the body for contains, image and body criteria
+	var raw []byte
+	var body map[string]any
+	if match.NeedsBody() && r.Body != nil {
 		bodyBytes, err := io.ReadAll(r.Body)
 		if err != nil {
 			return false
@@ -170,43 +495,129 @@ func MatchesRule(r *http.Request, match Match) bool {
 			return false
 		}
 		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+		raw = bodyBytes
 
-		// Parse the JSON body
-		var body map[string]any
+		// A body that is not a JSON object has no fields to match
 		if err := json.Unmarshal(bodyBytes, &body); err != nil {
-			return false
+			body = nil
 		}
+	}
+
+	matched, err := MatchRequest(r, raw, body, match)
+	return err == nil && matched
+}
 
-		// Check if the body matches the contains criteria
-		if !MatchValue(match.Contains, body) {
+// MatchRequest checks every criterion of a match against a request, its raw
+// body and its parsed JSON body, which are nil when the body was not read or
+// is not a JSON object. It is the one matcher that both ACL evaluation and
+// rewrites use. An error is returned for a path or method pattern that does
+// not compile.
+func MatchRequest(r *http.Request, raw []byte, body map[string]any, match Match) (bool, error) {
+	// Check path match
+	if match.Path != "" {
+		pathMatched, err := match.MatchPattern(match.Path, r.URL.Path)
+		if err != nil {
+			return false, fmt.Errorf("invalid path pattern: %w", err)
+		}
+		if !pathMatched {
+			return false, nil
+		}
+	}
+
+	// Check method match
+	if match.Method != "" {
+		methodMatched, err := match.MatchMethod(r.Method)
+		if err != nil {
+			return false, fmt.Errorf("invalid method pattern: %w", err)
+		}
+		if !methodMatched {
+			return false, nil
+		}
+	}
+
+	// Check identity, source, header and query criteria
+	if !MatchesIdentity(r, match) || !MatchesSource(r, match) || !MatchesHeaders(r, match) {
+		return false, nil
+	}
+	if !MatchesQuery(r, match) || !MatchesRawQuery(r, match) {
+		return false, nil
+	}
+
+	// Check image and contains criteria, a request without a body never
+	// matches a contains structure
+	if !MatchesImage(r, body, match) {
+		return false, nil
+	}
+	if len(match.Contains) > 0 && (body == nil || !MatchValue(match.Contains, body)) {
+		return false, nil
+	}
+
+	// Likewise a body regex needs a body, it and contains must both match
+	if match.BodyRegex != "" {
+		if len(raw) == 0 {
+			return false, nil
+		}
+		bodyMatched, err := regexp.Match(match.BodyRegex, raw)
+		if err != nil {
+			return false, fmt.Errorf("invalid body_regex pattern: %w", err)
+		}
+		if !bodyMatched {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// MatchesIdentity checks the identity criteria of a match. A match with
+// RequireIdentity set only applies to anonymous requests, so that a rule
+// can deny callers that could not be identified. PeerUID and PeerGID must
+// equal the caller's unix peer credentials.
+func MatchesIdentity(r *http.Request, match Match) bool {
+	if match.PeerUID != nil || match.PeerGID != nil {
+		id, ok := IdentityFromContext(r.Context())
+		if !ok {
+			return false
+		}
+		if match.PeerUID != nil && *match.PeerUID != id.UID {
+			return false
+		}
+		if match.PeerGID != nil && *match.PeerGID != id.GID {
 			return false
 		}
 	}
 
-	return true
+	if !match.RequireIdentity {
+		return true
+	}
+	return !HasIdentity(r)
 }
 
-// MatchesStructure checks if a body matches a structure
-func MatchesStructure(body map[string]any, match map[string]any) bool {
-	for key, expectedValue := range match {
-		actualValue, exists := body[key]
-		if !exists {
+// MatchesHeaders checks the header criteria of a match. Every header in the
+// match must have a value matching its pattern, and a header the request did
+// not send is matched as an empty string, so "^$" selects requests without it.
+func MatchesHeaders(r *http.Request, match Match) bool {
+	for name, pattern := range match.Headers {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
 			return false
 		}
 
-		// If the expected value is a map, recurse into it
-		if expectedMap, ok := expectedValue.(map[string]any); ok {
-			if actualMap, ok := actualValue.(map[string]any); ok {
-				if !MatchesStructure(actualMap, expectedMap) {
-					return false
-				}
-			} else {
-				return false
+		values := r.Header.Values(name)
+		if len(values) == 0 {
+			values = []string{""}
+		}
+
+		matched := false
+		for _, value := range values {
+			if re.MatchString(value) {
+				matched = true
+				break
 			}
-		} else if !MatchValue(actualValue, expectedValue) {
+		}
+		if !matched {
 			return false
 		}
 	}
-
 	return true
 }
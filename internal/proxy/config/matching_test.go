@@ -2,10 +2,18 @@ package config
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -343,3 +351,1004 @@ func TestContainsMatching(t *testing.T) {
 		})
 	}
 }
+
+func TestMatchesIdentity(t *testing.T) {
+	anonymous := httptest.NewRequest("POST", "/v1.42/containers/create", nil)
+
+	identified := httptest.NewRequest("POST", "/v1.42/containers/create", nil)
+	identified = identified.WithContext(ContextWithIdentity(identified.Context(), &Identity{UID: 1000, GID: 1000, PID: 42}))
+
+	withCert := httptest.NewRequest("POST", "/v1.42/containers/create", nil)
+	withCert.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}
+
+	uid0, uid1000 := uint32(0), uint32(1000)
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		match   Match
+		want    bool
+	}{
+		{
+			name:    "no identity requirement matches anonymous",
+			request: anonymous,
+			match:   Match{Path: "/v1.*/containers/create"},
+			want:    true,
+		},
+		{
+			name:    "no identity requirement matches identified",
+			request: identified,
+			match:   Match{Path: "/v1.*/containers/create"},
+			want:    true,
+		},
+		{
+			name:    "require identity matches anonymous",
+			request: anonymous,
+			match:   Match{Path: "/v1.*/containers/create", RequireIdentity: true},
+			want:    true,
+		},
+		{
+			name:    "require identity skips peer credentials",
+			request: identified,
+			match:   Match{Path: "/v1.*/containers/create", RequireIdentity: true},
+			want:    false,
+		},
+		{
+			name:    "require identity skips client certificate",
+			request: withCert,
+			match:   Match{Path: "/v1.*/containers/create", RequireIdentity: true},
+			want:    false,
+		},
+		{
+			name:    "peer uid matches",
+			request: identified,
+			match:   Match{Path: "/v1.*/containers/create", PeerUID: &uid1000},
+			want:    true,
+		},
+		{
+			name:    "peer uid skips other users",
+			request: identified,
+			match:   Match{Path: "/v1.*/containers/create", PeerUID: &uid0},
+			want:    false,
+		},
+		{
+			name:    "peer uid and gid must both match",
+			request: identified,
+			match:   Match{Path: "/v1.*/containers/create", PeerUID: &uid1000, PeerGID: &uid0},
+			want:    false,
+		},
+		{
+			name:    "peer gid skips anonymous",
+			request: anonymous,
+			match:   Match{Path: "/v1.*/containers/create", PeerGID: &uid1000},
+			want:    false,
+		},
+		{
+			name:    "peer uid skips client certificate",
+			request: withCert,
+			match:   Match{Path: "/v1.*/containers/create", PeerUID: &uid1000},
+			want:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchesRule(tt.request, tt.match); got != tt.want {
+				t.Errorf("MatchesRule() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvGlobMatching(t *testing.T) {
+	env := []any{"PATH=/usr/bin", "SECRET_TOKEN=abc123", "DEBUG=true"}
+
+	tests := []struct {
+		name    string
+		pattern any
+		value   any
+		want    bool
+	}{
+		{
+			name:    "key prefix matches regardless of value",
+			pattern: map[string]any{"Env": []any{map[string]any{"$env": "SECRET_*"}}},
+			value:   map[string]any{"Env": env},
+			want:    true,
+		},
+		{
+			name:    "key prefix with empty value",
+			pattern: map[string]any{"Env": []any{map[string]any{"$env": "SECRET_*"}}},
+			value:   map[string]any{"Env": []any{"SECRET_TOKEN="}},
+			want:    true,
+		},
+		{
+			name:    "key prefix does not match other keys",
+			pattern: map[string]any{"Env": []any{map[string]any{"$env": "SECRET_*"}}},
+			value:   map[string]any{"Env": []any{"PATH=/usr/bin", "MY_SECRET_TOKEN=abc"}},
+			want:    false,
+		},
+		{
+			name:    "key and value glob",
+			pattern: map[string]any{"Env": []any{map[string]any{"$env": "DEBUG=t*"}}},
+			value:   map[string]any{"Env": env},
+			want:    true,
+		},
+		{
+			name:    "key and value glob value mismatch",
+			pattern: map[string]any{"Env": []any{map[string]any{"$env": "DEBUG=f*"}}},
+			value:   map[string]any{"Env": env},
+			want:    false,
+		},
+		{
+			name:    "single character wildcard",
+			pattern: map[string]any{"$env": "DEBU?"},
+			value:   "DEBUG=true",
+			want:    true,
+		},
+		{
+			name:    "value may contain slashes",
+			pattern: map[string]any{"$env": "PATH=/usr/*"},
+			value:   "PATH=/usr/local/bin",
+			want:    true,
+		},
+		{
+			name:    "regex metacharacters are literal",
+			pattern: map[string]any{"$env": "A.B"},
+			value:   "AXB=1",
+			want:    false,
+		},
+		{
+			name:    "glob directly against an array",
+			pattern: map[string]any{"$env": "SECRET_*"},
+			value:   env,
+			want:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchValue(tt.pattern, tt.value); got != tt.want {
+				t.Errorf("MatchValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotMatching(t *testing.T) {
+	requireTeam := map[string]any{"$not": map[string]any{"Labels": map[string]any{"team": ".*"}}}
+
+	tests := []struct {
+		name    string
+		pattern any
+		value   any
+		want    bool
+	}{
+		{
+			name:    "matches when the field is absent",
+			pattern: requireTeam,
+			value:   map[string]any{"Image": "alpine"},
+			want:    true,
+		},
+		{
+			name:    "matches when the nested field is absent",
+			pattern: requireTeam,
+			value:   map[string]any{"Labels": map[string]any{"owner": "ci"}},
+			want:    true,
+		},
+		{
+			name:    "does not match when the structure matches",
+			pattern: requireTeam,
+			value:   map[string]any{"Labels": map[string]any{"team": "payments"}},
+			want:    false,
+		},
+		{
+			name:    "matches when the value does not",
+			pattern: map[string]any{"$not": map[string]any{"HostConfig": map[string]any{"NetworkMode": "^bridge$"}}},
+			value:   map[string]any{"HostConfig": map[string]any{"NetworkMode": "host"}},
+			want:    true,
+		},
+		{
+			name: "sibling keys must also hold",
+			pattern: map[string]any{
+				"Image": "^alpine",
+				"$not":  map[string]any{"Labels": map[string]any{"team": ".*"}},
+			},
+			value: map[string]any{"Image": "nginx"},
+			want:  false,
+		},
+		{
+			name: "sibling keys and not both hold",
+			pattern: map[string]any{
+				"Image": "^alpine",
+				"$not":  map[string]any{"Labels": map[string]any{"team": ".*"}},
+			},
+			value: map[string]any{"Image": "alpine:3"},
+			want:  true,
+		},
+		{
+			name:    "nested not",
+			pattern: map[string]any{"HostConfig": map[string]any{"$not": map[string]any{"ReadonlyRootfs": true}}},
+			value:   map[string]any{"HostConfig": map[string]any{"ReadonlyRootfs": false}},
+			want:    true,
+		},
+		{
+			name:    "nested not needs the parent",
+			pattern: map[string]any{"HostConfig": map[string]any{"$not": map[string]any{"ReadonlyRootfs": true}}},
+			value:   map[string]any{"Image": "alpine"},
+			want:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchValue(tt.pattern, tt.value); got != tt.want {
+				t.Errorf("MatchValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	body := map[string]any{"Labels": map[string]any{"owner": "ci"}}
+	if !MatchValue(requireTeam, body) {
+		t.Error("MatchValue() = false, want true for a body without the team label")
+	}
+	body["Labels"].(map[string]any)["team"] = "payments"
+	if MatchValue(requireTeam, body) {
+		t.Error("MatchValue() = true, want false for a body with the team label")
+	}
+}
+
+func TestComparisonMatching(t *testing.T) {
+	overTwoGB := map[string]any{"HostConfig": map[string]any{"Memory": map[string]any{"$gt": 2147483648}}}
+
+	tests := []struct {
+		name    string
+		pattern any
+		value   any
+		want    bool
+	}{
+		{
+			name:    "float body above an int bound",
+			pattern: overTwoGB,
+			value:   map[string]any{"HostConfig": map[string]any{"Memory": float64(4294967296)}},
+			want:    true,
+		},
+		{
+			name:    "equal to an exclusive bound",
+			pattern: overTwoGB,
+			value:   map[string]any{"HostConfig": map[string]any{"Memory": float64(2147483648)}},
+			want:    false,
+		},
+		{
+			name:    "equal to an inclusive bound",
+			pattern: map[string]any{"Memory": map[string]any{"$gte": 2147483648}},
+			value:   map[string]any{"Memory": float64(2147483648)},
+			want:    true,
+		},
+		{
+			name:    "float bound against an int value",
+			pattern: map[string]any{"CpuShares": map[string]any{"$lt": 1.5}},
+			value:   map[string]any{"CpuShares": 1},
+			want:    true,
+		},
+		{
+			name:    "fractional value above an int bound",
+			pattern: map[string]any{"NanoCpus": map[string]any{"$gt": 1}},
+			value:   map[string]any{"NanoCpus": 1.0001},
+			want:    true,
+		},
+		{
+			name:    "negative values",
+			pattern: map[string]any{"OomScoreAdj": map[string]any{"$lte": -500}},
+			value:   map[string]any{"OomScoreAdj": float64(-1000)},
+			want:    true,
+		},
+		{
+			name:    "json number value",
+			pattern: map[string]any{"Memory": map[string]any{"$gt": 0}},
+			value:   map[string]any{"Memory": json.Number("512")},
+			want:    true,
+		},
+		{
+			name:    "range with both bounds inside",
+			pattern: map[string]any{"Memory": map[string]any{"$gte": 1, "$lte": 10}},
+			value:   map[string]any{"Memory": float64(10)},
+			want:    true,
+		},
+		{
+			name:    "range with both bounds outside",
+			pattern: map[string]any{"Memory": map[string]any{"$gte": 1, "$lte": 10}},
+			value:   map[string]any{"Memory": float64(11)},
+			want:    false,
+		},
+		{
+			name:    "numeric string does not compare",
+			pattern: map[string]any{"Memory": map[string]any{"$gt": 0}},
+			value:   map[string]any{"Memory": "512"},
+			want:    false,
+		},
+		{
+			name:    "missing field does not compare",
+			pattern: overTwoGB,
+			value:   map[string]any{"HostConfig": map[string]any{}},
+			want:    false,
+		},
+		{
+			name:    "array length",
+			pattern: map[string]any{"HostConfig": map[string]any{"Binds": map[string]any{"$gt": 4}}},
+			value:   map[string]any{"HostConfig": map[string]any{"Binds": []any{"/a:/a", "/b:/b", "/c:/c", "/d:/d", "/e:/e"}}},
+			want:    true,
+		},
+		{
+			name:    "array length within the limit",
+			pattern: map[string]any{"HostConfig": map[string]any{"Binds": map[string]any{"$gt": 4}}},
+			value:   map[string]any{"HostConfig": map[string]any{"Binds": []any{"/a:/a"}}},
+			want:    false,
+		},
+		{
+			name:    "array element",
+			pattern: map[string]any{"Ports": []any{map[string]any{"$lt": 1024}}},
+			value:   map[string]any{"Ports": []any{float64(8080), float64(80)}},
+			want:    true,
+		},
+		{
+			name:    "negated comparison",
+			pattern: map[string]any{"$not": map[string]any{"Memory": map[string]any{"$gt": 0}}},
+			value:   map[string]any{"Memory": float64(0)},
+			want:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchValue(tt.pattern, tt.value); got != tt.want {
+				t.Errorf("MatchValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	body := map[string]any{"HostConfig": map[string]any{"Memory": float64(4294967296)}}
+	if !MatchValue(overTwoGB, body) {
+		t.Error("MatchValue() = false, want true for memory above the bound")
+	}
+	body["HostConfig"].(map[string]any)["Memory"] = float64(1024)
+	if MatchValue(overTwoGB, body) {
+		t.Error("MatchValue() = true, want false for memory below the bound")
+	}
+}
+
+func TestKeyMatchMatching(t *testing.T) {
+	internalLabels := map[string]any{"Labels": map[string]any{"$keyMatch": `^com\.mycorp\.internal\.`}}
+
+	tests := []struct {
+		name    string
+		pattern any
+		value   any
+		want    bool
+	}{
+		{
+			name:    "one key matches",
+			pattern: internalLabels,
+			value:   map[string]any{"Labels": map[string]any{"team": "payments", "com.mycorp.internal.owner": "ci"}},
+			want:    true,
+		},
+		{
+			name:    "no key matches",
+			pattern: internalLabels,
+			value:   map[string]any{"Labels": map[string]any{"team": "payments", "com.mycorp.public": "yes"}},
+			want:    false,
+		},
+		{
+			name:    "values are not matched",
+			pattern: internalLabels,
+			value:   map[string]any{"Labels": map[string]any{"owner": "com.mycorp.internal.ci"}},
+			want:    false,
+		},
+		{
+			name:    "empty labels",
+			pattern: internalLabels,
+			value:   map[string]any{"Labels": map[string]any{}},
+			want:    false,
+		},
+		{
+			name:    "missing labels",
+			pattern: internalLabels,
+			value:   map[string]any{"Image": "alpine"},
+			want:    false,
+		},
+		{
+			name:    "labels that are not a map",
+			pattern: internalLabels,
+			value:   map[string]any{"Labels": "com.mycorp.internal.owner"},
+			want:    false,
+		},
+		{
+			name:    "negated key match",
+			pattern: map[string]any{"$not": internalLabels},
+			value:   map[string]any{"Labels": map[string]any{"team": "payments"}},
+			want:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchValue(tt.pattern, tt.value); got != tt.want {
+				t.Errorf("MatchValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	body := map[string]any{"Labels": map[string]any{"com.mycorp.internal.owner": "ci"}}
+	if !MatchValue(internalLabels, body) {
+		t.Error("MatchValue() = false, want true for a body with an internal label")
+	}
+	body["Labels"] = map[string]any{"owner": "ci"}
+	if MatchValue(internalLabels, body) {
+		t.Error("MatchValue() = true, want false for a body without an internal label")
+	}
+}
+
+func TestMatchesImage(t *testing.T) {
+	match := Match{Image: `^registry\.example\.com/`}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   map[string]any
+		match  Match
+		want   bool
+	}{
+		{
+			name:   "no image criteria",
+			method: "GET",
+			target: "/v1.42/containers/json",
+			match:  Match{},
+			want:   true,
+		},
+		{
+			name:   "create from allowed registry",
+			method: "POST",
+			target: "/v1.42/containers/create",
+			body:   map[string]any{"Image": "registry.example.com/team/app:1.0"},
+			match:  match,
+			want:   true,
+		},
+		{
+			name:   "create from disallowed registry",
+			method: "POST",
+			target: "/v1.42/containers/create",
+			body:   map[string]any{"Image": "docker.io/library/nginx:latest"},
+			match:  match,
+			want:   false,
+		},
+		{
+			name:   "create without image",
+			method: "POST",
+			target: "/v1.42/containers/create",
+			body:   map[string]any{},
+			match:  match,
+			want:   false,
+		},
+		{
+			name:   "pull from allowed registry",
+			method: "POST",
+			target: "/v1.42/images/create?fromImage=registry.example.com/team/app&tag=1.0",
+			match:  match,
+			want:   true,
+		},
+		{
+			name:   "pull from disallowed registry",
+			method: "POST",
+			target: "/v1.42/images/create?fromImage=nginx&tag=latest",
+			match:  match,
+			want:   false,
+		},
+		{
+			name:   "pull tag is part of the reference",
+			method: "POST",
+			target: "/v1.42/images/create?fromImage=registry.example.com/team/app&tag=1.0",
+			match:  Match{Image: `:1\.0$`},
+			want:   true,
+		},
+		{
+			name:   "other endpoints have no image",
+			method: "GET",
+			target: "/v1.42/images/json",
+			match:  match,
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			if got := MatchesImage(r, tt.body, tt.match); got != tt.want {
+				t.Errorf("MatchesImage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string][]string
+		match   Match
+		want    bool
+	}{
+		{
+			name:  "no header criteria",
+			match: Match{},
+			want:  true,
+		},
+		{
+			name:    "header matches",
+			headers: map[string][]string{"X-Sidecar": {"ci-runner"}},
+			match:   Match{Headers: map[string]string{"X-Sidecar": "^ci-"}},
+			want:    true,
+		},
+		{
+			name:    "header name is case insensitive",
+			headers: map[string][]string{"X-Sidecar": {"ci-runner"}},
+			match:   Match{Headers: map[string]string{"x-sidecar": "^ci-"}},
+			want:    true,
+		},
+		{
+			name:    "header does not match",
+			headers: map[string][]string{"X-Sidecar": {"dev"}},
+			match:   Match{Headers: map[string]string{"X-Sidecar": "^ci-"}},
+			want:    false,
+		},
+		{
+			name:  "missing header does not match a non-empty pattern",
+			match: Match{Headers: map[string]string{"X-Registry-Auth": ".+"}},
+			want:  false,
+		},
+		{
+			name:  "missing header matches an empty pattern",
+			match: Match{Headers: map[string]string{"X-Registry-Auth": "^$"}},
+			want:  true,
+		},
+		{
+			name:    "any value of a repeated header",
+			headers: map[string][]string{"X-Team": {"ops", "ci"}},
+			match:   Match{Headers: map[string]string{"X-Team": "^ci$"}},
+			want:    true,
+		},
+		{
+			name:    "every header must match",
+			headers: map[string][]string{"X-Sidecar": {"ci-runner"}},
+			match:   Match{Headers: map[string]string{"X-Sidecar": "^ci-", "X-Registry-Auth": ".+"}},
+			want:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/v1.42/containers/json", nil)
+			for name, values := range tt.headers {
+				for _, value := range values {
+					r.Header.Add(name, value)
+				}
+			}
+			if got := MatchesHeaders(r, tt.match); got != tt.want {
+				t.Errorf("MatchesHeaders() = %v, want %v", got, tt.want)
+			}
+			if got := MatchesRule(r, tt.match); got != tt.want {
+				t.Errorf("MatchesRule() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesQuery(t *testing.T) {
+	buildArgs := url.QueryEscape(`{"HTTP_PROXY":"http://proxy:3128","NPM_TOKEN":"abc"}`)
+
+	tests := []struct {
+		name   string
+		target string
+		match  Match
+		want   bool
+	}{
+		{
+			name:   "no query criteria",
+			target: "/build?dockerfile=Dockerfile",
+			match:  Match{},
+			want:   true,
+		},
+		{
+			name:   "regex matches parameter",
+			target: "/build?dockerfile=ci/Dockerfile",
+			match:  Match{Query: map[string]any{"dockerfile": "^ci/"}},
+			want:   true,
+		},
+		{
+			name:   "regex does not match parameter",
+			target: "/build?dockerfile=Dockerfile",
+			match:  Match{Query: map[string]any{"dockerfile": "^ci/"}},
+			want:   false,
+		},
+		{
+			name:   "missing parameter matches an empty pattern",
+			target: "/build",
+			match:  Match{Query: map[string]any{"nocache": "^$"}},
+			want:   true,
+		},
+		{
+			name:   "decoded build arg key",
+			target: "/build?buildargs=" + buildArgs,
+			match:  Match{Query: map[string]any{"buildargs": map[string]any{"NPM_TOKEN": ".*"}}},
+			want:   true,
+		},
+		{
+			name:   "decoded build arg absent",
+			target: "/build?buildargs=" + url.QueryEscape(`{"HTTP_PROXY":"http://proxy:3128"}`),
+			match:  Match{Query: map[string]any{"buildargs": map[string]any{"NPM_TOKEN": ".*"}}},
+			want:   false,
+		},
+		{
+			name:   "structured pattern needs the parameter",
+			target: "/build",
+			match:  Match{Query: map[string]any{"buildargs": map[string]any{"NPM_TOKEN": ".*"}}},
+			want:   false,
+		},
+		{
+			name:   "structured pattern needs JSON",
+			target: "/build?buildargs=not-json",
+			match:  Match{Query: map[string]any{"buildargs": map[string]any{"NPM_TOKEN": ".*"}}},
+			want:   false,
+		},
+		{
+			name:   "every parameter must match",
+			target: "/build?dockerfile=ci/Dockerfile&nocache=1",
+			match:  Match{Query: map[string]any{"dockerfile": "^ci/", "nocache": "^$"}},
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", tt.target, nil)
+			if got := MatchesQuery(r, tt.match); got != tt.want {
+				t.Errorf("MatchesQuery() = %v, want %v", got, tt.want)
+			}
+			if got := MatchesRule(r, tt.match); got != tt.want {
+				t.Errorf("MatchesRule() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesRawQuery(t *testing.T) {
+	filters := url.Values{
+		"all":     {"1"},
+		"filters": {`{"label":["com.example.team=payments","env=prod"],"status":["running"]}`},
+	}.Encode()
+
+	tests := []struct {
+		name   string
+		target string
+		match  Match
+		want   bool
+	}{
+		{
+			name:   "no raw query criteria",
+			target: "/containers/json?" + filters,
+			match:  Match{},
+			want:   true,
+		},
+		{
+			name:   "substring of a filters value",
+			target: "/containers/json?" + filters,
+			match:  Match{RawQuery: `"env=prod"`},
+			want:   true,
+		},
+		{
+			name:   "pattern spanning filter keys",
+			target: "/containers/json?" + filters,
+			match:  Match{RawQuery: `"label":\[[^\]]*team=payments`},
+			want:   true,
+		},
+		{
+			name:   "substring absent",
+			target: "/containers/json?" + filters,
+			match:  Match{RawQuery: `"env=staging"`},
+			want:   false,
+		},
+		{
+			name:   "anchored to the start of the query",
+			target: "/containers/json?" + filters,
+			match:  Match{RawQuery: `^all=1&`},
+			want:   true,
+		},
+		{
+			name:   "no query string",
+			target: "/containers/json",
+			match:  Match{RawQuery: "filters="},
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if got := MatchesRawQuery(r, tt.match); got != tt.want {
+				t.Errorf("MatchesRawQuery() = %v, want %v", got, tt.want)
+			}
+			if got := MatchesRule(r, tt.match); got != tt.want {
+				t.Errorf("MatchesRule() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidrs   []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "ipv4", cidrs: []string{"10.0.0.0/8", "192.168.1.7/24"}, want: []string{"10.0.0.0/8", "192.168.1.0/24"}},
+		{name: "ipv6", cidrs: []string{"fd00::/8", "2001:db8::1/64"}, want: []string{"fd00::/8", "2001:db8::/64"}},
+		{name: "bare addresses", cidrs: []string{"127.0.0.1", "::1", "::ffff:10.1.2.3"}, want: []string{"127.0.0.1/32", "::1/128", "10.1.2.3/32"}},
+		{name: "invalid prefix length", cidrs: []string{"10.0.0.0/33"}, wantErr: true},
+		{name: "not an address", cidrs: []string{"localhost"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefixes, err := ParseCIDRs(tt.cidrs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCIDRs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(prefixes) != len(tt.want) {
+				t.Fatalf("ParseCIDRs() = %v, want %v", prefixes, tt.want)
+			}
+			for i, prefix := range prefixes {
+				if prefix.String() != tt.want[i] {
+					t.Errorf("prefix %d = %s, want %s", i, prefix, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSourceAddr(t *testing.T) {
+	trusted, err := ParseCIDRs([]string{"10.0.0.0/8", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		trusted    []netip.Prefix
+		want       string
+		wantErr    bool
+	}{
+		{name: "ipv4", remoteAddr: "192.0.2.1:51000", want: "192.0.2.1"},
+		{name: "ipv6", remoteAddr: "[2001:db8::1]:51000", want: "2001:db8::1"},
+		{name: "ipv6 loopback", remoteAddr: "[::1]:1234", want: "::1"},
+		{name: "ipv6 with zone", remoteAddr: "[fe80::1%eth0]:1234", want: "fe80::1"},
+		{name: "ipv4 mapped ipv6", remoteAddr: "[::ffff:192.0.2.1]:1234", want: "192.0.2.1"},
+		{name: "without port", remoteAddr: "192.0.2.1", want: "192.0.2.1"},
+		{name: "forwarded header ignored from untrusted peer", remoteAddr: "192.0.2.1:1234", forwarded: []string{"203.0.113.9"}, trusted: trusted, want: "192.0.2.1"},
+		{name: "forwarded header ignored without trusted proxies", remoteAddr: "10.0.0.2:1234", forwarded: []string{"203.0.113.9"}, want: "10.0.0.2"},
+		{name: "forwarded by trusted proxy", remoteAddr: "10.0.0.2:1234", forwarded: []string{"203.0.113.9"}, trusted: trusted, want: "203.0.113.9"},
+		{name: "forwarded ipv6 by trusted proxy", remoteAddr: "[fd00::2]:1234", forwarded: []string{"2001:db8::9"}, trusted: trusted, want: "2001:db8::9"},
+		{name: "spoofed entries left of the client", remoteAddr: "10.0.0.2:1234", forwarded: []string{"198.51.100.1, 203.0.113.9, 10.0.0.3"}, trusted: trusted, want: "203.0.113.9"},
+		{name: "chain split across headers", remoteAddr: "10.0.0.2:1234", forwarded: []string{"203.0.113.9", "10.0.0.3"}, trusted: trusted, want: "203.0.113.9"},
+		{name: "only trusted proxies", remoteAddr: "10.0.0.2:1234", forwarded: []string{"10.0.0.4, 10.0.0.3"}, trusted: trusted, want: "10.0.0.4"},
+		{name: "trusted peer without header", remoteAddr: "10.0.0.2:1234", trusted: trusted, want: "10.0.0.2"},
+		{name: "unix socket peer", remoteAddr: "@", wantErr: true},
+		{name: "empty remote address", remoteAddr: "", wantErr: true},
+		{name: "malformed remote address", remoteAddr: "not-an-ip:1234", wantErr: true},
+		{name: "malformed forwarded address", remoteAddr: "10.0.0.2:1234", forwarded: []string{"203.0.113.9, garbage"}, trusted: trusted, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/_ping", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, header := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", header)
+			}
+
+			got, err := SourceAddr(req, tt.trusted)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SourceAddr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrNoSourceAddr) {
+					t.Errorf("SourceAddr() error = %v, want ErrNoSourceAddr", err)
+				}
+				return
+			}
+			if got.String() != tt.want {
+				t.Errorf("SourceAddr() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesSource(t *testing.T) {
+	trusted, err := ParseCIDRs([]string{"192.0.2.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		cidrs      []string
+		remoteAddr string
+		trusted    []netip.Prefix
+		local      net.Addr
+		want       bool
+	}{
+		{name: "no source criteria", remoteAddr: "192.0.2.1:1234", want: true},
+		{name: "within cidr", cidrs: []string{"10.0.0.0/8"}, remoteAddr: "10.1.2.3:1234", want: true},
+		{name: "outside cidr", cidrs: []string{"10.0.0.0/8"}, remoteAddr: "192.0.2.1:1234", want: false},
+		{name: "any of several cidrs", cidrs: []string{"10.0.0.0/8", "2001:db8::/32"}, remoteAddr: "[2001:db8::1]:1234", want: true},
+		{name: "single host", cidrs: []string{"192.0.2.1"}, remoteAddr: "192.0.2.1:1234", want: true},
+		{name: "forwarded header is ignored", cidrs: []string{"10.0.0.0/8"}, remoteAddr: "192.0.2.1:1234", want: false},
+		{name: "forwarded header from an untrusted hop is ignored", cidrs: []string{"10.0.0.0/8"}, remoteAddr: "198.51.100.1:1234", trusted: trusted, want: false},
+		{name: "forwarded header from a trusted hop", cidrs: []string{"10.0.0.0/8"}, remoteAddr: "192.0.2.1:1234", trusted: trusted, want: true},
+		{name: "trusted hop is not the client", cidrs: []string{"192.0.2.0/24"}, remoteAddr: "192.0.2.1:1234", trusted: trusted, want: false},
+		{name: "unix socket", cidrs: []string{"10.0.0.0/8"}, remoteAddr: "@", local: &net.UnixAddr{Name: "/tmp/proxy.sock", Net: "unix"}, want: true},
+		{name: "unreadable address", cidrs: []string{"10.0.0.0/8"}, remoteAddr: "", want: false},
+		{name: "invalid cidr", cidrs: []string{"10.0.0.0/33"}, remoteAddr: "10.1.2.3:1234", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/_ping", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Forwarded-For", "10.0.0.9")
+			if tt.local != nil {
+				req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, tt.local))
+			}
+			if tt.trusted != nil {
+				req = req.WithContext(ContextWithTrustedProxies(req.Context(), tt.trusted))
+			}
+
+			if got := MatchesSource(req, Match{SourceCIDR: tt.cidrs}); got != tt.want {
+				t.Errorf("MatchesSource() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		pattern string
+		input   string
+		want    bool
+	}{
+		{name: "regex by default", pattern: "/v1.*/containers", input: "/v1.42/containers/json", want: true},
+		{name: "regex matches anywhere", mode: MatchModeRegex, pattern: "containers", input: "/v1.42/containers/json", want: true},
+		{name: "glob star", mode: MatchModeGlob, pattern: "/v1.*/containers/*", input: "/v1.42/containers/json", want: true},
+		{name: "glob must match the whole path", mode: MatchModeGlob, pattern: "/containers", input: "/v1.42/containers/json", want: false},
+		{name: "glob dot is literal", mode: MatchModeGlob, pattern: "/v1.42/*", input: "/v1x42/info", want: false},
+		{name: "glob question mark", mode: MatchModeGlob, pattern: "P??T", input: "POST", want: true},
+		{name: "regex metacharacters in a glob are literal", mode: MatchModeGlob, pattern: "^GET$", input: "GET", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Match{MatchMode: tt.mode}.MatchPattern(tt.pattern, tt.input)
+			if err != nil {
+				t.Fatalf("MatchPattern() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("MatchPattern(%q, %q) = %v, want %v", tt.pattern, tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchMethod(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		pattern string
+		method  string
+		want    bool
+	}{
+		{name: "exact method", pattern: "GET", method: "GET", want: true},
+		{name: "no substring match", pattern: "GET", method: "GETX", want: false},
+		{name: "no suffix match", pattern: "POST", method: "XPOST", want: false},
+		{name: "alternation", pattern: "GET|POST", method: "POST", want: true},
+		{name: "alternation is anchored as a whole", pattern: "GET|POST", method: "POSTX", want: false},
+		{name: "already anchored", pattern: "^(PUT|PATCH)$", method: "PATCH", want: true},
+		{name: "glob", mode: MatchModeGlob, pattern: "P*", method: "PUT", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Match{MatchMode: tt.mode, Method: tt.pattern}.MatchMethod(tt.method)
+			if err != nil {
+				t.Fatalf("MatchMethod() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("MatchMethod(%q) with %q = %v, want %v", tt.method, tt.pattern, got, tt.want)
+			}
+
+			// The proxy and rewrites decide with the same matcher
+			req := httptest.NewRequest(tt.method, "/v1.42/containers/json", nil)
+			if matched := MatchesRule(req, Match{MatchMode: tt.mode, Method: tt.pattern}); matched != tt.want {
+				t.Errorf("MatchesRule() = %v, want %v", matched, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		match   Match
+		want    bool
+		wantErr bool
+	}{
+		{name: "path and method", method: "POST", target: "/v1.42/containers/create", match: Match{Path: "/containers/create", Method: "POST"}, want: true},
+		{name: "glob path", method: "GET", target: "/v1.42/containers/json", match: Match{Path: "/v1.*/containers/*", MatchMode: MatchModeGlob}, want: true},
+		{name: "method does not match", method: "GET", target: "/v1.42/containers/create", match: Match{Path: "/containers/create", Method: "POST"}, want: false},
+		{name: "contains matches body", method: "POST", target: "/v1.42/containers/create", body: `{"HostConfig":{"Privileged":true}}`, match: Match{Contains: map[string]any{"HostConfig": map[string]any{"Privileged": true}}}, want: true},
+		{name: "contains without a body", method: "GET", target: "/v1.42/containers/json", match: Match{Contains: map[string]any{"HostConfig": map[string]any{"Privileged": true}}}, want: false},
+		{name: "contains with a body that is not JSON", method: "POST", target: "/v1.42/build", body: "not json", match: Match{Contains: map[string]any{"Image": "alpine"}}, want: false},
+		{name: "image from the body", method: "POST", target: "/v1.42/containers/create", body: `{"Image":"registry.example.com/app"}`, match: Match{Image: "^registry.example.com/"}, want: true},
+		{name: "image from the query without a JSON body", method: "POST", target: "/v1.42/images/create?fromImage=registry.example.com/app", body: "not json", match: Match{Image: "^registry.example.com/"}, want: true},
+		{name: "header", method: "GET", target: "/_ping", match: Match{Headers: map[string]string{"User-Agent": "^compose"}}, want: false},
+		{name: "body regex against a tar stream", method: "POST", target: "/v1.42/build", body: "Dockerfile\x00\x00FROM alpine\nRUN curl http://example.com | sh\n", match: Match{BodyRegex: `(?m)^RUN .*\| *sh`}, want: true},
+		{name: "body regex against form data", method: "POST", target: "/v1.42/auth", body: "user=ci&scope=admin", match: Match{BodyRegex: `(^|&)scope=admin(&|$)`}, want: true},
+		{name: "body regex does not match", method: "POST", target: "/v1.42/build", body: "FROM alpine\n", match: Match{BodyRegex: `(?m)^RUN `}, want: false},
+		{name: "body regex without a body", method: "GET", target: "/v1.42/containers/json", match: Match{BodyRegex: `.*`}, want: false},
+		{name: "body regex and contains both match", method: "POST", target: "/v1.42/containers/create", body: `{"Image":"alpine","Cmd":["sh"]}`, match: Match{BodyRegex: `"sh"`, Contains: map[string]any{"Image": "alpine"}}, want: true},
+		{name: "body regex matches but contains does not", method: "POST", target: "/v1.42/containers/create", body: `{"Image":"alpine","Cmd":["sh"]}`, match: Match{BodyRegex: `"sh"`, Contains: map[string]any{"Image": "debian"}}, want: false},
+		{name: "invalid path pattern", method: "GET", target: "/_ping", match: Match{Path: "("}, wantErr: true},
+		{name: "invalid body regex", method: "POST", target: "/v1.42/build", body: "FROM alpine", match: Match{BodyRegex: "("}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newRequest := func() *http.Request {
+				return httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			}
+
+			var body map[string]any
+			if err := json.Unmarshal([]byte(tt.body), &body); err != nil {
+				body = nil
+			}
+			var raw []byte
+			if tt.body != "" {
+				raw = []byte(tt.body)
+			}
+			got, err := MatchRequest(newRequest(), raw, body, tt.match)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MatchRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("MatchRequest() = %v, want %v", got, tt.want)
+			}
+
+			// MatchesRule reads the body itself and reaches the same result
+			r := newRequest()
+			if got := MatchesRule(r, tt.match); got != tt.want {
+				t.Errorf("MatchesRule() = %v, want %v", got, tt.want)
+			}
+			rest, err := io.ReadAll(r.Body)
+			if err != nil || string(rest) != tt.body {
+				t.Errorf("body after MatchesRule() = %q, %v, want %q", rest, err, tt.body)
+			}
+		})
+	}
+}
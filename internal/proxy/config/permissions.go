@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// DefaultSocketMode is the permission mode of proxy socket files when the
+// config does not set socket_mode
+const DefaultSocketMode os.FileMode = 0660
+
+// SocketFileMode returns the permission mode for the socket file, parsed from
+// the octal socket_mode string such as "0660" or "660"
+func (c ConfigSet) SocketFileMode() (os.FileMode, error) {
+	if c.SocketMode == "" {
+		return DefaultSocketMode, nil
+	}
+
+	mode, err := strconv.ParseUint(c.SocketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid socket_mode %q (must be an octal permission mode such as 0660)", c.SocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// SocketGroupID returns the group ID that should own the socket file, from a
+// group name or a numeric gid. ok is false when socket_group is not set.
+func (c ConfigSet) SocketGroupID() (gid int, ok bool, err error) {
+	if c.SocketGroup == "" {
+		return 0, false, nil
+	}
+
+	if gid, err := strconv.Atoi(c.SocketGroup); err == nil {
+		if gid < 0 {
+			return 0, false, fmt.Errorf("invalid socket_group %q (gid cannot be negative)", c.SocketGroup)
+		}
+		return gid, true, nil
+	}
+
+	group, err := user.LookupGroup(c.SocketGroup)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid socket_group %q: %w", c.SocketGroup, err)
+	}
+	gid, err = strconv.Atoi(group.Gid)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid socket_group %q: unexpected gid %q", c.SocketGroup, group.Gid)
+	}
+	return gid, true, nil
+}
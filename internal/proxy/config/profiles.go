@@ -0,0 +1,206 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// containerCreatePath matches container creates with or without an API version
+const containerCreatePath = `^(/v[0-9.]+)?/containers/create$`
+
+// profiles are the built-in rule bundles a config can reference by name in
+// its top-level profiles list. Every profile only denies, so profiles can be combined in any order and
+// never let through a request that the config's own rules would deny.
+var profiles = map[string][]Rule{
+	// read-only refuses every request that could change state
+	"read-only": {
+		{
+			Match:   Match{Path: "^/", Method: "^(POST|PUT|PATCH|DELETE)$"},
+			Actions: []Action{{Action: "deny", Reason: "read-only profile: only GET and HEAD requests are allowed"}},
+		},
+	},
+	// no-privileged refuses privileged containers and privileged execs
+	"no-privileged": {
+		{
+			Match: Match{
+				Path:     containerCreatePath,
+				Method:   "^POST$",
+				Contains: map[string]any{"HostConfig": map[string]any{"Privileged": true}},
+			},
+			Actions: []Action{{Action: "deny", Reason: "no-privileged profile: privileged containers are not allowed"}},
+		},
+		{
+			Match: Match{
+				Path:     `^(/v[0-9.]+)?/containers/[^/]+/exec$`,
+				Method:   "^POST$",
+				Contains: map[string]any{"Privileged": true},
+			},
+			Actions: []Action{{Action: "deny", Reason: "no-privileged profile: privileged exec is not allowed"}},
+		},
+	},
+	// no-host-namespaces refuses containers that share a host namespace
+	"no-host-namespaces": hostNamespaceRules("NetworkMode", "PidMode", "IpcMode", "UTSMode", "UsernsMode"),
+	// no-docker-socket refuses containers that bind mount a Docker socket,
+	// which would let them bypass the proxy
+	"no-docker-socket": {
+		{
+			Match: Match{
+				Path:     containerCreatePath,
+				Method:   "^POST$",
+				Contains: map[string]any{"HostConfig": map[string]any{"Binds": []any{`^[^:]*docker\.sock:`}}},
+			},
+			Actions: []Action{{Action: "deny", Reason: "no-docker-socket profile: mounting the Docker socket is not allowed"}},
+		},
+	},
+	// no-exec refuses running commands in existing containers
+	"no-exec": {
+		{
+			Match:   Match{Path: `^(/v[0-9.]+)?/containers/[^/]+/exec$`, Method: "^POST$"},
+			Actions: []Action{{Action: "deny", Reason: "no-exec profile: exec is not allowed"}},
+		},
+	},
+}
+
+// readOnlyPaths are the read endpoints the read-only-access profile allows
+const readOnlyPaths = `^(/v[0-9.]+)?/(_ping|version|containers|images|networks)(/|$)`
+
+// accessProfiles are the built-in allow-lists a config can select with
+// config.profile. Each one allows the API calls a kind of client needs, and
+// everything else falls through to the config's own rules and then to a
+// default deny. Their names differ from those of profiles, so that a name
+// put in the wrong field is refused rather than meaning the opposite.
+var accessProfiles = map[string][]Rule{
+	// read-only-access lets a client inspect containers, images and networks
+	"read-only-access": {
+		allowRule("read-only-access", readOnlyPaths, "^(GET|HEAD)$"),
+	},
+	// ci-builder lets a CI job build, pull and push images and run the
+	// containers of its build
+	"ci-builder": {
+		allowRule("ci-builder", `^(/v[0-9.]+)?/(_ping|version|info|containers|images|networks|volumes)(/|$)`, "^(GET|HEAD)$"),
+		allowRule("ci-builder", `^(/v[0-9.]+)?/(build|session|images/create)$`, "^POST$"),
+		allowRule("ci-builder", `^(/v[0-9.]+)?/images/.+/(push|tag)$`, "^POST$"),
+		allowRule("ci-builder", `^(/v[0-9.]+)?/containers/create$`, "^POST$"),
+		allowRule("ci-builder", `^(/v[0-9.]+)?/containers/[^/]+/(start|stop|kill|wait|attach|resize)$`, "^POST$"),
+		allowRule("ci-builder", `^(/v[0-9.]+)?/containers/[^/]+$`, "^DELETE$"),
+	},
+	// watchtower lets Watchtower pull new images and recreate the
+	// containers that use them
+	"watchtower": {
+		allowRule("watchtower", `^(/v[0-9.]+)?/(_ping|version|info|containers|images|networks)(/|$)`, "^(GET|HEAD)$"),
+		allowRule("watchtower", `^(/v[0-9.]+)?/images/create$`, "^POST$"),
+		allowRule("watchtower", `^(/v[0-9.]+)?/containers/create$`, "^POST$"),
+		allowRule("watchtower", `^(/v[0-9.]+)?/containers/[^/]+/(start|stop|kill|rename)$`, "^POST$"),
+		allowRule("watchtower", `^(/v[0-9.]+)?/networks/[^/]+/(connect|disconnect)$`, "^POST$"),
+		allowRule("watchtower", `^(/v[0-9.]+)?/(containers|images)/[^/]+$`, "^DELETE$"),
+	},
+}
+
+// allowRule returns an access profile rule allowing a path and method
+func allowRule(profile, path, method string) Rule {
+	return Rule{
+		Match:   Match{Path: path, Method: method},
+		Actions: []Action{{Action: "allow", Reason: profile + " profile"}},
+	}
+}
+
+// hostNamespaceRules returns a rule per HostConfig mode field denying "host"
+func hostNamespaceRules(fields ...string) []Rule {
+	rules := make([]Rule, 0, len(fields))
+	for _, field := range fields {
+		rules = append(rules, Rule{
+			Match: Match{
+				Path:     containerCreatePath,
+				Method:   "^POST$",
+				Contains: map[string]any{"HostConfig": map[string]any{field: "host"}},
+			},
+			Actions: []Action{{Action: "deny", Reason: fmt.Sprintf("no-host-namespaces profile: %s host is not allowed", field)}},
+		})
+	}
+	return rules
+}
+
+// ProfileNames returns the names of the built-in profiles, sorted
+func ProfileNames() []string {
+	return sortedKeys(profiles)
+}
+
+// AccessProfileNames returns the names of the built-in access profiles, sorted
+func AccessProfileNames() []string {
+	return sortedKeys(accessProfiles)
+}
+
+// ProfileRules returns a copy of the rules of a built-in profile
+func ProfileRules(name string) ([]Rule, bool) {
+	rules, ok := profiles[name]
+	if !ok {
+		return nil, false
+	}
+	return append([]Rule(nil), rules...), true
+}
+
+// ExpandProfiles replaces the profiles a config references with their rules,
+// placed ahead of the config's own rules so that they always apply. Profile
+// rules take the highest priority of the config's rules, so that no rule is
+// evaluated before them. The rules of an access profile follow, and unless
+// the config sets a default action everything they and the config's rules
+// do not allow is denied. The config then no longer references any
+// profiles, so expanding it again is a no-op.
+func ExpandProfiles(config *SocketConfig) error {
+	if config == nil || (len(config.Profiles) == 0 && config.Config.Profile == "") {
+		return nil
+	}
+
+	priority := 0
+	for _, rule := range config.Rules {
+		priority = max(priority, rule.Priority)
+	}
+
+	var rules []Rule
+	for _, name := range config.Profiles {
+		profileRules, ok := ProfileRules(name)
+		if !ok {
+			return unknownProfileError(name)
+		}
+		for i := range profileRules {
+			profileRules[i].Priority = priority
+		}
+		rules = append(rules, profileRules...)
+	}
+
+	// Access profile rules keep the default priority, so a rule of the
+	// config can be given a priority to deny what the profile allows
+	if name := config.Config.Profile; name != "" {
+		accessRules, ok := accessProfiles[name]
+		if !ok {
+			return unknownAccessProfileError(name)
+		}
+		rules = append(rules, accessRules...)
+		if config.Config.DefaultAction == "" {
+			config.Config.DefaultAction = DefaultActionDeny
+		}
+	}
+
+	config.Rules = append(rules, config.Rules...)
+	config.Profiles = nil
+	config.Config.Profile = ""
+	return nil
+}
+
+// unknownProfileError describes a profile name that is not built in, and
+// points an access profile name at config.profile
+func unknownProfileError(name string) error {
+	if _, ok := accessProfiles[name]; ok {
+		return fmt.Errorf("unknown profile %q, it is an access profile and is set with config.profile (available: %s)", name, strings.Join(ProfileNames(), ", "))
+	}
+	return fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(ProfileNames(), ", "))
+}
+
+// unknownAccessProfileError describes an access profile name that is not
+// built in, and points a profile name at the profiles list
+func unknownAccessProfileError(name string) error {
+	if _, ok := profiles[name]; ok {
+		return fmt.Errorf("unknown access profile %q, it is a profile and is listed under profiles (available: %s)", name, strings.Join(AccessProfileNames(), ", "))
+	}
+	return fmt.Errorf("unknown access profile %q (available: %s)", name, strings.Join(AccessProfileNames(), ", "))
+}
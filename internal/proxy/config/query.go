@@ -0,0 +1,62 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+// MatchesQuery checks the query criteria of a match. A string pattern is a
+// regex matched against the parameter value, with a parameter the request did
+// not send matched as an empty string. Any other pattern is matched like
+// contains against the parameter decoded as JSON, which is how Docker encodes
+// structured parameters such as buildargs, labels and filters.
+func MatchesQuery(r *http.Request, match Match) bool {
+	if len(match.Query) == 0 {
+		return true
+	}
+
+	query := r.URL.Query()
+	for key, pattern := range match.Query {
+		value := query.Get(key)
+
+		if expr, ok := pattern.(string); ok {
+			matched, err := regexp.MatchString(expr, value)
+			if err != nil || !matched {
+				return false
+			}
+			continue
+		}
+
+		if value == "" {
+			return false
+		}
+		var decoded any
+		if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+			return false
+		}
+		if !MatchValue(pattern, decoded) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchesRawQuery checks the raw query criteria of a match. The pattern is
+// matched against the percent-decoded query string, so that it can be written
+// against values such as JSON filters the way clients build them rather than
+// the way they are encoded on the wire.
+func MatchesRawQuery(r *http.Request, match Match) bool {
+	if match.RawQuery == "" {
+		return true
+	}
+
+	query, err := url.QueryUnescape(r.URL.RawQuery)
+	if err != nil {
+		query = r.URL.RawQuery
+	}
+
+	matched, err := regexp.MatchString(match.RawQuery, query)
+	return err == nil && matched
+}
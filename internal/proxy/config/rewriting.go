@@ -5,12 +5,36 @@ import (
 	"strings"
 )
 
+// ApplyRewriteAction applies an upsert, replace or delete action to a body,
+// reporting whether the body was modified
+func ApplyRewriteAction(body map[string]any, action Action) bool {
+	switch action.Action {
+	case "replace":
+		if MatchValue(action.Contains, body) {
+			return mergeStructure(body, action.Update, true, action.ArrayKey)
+		}
+	case "upsert":
+		return mergeStructure(body, action.Update, false, action.ArrayKey)
+	case "delete":
+		return DeleteMatchingFields(body, action.Contains)
+	}
+	return false
+}
+
 // MergeStructure merges an update structure into a body
 func MergeStructure(body map[string]any, update map[string]any, replace bool) bool {
+	return mergeStructure(body, update, replace, "")
+}
+
+// mergeStructure merges an update structure into a body. Objects in arrays
+// at any depth are identified by their arrayKey field when one is given, so
+// that an update replaces the object with the same key instead of adding a
+// second one.
+func mergeStructure(body map[string]any, update map[string]any, replace bool, arrayKey string) bool {
 	modified := false
 
 	for key, updateValue := range update {
-		if mergeValue(body, key, updateValue, replace) {
+		if mergeValue(body, key, updateValue, replace, arrayKey) {
 			modified = true
 		}
 	}
@@ -19,22 +43,22 @@ func MergeStructure(body map[string]any, update map[string]any, replace bool) bo
 }
 
 // mergeValue handles merging a single value into the body
-func mergeValue(body map[string]any, key string, updateValue any, replace bool) bool {
+func mergeValue(body map[string]any, key string, updateValue any, replace bool, arrayKey string) bool {
 	switch v := updateValue.(type) {
 	case map[string]any:
-		return mergeMap(body, key, v, replace)
+		return mergeMap(body, key, v, replace, arrayKey)
 	case []any:
-		return mergeArray(body, key, v, replace)
+		return mergeArray(body, key, v, replace, arrayKey)
 	default:
 		return mergeSimpleValue(body, key, v, replace)
 	}
 }
 
 // mergeMap handles merging a map value
-func mergeMap(body map[string]any, key string, updateMap map[string]any, replace bool) bool {
+func mergeMap(body map[string]any, key string, updateMap map[string]any, replace bool, arrayKey string) bool {
 	if actualValue, exists := body[key]; exists {
 		if actualMap, ok := actualValue.(map[string]any); ok {
-			return MergeStructure(actualMap, updateMap, replace)
+			return mergeStructure(actualMap, updateMap, replace, arrayKey)
 		}
 		if replace {
 			body[key] = updateMap
@@ -48,13 +72,13 @@ func mergeMap(body map[string]any, key string, updateMap map[string]any, replace
 }
 
 // mergeArray handles merging an array value
-func mergeArray(body map[string]any, key string, updateArray []any, replace bool) bool {
+func mergeArray(body map[string]any, key string, updateArray []any, replace bool, arrayKey string) bool {
 	if actualValue, exists := body[key]; exists {
 		if actualArray, ok := actualValue.([]any); ok {
 			if replace {
-				return replaceArray(body, key, actualArray, updateArray)
+				return replaceArray(body, key, actualArray, updateArray, arrayKey)
 			}
-			return upsertArray(body, key, actualArray, updateArray)
+			return upsertArray(body, key, actualArray, updateArray, arrayKey)
 		}
 		if replace {
 			body[key] = updateArray
@@ -68,14 +92,18 @@ func mergeArray(body map[string]any, key string, updateArray []any, replace bool
 }
 
 // replaceArray handles replacing elements in an array
-func replaceArray(body map[string]any, key string, actualArray, updateArray []any) bool {
+func replaceArray(body map[string]any, key string, actualArray, updateArray []any, arrayKey string) bool {
 	newArray := make([]any, 0)
 	replacedIndices := make(map[int]bool)
 
 	// Replace matching elements
 	for _, updateItem := range updateArray {
+		candidate := isReplacementCandidate
+		if _, ok := arrayKeyValue(updateItem, arrayKey); ok {
+			candidate = func(actual, update any) bool { return sameArrayKey(actual, update, arrayKey) }
+		}
 		for i, actualItem := range actualArray {
-			if !replacedIndices[i] && isReplacementCandidate(actualItem, updateItem) {
+			if !replacedIndices[i] && candidate(actualItem, updateItem) {
 				replacedIndices[i] = true
 				break
 			}
@@ -95,7 +123,12 @@ func replaceArray(body map[string]any, key string, actualArray, updateArray []an
 }
 
 // upsertArray handles upserting elements into an array
-func upsertArray(body map[string]any, key string, actualArray, updateArray []any) bool {
+func upsertArray(body map[string]any, key string, actualArray, updateArray []any, arrayKey string) bool {
+	for _, updateItem := range updateArray {
+		if _, ok := arrayKeyValue(updateItem, arrayKey); ok {
+			return upsertKeyedArray(body, key, actualArray, updateArray, arrayKey)
+		}
+	}
 	if isKeyValueArray(updateArray) {
 		return upsertKeyValueArray(body, key, actualArray, updateArray)
 	}
@@ -140,13 +173,69 @@ func upsertKeyValueArray(body map[string]any, key string, actualArray, updateArr
 	return false
 }
 
+// upsertKeyedArray upserts objects into an array, replacing the object whose
+// arrayKey field has the same value as an update's rather than appending a
+// duplicate. Items without the field are added unless already present.
+func upsertKeyedArray(body map[string]any, key string, actualArray, updateArray []any, arrayKey string) bool {
+	newArray := make([]any, len(actualArray))
+	copy(newArray, actualArray)
+	arrayModified := false
+
+	for _, updateItem := range updateArray {
+		idx := -1
+		for i, actualItem := range newArray {
+			if sameArrayKey(actualItem, updateItem, arrayKey) || reflect.DeepEqual(actualItem, updateItem) {
+				idx = i
+				break
+			}
+		}
+
+		switch {
+		case idx < 0:
+			newArray = append(newArray, updateItem)
+			arrayModified = true
+		case !reflect.DeepEqual(newArray[idx], updateItem):
+			newArray[idx] = updateItem
+			arrayModified = true
+		}
+	}
+
+	if arrayModified {
+		body[key] = newArray
+		return true
+	}
+	return false
+}
+
+// arrayKeyValue returns the arrayKey field of an object in an array
+func arrayKeyValue(item any, arrayKey string) (any, bool) {
+	object, ok := item.(map[string]any)
+	if !ok || arrayKey == "" {
+		return nil, false
+	}
+	value, ok := object[arrayKey]
+	return value, ok
+}
+
+// sameArrayKey reports whether two objects have the same arrayKey field
+func sameArrayKey(a, b any, arrayKey string) bool {
+	aValue, aOK := arrayKeyValue(a, arrayKey)
+	bValue, bOK := arrayKeyValue(b, arrayKey)
+	return aOK && bOK && reflect.DeepEqual(aValue, bValue)
+}
+
 // upsertSimpleArray handles upserting simple values into an array
 func upsertSimpleArray(body map[string]any, key string, actualArray, updateArray []any) bool {
+	equal := reflect.DeepEqual
+	if key == "Binds" {
+		equal = sameBind
+	}
+
 	newItems := false
 	for _, updateItem := range updateArray {
 		found := false
 		for _, actualItem := range actualArray {
-			if reflect.DeepEqual(actualItem, updateItem) {
+			if equal(actualItem, updateItem) {
 				found = true
 				break
 			}
@@ -163,6 +252,29 @@ func upsertSimpleArray(body map[string]any, key string, actualArray, updateArray
 	return false
 }
 
+// sameBind reports whether two Binds entries mount the same source at the
+// same target, ignoring any mode suffix such as :ro or :rw,z
+func sameBind(a, b any) bool {
+	aStr, aOK := a.(string)
+	bStr, bOK := b.(string)
+	if !aOK || !bOK {
+		return reflect.DeepEqual(a, b)
+	}
+
+	aSource, aTarget := splitBind(aStr)
+	bSource, bTarget := splitBind(bStr)
+	return aSource == bSource && aTarget == bTarget
+}
+
+// splitBind returns the source and target of a src:dst[:mode] bind
+func splitBind(bind string) (string, string) {
+	parts := strings.SplitN(bind, ":", 3)
+	if len(parts) == 1 {
+		return parts[0], parts[0]
+	}
+	return parts[0], parts[1]
+}
+
 // mergeSimpleValue handles merging a simple value
 func mergeSimpleValue(body map[string]any, key string, updateValue any, replace bool) bool {
 	if _, exists := body[key]; !exists || replace {
@@ -137,21 +137,8 @@ func TestApplyRewriteActions(t *testing.T) {
 			modified := false
 			for _, rule := range tt.actions {
 				for _, action := range rule.Actions {
-					switch action.Action {
-					case "replace":
-						if MatchesStructure(body, action.Contains) {
-							if MergeStructure(body, action.Update, true) {
-								modified = true
-							}
-						}
-					case "upsert":
-						if MergeStructure(body, action.Update, false) {
-							modified = true
-						}
-					case "delete":
-						if DeleteMatchingFields(body, action.Contains) {
-							modified = true
-						}
+					if ApplyRewriteAction(body, action) {
+						modified = true
 					}
 				}
 			}
@@ -250,17 +237,8 @@ func TestApplyRewriteRules(t *testing.T) {
 			for _, rule := range tt.config.Rules {
 				if MatchesRule(tt.request, rule.Match) {
 					for _, action := range rule.Actions {
-						switch action.Action {
-						case "replace":
-							if MatchesStructure(body, action.Contains) {
-								if MergeStructure(body, action.Update, true) {
-									modified = true
-								}
-							}
-						case "upsert":
-							if MergeStructure(body, action.Update, false) {
-								modified = true
-							}
+						if ApplyRewriteAction(body, action) {
+							modified = true
 						}
 					}
 				}
@@ -278,3 +256,260 @@ func TestApplyRewriteRules(t *testing.T) {
 		})
 	}
 }
+
+func TestDeleteMatchingFields_EnvGlob(t *testing.T) {
+	body := map[string]any{
+		"Env": []any{"PATH=/usr/bin", "SECRET_TOKEN=abc123", "SECRET_KEY=xyz"},
+	}
+
+	modified := DeleteMatchingFields(body, map[string]any{
+		"Env": []any{map[string]any{"$env": "SECRET_*"}},
+	})
+	if !modified {
+		t.Fatal("expected body to be modified")
+	}
+
+	want := []any{"PATH=/usr/bin"}
+	if !reflect.DeepEqual(body["Env"], want) {
+		t.Errorf("Env = %v, want %v", body["Env"], want)
+	}
+}
+
+func TestMergeStructure_BindDedup(t *testing.T) {
+	socketConfig := &SocketConfig{
+		Config: ConfigSet{PropagateSocket: "/var/run/docker.sock"},
+	}
+	propagation := socketConfig.GetPropagationRules()[0].Actions[0].Update
+
+	tests := []struct {
+		name      string
+		userBinds []any
+		want      []any
+	}{
+		{
+			name:      "same bind without mode",
+			userBinds: []any{"/var/run/docker.sock:/var/run/docker.sock"},
+			want:      []any{"/var/run/docker.sock:/var/run/docker.sock"},
+		},
+		{
+			name:      "same bind with different mode",
+			userBinds: []any{"/var/run/docker.sock:/var/run/docker.sock:rw"},
+			want:      []any{"/var/run/docker.sock:/var/run/docker.sock:rw"},
+		},
+		{
+			name:      "same bind with same mode",
+			userBinds: []any{"/var/run/docker.sock:/var/run/docker.sock:ro"},
+			want:      []any{"/var/run/docker.sock:/var/run/docker.sock:ro"},
+		},
+		{
+			name:      "same socket mounted elsewhere",
+			userBinds: []any{"/var/run/docker.sock:/docker.sock"},
+			want:      []any{"/var/run/docker.sock:/docker.sock", "/var/run/docker.sock:/var/run/docker.sock:ro"},
+		},
+		{
+			name:      "unrelated bind",
+			userBinds: []any{"/data:/data"},
+			want:      []any{"/data:/data", "/var/run/docker.sock:/var/run/docker.sock:ro"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]any{
+				"HostConfig": map[string]any{
+					"Binds": tt.userBinds,
+				},
+			}
+
+			MergeStructure(body, propagation, false)
+
+			got := body["HostConfig"].(map[string]any)["Binds"]
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Binds = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyRewriteAction_ArrayKey(t *testing.T) {
+	mount := func(source, target string, readOnly bool) map[string]any {
+		return map[string]any{"Type": "bind", "Source": source, "Target": target, "ReadOnly": readOnly}
+	}
+
+	tests := []struct {
+		name         string
+		body         map[string]any
+		action       Action
+		want         map[string]any
+		wantModified bool
+	}{
+		{
+			name: "mount with an existing target is replaced",
+			body: map[string]any{"HostConfig": map[string]any{
+				"Mounts": []any{mount("/data", "/data", false), mount("/var/run/docker.sock", "/var/run/docker.sock", false)},
+			}},
+			action: Action{Action: "upsert", ArrayKey: "Target", Update: map[string]any{"HostConfig": map[string]any{
+				"Mounts": []any{mount("/run/proxy.sock", "/var/run/docker.sock", true)},
+			}}},
+			want: map[string]any{"HostConfig": map[string]any{
+				"Mounts": []any{mount("/data", "/data", false), mount("/run/proxy.sock", "/var/run/docker.sock", true)},
+			}},
+			wantModified: true,
+		},
+		{
+			name: "mount with a new target is appended",
+			body: map[string]any{"HostConfig": map[string]any{
+				"Mounts": []any{mount("/data", "/data", false)},
+			}},
+			action: Action{Action: "upsert", ArrayKey: "Target", Update: map[string]any{"HostConfig": map[string]any{
+				"Mounts": []any{mount("/run/proxy.sock", "/var/run/docker.sock", true)},
+			}}},
+			want: map[string]any{"HostConfig": map[string]any{
+				"Mounts": []any{mount("/data", "/data", false), mount("/run/proxy.sock", "/var/run/docker.sock", true)},
+			}},
+			wantModified: true,
+		},
+		{
+			name: "identical mount is left alone",
+			body: map[string]any{"HostConfig": map[string]any{
+				"Mounts": []any{mount("/run/proxy.sock", "/var/run/docker.sock", true)},
+			}},
+			action: Action{Action: "upsert", ArrayKey: "Target", Update: map[string]any{"HostConfig": map[string]any{
+				"Mounts": []any{mount("/run/proxy.sock", "/var/run/docker.sock", true)},
+			}}},
+			want: map[string]any{"HostConfig": map[string]any{
+				"Mounts": []any{mount("/run/proxy.sock", "/var/run/docker.sock", true)},
+			}},
+			wantModified: false,
+		},
+		{
+			name: "mount is duplicated without an array key",
+			body: map[string]any{"HostConfig": map[string]any{
+				"Mounts": []any{mount("/var/run/docker.sock", "/var/run/docker.sock", false)},
+			}},
+			action: Action{Action: "upsert", Update: map[string]any{"HostConfig": map[string]any{
+				"Mounts": []any{mount("/run/proxy.sock", "/var/run/docker.sock", true)},
+			}}},
+			want: map[string]any{"HostConfig": map[string]any{
+				"Mounts": []any{mount("/var/run/docker.sock", "/var/run/docker.sock", false), mount("/run/proxy.sock", "/var/run/docker.sock", true)},
+			}},
+			wantModified: true,
+		},
+		{
+			name: "port binding with an existing host ip is replaced",
+			body: map[string]any{"HostConfig": map[string]any{"PortBindings": map[string]any{
+				"80/tcp": []any{
+					map[string]any{"HostIp": "0.0.0.0", "HostPort": "8080"},
+					map[string]any{"HostIp": "::", "HostPort": "8080"},
+				},
+			}}},
+			action: Action{Action: "upsert", ArrayKey: "HostIp", Update: map[string]any{"HostConfig": map[string]any{"PortBindings": map[string]any{
+				"80/tcp": []any{map[string]any{"HostIp": "0.0.0.0", "HostPort": "9090"}},
+			}}}},
+			want: map[string]any{"HostConfig": map[string]any{"PortBindings": map[string]any{
+				"80/tcp": []any{
+					map[string]any{"HostIp": "0.0.0.0", "HostPort": "9090"},
+					map[string]any{"HostIp": "::", "HostPort": "8080"},
+				},
+			}}},
+			wantModified: true,
+		},
+		{
+			name: "key value arrays in the same update keep their semantics",
+			body: map[string]any{
+				"Env":        []any{"DEBUG=1"},
+				"HostConfig": map[string]any{"Mounts": []any{mount("/a", "/data", false)}},
+			},
+			action: Action{Action: "upsert", ArrayKey: "Target", Update: map[string]any{
+				"Env":        []any{"DEBUG=0"},
+				"HostConfig": map[string]any{"Mounts": []any{mount("/b", "/data", false)}},
+			}},
+			want: map[string]any{
+				"Env":        []any{"DEBUG=0"},
+				"HostConfig": map[string]any{"Mounts": []any{mount("/b", "/data", false)}},
+			},
+			wantModified: true,
+		},
+		{
+			name: "replace matches objects by array key",
+			body: map[string]any{"Image": "app", "HostConfig": map[string]any{
+				"Mounts": []any{mount("/data", "/data", false), mount("/var/run/docker.sock", "/var/run/docker.sock", false)},
+			}},
+			action: Action{
+				Action:   "replace",
+				ArrayKey: "Target",
+				Contains: map[string]any{"Image": "app"},
+				Update: map[string]any{"HostConfig": map[string]any{
+					"Mounts": []any{mount("/run/proxy.sock", "/var/run/docker.sock", true)},
+				}},
+			},
+			want: map[string]any{"Image": "app", "HostConfig": map[string]any{
+				"Mounts": []any{mount("/run/proxy.sock", "/var/run/docker.sock", true), mount("/data", "/data", false)},
+			}},
+			wantModified: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modified := ApplyRewriteAction(tt.body, tt.action)
+			if modified != tt.wantModified {
+				t.Errorf("ApplyRewriteAction() modified = %v, want %v", modified, tt.wantModified)
+			}
+			if !reflect.DeepEqual(tt.body, tt.want) {
+				t.Errorf("body = %v, want %v", tt.body, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteContainsMatchesLikeMatchContains(t *testing.T) {
+	tests := []struct {
+		name     string
+		contains map[string]any
+		body     string
+		want     bool
+	}{
+		{name: "regex", contains: map[string]any{"Image": "^nginx"}, body: `{"Image":"nginx:1.25"}`, want: true},
+		{name: "regex without a match", contains: map[string]any{"Image": "^nginx"}, body: `{"Image":"alpine"}`, want: false},
+		{name: "array subset", contains: map[string]any{"Env": []any{"SECRET_X=1"}}, body: `{"Env":["DEBUG=1","SECRET_X=1"]}`, want: true},
+		{name: "array subset without a match", contains: map[string]any{"Env": []any{"SECRET_X=1"}}, body: `{"Env":["DEBUG=1"]}`, want: false},
+		{name: "env glob", contains: map[string]any{"Env": []any{map[string]any{"$env": "SECRET_*"}}}, body: `{"Env":["SECRET_TOKEN=abc"]}`, want: true},
+		{name: "env glob without a match", contains: map[string]any{"Env": []any{map[string]any{"$env": "SECRET_*"}}}, body: `{"Env":["DEBUG=1"]}`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decode := func() map[string]any {
+				var body map[string]any
+				if err := json.Unmarshal([]byte(tt.body), &body); err != nil {
+					t.Fatal(err)
+				}
+				return body
+			}
+
+			req := httptest.NewRequest("POST", "/v1.42/containers/create", nil)
+			matched, err := MatchRequest(req, []byte(tt.body), decode(), Match{Contains: tt.contains})
+			if err != nil {
+				t.Fatalf("MatchRequest() error = %v", err)
+			}
+			if matched != tt.want {
+				t.Errorf("match contains = %v, want %v", matched, tt.want)
+			}
+
+			update := map[string]any{"Labels": map[string]any{"rewritten": "true"}}
+			if got := ApplyRewriteAction(decode(), Action{Action: "replace", Contains: tt.contains, Update: update}); got != tt.want {
+				t.Errorf("replace contains = %v, want %v", got, tt.want)
+			}
+
+			template := Action{Action: "replace", Contains: tt.contains, Template: map[string]string{"Labels.rewritten": "true"}}
+			got, err := ApplyTemplateAction(decode(), template, TemplateData{})
+			if err != nil {
+				t.Fatalf("ApplyTemplateAction() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("replace template contains = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// SchemaDialect is the JSON Schema draft the config schema is written in
+const SchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema returns a JSON Schema for socket config files, for editors and for
+// checking configs in CI before they reach the proxy. Properties are read
+// from the config structs, so every field is described as soon as it
+// exists, and the constraints ValidateConfig checks by value are layered on
+// top. Unknown properties are refused to catch misspelt keys, which the
+// proxy itself ignores.
+func Schema() map[string]any {
+	defs := make(map[string]any)
+	structSchema(reflect.TypeOf(SocketConfig{}), defs)
+
+	constrain(defs, "SocketConfig", "profiles", map[string]any{"items": enumSchema(ProfileNames()...)})
+	constrain(defs, "ConfigSet", "default_action", enumSchema(DefaultActionAllow, DefaultActionDeny))
+	constrain(defs, "ConfigSet", "mode", enumSchema(ModeEnforce, ModeShadow))
+	constrain(defs, "ConfigSet", "profile", enumSchema(AccessProfileNames()...))
+	constrain(defs, "ConfigSet", "listen", map[string]any{"pattern": `^tcp://.*:[0-9]+$`})
+	constrain(defs, "ConfigSet", "socket_mode", map[string]any{"pattern": `^0*[0-7]{1,3}$`})
+	constrain(defs, "ConfigSet", "min_api_version", map[string]any{"pattern": `^[0-9]+\.[0-9]+$`})
+	constrain(defs, "ConfigSet", "max_api_version", map[string]any{"pattern": `^[0-9]+\.[0-9]+$`})
+	constrain(defs, "ConfigSet", "body_methods", map[string]any{"items": map[string]any{"pattern": "^[A-Z]+$"}})
+	for _, name := range []string{"allow_log_sample_rate", "max_connections", "audit_log_max_size", "max_body_bytes", "upstream_retries"} {
+		constrain(defs, "ConfigSet", name, map[string]any{"minimum": 0})
+	}
+
+	require(defs, "Rule", "match", "actions")
+	constrain(defs, "Rule", "actions", map[string]any{"minItems": 1})
+
+	require(defs, "Match", "path")
+	constrain(defs, "Match", "path", map[string]any{"minLength": 1})
+	constrain(defs, "Match", "match_mode", enumSchema(MatchModeRegex, MatchModeGlob))
+
+	require(defs, "Action", "action")
+	constrain(defs, "Action", "action", enumSchema("allow", "deny", "ratelimit", "rewrite-path", "upsert", "replace", "delete"))
+	constrain(defs, "Action", "phase", enumSchema(PhaseRequest, PhaseResponse))
+	constrain(defs, "Action", "log_level", enumSchema("debug", "info", "warn", "error"))
+	constrain(defs, "Action", "status_code", map[string]any{"minimum": 400, "maximum": 599})
+	constrain(defs, "Action", "limit", map[string]any{"minimum": 1})
+	constrain(defs, "Action", "code", map[string]any{"pattern": reasonCodePattern.String()})
+	action := defs["Action"].(map[string]any)
+	action["allOf"] = []any{
+		actionRequires([]string{"deny"}, "reason"),
+		actionRequires([]string{"ratelimit"}, "limit", "window"),
+		actionRequires([]string{"rewrite-path"}, "pattern"),
+		actionRequires([]string{"replace", "delete"}, "contains"),
+		map[string]any{
+			"if":   actionIs("upsert", "replace"),
+			"then": map[string]any{"anyOf": []any{requiredSchema("update"), requiredSchema("template")}},
+		},
+	}
+
+	return map[string]any{
+		"$schema": SchemaDialect,
+		"title":   "docker-socket-proxy socket config",
+		"$ref":    "#/$defs/SocketConfig",
+		"$defs":   defs,
+	}
+}
+
+// structSchema adds an object schema for a struct to defs, named after the
+// type, along with the structs its fields refer to
+func structSchema(t reflect.Type, defs map[string]any) {
+	if _, ok := defs[t.Name()]; ok {
+		return
+	}
+
+	properties := make(map[string]any)
+	defs[t.Name()] = map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		properties[name] = typeSchema(t.Field(i).Type, defs)
+	}
+}
+
+// typeSchema returns the schema for a field's type
+func typeSchema(t reflect.Type, defs map[string]any) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem(), defs)
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem(), defs)}
+	case reflect.Map:
+		// Structures matched against bodies may hold anything
+		if t.Elem().Kind() == reflect.Interface {
+			return map[string]any{"type": "object"}
+		}
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem(), defs)}
+	case reflect.Struct:
+		structSchema(t, defs)
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	default:
+		return map[string]any{}
+	}
+}
+
+// constrain adds keywords to a property of a definition, a nested map such
+// as the items of an array is merged rather than replaced
+func constrain(defs map[string]any, def, property string, keywords map[string]any) {
+	properties := defs[def].(map[string]any)["properties"].(map[string]any)
+	schema, ok := properties[property].(map[string]any)
+	if !ok {
+		return
+	}
+	for key, value := range keywords {
+		if nested, ok := value.(map[string]any); ok {
+			if existing, ok := schema[key].(map[string]any); ok {
+				for k, v := range nested {
+					existing[k] = v
+				}
+				continue
+			}
+		}
+		schema[key] = value
+	}
+}
+
+// require marks properties of a definition as required
+func require(defs map[string]any, def string, properties ...string) {
+	defs[def].(map[string]any)["required"] = properties
+}
+
+// enumSchema restricts a string to the given values
+func enumSchema(values ...string) map[string]any {
+	return map[string]any{"enum": values}
+}
+
+// requiredSchema requires the given properties
+func requiredSchema(properties ...string) map[string]any {
+	return map[string]any{"required": properties}
+}
+
+// actionIs matches actions of any of the given types
+func actionIs(actions ...string) map[string]any {
+	return map[string]any{
+		"required":   []string{"action"},
+		"properties": map[string]any{"action": enumSchema(actions...)},
+	}
+}
+
+// actionRequires requires properties of actions of the given types
+func actionRequires(actions []string, properties ...string) map[string]any {
+	return map[string]any{"if": actionIs(actions...), "then": requiredSchema(properties...)}
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaDescribesEveryField(t *testing.T) {
+	defs := Schema()["$defs"].(map[string]any)
+
+	for _, typ := range []reflect.Type{
+		reflect.TypeOf(SocketConfig{}),
+		reflect.TypeOf(ConfigSet{}),
+		reflect.TypeOf(Rule{}),
+		reflect.TypeOf(Match{}),
+		reflect.TypeOf(Action{}),
+	} {
+		def, ok := defs[typ.Name()].(map[string]any)
+		if !ok {
+			t.Errorf("schema has no definition for %s", typ.Name())
+			continue
+		}
+		properties := def["properties"].(map[string]any)
+		for i := 0; i < typ.NumField(); i++ {
+			name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+			if _, ok := properties[name]; !ok {
+				t.Errorf("%s schema is missing property %q", typ.Name(), name)
+			}
+		}
+		if len(properties) != typ.NumField() {
+			t.Errorf("%s schema has %d properties, want %d", typ.Name(), len(properties), typ.NumField())
+		}
+	}
+}
+
+func TestSchemaConstraints(t *testing.T) {
+	defs := Schema()["$defs"].(map[string]any)
+	property := func(def, name string) map[string]any {
+		return defs[def].(map[string]any)["properties"].(map[string]any)[name].(map[string]any)
+	}
+
+	if items := property("SocketConfig", "profiles")["items"].(map[string]any); !reflect.DeepEqual(items["enum"], ProfileNames()) {
+		t.Errorf("profiles items = %v, want the profile names", items)
+	}
+	if got := property("ConfigSet", "default_action")["enum"]; !reflect.DeepEqual(got, []string{"allow", "deny"}) {
+		t.Errorf("default_action enum = %v", got)
+	}
+	if got := defs["Rule"].(map[string]any)["required"]; !reflect.DeepEqual(got, []string{"match", "actions"}) {
+		t.Errorf("rule required = %v", got)
+	}
+	if got := property("Match", "peer_uid"); !reflect.DeepEqual(got, map[string]any{"type": "integer", "minimum": 0}) {
+		t.Errorf("peer_uid = %v", got)
+	}
+	if got := property("Rule", "actions")["items"]; !reflect.DeepEqual(got, map[string]any{"$ref": "#/$defs/Action"}) {
+		t.Errorf("actions items = %v", got)
+	}
+
+	// A deny without a reason is refused by the schema as by validation
+	data, err := json.Marshal(defs["Action"].(map[string]any)["allOf"])
+	if err != nil {
+		t.Fatalf("schema does not marshal: %v", err)
+	}
+	if want := `{"if":{"properties":{"action":{"enum":["deny"]}},"required":["action"]},"then":{"required":["reason"]}}`; !strings.Contains(string(data), want) {
+		t.Errorf("action conditions = %s, want them to contain %s", data, want)
+	}
+}
+
+func TestSchemaActionValues(t *testing.T) {
+	defs := Schema()["$defs"].(map[string]any)
+	action := defs["Action"].(map[string]any)["properties"].(map[string]any)["action"].(map[string]any)
+
+	// Every value the schema offers is one validation knows
+	for _, value := range action["enum"].([]string) {
+		err := validateAction(0, 0, Action{Action: value})
+		if err != nil && strings.Contains(err.Error(), "invalid action") {
+			t.Errorf("schema offers action %q that validation refuses: %v", value, err)
+		}
+	}
+	if err := validateAction(0, 0, Action{Action: "block"}); err == nil || !strings.Contains(err.Error(), "invalid action") {
+		t.Errorf("validateAction() error = %v, want an invalid action", err)
+	}
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ErrNoSourceAddr is returned when a request has no usable client address,
+// such as requests arriving on a unix socket, or when a forwarded address is
+// malformed. Source matching fails closed on it.
+var ErrNoSourceAddr = errors.New("no valid source address")
+
+// ParseCIDRs parses a list of CIDRs, IPv4 or IPv6. A bare address is taken
+// as a single host, /32 or /128.
+func ParseCIDRs(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// SourceAddr returns the client address of a request. The peer is taken from
+// r.RemoteAddr, in either host:port form ("10.0.0.1:1234", "[::1]:1234") or
+// as a bare address. When the peer is one of the trusted proxies the
+// X-Forwarded-For chain is walked from the right, skipping further trusted
+// proxies, and the first address that is not trusted is the client. Entries
+// left of it were written by the client itself and are never believed.
+func SourceAddr(r *http.Request, trustedProxies []netip.Prefix) (netip.Addr, error) {
+	peer, err := parseSourceAddr(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%w: remote address %q", ErrNoSourceAddr, r.RemoteAddr)
+	}
+	if !containsAddr(trustedProxies, peer) {
+		return peer, nil
+	}
+
+	var chain []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		chain = append(chain, strings.Split(header, ",")...)
+	}
+
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, err := parseSourceAddr(strings.TrimSpace(chain[i]))
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("%w: forwarded address %q", ErrNoSourceAddr, chain[i])
+		}
+		client = addr
+		if !containsAddr(trustedProxies, addr) {
+			break
+		}
+	}
+	return client, nil
+}
+
+// parseSourceAddr parses an address with or without a port. IPv4-mapped IPv6
+// addresses are unmapped and zones dropped, so they compare against plain
+// IPv4 and IPv6 CIDRs.
+func parseSourceAddr(s string) (netip.Addr, error) {
+	var addr netip.Addr
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		addr = addrPort.Addr()
+	} else {
+		addr, err = netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+		if err != nil {
+			return netip.Addr{}, err
+		}
+	}
+	return addr.Unmap().WithZone(""), nil
+}
+
+// containsAddr reports whether any of the prefixes contains the address
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+type trustedProxiesContextKey struct{}
+
+// ContextWithTrustedProxies returns a context carrying the trusted proxies
+// of the socket a request arrived on
+func ContextWithTrustedProxies(ctx context.Context, prefixes []netip.Prefix) context.Context {
+	return context.WithValue(ctx, trustedProxiesContextKey{}, prefixes)
+}
+
+// TrustedProxiesFromContext returns the trusted proxies stored in the
+// context, none when it carries none
+func TrustedProxiesFromContext(ctx context.Context) []netip.Prefix {
+	prefixes, _ := ctx.Value(trustedProxiesContextKey{}).([]netip.Prefix)
+	return prefixes
+}
+
+// MatchesSource checks the source_cidr criteria of a match. Requests that
+// arrived on a unix socket have no client address and always match, TCP
+// requests match when their client address, as SourceAddr reads it with the
+// trusted proxies of the request's context, is within one of the CIDRs. A
+// CIDR that does not parse or an address that cannot be read never matches.
+func MatchesSource(r *http.Request, match Match) bool {
+	if len(match.SourceCIDR) == 0 {
+		return true
+	}
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && local.Network() == "unix" {
+		return true
+	}
+
+	prefixes, err := ParseCIDRs(match.SourceCIDR)
+	if err != nil {
+		return false
+	}
+	addr, err := SourceAddr(r, TrustedProxiesFromContext(r.Context()))
+	if err != nil {
+		return false
+	}
+	return containsAddr(prefixes, addr)
+}
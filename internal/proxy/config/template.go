@@ -0,0 +1,162 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TemplateData is what the placeholders of an action's template resolve to
+type TemplateData struct {
+	Header     http.Header
+	Now        time.Time
+	SocketName string
+}
+
+// Template placeholders, a header placeholder is followed by the header name
+const (
+	templateNow        = ".Now"
+	templateSocketName = ".SocketName"
+	templateHeader     = ".Header."
+)
+
+// ApplyTemplateAction merges the rendered template of an upsert or replace
+// action into a body, reporting whether the body changed. A replace only
+// applies to bodies that match its contains structure, as with its update.
+func ApplyTemplateAction(body map[string]any, action Action, data TemplateData) (bool, error) {
+	if len(action.Template) == 0 {
+		return false, nil
+	}
+	if action.Action == "replace" && !MatchValue(action.Contains, body) {
+		return false, nil
+	}
+
+	update, err := RenderTemplate(action.Template, data)
+	if err != nil {
+		return false, err
+	}
+	return mergeStructure(body, update, action.Action == "replace", action.ArrayKey), nil
+}
+
+// RenderTemplate resolves a template into an update structure. Keys are field
+// paths separated by dots, with \. for a dot within a field name such as a
+// label key. Values are always strings, and resolved values are never
+// expanded again, so a header cannot inject placeholders of its own.
+func RenderTemplate(template map[string]string, data TemplateData) (map[string]any, error) {
+	update := make(map[string]any)
+	for key, value := range template {
+		rendered, err := expandTemplate(value, &data)
+		if err != nil {
+			return nil, fmt.Errorf("template %q: %w", key, err)
+		}
+
+		fields := templatePath(key)
+		target := update
+		for _, field := range fields[:len(fields)-1] {
+			next, ok := target[field].(map[string]any)
+			if !ok {
+				next = make(map[string]any)
+				target[field] = next
+			}
+			target = next
+		}
+		target[fields[len(fields)-1]] = rendered
+	}
+	return update, nil
+}
+
+// checkTemplate reports the first template key or value that cannot be rendered
+func checkTemplate(template map[string]string) error {
+	paths := make(map[string]bool, len(template))
+	for key, value := range template {
+		fields := templatePath(key)
+		for _, field := range fields {
+			if field == "" {
+				return fmt.Errorf("template key %q has an empty field", key)
+			}
+		}
+		if _, err := expandTemplate(value, nil); err != nil {
+			return fmt.Errorf("template %q: %w", key, err)
+		}
+		paths[strings.Join(fields, "\x00")] = true
+	}
+
+	// A field cannot be set to a value and have fields of its own
+	for key := range template {
+		fields := templatePath(key)
+		for i := 1; i < len(fields); i++ {
+			if paths[strings.Join(fields[:i], "\x00")] {
+				return fmt.Errorf("template key %q conflicts with a value set for one of its parents", key)
+			}
+		}
+	}
+	return nil
+}
+
+// expandTemplate replaces the placeholders in a value. Without data it only
+// checks that every placeholder is known.
+func expandTemplate(value string, data *TemplateData) (string, error) {
+	var out strings.Builder
+	for {
+		start := strings.Index(value, "{{")
+		if start < 0 {
+			out.WriteString(value)
+			return out.String(), nil
+		}
+		end := strings.Index(value[start:], "}}")
+		if end < 0 {
+			return "", fmt.Errorf("unclosed placeholder in %q", value)
+		}
+
+		out.WriteString(value[:start])
+		resolved, err := resolvePlaceholder(strings.TrimSpace(value[start+2:start+end]), data)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(resolved)
+		value = value[start+end+2:]
+	}
+}
+
+// resolvePlaceholder returns the value of a single placeholder
+func resolvePlaceholder(name string, data *TemplateData) (string, error) {
+	switch {
+	case name == templateNow:
+		if data == nil {
+			return "", nil
+		}
+		return data.Now.UTC().Format(time.RFC3339), nil
+	case name == templateSocketName:
+		if data == nil {
+			return "", nil
+		}
+		return data.SocketName, nil
+	case strings.HasPrefix(name, templateHeader) && len(name) > len(templateHeader):
+		if data == nil {
+			return "", nil
+		}
+		return data.Header.Get(strings.TrimPrefix(name, templateHeader)), nil
+	default:
+		return "", fmt.Errorf("unknown placeholder {{%s}}, expected {{.Now}}, {{.SocketName}} or {{.Header.<name>}}", name)
+	}
+}
+
+// templatePath splits a template key into its fields
+func templatePath(key string) []string {
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch {
+		case key[i] == '\\' && i+1 < len(key) && key[i+1] == '.':
+			field.WriteByte('.')
+			i++
+		case key[i] == '.':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(key[i])
+		}
+	}
+	return append(fields, field.String())
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyTemplateAction(t *testing.T) {
+	data := TemplateData{
+		Header:     http.Header{"X-User": []string{"alice"}, "X-Evil": []string{"{{.SocketName}}\"}"}},
+		Now:        time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600)),
+		SocketName: "ci.sock",
+	}
+
+	tests := []struct {
+		name         string
+		body         map[string]any
+		action       Action
+		wantBody     map[string]any
+		wantModified bool
+	}{
+		{
+			name: "upsert computed labels",
+			body: map[string]any{"Image": "alpine"},
+			action: Action{Action: "upsert", Template: map[string]string{
+				"Labels.created_at":         "{{.Now}}",
+				"Labels.com\\.example\\.by": "{{ .Header.X-User }} via {{.SocketName}}",
+			}},
+			wantBody: map[string]any{
+				"Image": "alpine",
+				"Labels": map[string]any{
+					"created_at":     "2024-01-02T02:04:05Z",
+					"com.example.by": "alice via ci.sock",
+				},
+			},
+			wantModified: true,
+		},
+		{
+			name:         "upsert keeps a value the client set",
+			body:         map[string]any{"Labels": map[string]any{"owner": "bob"}},
+			action:       Action{Action: "upsert", Template: map[string]string{"Labels.owner": "{{.Header.X-User}}"}},
+			wantBody:     map[string]any{"Labels": map[string]any{"owner": "bob"}},
+			wantModified: false,
+		},
+		{
+			name: "replace overwrites a matching body",
+			body: map[string]any{"Labels": map[string]any{"owner": "bob"}},
+			action: Action{
+				Action:   "replace",
+				Contains: map[string]any{"Labels": map[string]any{"owner": "bob"}},
+				Template: map[string]string{"Labels.owner": "{{.Header.X-User}}"},
+			},
+			wantBody:     map[string]any{"Labels": map[string]any{"owner": "alice"}},
+			wantModified: true,
+		},
+		{
+			name: "replace skips a body that does not match",
+			body: map[string]any{"Labels": map[string]any{"owner": "carol"}},
+			action: Action{
+				Action:   "replace",
+				Contains: map[string]any{"Labels": map[string]any{"owner": "bob"}},
+				Template: map[string]string{"Labels.owner": "{{.Header.X-User}}"},
+			},
+			wantBody:     map[string]any{"Labels": map[string]any{"owner": "carol"}},
+			wantModified: false,
+		},
+		{
+			name:         "header values are not expanded again",
+			body:         map[string]any{},
+			action:       Action{Action: "upsert", Template: map[string]string{"Labels.evil": "{{.Header.X-Evil}}"}},
+			wantBody:     map[string]any{"Labels": map[string]any{"evil": "{{.SocketName}}\"}"}},
+			wantModified: true,
+		},
+		{
+			name:         "missing header is empty",
+			body:         map[string]any{},
+			action:       Action{Action: "upsert", Template: map[string]string{"User": "{{.Header.X-Missing}}"}},
+			wantBody:     map[string]any{"User": ""},
+			wantModified: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modified, err := ApplyTemplateAction(tt.body, tt.action, data)
+			if err != nil {
+				t.Fatalf("ApplyTemplateAction() error = %v", err)
+			}
+			if modified != tt.wantModified {
+				t.Errorf("ApplyTemplateAction() modified = %v, want %v", modified, tt.wantModified)
+			}
+			if !reflect.DeepEqual(tt.body, tt.wantBody) {
+				t.Errorf("body = %v, want %v", tt.body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  Action
+		wantErr string
+	}{
+		{name: "known placeholders", action: Action{Action: "upsert", Template: map[string]string{"Labels.at": "{{.Now}} {{.SocketName}} {{.Header.X-User}}"}}},
+		{name: "template without update", action: Action{Action: "replace", Contains: map[string]any{"User": "root"}, Template: map[string]string{"User": "{{.Header.X-User}}"}}},
+		{name: "unknown placeholder", action: Action{Action: "upsert", Template: map[string]string{"User": "{{.Env.HOME}}"}}, wantErr: "unknown placeholder {{.Env.HOME}}"},
+		{name: "header without a name", action: Action{Action: "upsert", Template: map[string]string{"User": "{{.Header.}}"}}, wantErr: "unknown placeholder"},
+		{name: "unclosed placeholder", action: Action{Action: "upsert", Template: map[string]string{"User": "{{.Now"}}, wantErr: "unclosed placeholder"},
+		{name: "empty field", action: Action{Action: "upsert", Template: map[string]string{"Labels..at": "{{.Now}}"}}, wantErr: "empty field"},
+		{name: "conflicting keys", action: Action{Action: "upsert", Template: map[string]string{"Labels": "x", "Labels.at": "{{.Now}}"}}, wantErr: "conflicts"},
+		{name: "delete action", action: Action{Action: "delete", Contains: map[string]any{"User": "root"}, Template: map[string]string{"User": "x"}}, wantErr: "only supported by upsert and replace"},
+		{name: "response phase", action: Action{Action: "upsert", Phase: PhaseResponse, Template: map[string]string{"User": "x"}}, wantErr: "only supported in the request phase"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &SocketConfig{Rules: []Rule{{Match: Match{Path: "/containers/create"}, Actions: []Action{tt.action}}}}
+			err := ValidateConfig(cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateConfig() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateConfig() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
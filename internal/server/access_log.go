@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"time"
+
+	"docker-socket-proxy/internal/logging"
+)
+
+// accessLogWriter records the status and size of a response for the access
+// log. It unwraps to the writer it wraps, so that flushing the responses of
+// streaming endpoints keeps working.
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	// Informational responses are followed by the real one
+	if w.status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Hijack hands the connection of an upgraded request, such as an attach, to
+// the reverse proxy. What is sent over it afterwards is not counted.
+func (w *accessLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// logAccess writes the access log line of a finished request, with the
+// method and path the client sent before any rewrite and the rule that
+// decided it
+func logAccess(r *http.Request, method, path, socketPath string, w *accessLogWriter, start time.Time, decision ruleDecision) {
+	status := w.status
+	if status == 0 {
+		// Nothing was written, which the server answers with an empty 200
+		status = http.StatusOK
+	}
+	logging.GetLogger().Info("Request completed", append(append([]any{
+		"socket", socketPath,
+		"method", method,
+		"path", path,
+		"status", status,
+		"bytes", w.bytes,
+		"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+	}, decisionAttrs(decision)...), peerCredAttrs(r)...)...)
+}
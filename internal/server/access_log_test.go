@@ -0,0 +1,211 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"docker-socket-proxy/internal/logging"
+	"docker-socket-proxy/internal/proxy/config"
+)
+
+func TestProxyHandler_AccessLog(t *testing.T) {
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		if _, err := io.WriteString(w, `{"Id":"abc"}`); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+
+	var logs bytes.Buffer
+	logging.SetOutput(&logs)
+	defer logging.SetOutput(os.Stdout)
+
+	socketPath := "/tmp/access-log.sock"
+	configs := map[string]*config.SocketConfig{
+		socketPath: {
+			Config: config.ConfigSet{AccessLog: true},
+			Rules: []config.Rule{
+				{Match: config.Match{Path: "/containers/create"}, Actions: []config.Action{{Action: "allow"}}},
+				{Match: config.Match{Path: "/.*"}, Actions: []config.Action{{Action: "deny", Reason: "not allowed"}}},
+			},
+		},
+	}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+
+	accessLines := func() []map[string]any {
+		t.Helper()
+		var lines []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			var entry map[string]any
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("Failed to parse log line %q: %v", line, err)
+			}
+			if entry["msg"] == "Request completed" {
+				lines = append(lines, entry)
+			}
+		}
+		return lines
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus float64
+		wantBytes  float64
+		wantRule   float64
+	}{
+		{name: "forwarded", method: "POST", target: "/v1.43/containers/create", wantStatus: http.StatusCreated, wantBytes: float64(len(`{"Id":"abc"}`)), wantRule: 0},
+		{name: "denied", method: "DELETE", target: "/v1.43/containers/abc", wantStatus: http.StatusForbidden, wantRule: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, httptest.NewRequest(tt.method, tt.target, nil), socketPath)
+
+			lines := accessLines()
+			if len(lines) != 1 {
+				t.Fatalf("got %d access log lines, want 1: %s", len(lines), logs.String())
+			}
+			entry := lines[0]
+			if entry["socket"] != socketPath || entry["method"] != tt.method || entry["path"] != tt.target {
+				t.Errorf("access log entry = %v", entry)
+			}
+			if entry["status"] != tt.wantStatus || float64(w.Code) != tt.wantStatus {
+				t.Errorf("status = %v, response %d, want %v", entry["status"], w.Code, tt.wantStatus)
+			}
+			if tt.wantBytes > 0 && entry["bytes"] != tt.wantBytes {
+				t.Errorf("bytes = %v, want %v", entry["bytes"], tt.wantBytes)
+			}
+			if tt.wantBytes == 0 && entry["bytes"] != float64(w.Body.Len()) {
+				t.Errorf("bytes = %v, want the %d of the denial", entry["bytes"], w.Body.Len())
+			}
+			if entry["rule"] != tt.wantRule || entry["action_index"] != float64(0) {
+				t.Errorf("rule = %v, action_index = %v, want rule %v and its first action", entry["rule"], entry["action_index"], tt.wantRule)
+			}
+			if _, ok := entry["duration_ms"].(float64); !ok {
+				t.Errorf("duration_ms = %v, want a number", entry["duration_ms"])
+			}
+		})
+	}
+
+	// Without access_log only the decision is logged
+	configs[socketPath].Config.AccessLog = false
+	logs.Reset()
+	handler.ServeHTTPWithSocket(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1.43/containers/create", nil), socketPath)
+	if lines := accessLines(); len(lines) != 0 {
+		t.Errorf("got %d access log lines with access_log off, want none", len(lines))
+	}
+}
+
+func TestProxyHandler_AccessLogHijacked(t *testing.T) {
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Failed to hijack connection: %v", err)
+			return
+		}
+		defer func() {
+			if err := conn.Close(); err != nil {
+				t.Errorf("Failed to close connection: %v", err)
+			}
+		}()
+		if _, err := buf.WriteString("HTTP/1.1 101 UPGRADED\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n"); err != nil {
+			t.Errorf("Failed to write upgrade: %v", err)
+			return
+		}
+		if err := buf.Flush(); err != nil {
+			t.Errorf("Failed to flush upgrade: %v", err)
+		}
+	}))
+
+	var logs bytes.Buffer
+	var logsMu sync.Mutex
+	logging.SetOutput(lockedWriter{w: &logs, mu: &logsMu})
+	defer logging.SetOutput(os.Stdout)
+
+	socketPath := "/tmp/access-log-attach.sock"
+	configs := map[string]*config.SocketConfig{socketPath: {
+		Config: config.ConfigSet{AccessLog: true},
+		Rules:  []config.Rule{{Match: config.Match{Path: "/attach"}, Actions: []config.Action{{Action: "allow"}}}},
+	}}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTPWithSocket(w, r, socketPath)
+	}))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			t.Errorf("Failed to close connection: %v", err)
+		}
+	}()
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	request := "POST /v1.43/containers/abc/attach?stream=1 HTTP/1.1\r\n" +
+		"Host: docker\r\nConnection: Upgrade\r\nUpgrade: tcp\r\nContent-Length: 0\r\n\r\n"
+	if _, err := io.WriteString(conn, request); err != nil {
+		t.Fatalf("Failed to write request: %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("Failed to read upgrade response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+
+	// The line is written once both sides are done with the connection
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatalf("Failed to close the write side: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		logsMu.Lock()
+		written := logs.String()
+		logsMu.Unlock()
+		if strings.Contains(written, `"msg":"Request completed"`) {
+			if !strings.Contains(written, `"status":101`) {
+				t.Errorf("access log line has no 101 status: %s", written)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no access log line for the attach: %s", written)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// lockedWriter serializes writes to a buffer that is read concurrently
+type lockedWriter struct {
+	w  io.Writer
+	mu *sync.Mutex
+}
+
+func (l lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
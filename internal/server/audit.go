@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"docker-socket-proxy/internal/audit"
+	"docker-socket-proxy/internal/logging"
+	"docker-socket-proxy/internal/proxy/config"
+)
+
+// auditRetryInterval is how long to wait before trying again to open an
+// audit log that could not be opened
+const auditRetryInterval = time.Minute
+
+// auditDecision appends a decision to the socket's audit log, if it has one.
+// Auditing is best effort, a log that cannot be written never fails the request.
+func (h *ProxyHandler) auditDecision(r *http.Request, socketPath string, socketConfig *config.SocketConfig, decision ruleDecision) {
+	if socketConfig == nil || socketConfig.Config.AuditLog == "" {
+		return
+	}
+
+	auditLog := h.auditLogFor(socketConfig.Config.AuditLog, socketConfig.Config.AuditLogMaxSize)
+	if auditLog == nil {
+		return
+	}
+
+	action := "deny"
+	if decision.allowed {
+		action = "allow"
+	}
+	entry := audit.Entry{
+		Time:        time.Now().UTC(),
+		Socket:      filepath.Base(socketPath),
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		Rule:        decision.rule,
+		ActionIndex: decision.action,
+		Base:        decision.base,
+		Shadow:      decision.shadow,
+		Action:      action,
+		Reason:      decision.reason,
+		ReasonCode:  decision.code,
+		Peer:        audit.Peer{Addr: r.RemoteAddr},
+	}
+	if decision.rule < 0 {
+		entry.ActionIndex = -1
+	}
+	if id, ok := config.IdentityFromContext(r.Context()); ok {
+		uid, gid, pid := id.UID, id.GID, id.PID
+		entry.Peer.UID, entry.Peer.GID, entry.Peer.PID = &uid, &gid, &pid
+	}
+	auditLog.Log(entry)
+}
+
+// auditLogFor returns the open audit log for a path, opening it on first use.
+// Sockets that share a path share the log, and the first to open it sets its
+// maximum size.
+func (h *ProxyHandler) auditLogFor(path string, maxSize int64) *audit.Logger {
+	h.auditMu.Lock()
+	defer h.auditMu.Unlock()
+
+	if auditLog, ok := h.auditLogs[path]; ok {
+		return auditLog
+	}
+	if failedAt, ok := h.auditFailures[path]; ok && time.Since(failedAt) < auditRetryInterval {
+		return nil
+	}
+
+	auditLog, err := audit.Open(path, maxSize)
+	if err != nil {
+		logging.GetLogger().Error("Failed to open audit log", "path", path, "error", err)
+		if h.auditFailures == nil {
+			h.auditFailures = make(map[string]time.Time)
+		}
+		h.auditFailures[path] = time.Now()
+		return nil
+	}
+
+	if h.auditLogs == nil {
+		h.auditLogs = make(map[string]*audit.Logger)
+	}
+	delete(h.auditFailures, path)
+	h.auditLogs[path] = auditLog
+	return auditLog
+}
+
+// closeAuditLogs writes out and closes every open audit log
+func (h *ProxyHandler) closeAuditLogs() {
+	h.auditMu.Lock()
+	defer h.auditMu.Unlock()
+
+	for path, auditLog := range h.auditLogs {
+		if dropped := auditLog.Dropped(); dropped > 0 {
+			logging.GetLogger().Warn("Audit log entries were dropped", "path", path, "count", dropped)
+		}
+		if err := auditLog.Close(); err != nil {
+			logging.GetLogger().Error("Failed to close audit log", "path", path, "error", err)
+		}
+		delete(h.auditLogs, path)
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"docker-socket-proxy/internal/logging"
+	"docker-socket-proxy/internal/proxy/config"
+	"docker-socket-proxy/internal/storage"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// configWatchDelay is how long a config file must go without changes before
+// it is reloaded, so that a file is not read while it is still being written
+const configWatchDelay = 100 * time.Millisecond
+
+// WithConfigWatch reloads socket configs when their files in the storage
+// directory change
+func WithConfigWatch(watch bool) Option {
+	return func(s *Server) {
+		s.watchConfigs = watch
+	}
+}
+
+// startConfigWatcher watches the storage directory until the server stops
+func (s *Server) startConfigWatcher() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config watcher: %w", err)
+	}
+	if err := watcher.Add(s.store.Dir()); err != nil {
+		if closeErr := watcher.Close(); closeErr != nil {
+			logging.GetLogger().Warn("Failed to close config watcher", "error", closeErr)
+		}
+		return fmt.Errorf("failed to watch config directory %s: %w", s.store.Dir(), err)
+	}
+
+	logging.GetLogger().Info("Watching socket configs for changes", "path", s.store.Dir())
+	go s.runConfigWatcher(watcher)
+	return nil
+}
+
+// runConfigWatcher reloads config files once they have settled after a change
+func (s *Server) runConfigWatcher(watcher *fsnotify.Watcher) {
+	log := logging.GetLogger()
+
+	pending := make(map[string]*time.Timer)
+	defer func() {
+		for _, timer := range pending {
+			timer.Stop()
+		}
+		if err := watcher.Close(); err != nil {
+			log.Warn("Failed to close config watcher", "error", err)
+		}
+	}()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+				continue
+			}
+			name := filepath.Base(event.Name)
+			if timer, ok := pending[name]; ok {
+				timer.Reset(configWatchDelay)
+				continue
+			}
+			pending[name] = time.AfterFunc(configWatchDelay, func() { s.reloadConfigFile(name) })
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Error("Config watcher error", "error", err)
+		}
+	}
+}
+
+// reloadConfigFile applies a changed config file. A config that fails to load
+// or validate is rejected and the socket keeps its current config. A file
+// for a socket that does not exist yet starts serving it.
+func (s *Server) reloadConfigFile(name string) {
+	log := logging.GetLogger()
+
+	socketName, ok := storage.SocketNameForFile(name)
+	if !ok {
+		return
+	}
+	socketPath := filepath.Join(s.socketDir, socketName)
+
+	// Changes made through the management API hold the same lock
+	s.handler.createMu.Lock()
+	defer s.handler.createMu.Unlock()
+
+	cfg, err := s.store.LoadConfig(socketPath)
+	if err == nil {
+		err = config.ExpandProfiles(cfg)
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// The socket was deleted or its file moved away
+			return
+		}
+		log.Warn("Rejected changed socket config", "path", socketPath, "file", name, "error", err)
+		return
+	}
+
+	s.configMu.Lock()
+	old, exists := s.socketConfigs[socketPath]
+	if exists && old.Config.Listen != cfg.Config.Listen {
+		s.configMu.Unlock()
+		log.Warn("Rejected changed socket config", "path", socketPath, "file", name, "error", errListenChanged)
+		return
+	}
+	if exists {
+		if cfg.Name == "" {
+			cfg.Name = old.Name
+		}
+		s.socketConfigs[socketPath] = cfg
+	}
+	s.configMu.Unlock()
+
+	if exists {
+		// Saves by the management API land here too, with nothing new in them
+		if !reflect.DeepEqual(old, cfg) {
+			log.Info("Reloaded socket config", "path", socketPath, "rules", len(cfg.Rules))
+		}
+		return
+	}
+
+	if err := s.restoreSocket(socketPath, cfg); err != nil {
+		log.Error("Failed to start socket for new config", "path", socketPath, "error", err)
+	}
+}
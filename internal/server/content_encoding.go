@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// contentEncoding returns the Content-Encoding of a request body, empty when
+// it is sent as is
+func contentEncoding(r *http.Request) string {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if encoding == "identity" {
+		return ""
+	}
+	return encoding
+}
+
+// decodeBody decompresses a gzip or deflate encoded body so that rules see
+// the JSON the client sent. A body that decodes to more than limit bytes
+// fails with errBodyTooLarge. Other encodings cannot be inspected, so they
+// fail rather than let a compressed body slip past the rules that read it.
+func decodeBody(encoding string, encoded []byte, limit int64) ([]byte, error) {
+	var reader io.ReadCloser
+	var err error
+	switch encoding {
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(encoded))
+	case "deflate":
+		reader, err = zlib.NewReader(bytes.NewReader(encoded))
+	default:
+		return nil, fmt.Errorf("%w: unsupported Content-Encoding %q", errReadBody, encoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s body: %v", errReadBody, encoding, err)
+	}
+
+	decoded, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if closeErr := reader.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s body: %v", errReadBody, encoding, err)
+	}
+	if int64(len(decoded)) > limit {
+		return nil, fmt.Errorf("%w: more than %d bytes once decoded", errBodyTooLarge, limit)
+	}
+	return decoded, nil
+}
+
+// encodeBody compresses a rewritten body with the encoding the client sent
+// it in, which the request still declares
+func encodeBody(encoding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	switch encoding {
+	case "":
+		return body, nil
+	case "gzip", "x-gzip":
+		writer = gzip.NewWriter(&buf)
+	case "deflate":
+		writer = zlib.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+
+	if _, err := writer.Write(body); err != nil {
+		return nil, fmt.Errorf("failed to encode modified body: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode modified body: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"docker-socket-proxy/internal/proxy/config"
+)
+
+func TestProxyHandler_CompressedBodies(t *testing.T) {
+	type forwardedRequest struct {
+		encoding string
+		body     []byte
+	}
+	var forwarded []forwardedRequest
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read forwarded body: %v", err)
+		}
+		forwarded = append(forwarded, forwardedRequest{encoding: r.Header.Get("Content-Encoding"), body: body})
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	compress := func(encoding, body string) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		var writer io.WriteCloser = gzip.NewWriter(&buf)
+		if encoding == "deflate" {
+			writer = zlib.NewWriter(&buf)
+		}
+		if _, err := io.WriteString(writer, body); err != nil {
+			t.Fatalf("Failed to compress body: %v", err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("Failed to compress body: %v", err)
+		}
+		return buf.Bytes()
+	}
+	decompress := func(encoding string, body []byte) string {
+		t.Helper()
+		decoded, err := decodeBody(encoding, body, config.DefaultMaxBodyBytes)
+		if err != nil {
+			t.Fatalf("Failed to decompress forwarded body: %v", err)
+		}
+		return string(decoded)
+	}
+
+	cfg := &config.SocketConfig{
+		Config: config.ConfigSet{MaxBodyBytes: 256},
+		Rules: []config.Rule{
+			{
+				Match:   config.Match{Path: "/containers/create", Method: "POST", Contains: map[string]any{"HostConfig": map[string]any{"Privileged": true}}},
+				Actions: []config.Action{{Action: "deny", Reason: "privileged"}},
+			},
+			{
+				Match:   config.Match{Path: "/containers/create", Method: "POST", Contains: map[string]any{"Image": "^nginx"}},
+				Actions: []config.Action{{Action: "upsert", Update: map[string]any{"Labels": map[string]any{"proxied": "true"}}}},
+			},
+		},
+	}
+	socketPath := "/tmp/compressed-bodies.sock"
+	handler := NewProxyHandler(upstream, map[string]*config.SocketConfig{socketPath: cfg}, &sync.RWMutex{})
+
+	privileged := `{"Image":"alpine","HostConfig":{"Privileged":true}}`
+	tests := []struct {
+		name         string
+		encoding     string
+		body         []byte
+		wantStatus   int
+		wantBody     string
+		wantOriginal bool
+	}{
+		{name: "gzip privileged container", encoding: "gzip", body: compress("gzip", privileged), wantStatus: http.StatusForbidden},
+		{name: "upper case encoding", encoding: "GZIP", body: compress("gzip", privileged), wantStatus: http.StatusForbidden},
+		{name: "deflate privileged container", encoding: "deflate", body: compress("deflate", privileged), wantStatus: http.StatusForbidden},
+		{name: "gzip body without a match", encoding: "gzip", body: compress("gzip", `{"Image":"alpine"}`), wantStatus: http.StatusOK, wantBody: `{"Image":"alpine"}`, wantOriginal: true},
+		{name: "gzip body is rewritten", encoding: "gzip", body: compress("gzip", `{"Image":"nginx"}`), wantStatus: http.StatusOK, wantBody: `{"Image":"nginx","Labels":{"proxied":"true"}}`},
+		{name: "deflate body is rewritten", encoding: "deflate", body: compress("deflate", `{"Image":"nginx"}`), wantStatus: http.StatusOK, wantBody: `{"Image":"nginx","Labels":{"proxied":"true"}}`},
+		{name: "corrupt gzip body", encoding: "gzip", body: []byte(privileged), wantStatus: http.StatusInternalServerError},
+		{name: "unsupported encoding", encoding: "br", body: []byte(privileged), wantStatus: http.StatusInternalServerError},
+		{name: "over the limit once decoded", encoding: "gzip", body: compress("gzip", `{"Image":"`+strings.Repeat("a", 512)+`"}`), wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded = nil
+			req := httptest.NewRequest("POST", "/v1.42/containers/create", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", tt.encoding)
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, req, socketPath)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				if len(forwarded) != 0 {
+					t.Errorf("forwarded %d requests, want none", len(forwarded))
+				}
+				return
+			}
+			if len(forwarded) != 1 {
+				t.Fatalf("forwarded %d requests, want 1", len(forwarded))
+			}
+			got := forwarded[0]
+			if got.encoding != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", got.encoding, tt.encoding)
+			}
+			if tt.wantOriginal && !bytes.Equal(got.body, tt.body) {
+				t.Error("forwarded body differs from the one the client sent")
+			}
+			if body := decompress(strings.ToLower(tt.encoding), got.body); body != tt.wantBody {
+				t.Errorf("forwarded body = %s, want %s", body, tt.wantBody)
+			}
+		})
+	}
+}
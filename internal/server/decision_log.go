@@ -0,0 +1,177 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"docker-socket-proxy/internal/management"
+)
+
+const (
+	// decisionLogSize is how many recent decisions are kept per socket
+	decisionLogSize = 1000
+	// decisionFollowerBuffer is how many decisions a follower may fall behind
+	// by before further decisions are dropped for it
+	decisionFollowerBuffer = 64
+)
+
+// decisionLog is a ring buffer of a socket's recent decisions, which can be
+// followed for new ones as they are made
+type decisionLog struct {
+	mu        sync.Mutex
+	entries   []management.LogEntry
+	next      int
+	full      bool
+	followers map[chan management.LogEntry]struct{}
+	closed    bool
+}
+
+// newDecisionLog creates an empty decision log
+func newDecisionLog() *decisionLog {
+	return &decisionLog{
+		entries:   make([]management.LogEntry, decisionLogSize),
+		followers: make(map[chan management.LogEntry]struct{}),
+	}
+}
+
+// add records a decision and passes it on to followers. A follower that is
+// not keeping up misses the entry rather than holding up the request.
+func (l *decisionLog) add(entry management.LogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+
+	for follower := range l.followers {
+		select {
+		case follower <- entry:
+		default:
+		}
+	}
+}
+
+// tail returns up to the last n decisions, oldest first. A negative n
+// returns every decision kept.
+func (l *decisionLog) tail(n int) []management.LogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.tailLocked(n)
+}
+
+// tailLocked is tail for callers that hold l.mu
+func (l *decisionLog) tailLocked(n int) []management.LogEntry {
+	count := l.next
+	if l.full {
+		count = len(l.entries)
+	}
+	if n >= 0 && n < count {
+		count = n
+	}
+
+	tail := make([]management.LogEntry, 0, count)
+	for i := count; i > 0; i-- {
+		tail = append(tail, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+	return tail
+}
+
+// follow returns up to the last n decisions along with a channel of the
+// decisions made from then on, so that none are missed or repeated in
+// between. The channel is closed by stop, or once the log is closed.
+func (l *decisionLog) follow(n int) (tail []management.LogEntry, entries <-chan management.LogEntry, stop func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	follower := make(chan management.LogEntry, decisionFollowerBuffer)
+	if l.closed {
+		close(follower)
+		return l.tailLocked(n), follower, func() {}
+	}
+	l.followers[follower] = struct{}{}
+
+	stop = func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if _, ok := l.followers[follower]; ok {
+			delete(l.followers, follower)
+			close(follower)
+		}
+	}
+	return l.tailLocked(n), follower, stop
+}
+
+// close ends every follower and refuses new ones. Decisions are still kept.
+func (l *decisionLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	for follower := range l.followers {
+		delete(l.followers, follower)
+		close(follower)
+	}
+}
+
+// decisionLogFor returns the decision log of a socket, creating it if needed
+func (h *ProxyHandler) decisionLogFor(socketPath string) *decisionLog {
+	h.decisionLogMu.Lock()
+	defer h.decisionLogMu.Unlock()
+
+	if h.decisionLogs == nil {
+		h.decisionLogs = make(map[string]*decisionLog)
+	}
+	decisions, ok := h.decisionLogs[socketPath]
+	if !ok {
+		decisions = newDecisionLog()
+		if h.decisionLogsClosed {
+			decisions.close()
+		}
+		h.decisionLogs[socketPath] = decisions
+	}
+	return decisions
+}
+
+// logDecision adds a decision to the socket's recent decisions
+func (h *ProxyHandler) logDecision(r *http.Request, socketPath string, decision ruleDecision) {
+	action := "deny"
+	if decision.allowed {
+		action = "allow"
+	}
+	h.decisionLogFor(socketPath).add(management.LogEntry{
+		Time:     time.Now().UTC(),
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Decision: action,
+		Reason:   decision.reason,
+		Shadow:   decision.shadow,
+	})
+}
+
+// forgetDecisionLog drops a deleted socket's decisions and ends its followers
+func (h *ProxyHandler) forgetDecisionLog(socketPath string) {
+	h.decisionLogMu.Lock()
+	decisions, ok := h.decisionLogs[socketPath]
+	delete(h.decisionLogs, socketPath)
+	h.decisionLogMu.Unlock()
+
+	if ok {
+		decisions.close()
+	}
+}
+
+// closeDecisionLogs ends every follower, so that following clients do not
+// hold up the management server shutting down
+func (h *ProxyHandler) closeDecisionLogs() {
+	h.decisionLogMu.Lock()
+	defer h.decisionLogMu.Unlock()
+
+	h.decisionLogsClosed = true
+	for _, decisions := range h.decisionLogs {
+		decisions.close()
+	}
+}
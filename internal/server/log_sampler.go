@@ -0,0 +1,89 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"docker-socket-proxy/internal/logging"
+)
+
+// allowLogFlushInterval is how often suppressed allow log counts are summarised
+var allowLogFlushInterval = time.Second
+
+// logSampler limits how many log lines are emitted per second
+type logSampler struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	count       int
+	suppressed  int
+}
+
+// allow reports whether a line may be logged under the given per-second
+// rate, counting the lines it suppresses until they are flushed
+func (s *logSampler) allow(now time.Time, rate int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.windowStart) >= time.Second {
+		s.windowStart = now
+		s.count = 0
+	}
+
+	if s.count < rate {
+		s.count++
+		return true
+	}
+
+	s.suppressed++
+	return false
+}
+
+// flush returns how many lines were suppressed since the last flush and
+// resets the count
+func (s *logSampler) flush() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	suppressed := s.suppressed
+	s.suppressed = 0
+	return suppressed
+}
+
+// runAllowLogFlusher periodically summarises suppressed allow logs until done
+// is closed, so counts are reported even after a socket's traffic stops
+func (h *ProxyHandler) runAllowLogFlusher(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			h.flushAllowLogs()
+		}
+	}
+}
+
+// flushAllowLogs logs a summary for every socket that suppressed allow logs
+// since the last flush
+func (h *ProxyHandler) flushAllowLogs() {
+	h.samplerMu.Lock()
+	samplers := make(map[string]*logSampler, len(h.samplers))
+	for socketPath, sampler := range h.samplers {
+		samplers[socketPath] = sampler
+	}
+	h.samplerMu.Unlock()
+
+	for socketPath, sampler := range samplers {
+		logSuppressedAllows(socketPath, sampler)
+	}
+}
+
+// logSuppressedAllows logs how many allow lines the socket's sampler
+// suppressed, if any
+func logSuppressedAllows(socketPath string, sampler *logSampler) {
+	if suppressed := sampler.flush(); suppressed > 0 {
+		logging.GetLogger().Info("Suppressed allow logs", "socket", socketPath, "count", suppressed)
+	}
+}
@@ -1,12 +1,20 @@
 package server
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net"
+	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -16,6 +24,7 @@ import (
 	"docker-socket-proxy/internal/storage"
 
 	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
 )
 
 type ManagementHandler struct {
@@ -25,11 +34,21 @@ type ManagementHandler struct {
 	proxyHandler  *ProxyHandler
 	servers       map[string]*http.Server
 	serverMu      sync.RWMutex
+	createMu      sync.Mutex
 	store         *storage.FileStore
 	mux           *http.ServeMux
+	basePath      string
+	token         string // Bearer token every request but health checks must carry, empty for none
 }
 
 func NewManagementHandler(dockerSocket string, configs map[string]*config.SocketConfig, mu *sync.RWMutex, store *storage.FileStore) *ManagementHandler {
+	return newManagementHandler(dockerSocket, configs, mu, store, "")
+}
+
+// newManagementHandler creates a management handler whose routes are served under basePath
+func newManagementHandler(dockerSocket string, configs map[string]*config.SocketConfig, mu *sync.RWMutex, store *storage.FileStore, basePath string) *ManagementHandler {
+	basePath = management.NormalizeBasePath(basePath)
+
 	// Create the handler first
 	h := &ManagementHandler{
 		dockerSocket:  dockerSocket,
@@ -39,35 +58,92 @@ func NewManagementHandler(dockerSocket string, configs map[string]*config.Socket
 		servers:       make(map[string]*http.Server),
 		store:         store,
 		mux:           http.NewServeMux(), // Initialize mux immediately
+		basePath:      basePath,
 	}
 
-	h.mux.HandleFunc("/socket/create", func(w http.ResponseWriter, r *http.Request) {
+	h.mux.HandleFunc(basePath+"/socket/create", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 			return
 		}
 		h.CreateSocketHandler(w, r)
 	})
 
-	h.mux.HandleFunc("/socket/list", func(w http.ResponseWriter, r *http.Request) {
+	h.mux.HandleFunc(basePath+"/socket/list", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 			return
 		}
 		h.handleListSockets(w, r)
 	})
 
-	h.mux.HandleFunc("/socket/describe", func(w http.ResponseWriter, r *http.Request) {
+	h.mux.HandleFunc(basePath+"/socket/describe", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 			return
 		}
 		h.handleDescribeSocket(w, r)
 	})
 
-	h.mux.HandleFunc("/socket/delete", func(w http.ResponseWriter, r *http.Request) {
+	h.mux.HandleFunc(basePath+"/socket/test", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		h.handleTestSocket(w, r)
+	})
+
+	h.mux.HandleFunc(basePath+"/socket/export", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		h.handleExportSockets(w, r)
+	})
+
+	h.mux.HandleFunc(basePath+"/socket/update", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		h.handleUpdateSocket(w, r)
+	})
+
+	h.mux.HandleFunc(basePath+"/socket/rename", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		h.handleRenameSocket(w, r)
+	})
+
+	h.mux.HandleFunc(basePath+"/socket/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		h.handleSocketStats(w, r)
+	})
+
+	h.mux.HandleFunc(basePath+"/socket/stats/reset", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		h.handleResetStats(w, r)
+	})
+
+	h.mux.HandleFunc(basePath+"/socket/logs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		h.handleSocketLogs(w, r)
+	})
+
+	h.mux.HandleFunc(basePath+"/socket/delete", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 			return
 		}
 
@@ -78,22 +154,39 @@ func NewManagementHandler(dockerSocket string, configs map[string]*config.Socket
 		}
 
 		if socketName == "" {
-			http.Error(w, "Socket parameter is required", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, "Socket parameter is required")
 			return
 		}
 
 		h.handleDeleteSocket(w, r)
 	})
 
-	h.mux.HandleFunc("/socket/clean", func(w http.ResponseWriter, r *http.Request) {
+	h.mux.HandleFunc(basePath+"/socket/clean", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 			return
 		}
 
 		h.cleanSockets(w, r)
 	})
 
+	h.mux.HandleFunc(basePath+"/health", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		h.handleHealth(w, r)
+	})
+
+	h.mux.HandleFunc(basePath+"/loglevel", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		h.handleLogLevel(w, r)
+	})
+
 	return h
 }
 
@@ -105,32 +198,190 @@ func (h *ManagementHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Log the request
 	log.Info("Management request", "method", r.Method, "path", r.URL.Path)
 
+	if !h.authorized(r) {
+		log.Warn("Management request rejected, missing or invalid token", "method", r.Method, "path", r.URL.Path)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="docker-socket-proxy"`)
+		writeError(w, http.StatusUnauthorized, "missing or invalid management token")
+		return
+	}
+
 	// Let the mux handle the request
 	if h.mux != nil {
 		h.mux.ServeHTTP(w, r)
 	} else {
 		log.Error("Mux is nil, cannot handle request")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+// authorized reports whether a request may use the management API. With a
+// token configured every request but the health check must send it as a
+// bearer token, so that probes keep working without the secret.
+func (h *ManagementHandler) authorized(r *http.Request) bool {
+	if h.token == "" || r.URL.Path == h.basePath+"/health" {
+		return true
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
 	}
+	// Comparing digests keeps the comparison constant time whatever the length
+	got := sha256.Sum256([]byte(token))
+	want := sha256.Sum256([]byte(h.token))
+	return subtle.ConstantTimeCompare(got[:], want[:]) == 1
 }
 
 // validateAndDecodeConfig validates and decodes the socket configuration from the request
 func (h *ManagementHandler) validateAndDecodeConfig(r *http.Request) (*config.SocketConfig, error) {
+	socketConfig, _, err := h.decodeCreateRequest(r)
+	return socketConfig, err
+}
+
+// decodeCreateRequest decodes the socket configuration and the optional
+// socket name from a create request. A ?name= query parameter takes
+// precedence over a name field in the body.
+func (h *ManagementHandler) decodeCreateRequest(r *http.Request) (*config.SocketConfig, string, error) {
 	// Default config if none is provided
-	socketConfig := &config.SocketConfig{}
+	var socketConfig config.SocketConfig
 
-	// If there's a request body, try to decode it
+	// If there's a request body, try to decode it. A config that was given
+	// is validated now, so that a broken pattern fails the create rather than
+	// every request that reaches it. A body holding only a name has none.
 	if r.Body != nil && r.ContentLength > 0 {
-		if r.Header.Get("Content-Type") != "application/json" {
-			return nil, fmt.Errorf("expected Content-Type application/json")
+		if err := decodeConfigBody(r, &socketConfig); err != nil {
+			return nil, "", err
+		}
+		if !reflect.DeepEqual(socketConfig, config.SocketConfig{Name: socketConfig.Name}) {
+			if err := config.ValidateConfig(&socketConfig); err != nil {
+				return nil, "", fmt.Errorf("invalid configuration: %w", err)
+			}
+		}
+	}
+
+	// The name is stored with the config, so it is still known after a restart
+	if queryName := r.URL.Query().Get("name"); queryName != "" {
+		socketConfig.Name = queryName
+	}
+
+	// Store the rules profiles expand to, so the socket does not change if
+	// the built-in profiles do
+	if err := config.ExpandProfiles(&socketConfig); err != nil {
+		return nil, "", err
+	}
+
+	return &socketConfig, socketConfig.Name, nil
+}
+
+// apiError is an error the management API answers with its own status
+// code. Errors that wrap one are answered with its code.
+type apiError struct {
+	Code    int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// writeError writes an error response in the management API's envelope
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeAPIError(w, &apiError{Code: status, Message: message})
+}
+
+// writeAPIError writes an error response with the message of err and the
+// code of the apiError it wraps, 500 when it wraps none
+func writeAPIError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		status = apiErr.Code
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	response := management.Response[management.ErrorResponse]{
+		Status: "error",
+		Response: management.ErrorResponse{
+			Error: err.Error(),
+			Code:  status,
+		},
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logging.GetLogger().Debug("Failed to write error response", "error", err)
+	}
+}
+
+// errUnsupportedMediaType is returned for config bodies that are neither JSON
+// nor YAML
+var errUnsupportedMediaType = errors.New("unsupported Content-Type")
+
+// decodeConfigBody decodes a JSON or YAML config body according to its
+// Content-Type. A body without a Content-Type is taken to be JSON.
+func decodeConfigBody(r *http.Request, v any) error {
+	mediaType := "application/json"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return fmt.Errorf("%w %q", errUnsupportedMediaType, contentType)
 		}
+		mediaType = parsed
+	}
 
-		if err := json.NewDecoder(r.Body).Decode(socketConfig); err != nil {
-			return nil, fmt.Errorf("invalid JSON configuration: %w", err)
+	switch mediaType {
+	case "application/json":
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+			return fmt.Errorf("invalid JSON configuration: %w", err)
+		}
+	case "application/yaml", "application/x-yaml", "text/yaml":
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read configuration: %w", err)
+		}
+		// Go through JSON so the config holds the same value types, such as
+		// float64 numbers, as a JSON body and the request bodies it matches
+		var doc any
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("invalid YAML configuration: %w", err)
+		}
+		converted, err := json.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("invalid YAML configuration: %w", err)
 		}
+		if err := json.Unmarshal(converted, v); err != nil {
+			return fmt.Errorf("invalid YAML configuration: %w", err)
+		}
+	default:
+		return fmt.Errorf("%w %q, expected application/json or application/yaml", errUnsupportedMediaType, mediaType)
+	}
+	return nil
+}
+
+// configBodyStatus returns the status code for a config body that could not
+// be decoded
+func configBodyStatus(err error) int {
+	if errors.Is(err, errUnsupportedMediaType) {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+// socketFileName returns the socket file name for a requested socket name,
+// generating a unique one when no name is given
+func socketFileName(name string) (string, error) {
+	if name == "" {
+		return fmt.Sprintf("docker-proxy-%s.sock", uuid.New().String()), nil
 	}
 
-	return socketConfig, nil
+	if strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return "", fmt.Errorf("invalid socket name %q: must not contain path separators or ..", name)
+	}
+
+	if !strings.HasSuffix(name, ".sock") {
+		name += ".sock"
+	}
+	return name, nil
 }
 
 // CreateSocketHandler handles requests to create a new socket
@@ -141,64 +392,109 @@ func (h *ManagementHandler) CreateSocketHandler(w http.ResponseWriter, r *http.R
 	srv, ok := r.Context().Value(serverContextKey).(*Server)
 	if !ok {
 		log.Error("Server not found in context")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
 	// Validate and decode the configuration
-	socketConfig, err := h.validateAndDecodeConfig(r)
+	socketConfig, name, err := h.decodeCreateRequest(r)
 	if err != nil {
 		log.Error("Invalid configuration", "error", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, configBodyStatus(err), err.Error())
+		return
+	}
+
+	// Work out the socket file name, generating one if none was requested
+	socketName, err := socketFileName(name)
+	if err != nil {
+		log.Error("Invalid socket name", "error", err)
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Generate a unique socket path
-	socketName := fmt.Sprintf("docker-proxy-%s.sock", uuid.New().String())
+	// Optionally make sure the Docker daemon is reachable before creating the socket
+	if r.URL.Query().Get("check_upstream") == "true" {
+		if err := pingUpstream(h.proxyHandler.upstream, upstreamPingTimeout); err != nil {
+			log.Error("Upstream Docker socket is not reachable", "error", err, "upstream", h.proxyHandler.upstream.String())
+			writeError(w, http.StatusBadGateway, fmt.Sprintf("Upstream Docker socket %s is not reachable: %v", h.proxyHandler.upstream, err))
+			return
+		}
+	}
+
 	socketPath := filepath.Join(srv.socketDir, socketName)
 
-	// Create the socket listener
-	listener, err := net.Listen("unix", socketPath)
-	if err != nil {
+	// Create the socket and start serving it
+	if err := h.createSocket(srv, socketPath, socketConfig); err != nil {
 		log.Error("Failed to create socket", "error", err, "path", socketPath)
-		http.Error(w, fmt.Sprintf("Failed to create socket: %v", err), http.StatusInternalServerError)
+		writeAPIError(w, fmt.Errorf("Failed to create socket: %w", err))
+		return
+	}
+
+	// Return the socket path
+	w.Header().Set("Content-Type", "application/json")
+	response := management.Response[management.CreateResponse]{
+		Status: "success",
+		Response: management.CreateResponse{
+			Socket: socketPath,
+		},
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Failed to encode response", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
+}
+
+// errSocketExists is returned when creating a socket at a path that is already in use
+var errSocketExists = &apiError{Code: http.StatusConflict, Message: "socket already exists"}
+
+// createSocket listens on socketPath and starts proxying it with the given
+// config. Creation is serialized so that concurrent creates for the same path
+// cannot both pass the existence check; the loser gets errSocketExists.
+func (h *ManagementHandler) createSocket(srv *Server, socketPath string, socketConfig *config.SocketConfig) error {
+	log := logging.GetLogger()
+
+	h.createMu.Lock()
+	defer h.createMu.Unlock()
+
+	// Refuse to replace a socket that is already configured or on disk
+	h.configMu.RLock()
+	_, configured := h.socketConfigs[socketPath]
+	h.configMu.RUnlock()
+	if configured {
+		return fmt.Errorf("%w: %s", errSocketExists, filepath.Base(socketPath))
+	}
+	if _, err := os.Stat(socketPath); err == nil {
+		return fmt.Errorf("%w: %s", errSocketExists, filepath.Base(socketPath))
+	}
 
-	// Set socket permissions
-	if err := os.Chmod(socketPath, 0660); err != nil {
-		log.Warn("Failed to set socket permissions", "error", err)
+	// Create the socket listener
+	listener, err := listenProxySocket(socketPath, socketConfig)
+	if err != nil {
+		return err
 	}
 
 	// Add the socket to the server's tracking
 	srv.TrackSocket(socketPath)
 
-	// Add the configuration to the map
-	h.configMu.Lock()
-	h.socketConfigs[socketPath] = socketConfig
-	h.configMu.Unlock()
-
 	// Save the configuration to disk
 	if err := h.store.SaveConfig(socketPath, socketConfig); err != nil {
 		log.Error("Failed to save socket configuration", "error", err)
 		// Continue anyway - the socket will still work
 	}
 
-	// Create a proxy handler for the socket
-	proxyHandler := NewProxyHandler(h.dockerSocket, h.socketConfigs, h.configMu)
+	// Start tracking runtime stats for the socket
+	h.proxyHandler.registerSocket(socketPath)
 
 	// Create a server for the socket
-	server := &http.Server{
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Serve the request
-			proxyHandler.ServeHTTPWithSocket(w, r, socketPath)
-		}),
-	}
+	server := newProxyServer(h.proxyHandler, socketPath)
 
-	// Add the server to the map
-	srv.proxyMu.Lock()
+	// Add the configuration and the server to the maps together, under the
+	// server's config lock that guards both
+	h.configMu.Lock()
+	h.socketConfigs[socketPath] = socketConfig
 	srv.proxyServers[socketPath] = server
-	srv.proxyMu.Unlock()
+	h.configMu.Unlock()
 
 	// Start the server in a goroutine
 	go func() {
@@ -208,181 +504,707 @@ func (h *ManagementHandler) CreateSocketHandler(w http.ResponseWriter, r *http.R
 		}
 	}()
 
-	// Return the socket path
-	w.Header().Set("Content-Type", "application/json")
-	response := management.Response[management.CreateResponse]{
-		Status: "success",
-		Response: management.CreateResponse{
-			Socket: socketPath,
-		},
-	}
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Error("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
+	return nil
 }
 
-// handleDeleteSocket handles the deletion of a socket
-func (h *ManagementHandler) handleDeleteSocket(w http.ResponseWriter, r *http.Request) {
+// handleUpdateSocket replaces the configuration of an existing socket, keeping
+// its listener and connected clients
+func (h *ManagementHandler) handleUpdateSocket(w http.ResponseWriter, r *http.Request) {
 	log := logging.GetLogger()
 
-	// Get the socket path from the query parameters or header
 	socketName := r.URL.Query().Get("socket")
 	if socketName == "" {
-		// Try to get it from the header for backward compatibility
-		socketName = r.Header.Get("Socket-Path")
-		if socketName == "" {
-			http.Error(w, "socket path is required", http.StatusBadRequest)
-			return
-		}
+		writeError(w, http.StatusBadRequest, "socket parameter is required")
+		return
 	}
-
 	socketPath := h.resolveSocketPath(r, socketName)
-	log.Info("Deleting socket", "path", socketPath)
 
-	// Get the server from the context
-	srv, _ := r.Context().Value(serverContextKey).(*Server)
+	// Decode and validate the new configuration before touching the old one
+	var socketConfig config.SocketConfig
+	if err := decodeConfigBody(r, &socketConfig); err != nil {
+		writeError(w, configBodyStatus(err), err.Error())
+		return
+	}
+	if err := config.ValidateConfig(&socketConfig); err != nil {
+		log.Error("Invalid configuration for update", "error", err, "path", socketPath)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid configuration: %v", err))
+		return
+	}
+	if err := config.ExpandProfiles(&socketConfig); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid configuration: %v", err))
+		return
+	}
 
-	// Delete the socket and associated resources
-	if err := h.deleteSocket(socketPath, srv); err != nil {
-		log.Error("Failed to delete socket", "error", err)
-		http.Error(w, fmt.Sprintf("Failed to delete socket: %v", err), http.StatusInternalServerError)
+	if err := h.updateSocket(socketPath, &socketConfig); err != nil {
+		log.Error("Failed to update socket", "error", err, "path", socketPath)
+		writeAPIError(w, fmt.Errorf("Failed to update socket: %w", err))
 		return
 	}
 
+	log.Info("Updated proxy socket configuration", "path", socketPath, "rules", len(socketConfig.Rules))
+
 	w.Header().Set("Content-Type", "application/json")
-	response := management.Response[management.DeleteResponse]{
+	response := management.Response[management.UpdateResponse]{
 		Status: "success",
-		Response: management.DeleteResponse{
-			Message: fmt.Sprintf("Socket %s deleted successfully", socketPath),
+		Response: management.UpdateResponse{
+			Socket: socketPath,
 		},
 	}
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Error("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 }
 
-// deleteSocket handles the actual deletion of a socket and its resources
-func (h *ManagementHandler) deleteSocket(socketPath string, srv *Server) error {
-	log := logging.GetLogger()
-	var errs []string
+// errSocketNotFound is returned when operating on a socket that is not configured
+var errSocketNotFound = &apiError{Code: http.StatusNotFound, Message: "socket not found"}
 
-	// Check if the socket exists in our config map
-	h.configMu.RLock()
-	_, exists := h.socketConfigs[socketPath]
-	h.configMu.RUnlock()
+// errListenChanged is returned for an update that moves a socket to another
+// listen address, which needs the socket to be recreated
+var errListenChanged = &apiError{Code: http.StatusBadRequest, Message: "the listen address of a socket cannot be changed, delete and recreate it instead"}
 
-	// Remove the socket file
-	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
-		log.Error("Failed to remove socket file", "error", err)
-		errs = append(errs, fmt.Sprintf("remove socket file: %v", err))
-		// Continue anyway - we still want to clean up other resources
-	}
+// updateSocket swaps in a new config for a socket and persists it. Requests
+// already being evaluated finish with the old config. If the new config cannot
+// be saved the old one is restored, so memory and disk stay in agreement.
+func (h *ManagementHandler) updateSocket(socketPath string, socketConfig *config.SocketConfig) error {
+	h.createMu.Lock()
+	defer h.createMu.Unlock()
 
-	// Remove the config from the map
 	h.configMu.Lock()
-	delete(h.socketConfigs, socketPath)
-	h.configMu.Unlock()
-
-	// Delete the config file
-	if err := h.store.DeleteConfig(socketPath); err != nil {
-		log.Error("Failed to delete config file", "error", err)
-		errs = append(errs, fmt.Sprintf("delete config file: %v", err))
-		// Continue anyway - we've already removed the socket
+	old, exists := h.socketConfigs[socketPath]
+	if exists && old.Config.Listen != socketConfig.Config.Listen {
+		h.configMu.Unlock()
+		return errListenChanged
 	}
-
-	// Stop the proxy server if it's running
-	if exists && srv != nil {
-		srv.configMu.Lock()
-		if server, ok := srv.proxyServers[socketPath]; ok {
-			if err := server.Close(); err != nil {
-				log.Error("Failed to stop proxy server", "error", err)
-				errs = append(errs, fmt.Sprintf("stop proxy server: %v", err))
-			}
-			delete(srv.proxyServers, socketPath)
+	if exists {
+		// A config sent without a name keeps the one the socket has
+		if socketConfig.Name == "" {
+			socketConfig.Name = old.Name
 		}
-		srv.configMu.Unlock()
+		h.socketConfigs[socketPath] = socketConfig
+	}
+	h.configMu.Unlock()
 
-		// Untrack the socket
-		srv.UntrackSocket(socketPath)
+	if !exists {
+		return fmt.Errorf("%w: %s", errSocketNotFound, filepath.Base(socketPath))
 	}
 
-	if len(errs) > 0 {
-		return fmt.Errorf("errors during socket deletion: %s", strings.Join(errs, "; "))
+	if err := h.store.SaveConfig(socketPath, socketConfig); err != nil {
+		h.configMu.Lock()
+		h.socketConfigs[socketPath] = old
+		h.configMu.Unlock()
+		return fmt.Errorf("failed to save configuration: %w", err)
 	}
 
 	return nil
 }
 
-func (h *ManagementHandler) handleListSockets(w http.ResponseWriter, r *http.Request) {
+// handleRenameSocket gives a socket a new name, keeping its socket file
+// unless move=true asks for the file to be renamed after it as well
+func (h *ManagementHandler) handleRenameSocket(w http.ResponseWriter, r *http.Request) {
 	log := logging.GetLogger()
 
-	// Get the server from the context
-	_, ok := r.Context().Value(serverContextKey).(*Server)
-	if !ok {
-		log.Warn("Server not found in context - returning empty list for test compatibility")
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode([]string{}); err != nil {
-			log.Error("Failed to encode empty response", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
+	socketName := r.URL.Query().Get("socket")
+	newName := r.URL.Query().Get("name")
+	if socketName == "" || newName == "" {
+		writeError(w, http.StatusBadRequest, "socket and name parameters are required")
 		return
 	}
+	newFileName, err := socketFileName(newName)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	move := r.URL.Query().Get("move") == "true"
 
-	// Get the list of sockets
-	h.configMu.RLock()
-	sockets := make([]string, 0, len(h.socketConfigs))
-	for socketPath := range h.socketConfigs {
-		// Extract just the filename from the path
-		socketName := filepath.Base(socketPath)
-		sockets = append(sockets, socketName)
+	h.createMu.Lock()
+	defer h.createMu.Unlock()
+
+	socketPath, err := h.resolveSocketPrefix(r, socketName)
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
 	}
-	h.configMu.RUnlock()
 
-	// Return the list of sockets
+	srv, _ := r.Context().Value(serverContextKey).(*Server)
+	newPath, err := h.renameSocket(srv, socketPath, newName, newFileName, move)
+	if err != nil {
+		log.Error("Failed to rename socket", "error", err, "path", socketPath, "name", newName)
+		writeAPIError(w, fmt.Errorf("Failed to rename socket: %w", err))
+		return
+	}
+
+	log.Info("Renamed proxy socket", "path", socketPath, "new_path", newPath, "name", newName)
+
 	w.Header().Set("Content-Type", "application/json")
-	response := management.Response[management.ListResponse]{
+	response := management.Response[management.RenameResponse]{
 		Status: "success",
-		Response: management.ListResponse{
-			Sockets: sockets,
+		Response: management.RenameResponse{
+			Socket: newPath,
+			Name:   newName,
 		},
 	}
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Error("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 }
 
-// handleDescribeSocket handles requests to describe a socket's configuration
-func (h *ManagementHandler) handleDescribeSocket(w http.ResponseWriter, r *http.Request) {
-	log := logging.GetLogger()
+// errNoSocketFile is returned for moving a socket served on a TCP address
+var errNoSocketFile = &apiError{Code: http.StatusBadRequest, Message: "the socket is served on a TCP address and has no socket file to move"}
 
-	// Get the socket path from the query parameters
-	socketName := r.URL.Query().Get("socket")
-	if socketName == "" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		response := management.Response[management.ErrorResponse]{
-			Status: "error",
-			Response: management.ErrorResponse{
-				Error: "socket parameter is required",
-			},
-		}
-		if err := json.NewEncoder(w).Encode(response); err != nil {
-			log.Error("Failed to encode error response", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
+// renameSocket stores a new name with a socket's config and returns the
+// socket's path afterwards. A name already used by another socket, as its
+// name or its file name, is refused. With move the socket is served from a
+// file named after it, and the old file is drained and removed. Callers must
+// hold h.createMu.
+func (h *ManagementHandler) renameSocket(srv *Server, socketPath, newName, newFileName string, move bool) (string, error) {
+	h.configMu.RLock()
+	old, exists := h.socketConfigs[socketPath]
+	var taken bool
+	for path, cfg := range h.socketConfigs {
+		if path != socketPath && (cfg.Name == newName || filepath.Base(path) == newFileName) {
+			taken = true
 		}
+	}
+	h.configMu.RUnlock()
+	switch {
+	case !exists:
+		return "", fmt.Errorf("%w: %s", errSocketNotFound, filepath.Base(socketPath))
+	case taken:
+		return "", fmt.Errorf("%w: %s", errSocketExists, newName)
+	}
+
+	// The config is copied rather than changed, requests being evaluated
+	// keep the one they started with
+	renamed := *old
+	renamed.Name = newName
+
+	newPath := filepath.Join(filepath.Dir(socketPath), newFileName)
+	if !move || newPath == socketPath {
+		if err := h.store.SaveConfig(socketPath, &renamed); err != nil {
+			return "", fmt.Errorf("failed to save configuration: %w", err)
+		}
+		h.configMu.Lock()
+		h.socketConfigs[socketPath] = &renamed
+		h.configMu.Unlock()
+		return socketPath, nil
+	}
+
+	if renamed.Config.Listen != "" {
+		return "", errNoSocketFile
+	}
+	if srv == nil {
+		return "", fmt.Errorf("cannot move a socket without a server")
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return "", fmt.Errorf("%w: %s", errSocketExists, newFileName)
+	}
+	return newPath, h.moveSocket(srv, socketPath, newPath, &renamed)
+}
+
+// moveSocket serves a socket's renamed config from a new socket file, then
+// drains the proxy server of the old file and removes it along with its
+// stored config. Clients connected to the old file finish their requests,
+// new ones have to use the new file.
+func (h *ManagementHandler) moveSocket(srv *Server, socketPath, newPath string, socketConfig *config.SocketConfig) error {
+	log := logging.GetLogger()
+
+	listener, err := listenProxySocket(newPath, socketConfig)
+	if err != nil {
+		return err
+	}
+	if err := h.store.SaveConfig(newPath, socketConfig); err != nil {
+		if closeErr := listener.Close(); closeErr != nil {
+			log.Warn("Failed to close listener", "path", newPath, "error", closeErr)
+		}
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+
+	server := newProxyServer(h.proxyHandler, newPath)
+
+	h.configMu.Lock()
+	h.socketConfigs[newPath] = socketConfig
+	delete(h.socketConfigs, socketPath)
+	oldServer := srv.proxyServers[socketPath]
+	srv.proxyServers[newPath] = server
+	delete(srv.proxyServers, socketPath)
+	h.configMu.Unlock()
+
+	h.proxyHandler.moveSocket(socketPath, newPath)
+	srv.UntrackSocket(socketPath)
+	srv.TrackSocket(newPath)
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Error("Proxy server error", "error", err, "path", newPath)
+		}
+	}()
+
+	if oldServer != nil {
+		ctx, cancel := drainContext(srv)
+		defer cancel()
+		if err := drainProxyServer(ctx, socketPath, oldServer); err != nil {
+			log.Warn("Failed to stop proxy server of moved socket", "path", socketPath, "error", err)
+		}
+	}
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		log.Warn("Failed to remove moved socket file", "path", socketPath, "error", err)
+	}
+	if err := h.store.DeleteConfig(socketPath); err != nil {
+		log.Warn("Failed to delete config file of moved socket", "path", socketPath, "error", err)
+	}
+	return nil
+}
+
+// handleDeleteSocket handles the deletion of a socket
+func (h *ManagementHandler) handleDeleteSocket(w http.ResponseWriter, r *http.Request) {
+	log := logging.GetLogger()
+
+	// Get the socket path from the query parameters or header
+	socketName := r.URL.Query().Get("socket")
+	if socketName == "" {
+		// Try to get it from the header for backward compatibility
+		socketName = r.Header.Get("Socket-Path")
+		if socketName == "" {
+			writeError(w, http.StatusBadRequest, "socket path is required")
+			return
+		}
+	}
+
+	// Delete the socket and associated resources, a create of the same path
+	// waits until it is gone
+	h.createMu.Lock()
+	defer h.createMu.Unlock()
+
+	socketPath, err := h.resolveSocketPrefix(r, socketName)
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	h.configMu.RLock()
+	_, exists := h.socketConfigs[socketPath]
+	h.configMu.RUnlock()
+	if !exists {
+		writeAPIError(w, fmt.Errorf("%w: %s", errSocketNotFound, filepath.Base(socketPath)))
+		return
+	}
+	log.Info("Deleting socket", "path", socketPath)
+
+	// Get the server from the context
+	srv, _ := r.Context().Value(serverContextKey).(*Server)
+	ctx, cancel := drainContext(srv)
+	defer cancel()
+	if err := h.deleteSocket(ctx, socketPath, srv); err != nil {
+		log.Error("Failed to delete socket", "error", err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to delete socket: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := management.Response[management.DeleteResponse]{
+		Status: "success",
+		Response: management.DeleteResponse{
+			Message: fmt.Sprintf("Socket %s deleted successfully", socketPath),
+		},
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Failed to encode response", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+}
+
+// drainContext returns the context a deleted socket's in-flight requests
+// have to finish within. It is not tied to the management request, so a
+// client giving up on the delete does not cut the drain short.
+func drainContext(srv *Server) (context.Context, context.CancelFunc) {
+	timeout := DefaultDrainTimeout
+	if srv != nil {
+		timeout = srv.drainTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// deleteSocket handles the actual deletion of a socket and its resources.
+// The socket's proxy server is shut down first, so that requests in flight
+// can finish with the socket's config, and closed once ctx is done. Callers
+// must hold h.createMu, so that the socket is not recreated part way through.
+func (h *ManagementHandler) deleteSocket(ctx context.Context, socketPath string, srv *Server) error {
+	log := logging.GetLogger()
+	var errs []string
+
+	// Check if the socket exists in our config map
+	h.configMu.RLock()
+	_, exists := h.socketConfigs[socketPath]
+	h.configMu.RUnlock()
+
+	// Stop the proxy server if it's running
+	if exists && srv != nil {
+		srv.configMu.Lock()
+		server, ok := srv.proxyServers[socketPath]
+		delete(srv.proxyServers, socketPath)
+		srv.configMu.Unlock()
+
+		if ok {
+			if err := drainProxyServer(ctx, socketPath, server); err != nil {
+				log.Error("Failed to stop proxy server", "error", err)
+				errs = append(errs, fmt.Sprintf("stop proxy server: %v", err))
+			}
+		}
+
+		// Untrack the socket
+		srv.UntrackSocket(socketPath)
+	}
+
+	// Remove the socket file
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		log.Error("Failed to remove socket file", "error", err)
+		errs = append(errs, fmt.Sprintf("remove socket file: %v", err))
+		// Continue anyway - we still want to clean up other resources
+	}
+
+	// Remove the config from the map
+	h.configMu.Lock()
+	delete(h.socketConfigs, socketPath)
+	h.configMu.Unlock()
+
+	// Drop any runtime stats for the socket
+	h.proxyHandler.forgetSocket(socketPath)
+
+	// Delete the config file
+	if err := h.store.DeleteConfig(socketPath); err != nil {
+		log.Error("Failed to delete config file", "error", err)
+		errs = append(errs, fmt.Sprintf("delete config file: %v", err))
+		// Continue anyway - we've already removed the socket
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("errors during socket deletion: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// drainProxyServer stops a proxy server accepting connections and waits for
+// its in-flight requests to finish, closing the connections still open once
+// ctx is done
+func drainProxyServer(ctx context.Context, socketPath string, server *http.Server) error {
+	log := logging.GetLogger()
+
+	err := server.Shutdown(ctx)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	log.Warn("Proxy server did not drain in time, closing its connections", "path", socketPath)
+	return server.Close()
+}
+
+func (h *ManagementHandler) handleListSockets(w http.ResponseWriter, r *http.Request) {
+	log := logging.GetLogger()
+
+	// Get the server from the context
+	_, ok := r.Context().Value(serverContextKey).(*Server)
+	if !ok {
+		log.Error("Server not found in context")
+		writeError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
+	detail := r.URL.Query().Get("detail") == "true"
+
+	// Get the list of sockets. configMu is enough, a list must not wait for
+	// a create or for a delete to drain.
+	h.configMu.RLock()
+	sockets := make([]string, 0, len(h.socketConfigs))
+	entries := make([]management.SocketEntry, 0, len(h.socketConfigs))
+	var details []management.SocketDetail
+	for socketPath, socketConfig := range h.socketConfigs {
+		// Extract just the filename from the path
+		socketName := filepath.Base(socketPath)
+		sockets = append(sockets, socketName)
+		entries = append(entries, h.socketEntry(socketPath, socketConfig))
+
+		if detail {
+			details = append(details, h.socketDetail(socketPath, socketConfig))
+		}
+	}
+	h.configMu.RUnlock()
+
+	sort.Strings(sockets)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	sort.Slice(details, func(i, j int) bool { return details[i].Name < details[j].Name })
+
+	// Return the list of sockets
+	w.Header().Set("Content-Type", "application/json")
+	response := management.Response[management.ListResponse]{
+		Status: "success",
+		Response: management.ListResponse{
+			Sockets:       sockets,
+			Details:       details,
+			SchemaVersion: management.ListSchemaVersion,
+			Entries:       entries,
+		},
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Failed to encode response", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+}
+
+// socketEntry builds the stable listing entry for a socket
+func (h *ManagementHandler) socketEntry(socketPath string, socketConfig *config.SocketConfig) management.SocketEntry {
+	entry := management.SocketEntry{
+		Name:      filepath.Base(socketPath),
+		Type:      "unix",
+		Address:   socketPath,
+		CreatedAt: h.proxyHandler.snapshotStats(socketPath).CreatedAt,
+	}
+	if socketConfig != nil {
+		entry.DisplayName = socketConfig.Name
+		entry.Rules = len(socketConfig.Rules)
+		if address, err := socketConfig.Config.ListenAddress(); err == nil && address != "" {
+			entry.Type, entry.Address = "tcp", address
+		}
+	}
+	return entry
+}
+
+// socketDetail builds the detailed listing entry for a socket
+func (h *ManagementHandler) socketDetail(socketPath string, socketConfig *config.SocketConfig) management.SocketDetail {
+	stats := h.proxyHandler.snapshotStats(socketPath)
+
+	detail := management.SocketDetail{
+		Name:      filepath.Base(socketPath),
+		CreatedAt: stats.CreatedAt,
+	}
+	if socketConfig != nil {
+		detail.Rules = len(socketConfig.Rules)
+	}
+	if !stats.LastDecision.IsZero() {
+		lastDecision := stats.LastDecision
+		detail.LastDecision = &lastDecision
+	}
+
+	return detail
+}
+
+// socketRuleStats builds the per-rule hit counters for a socket
+func (h *ManagementHandler) socketRuleStats(socketPath string, socketConfig *config.SocketConfig) *management.SocketStats {
+	hits, since := h.proxyHandler.snapshotRuleHits(socketPath, socketConfig)
+	decisions := h.proxyHandler.snapshotStats(socketPath)
+
+	stats := &management.SocketStats{
+		Since:        since,
+		Allowed:      decisions.Allowed,
+		Denied:       decisions.Denied,
+		Rewritten:    decisions.Rewritten,
+		ShadowDenied: decisions.ShadowDenied,
+		Rules:        make([]management.RuleStat, 0, len(hits)),
+	}
+	for i, count := range hits {
+		stats.Rules = append(stats.Rules, management.RuleStat{
+			Index:  i,
+			Path:   socketConfig.Rules[i].Match.Path,
+			Method: socketConfig.Rules[i].Match.Method,
+			Hits:   count,
+		})
+	}
+	return stats
+}
+
+// handleSocketStats returns the decision counters of the socket named by
+// ?socket=, or of every socket when it is not given, sorted by name
+func (h *ManagementHandler) handleSocketStats(w http.ResponseWriter, r *http.Request) {
+	log := logging.GetLogger()
+
+	socketName := r.URL.Query().Get("socket")
+
+	h.configMu.RLock()
+	sockets := make([]string, 0, len(h.socketConfigs))
+	for socketPath := range h.socketConfigs {
+		if socketName == "" || socketPath == h.resolveSocketPath(r, socketName) {
+			sockets = append(sockets, socketPath)
+		}
+	}
+	h.configMu.RUnlock()
+	sort.Slice(sockets, func(i, j int) bool { return filepath.Base(sockets[i]) < filepath.Base(sockets[j]) })
+
+	if socketName != "" && len(sockets) == 0 {
+		writeError(w, http.StatusNotFound, "socket not found")
+		return
+	}
+
+	counters := make([]management.SocketCounters, 0, len(sockets))
+	for _, socketPath := range sockets {
+		stats := h.proxyHandler.snapshotStats(socketPath)
+		socket := management.SocketCounters{
+			Name:         filepath.Base(socketPath),
+			Requests:     stats.Allowed + stats.Denied + stats.ShadowDenied,
+			Allowed:      stats.Allowed,
+			Denied:       stats.Denied,
+			Rewritten:    stats.Rewritten,
+			ShadowDenied: stats.ShadowDenied,
+		}
+		if !stats.LastDecision.IsZero() {
+			lastRequest := stats.LastDecision
+			socket.LastRequest = &lastRequest
+		}
+		counters = append(counters, socket)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := management.Response[management.StatsResponse]{
+		Status: "success",
+		Response: management.StatsResponse{
+			Sockets: counters,
+		},
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Failed to encode response", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+}
+
+// handleResetStats zeroes the counters of the socket named by ?socket=, or of
+// every socket when it is not given
+func (h *ManagementHandler) handleResetStats(w http.ResponseWriter, r *http.Request) {
+	log := logging.GetLogger()
+
+	socketName := r.URL.Query().Get("socket")
+
+	h.configMu.RLock()
+	sockets := make([]string, 0, len(h.socketConfigs))
+	for socketPath := range h.socketConfigs {
+		if socketName == "" || socketPath == h.resolveSocketPath(r, socketName) {
+			sockets = append(sockets, socketPath)
+		}
+	}
+	h.configMu.RUnlock()
+	sort.Strings(sockets)
+
+	if socketName != "" && len(sockets) == 0 {
+		writeError(w, http.StatusNotFound, "socket not found")
+		return
+	}
+
+	h.proxyHandler.resetStats(sockets)
+	log.Info("Reset socket stats", "sockets", len(sockets))
+
+	w.Header().Set("Content-Type", "application/json")
+	response := management.Response[management.StatsResetResponse]{
+		Status: "success",
+		Response: management.StatsResetResponse{
+			Sockets: sockets,
+		},
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Failed to encode response", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+}
+
+// handleSocketLogs streams the recent decisions of the socket named by
+// ?socket= as JSON lines, the last ?tail= of them or all that are kept. With
+// ?follow=true the response stays open and new decisions are streamed as they
+// are made, until the client goes away or the socket is deleted.
+func (h *ManagementHandler) handleSocketLogs(w http.ResponseWriter, r *http.Request) {
+	log := logging.GetLogger()
+
+	socketName := r.URL.Query().Get("socket")
+	if socketName == "" {
+		writeError(w, http.StatusBadRequest, "socket parameter is required")
+		return
+	}
+
+	tail := -1
+	if value := r.URL.Query().Get("tail"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "tail must be a non-negative integer")
+			return
+		}
+		tail = n
+	}
+	follow := r.URL.Query().Get("follow") == "true"
+
 	socketPath := h.resolveSocketPath(r, socketName)
+	h.configMu.RLock()
+	_, exists := h.socketConfigs[socketPath]
+	h.configMu.RUnlock()
+	if !exists {
+		writeError(w, http.StatusNotFound, "socket not found")
+		return
+	}
+
+	decisions := h.proxyHandler.decisionLogFor(socketPath)
+	var entries []management.LogEntry
+	var updates <-chan management.LogEntry
+	if follow {
+		var stop func()
+		entries, updates, stop = decisions.follow(tail)
+		defer stop()
+	} else {
+		entries = decisions.tail(tail)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			log.Debug("Failed to write socket log entry", "error", err)
+			return
+		}
+	}
+	if !follow {
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case entry, ok := <-updates:
+			if !ok {
+				return
+			}
+			if err := encoder.Encode(entry); err != nil {
+				log.Debug("Failed to write socket log entry", "error", err)
+				return
+			}
+		}
+	}
+}
+
+// handleDescribeSocket handles requests to describe a socket's configuration
+func (h *ManagementHandler) handleDescribeSocket(w http.ResponseWriter, r *http.Request) {
+	log := logging.GetLogger()
+
+	// Get the socket path from the query parameters
+	socketName := r.URL.Query().Get("socket")
+	if socketName == "" {
+		writeError(w, http.StatusBadRequest, "socket parameter is required")
+		return
+	}
+
+	socketPath, err := h.resolveSocketPrefix(r, socketName)
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
 	log.Info("Describing socket", "path", socketPath)
 
 	// Get the configuration for the socket
@@ -391,35 +1213,180 @@ func (h *ManagementHandler) handleDescribeSocket(w http.ResponseWriter, r *http.
 	h.configMu.RUnlock()
 
 	if !exists {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		response := management.Response[management.ErrorResponse]{
-			Status: "error",
-			Response: management.ErrorResponse{
-				Error: "socket not found",
-			},
-		}
-		if err := json.NewEncoder(w).Encode(response); err != nil {
-			log.Error("Failed to encode error response", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
+		writeError(w, http.StatusNotFound, "socket not found")
 		return
 	}
 
-	// Return the socket configuration
+	// Return the socket configuration, or with effective the rules as the
+	// proxy evaluates them
 	response := management.Response[management.DescribeResponse]{
 		Status: "success",
 		Response: management.DescribeResponse{
 			Config: socketConfig,
 		},
 	}
+	if r.URL.Query().Get("effective") == "true" {
+		response.Response.Config = socketConfig.EffectiveConfigWithBase(h.proxyHandler.baseConfig)
+	}
+	if r.URL.Query().Get("stats") == "true" {
+		response.Response.Stats = h.socketRuleStats(socketPath, socketConfig)
+	}
 
 	// Set headers and write response
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Error("Failed to encode response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+}
+
+// handleTestSocket runs a hypothetical request through a socket's rules and
+// reports the decision, without forwarding anything to the Docker daemon
+func (h *ManagementHandler) handleTestSocket(w http.ResponseWriter, r *http.Request) {
+	log := logging.GetLogger()
+
+	socketName := r.URL.Query().Get("socket")
+	if socketName == "" {
+		writeError(w, http.StatusBadRequest, "socket parameter is required")
+		return
+	}
+	socketPath := h.resolveSocketPath(r, socketName)
+
+	var testRequest management.TestRequest
+	if err := json.NewDecoder(r.Body).Decode(&testRequest); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid test request: %v", err))
+		return
+	}
+	if testRequest.Method == "" || testRequest.Path == "" {
+		writeError(w, http.StatusBadRequest, "test request needs a method and a path")
+		return
+	}
+
+	h.configMu.RLock()
+	socketConfig, exists := h.socketConfigs[socketPath]
+	h.configMu.RUnlock()
+
+	if !exists {
+		writeError(w, http.StatusNotFound, "socket not found")
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), dryRunContextKey, socketPath)
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(testRequest.Method), testRequest.Path, strings.NewReader(testRequest.Body))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid test request: %v", err))
+		return
+	}
+	for name, value := range testRequest.Headers {
+		req.Header.Set(name, value)
+	}
+
+	// Rate limits are checked against the socket's own traffic without
+	// taking any of its tokens
+	originalPath := req.URL.Path
+	decision, err := h.proxyHandler.evaluateRules(req, "", socketConfig)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("failed to evaluate rules: %v", err))
+		return
+	}
+
+	result := management.TestResponse{
+		Allowed: decision.allowed,
+		Status:  http.StatusOK,
+		Reason:  decision.reason,
+		Code:    decision.code,
+		Rule:    decision.rule,
+		Base:    decision.base,
+		Matched: decision.matched,
+	}
+	if result.Matched == nil {
+		result.Matched = []int{}
+	}
+	switch {
+	case decision.rateLimited:
+		result.Status = http.StatusTooManyRequests
+	case !decision.allowed && decision.statusCode != 0:
+		result.Status = decision.statusCode
+	case !decision.allowed:
+		result.Status = http.StatusForbidden
+	}
+	if decision.rewritten {
+		if req.URL.Path != originalPath {
+			result.Path = req.URL.Path
+		}
+		if body, err := io.ReadAll(req.Body); err == nil && string(body) != testRequest.Body {
+			result.Body = string(body)
+		}
+	}
+
+	log.Info("Tested request against socket", "path", socketPath, "method", req.Method, "request_path", testRequest.Path, "allowed", result.Allowed)
+
+	w.Header().Set("Content-Type", "application/json")
+	response := management.Response[management.TestResponse]{
+		Status:   "success",
+		Response: result,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Failed to encode response", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+}
+
+// handleHealth reports whether the daemon can serve requests, which it can
+// only do while the Docker daemon it proxies answers /_ping
+func (h *ManagementHandler) handleHealth(w http.ResponseWriter, r *http.Request) {
+	log := logging.GetLogger()
+
+	if err := pingUpstream(h.proxyHandler.upstream, healthCheckTimeout); err != nil {
+		log.Warn("Health check failed", "error", err, "upstream", h.proxyHandler.upstream.String())
+		writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("Docker daemon %s is not reachable: %v", h.proxyHandler.upstream, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := management.Response[management.HealthResponse]{
+		Status: "success",
+		Response: management.HealthResponse{
+			Status:   "healthy",
+			Upstream: h.proxyHandler.upstream.String(),
+		},
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Failed to encode response", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+}
+
+// handleExportSockets returns the name and config of every socket, sorted by
+// name, in a form that can be imported through the create route
+func (h *ManagementHandler) handleExportSockets(w http.ResponseWriter, r *http.Request) {
+	log := logging.GetLogger()
+
+	h.configMu.RLock()
+	sockets := make([]management.ExportedSocket, 0, len(h.socketConfigs))
+	for socketPath, socketConfig := range h.socketConfigs {
+		sockets = append(sockets, management.ExportedSocket{
+			Name:   filepath.Base(socketPath),
+			Config: socketConfig,
+		})
+	}
+	h.configMu.RUnlock()
+
+	sort.Slice(sockets, func(i, j int) bool {
+		return sockets[i].Name < sockets[j].Name
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	response := management.Response[management.ExportResponse]{
+		Status:   "success",
+		Response: management.ExportResponse{Sockets: sockets},
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Failed to encode response", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 }
@@ -457,6 +1424,95 @@ func (h *ManagementHandler) resolveSocketPath(r *http.Request, socketName string
 	return filepath.Join(management.DefaultSocketDir, socketName)
 }
 
+// ambiguousSocketError is returned for a socket name prefix that more than
+// one socket starts with
+type ambiguousSocketError struct {
+	prefix     string
+	candidates []string
+}
+
+func (e *ambiguousSocketError) Error() string {
+	return fmt.Sprintf("socket name %q is ambiguous, it matches %s", e.prefix, strings.Join(e.candidates, ", "))
+}
+
+// resolveSocketPrefix resolves a socket name like resolveSocketPath, and
+// otherwise as the unique prefix of the name of an existing socket in the same
+// directory. A name that matches nothing resolves to its path as is, so that
+// callers report the socket as not found.
+func (h *ManagementHandler) resolveSocketPrefix(r *http.Request, socketName string) (string, error) {
+	socketPath := h.resolveSocketPath(r, socketName)
+
+	h.configMu.RLock()
+	defer h.configMu.RUnlock()
+
+	if _, exists := h.socketConfigs[socketPath]; exists || strings.Contains(socketName, "/") {
+		return socketPath, nil
+	}
+
+	dir := filepath.Dir(socketPath)
+	var candidates []string
+	for path := range h.socketConfigs {
+		if filepath.Dir(path) == dir && strings.HasPrefix(filepath.Base(path), socketName) {
+			candidates = append(candidates, path)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return socketPath, nil
+	case 1:
+		return candidates[0], nil
+	}
+
+	names := make([]string, 0, len(candidates))
+	for _, path := range candidates {
+		names = append(names, filepath.Base(path))
+	}
+	sort.Strings(names)
+	return "", &ambiguousSocketError{prefix: socketName, candidates: names}
+}
+
+// handleLogLevel changes the daemon's log level, taken from ?level= or a
+// {"level": "..."} body
+func (h *ManagementHandler) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	log := logging.GetLogger()
+
+	levelName := r.URL.Query().Get("level")
+	if levelName == "" && r.Body != nil && r.ContentLength != 0 {
+		var request management.LogLevelResponse
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+			return
+		}
+		levelName = request.Level
+	}
+	if levelName == "" {
+		writeError(w, http.StatusBadRequest, "Level parameter is required")
+		return
+	}
+
+	level, err := logging.ParseLevel(levelName)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid log level %q", levelName))
+		return
+	}
+
+	logging.SetLevel(level)
+	log.Info("Log level changed", "level", level.String())
+
+	w.Header().Set("Content-Type", "application/json")
+	response := management.Response[management.LogLevelResponse]{
+		Status: "success",
+		Response: management.LogLevelResponse{
+			Level: level.String(),
+		},
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Failed to encode response", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+}
+
 // cleanSockets removes all sockets
 func (h *ManagementHandler) cleanSockets(w http.ResponseWriter, r *http.Request) {
 	log := logging.GetLogger()
@@ -466,10 +1522,15 @@ func (h *ManagementHandler) cleanSockets(w http.ResponseWriter, r *http.Request)
 	srv, ok := r.Context().Value(serverContextKey).(*Server)
 	if !ok {
 		log.Error("Server not found in context")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
+	// Hold the create lock across listing and deleting, so that a socket
+	// created meanwhile is neither missed nor left half deleted
+	h.createMu.Lock()
+	defer h.createMu.Unlock()
+
 	// Get the list of sockets
 	h.configMu.RLock()
 	sockets := make([]string, 0, len(h.socketConfigs))
@@ -478,10 +1539,12 @@ func (h *ManagementHandler) cleanSockets(w http.ResponseWriter, r *http.Request)
 	}
 	h.configMu.RUnlock()
 
-	// Delete each socket
+	// Delete each socket, all of them share the one grace period
+	ctx, cancel := drainContext(srv)
+	defer cancel()
 	var errs []string
 	for _, socket := range sockets {
-		if err := h.deleteSocket(socket, srv); err != nil {
+		if err := h.deleteSocket(ctx, socket, srv); err != nil {
 			log.Error("Failed to delete socket", "socket", socket, "error", err)
 			errs = append(errs, fmt.Sprintf("%s: %v", socket, err))
 		}
@@ -489,26 +1552,20 @@ func (h *ManagementHandler) cleanSockets(w http.ResponseWriter, r *http.Request)
 
 	// Return the result
 	if len(errs) > 0 {
-		w.WriteHeader(http.StatusInternalServerError)
-		if err := json.NewEncoder(w).Encode(map[string]any{
-			"status":  "error",
-			"message": "Failed to delete some sockets",
-			"errors":  errs,
-		}); err != nil {
-			log.Error("Failed to encode error response", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
+		writeError(w, http.StatusInternalServerError, "Failed to delete some sockets: "+strings.Join(errs, "; "))
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(map[string]any{
-		"status":  "success",
-		"message": fmt.Sprintf("Deleted %d sockets", len(sockets)),
-	}); err != nil {
-		log.Error("Failed to encode success response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	w.Header().Set("Content-Type", "application/json")
+	response := management.Response[management.DeleteResponse]{
+		Status: "success",
+		Response: management.DeleteResponse{
+			Message: fmt.Sprintf("Deleted %d sockets", len(sockets)),
+		},
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Failed to encode response", "error", err)
+		writeError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 }
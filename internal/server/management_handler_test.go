@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -15,7 +17,10 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"docker-socket-proxy/internal/logging"
+	"docker-socket-proxy/internal/management"
 	"docker-socket-proxy/internal/proxy/config"
 	"docker-socket-proxy/internal/storage"
 )
@@ -263,7 +268,7 @@ func TestManagementHandler_DeleteSocket(t *testing.T) {
 			socketName: "nonexistent.sock",
 			useHeader:  false,
 			withServer: true,
-			wantStatus: http.StatusOK, // We don't return an error for nonexistent sockets
+			wantStatus: http.StatusNotFound,
 		},
 	}
 
@@ -414,6 +419,30 @@ func TestManagementHandler_ListSockets(t *testing.T) {
 		}
 	})
 
+	// A list must not wait behind a create in flight
+	t.Run("while a create holds the lock", func(t *testing.T) {
+		handler.createMu.Lock()
+		defer handler.createMu.Unlock()
+
+		done := make(chan int, 1)
+		go func() {
+			req := httptest.NewRequest("GET", "/socket/list", nil)
+			req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			done <- w.Code
+		}()
+
+		select {
+		case code := <-done:
+			if code != http.StatusOK {
+				t.Errorf("ServeHTTP() status = %v, want %v", code, http.StatusOK)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("list blocked on the create lock")
+		}
+	})
+
 	// Test without server context
 	t.Run("without server context", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/socket/list", nil)
@@ -421,17 +450,16 @@ func TestManagementHandler_ListSockets(t *testing.T) {
 
 		handler.ServeHTTP(w, req)
 
-		if w.Code != http.StatusOK {
-			t.Errorf("ServeHTTP() status = %v, want %v", w.Code, http.StatusOK)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("ServeHTTP() status = %v, want %v", w.Code, http.StatusInternalServerError)
 		}
 
-		var sockets []string
-		if err := json.NewDecoder(w.Body).Decode(&sockets); err != nil {
+		var response management.Response[management.ErrorResponse]
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
 			t.Errorf("Failed to decode response: %v", err)
 		}
-
-		if len(sockets) != 0 {
-			t.Errorf("Expected 0 sockets, got %d", len(sockets))
+		if response.Status != "error" {
+			t.Errorf("Expected status error, got %s", response.Status)
 		}
 	})
 }
@@ -506,7 +534,8 @@ func TestManagementHandler_DescribeSocket(t *testing.T) {
 			wantContent: `{
 				"status": "error",
 				"response": {
-					"error": "socket parameter is required"
+					"error": "socket parameter is required",
+					"code": 400
 				}
 			}`,
 		},
@@ -517,7 +546,8 @@ func TestManagementHandler_DescribeSocket(t *testing.T) {
 			wantContent: `{
 				"status": "error",
 				"response": {
-					"error": "socket not found"
+					"error": "socket not found",
+					"code": 404
 				}
 			}`,
 		},
@@ -664,6 +694,171 @@ func TestManagementHandler_ResolveSocketPath(t *testing.T) {
 	}
 }
 
+func TestManagementHandler_ResolveSocketPrefix(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	allow := &config.SocketConfig{Rules: []config.Rule{{Match: config.Match{Path: "/_ping"}, Actions: []config.Action{{Action: "allow"}}}}}
+	configs := map[string]*config.SocketConfig{
+		filepath.Join(tmpDir, "3f2a9c1e.sock"): allow,
+		filepath.Join(tmpDir, "3f7b0d42.sock"): allow,
+		filepath.Join(tmpDir, "ci.sock"):       allow,
+		filepath.Join(tmpDir, "ci.sock.old"):   allow,
+	}
+	store := storage.NewFileStore(tmpDir)
+	srv := &Server{
+		socketDir:     tmpDir,
+		store:         store,
+		socketConfigs: configs,
+		proxyServers:  make(map[string]*http.Server),
+	}
+	handler := NewManagementHandler("/tmp/docker.sock", configs, &sync.RWMutex{}, store)
+
+	tests := []struct {
+		name       string
+		socketName string
+		want       string
+		wantErr    string
+	}{
+		{name: "exact name", socketName: "ci.sock", want: filepath.Join(tmpDir, "ci.sock")},
+		{name: "unique prefix", socketName: "3f2", want: filepath.Join(tmpDir, "3f2a9c1e.sock")},
+		{name: "ambiguous prefix", socketName: "3f", wantErr: `socket name "3f" is ambiguous, it matches 3f2a9c1e.sock, 3f7b0d42.sock`},
+		{name: "no match", socketName: "nope", want: filepath.Join(tmpDir, "nope")},
+		{name: "full path is not a prefix", socketName: filepath.Join(tmpDir, "3f2"), want: filepath.Join(tmpDir, "3f2")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+
+			got, err := handler.resolveSocketPrefix(req, tt.socketName)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("resolveSocketPrefix() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveSocketPrefix() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveSocketPrefix() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// An ambiguous prefix is a conflict for describe and delete alike
+	for _, request := range []struct{ method, path string }{
+		{"GET", "/socket/describe?socket=3f"},
+		{"DELETE", "/socket/delete?socket=3f"},
+	} {
+		req := httptest.NewRequest(request.method, request.path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusConflict {
+			t.Errorf("%s %s status = %d, want %d", request.method, request.path, rr.Code, http.StatusConflict)
+		}
+	}
+	if len(configs) != 4 {
+		t.Errorf("sockets left = %d, want 4", len(configs))
+	}
+}
+
+func TestManagementHandler_TCPSocket(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// Find a free port to listen on
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := probe.Addr().String()
+	if err := probe.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store := storage.NewFileStore(tmpDir)
+	configs := make(map[string]*config.SocketConfig)
+	srv := &Server{
+		socketDir:     tmpDir,
+		store:         store,
+		socketConfigs: configs,
+		proxyServers:  make(map[string]*http.Server),
+	}
+	handler := NewManagementHandler(upstream, configs, &sync.RWMutex{}, store)
+
+	socketPath := filepath.Join(tmpDir, "remote")
+	cfg := &config.SocketConfig{
+		Config: config.ConfigSet{Listen: "tcp://" + address, DefaultAction: config.DefaultActionDeny},
+		Rules:  []config.Rule{{Match: config.Match{Path: "/_ping", Method: "GET"}, Actions: []config.Action{{Action: "allow"}}}},
+	}
+	if err := handler.createSocket(srv, socketPath, cfg); err != nil {
+		t.Fatalf("createSocket() error = %v", err)
+	}
+	defer srv.Stop()
+
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("a socket file was created for a TCP socket: %v", err)
+	}
+	for path, want := range map[string]int{"/_ping": http.StatusOK, "/containers/json": http.StatusForbidden} {
+		resp, err := http.Get("http://" + address + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		if err := resp.Body.Close(); err != nil {
+			t.Errorf("Failed to close response body: %v", err)
+		}
+		if resp.StatusCode != want {
+			t.Errorf("GET %s status = %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+
+	entry := handler.socketEntry(socketPath, cfg)
+	if entry.Type != "tcp" || entry.Address != address {
+		t.Errorf("socketEntry() = %s %s, want tcp %s", entry.Type, entry.Address, address)
+	}
+
+	// The listener cannot move without recreating the socket
+	moved := *cfg
+	moved.Config.Listen = "tcp://127.0.0.1:1"
+	if err := handler.updateSocket(socketPath, &moved); !errors.Is(err, errListenChanged) {
+		t.Errorf("updateSocket() error = %v, want %v", err, errListenChanged)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := handler.deleteSocket(ctx, socketPath, srv); err != nil {
+		t.Fatalf("deleteSocket() error = %v", err)
+	}
+	if conn, err := net.Dial("tcp", address); err == nil {
+		t.Error("the TCP listener is still open after the socket was deleted")
+		if err := conn.Close(); err != nil {
+			t.Errorf("Failed to close connection: %v", err)
+		}
+	}
+}
+
 func TestManagementHandler_ValidateAndDecodeConfig(t *testing.T) {
 	handler := NewManagementHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{}, nil)
 
@@ -687,6 +882,26 @@ func TestManagementHandler_ValidateAndDecodeConfig(t *testing.T) {
 			body:    `{"rules":`,
 			wantErr: true,
 		},
+		{
+			name:    "name only",
+			body:    `{"name":"ci"}`,
+			wantErr: false,
+		},
+		{
+			name:    "malformed path regex",
+			body:    `{"rules":[{"match":{"path":"/containers/(json"},"actions":[{"action":"allow"}]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "malformed method regex",
+			body:    `{"rules":[{"match":{"path":"/test","method":"GE[T"},"actions":[{"action":"allow"}]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "malformed contains regex",
+			body:    `{"rules":[{"match":{"path":"/containers/create","contains":{"Image":"nginx:*+"}},"actions":[{"action":"deny"}]}]}`,
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -712,6 +927,73 @@ func TestManagementHandler_ValidateAndDecodeConfig(t *testing.T) {
 	}
 }
 
+func TestManagementHandler_ConfigContentTypes(t *testing.T) {
+	handler := NewManagementHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{}, nil)
+
+	yamlBody := `name: ci
+config:
+  default_action: deny
+rules:
+  - match:
+      path: "/v1.*/containers/create"
+      contains:
+        HostConfig:
+          Memory: 0
+    actions:
+      - action: allow
+`
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+		wantName    string
+	}{
+		{name: "json", contentType: "application/json", body: `{"name":"ci","rules":[{"match":{"path":"/v1.*/containers/create","contains":{"HostConfig":{"Memory":0}}},"actions":[{"action":"allow"}]}]}`, wantName: "ci"},
+		{name: "json with charset", contentType: "application/json; charset=utf-8", body: `{"name":"ci","rules":[{"match":{"path":"/v1.*/containers/create","contains":{"HostConfig":{"Memory":0}}},"actions":[{"action":"allow"}]}]}`, wantName: "ci"},
+		{name: "json without content type", body: `{"name":"ci","rules":[{"match":{"path":"/v1.*/containers/create","contains":{"HostConfig":{"Memory":0}}},"actions":[{"action":"allow"}]}]}`, wantName: "ci"},
+		{name: "yaml", contentType: "application/yaml", body: yamlBody, wantName: "ci"},
+		{name: "x-yaml", contentType: "application/x-yaml", body: yamlBody, wantName: "ci"},
+		{name: "invalid yaml", contentType: "application/yaml", body: "rules: [", wantStatus: http.StatusBadRequest},
+		{name: "unsupported content type", contentType: "text/plain", body: yamlBody, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "malformed content type", contentType: "application/", body: yamlBody, wantStatus: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/socket/create", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			cfg, name, err := handler.decodeCreateRequest(req)
+			if tt.wantStatus != 0 {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if got := configBodyStatus(err); got != tt.wantStatus {
+					t.Errorf("configBodyStatus() = %d, want %d", got, tt.wantStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeCreateRequest() error = %v", err)
+			}
+			if name != tt.wantName {
+				t.Errorf("name = %q, want %q", name, tt.wantName)
+			}
+
+			// Numbers decode the same way whatever the body format, so
+			// they compare equal to the numbers in request bodies
+			hostConfig, _ := cfg.Rules[0].Match.Contains["HostConfig"].(map[string]any)
+			if memory, ok := hostConfig["Memory"].(float64); !ok || memory != 0 {
+				t.Errorf("Memory = %#v, want float64 0", hostConfig["Memory"])
+			}
+		})
+	}
+}
+
 func TestManagementHandler(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
 	if err != nil {
@@ -856,3 +1138,1604 @@ func createTestConfig() *config.SocketConfig {
 		},
 	}
 }
+
+func TestManagementHandler_ListSocketsDetail(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	configs := make(map[string]*config.SocketConfig)
+	store := storage.NewFileStore(tmpDir)
+	srv := &Server{
+		socketDir:     tmpDir,
+		store:         store,
+		socketConfigs: configs,
+		proxyServers:  make(map[string]*http.Server),
+	}
+
+	socketPath1 := filepath.Join(tmpDir, "test1.sock")
+	socketPath2 := filepath.Join(tmpDir, "test2.sock")
+	configs[socketPath1] = createTestConfig()
+	configs[socketPath2] = &config.SocketConfig{}
+
+	handler := NewManagementHandler("/tmp/docker.sock", configs, &sync.RWMutex{}, store)
+	handler.proxyHandler.registerSocket(socketPath1)
+	handler.proxyHandler.registerSocket(socketPath2)
+	handler.proxyHandler.recordDecision(socketPath1, ruleDecision{allowed: true})
+
+	req := httptest.NewRequest("GET", "/socket/list?detail=true", nil)
+	req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("ServeHTTP() status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	var response management.Response[management.ListResponse]
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	details := response.Response.Details
+	if len(details) != 2 {
+		t.Fatalf("Expected 2 socket details, got %d", len(details))
+	}
+
+	if details[0].Name != "test1.sock" || details[0].Rules != 1 {
+		t.Errorf("Unexpected detail for test1.sock: %+v", details[0])
+	}
+	if details[0].CreatedAt.IsZero() {
+		t.Error("Expected created_at to be populated")
+	}
+	if details[0].LastDecision == nil {
+		t.Error("Expected last_decision to be populated for a socket that made a decision")
+	}
+
+	if details[1].Name != "test2.sock" || details[1].Rules != 0 {
+		t.Errorf("Unexpected detail for test2.sock: %+v", details[1])
+	}
+	if details[1].LastDecision != nil {
+		t.Errorf("Expected no last_decision for an unused socket, got %v", details[1].LastDecision)
+	}
+
+	// Without the detail parameter only names are returned
+	req = httptest.NewRequest("GET", "/socket/list", nil)
+	req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	response = management.Response[management.ListResponse]{}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Response.Details) != 0 {
+		t.Errorf("Expected no details without detail parameter, got %d", len(response.Response.Details))
+	}
+}
+
+func TestManagementHandler_CreateSocketCheckUpstream(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	reachable := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_ping" {
+			t.Errorf("Expected /_ping, got %s", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		upstream   string
+		query      string
+		wantStatus int
+	}{
+		{
+			name:       "reachable upstream",
+			upstream:   reachable,
+			query:      "?check_upstream=true",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "unreachable upstream",
+			upstream:   filepath.Join(tmpDir, "missing.sock"),
+			query:      "?check_upstream=true",
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:       "unreachable upstream without check",
+			upstream:   filepath.Join(tmpDir, "missing.sock"),
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewFileStore(tmpDir + "/")
+			configs := make(map[string]*config.SocketConfig)
+			mockServer := &Server{
+				socketDir:     tmpDir,
+				store:         store,
+				socketConfigs: configs,
+				proxyServers:  make(map[string]*http.Server),
+			}
+			handler := NewManagementHandler(tt.upstream, configs, &sync.RWMutex{}, store)
+
+			configJSON, err := json.Marshal(createTestConfig())
+			if err != nil {
+				t.Fatalf("Failed to marshal config: %v", err)
+			}
+
+			req := httptest.NewRequest("POST", "/socket/create"+tt.query, bytes.NewBuffer(configJSON))
+			req.Header.Set("Content-Type", "application/json")
+			req = req.WithContext(context.WithValue(req.Context(), serverContextKey, mockServer))
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				if !strings.Contains(w.Body.String(), "not reachable") {
+					t.Errorf("Expected a clear upstream error, got %q", w.Body.String())
+				}
+				if len(configs) != 0 {
+					t.Errorf("Expected no socket to be created, got %d", len(configs))
+				}
+			}
+
+			mockServer.configMu.Lock()
+			for _, server := range mockServer.proxyServers {
+				if err := server.Close(); err != nil {
+					t.Errorf("Failed to close proxy server: %v", err)
+				}
+			}
+			mockServer.configMu.Unlock()
+		})
+	}
+}
+
+func TestManagementHandler_BasePath(t *testing.T) {
+	configs := make(map[string]*config.SocketConfig)
+	handler := newManagementHandler("/tmp/docker.sock", configs, &sync.RWMutex{}, storage.NewFileStore("/tmp/"), "/dsp/")
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{name: "list under prefix", method: "GET", path: "/dsp/socket/list", wantStatus: http.StatusOK},
+		{name: "describe under prefix", method: "GET", path: "/dsp/socket/describe", wantStatus: http.StatusBadRequest},
+		{name: "list without prefix", method: "GET", path: "/socket/list", wantStatus: http.StatusNotFound},
+		{name: "create without prefix", method: "POST", path: "/socket/create", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req = req.WithContext(context.WithValue(req.Context(), serverContextKey, &Server{}))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.wantStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestManagementHandler_Token(t *testing.T) {
+	configs := make(map[string]*config.SocketConfig)
+	handler := newManagementHandler("/tmp/docker.sock", configs, &sync.RWMutex{}, storage.NewFileStore("/tmp/"), "/dsp")
+	handler.token = "s3cret"
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		wantAllowed   bool
+	}{
+		{name: "matching token", path: "/dsp/socket/list", authorization: "Bearer s3cret", wantAllowed: true},
+		{name: "no token", path: "/dsp/socket/list", wantAllowed: false},
+		{name: "wrong token", path: "/dsp/socket/list", authorization: "Bearer s3cre", wantAllowed: false},
+		{name: "token with another scheme", path: "/dsp/socket/list", authorization: "Basic s3cret", wantAllowed: false},
+		{name: "unknown route without token", path: "/dsp/socket/unknown", wantAllowed: false},
+		{name: "health without token", path: "/dsp/health", wantAllowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if allowed := w.Code != http.StatusUnauthorized; allowed != tt.wantAllowed {
+				t.Errorf("GET %s: got status %d, want allowed %v", tt.path, w.Code, tt.wantAllowed)
+			}
+			if !tt.wantAllowed && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected a WWW-Authenticate header on a rejected request")
+			}
+		})
+	}
+}
+
+func TestManagementHandler_ConcurrentCreateSamePath(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	store := storage.NewFileStore(tmpDir + "/")
+	configs := make(map[string]*config.SocketConfig)
+	mockServer := &Server{
+		socketDir:     tmpDir,
+		store:         store,
+		socketConfigs: configs,
+		proxyServers:  make(map[string]*http.Server),
+	}
+	handler := NewManagementHandler("/tmp/docker.sock", configs, &mockServer.configMu, store)
+	socketPath := filepath.Join(tmpDir, "shared.sock")
+
+	const attempts = 10
+	errs := make([]error, attempts)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = handler.createSocket(mockServer, socketPath, createTestConfig())
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, errSocketExists):
+			t.Errorf("Expected errSocketExists, got %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("Expected exactly one create to succeed, got %d", succeeded)
+	}
+
+	mockServer.configMu.Lock()
+	for _, server := range mockServer.proxyServers {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close proxy server: %v", err)
+		}
+	}
+	mockServer.configMu.Unlock()
+}
+
+func TestManagementHandler_ListSocketsSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		sockets []string
+	}{
+		{name: "zero sockets", sockets: nil},
+		{name: "one socket", sockets: []string{"one.sock"}},
+		{name: "many sockets", sockets: []string{"c.sock", "a.sock", "b.sock"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configs := make(map[string]*config.SocketConfig)
+			for _, name := range tt.sockets {
+				configs[filepath.Join("/tmp", name)] = createTestConfig()
+			}
+			srv := &Server{socketDir: "/tmp", socketConfigs: configs}
+			handler := NewManagementHandler("/tmp/docker.sock", configs, &sync.RWMutex{}, storage.NewFileStore("/tmp/"))
+
+			req := httptest.NewRequest("GET", "/socket/list", nil)
+			req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+
+			var raw struct {
+				Response struct {
+					SchemaVersion int              `json:"schema_version"`
+					Entries       []map[string]any `json:"entries"`
+				} `json:"response"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+
+			if raw.Response.SchemaVersion != management.ListSchemaVersion {
+				t.Errorf("schema_version = %d, want %d", raw.Response.SchemaVersion, management.ListSchemaVersion)
+			}
+			if len(raw.Response.Entries) != len(tt.sockets) {
+				t.Fatalf("Expected %d entries, got %d", len(tt.sockets), len(raw.Response.Entries))
+			}
+
+			prev := ""
+			for _, entry := range raw.Response.Entries {
+				for _, field := range []string{"name", "type", "address", "rules", "created_at"} {
+					if _, ok := entry[field]; !ok {
+						t.Errorf("Entry %v is missing field %q", entry, field)
+					}
+				}
+				name := entry["name"].(string)
+				if name < prev {
+					t.Errorf("Entries are not sorted by name: %q after %q", name, prev)
+				}
+				prev = name
+				if entry["type"] != "unix" || entry["address"] != filepath.Join("/tmp", name) {
+					t.Errorf("Unexpected type/address in entry %v", entry)
+				}
+				if entry["rules"] != float64(len(createTestConfig().Rules)) {
+					t.Errorf("Unexpected rule count in entry %v", entry)
+				}
+			}
+		})
+	}
+}
+
+func TestManagementHandler_CreateNamedSocket(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	store := storage.NewFileStore(tmpDir + "/")
+	configs := make(map[string]*config.SocketConfig)
+	mockServer := &Server{
+		socketDir:     tmpDir,
+		store:         store,
+		socketConfigs: configs,
+		proxyServers:  make(map[string]*http.Server),
+	}
+	handler := NewManagementHandler("/tmp/docker.sock", configs, &mockServer.configMu, store)
+	defer func() {
+		mockServer.configMu.Lock()
+		for _, server := range mockServer.proxyServers {
+			if err := server.Close(); err != nil {
+				t.Errorf("Failed to close proxy server: %v", err)
+			}
+		}
+		mockServer.configMu.Unlock()
+	}()
+
+	rules := `"rules":[{"match":{"path":"/.*"},"actions":[{"action":"allow"}]}]`
+
+	tests := []struct {
+		name       string
+		query      string
+		body       string
+		wantStatus int
+		wantSocket string
+		wantName   string
+	}{
+		{
+			name:       "name from query",
+			query:      "?name=ci",
+			body:       "{" + rules + "}",
+			wantStatus: http.StatusOK,
+			wantSocket: "ci.sock",
+			wantName:   "ci",
+		},
+		{
+			name:       "name from body",
+			body:       `{"name":"builds.sock",` + rules + "}",
+			wantStatus: http.StatusOK,
+			wantSocket: "builds.sock",
+			wantName:   "builds.sock",
+		},
+		{
+			name:       "query takes precedence over body",
+			query:      "?name=release",
+			body:       `{"name":"ignored",` + rules + "}",
+			wantStatus: http.StatusOK,
+			wantSocket: "release.sock",
+			wantName:   "release",
+		},
+		{
+			name:       "duplicate name",
+			query:      "?name=ci",
+			body:       "{" + rules + "}",
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "path separator",
+			query:      "?name=" + url.QueryEscape("../escape"),
+			body:       "{" + rules + "}",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "dot dot",
+			body:       `{"name":"..",` + rules + "}",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/socket/create"+tt.query, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req = req.WithContext(context.WithValue(req.Context(), serverContextKey, mockServer))
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantSocket == "" {
+				return
+			}
+
+			var response management.Response[management.CreateResponse]
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if want := filepath.Join(tmpDir, tt.wantSocket); response.Response.Socket != want {
+				t.Errorf("Expected socket %s, got %s", want, response.Response.Socket)
+			}
+
+			// The name is stored with the config to be restored with it
+			stored, err := store.LoadConfig(response.Response.Socket)
+			if err != nil {
+				t.Fatalf("Failed to load stored config: %v", err)
+			}
+			if stored.Name != tt.wantName {
+				t.Errorf("stored name = %q, want %q", stored.Name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestManagementHandler_LogLevel(t *testing.T) {
+	defer logging.SetLevel(slog.LevelInfo)
+	handler := NewManagementHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{}, nil)
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		wantLevel  slog.Level
+	}{
+		{name: "query parameter", method: "POST", target: "/loglevel?level=debug", wantStatus: http.StatusOK, wantLevel: slog.LevelDebug},
+		{name: "json body", method: "POST", target: "/loglevel", body: `{"level":"warn"}`, wantStatus: http.StatusOK, wantLevel: slog.LevelWarn},
+		{name: "invalid level", method: "POST", target: "/loglevel?level=loud", wantStatus: http.StatusBadRequest, wantLevel: slog.LevelWarn},
+		{name: "missing level", method: "POST", target: "/loglevel", wantStatus: http.StatusBadRequest, wantLevel: slog.LevelWarn},
+		{name: "wrong method", method: "GET", target: "/loglevel?level=info", wantStatus: http.StatusMethodNotAllowed, wantLevel: slog.LevelWarn},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if logging.Level() != tt.wantLevel {
+				t.Errorf("Level() = %v, want %v", logging.Level(), tt.wantLevel)
+			}
+		})
+	}
+}
+
+func TestManagementHandler_DescribeSocketEffective(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	socketPath := filepath.Join(tmpDir, "test.sock")
+	cfg := &config.SocketConfig{
+		Config: config.ConfigSet{PropagateSocket: socketPath},
+		Rules: []config.Rule{
+			{Match: config.Match{Path: "/containers/json"}, Actions: []config.Action{{Action: "allow"}}},
+			{Match: config.Match{Path: "/.*"}, Actions: []config.Action{{Action: "deny", Reason: "first"}}, Priority: 1},
+		},
+	}
+	configs := map[string]*config.SocketConfig{socketPath: cfg}
+	store := storage.NewFileStore(tmpDir + "/")
+	srv := &Server{socketDir: tmpDir, store: store, socketConfigs: configs, proxyServers: make(map[string]*http.Server)}
+	handler := NewManagementHandler("/tmp/docker.sock", configs, &sync.RWMutex{}, store)
+
+	tests := []struct {
+		name        string
+		query       string
+		wantPaths   []string
+		wantDefault string
+	}{
+		{name: "stored", query: "socket=test.sock", wantPaths: []string{"/containers/json", "/.*"}},
+		{
+			name:        "effective",
+			query:       "socket=test.sock&effective=true",
+			wantPaths:   []string{"/v1.*/containers/create", "/.*", "/containers/json"},
+			wantDefault: config.DefaultActionAllow,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/socket/describe?"+tt.query, nil)
+			req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("ServeHTTP() status = %v, want %v: %s", w.Code, http.StatusOK, w.Body.String())
+			}
+
+			var response management.Response[struct {
+				Config config.SocketConfig `json:"config"`
+			}]
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+
+			got := response.Response.Config
+			var paths []string
+			for _, rule := range got.Rules {
+				paths = append(paths, rule.Match.Path)
+			}
+			if !reflect.DeepEqual(paths, tt.wantPaths) {
+				t.Errorf("rule paths = %v, want %v", paths, tt.wantPaths)
+			}
+			if got.Config.DefaultAction != tt.wantDefault {
+				t.Errorf("default_action = %q, want %q", got.Config.DefaultAction, tt.wantDefault)
+			}
+		})
+	}
+}
+
+func TestManagementHandler_DescribeSocketStats(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	socketPath := filepath.Join(tmpDir, "test.sock")
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{
+				Match:   config.Match{Path: "/containers", Method: "GET"},
+				Actions: []config.Action{{Action: "allow"}},
+			},
+			{
+				Match:   config.Match{Path: "/.*"},
+				Actions: []config.Action{{Action: "deny", Reason: "default"}},
+			},
+		},
+	}
+	configs := map[string]*config.SocketConfig{socketPath: cfg}
+	store := storage.NewFileStore(tmpDir + "/")
+	srv := &Server{
+		socketDir:     tmpDir,
+		store:         store,
+		socketConfigs: configs,
+		proxyServers:  make(map[string]*http.Server),
+	}
+
+	handler := NewManagementHandler("/tmp/docker.sock", configs, &sync.RWMutex{}, store)
+	handler.proxyHandler.registerSocket(socketPath)
+	handler.proxyHandler.recordRuleHits(socketPath, cfg, []int{1})
+	handler.proxyHandler.recordRuleHits(socketPath, cfg, []int{1})
+
+	tests := []struct {
+		name      string
+		query     string
+		wantStats bool
+	}{
+		{name: "without stats", query: "socket=test.sock"},
+		{name: "with stats", query: "socket=test.sock&stats=true", wantStats: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/socket/describe?"+tt.query, nil)
+			req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("ServeHTTP() status = %v, want %v", w.Code, http.StatusOK)
+			}
+
+			var response management.Response[management.DescribeResponse]
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+
+			stats := response.Response.Stats
+			if !tt.wantStats {
+				if stats != nil {
+					t.Errorf("Expected no stats, got %+v", stats)
+				}
+				return
+			}
+			if stats == nil || len(stats.Rules) != 2 {
+				t.Fatalf("Expected stats for 2 rules, got %+v", stats)
+			}
+			if stats.Since.IsZero() {
+				t.Error("Expected since to be populated")
+			}
+			want := []management.RuleStat{
+				{Index: 0, Path: "/containers", Method: "GET", Hits: 0},
+				{Index: 1, Path: "/.*", Hits: 2},
+			}
+			for i := range want {
+				if stats.Rules[i] != want[i] {
+					t.Errorf("rule %d = %+v, want %+v", i, stats.Rules[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestManagementHandler_RenameSocket(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	store := storage.NewFileStore(tmpDir + "/")
+	configs := make(map[string]*config.SocketConfig)
+	mockServer := &Server{
+		socketDir:     tmpDir,
+		store:         store,
+		socketConfigs: configs,
+		proxyServers:  make(map[string]*http.Server),
+	}
+	handler := NewManagementHandler(upstream, configs, &mockServer.configMu, store)
+	defer func() {
+		mockServer.configMu.Lock()
+		for _, server := range mockServer.proxyServers {
+			if err := server.Close(); err != nil {
+				t.Errorf("Failed to close proxy server: %v", err)
+			}
+		}
+		mockServer.configMu.Unlock()
+	}()
+
+	allowAll := func(name string) *config.SocketConfig {
+		return &config.SocketConfig{
+			Name:  name,
+			Rules: []config.Rule{{Match: config.Match{Path: "/.*"}, Actions: []config.Action{{Action: "allow"}}}},
+		}
+	}
+	socketPath := filepath.Join(tmpDir, "ci.sock")
+	if err := handler.createSocket(mockServer, socketPath, allowAll("ci")); err != nil {
+		t.Fatalf("createSocket() error = %v", err)
+	}
+	if err := handler.createSocket(mockServer, filepath.Join(tmpDir, "other.sock"), allowAll("other")); err != nil {
+		t.Fatalf("createSocket() error = %v", err)
+	}
+
+	rename := func(query string) (int, management.RenameResponse) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/socket/rename?"+query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), serverContextKey, mockServer))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var response management.Response[management.RenameResponse]
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return w.Code, response.Response
+	}
+	get := func(path string) int {
+		t.Helper()
+		client := &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+					return net.Dial("unix", path)
+				},
+			},
+		}
+		resp, err := client.Get("http://docker/v1.42/info")
+		if err != nil {
+			t.Fatalf("request through proxy socket failed: %v", err)
+		}
+		if err := resp.Body.Close(); err != nil {
+			t.Errorf("Failed to close response body: %v", err)
+		}
+		return resp.StatusCode
+	}
+	storedName := func(path string) string {
+		t.Helper()
+		stored, err := store.LoadConfig(path)
+		if err != nil {
+			t.Fatalf("LoadConfig(%s) error = %v", path, err)
+		}
+		return stored.Name
+	}
+
+	// Renaming keeps the socket file
+	status, response := rename("socket=ci&name=build")
+	if status != http.StatusOK {
+		t.Fatalf("rename status = %d, want %d", status, http.StatusOK)
+	}
+	if response.Socket != socketPath || response.Name != "build" {
+		t.Errorf("rename response = %+v", response)
+	}
+	if configs[socketPath].Name != "build" || storedName(socketPath) != "build" {
+		t.Errorf("name = %q, stored %q, want build", configs[socketPath].Name, storedName(socketPath))
+	}
+	if got := get(socketPath); got != http.StatusOK {
+		t.Errorf("request after rename = %d, want %d", got, http.StatusOK)
+	}
+
+	if status, _ := rename("socket=ci&name=other"); status != http.StatusConflict {
+		t.Errorf("rename to a taken name = %d, want %d", status, http.StatusConflict)
+	}
+	if status, _ := rename("socket=ci&name=other.sock&move=true"); status != http.StatusConflict {
+		t.Errorf("move onto a taken file = %d, want %d", status, http.StatusConflict)
+	}
+	if status, _ := rename("socket=missing.sock&name=new"); status != http.StatusNotFound {
+		t.Errorf("rename of a missing socket = %d, want %d", status, http.StatusNotFound)
+	}
+	if status, _ := rename("socket=ci&name=../escape"); status != http.StatusBadRequest {
+		t.Errorf("rename to a path = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	// Moving serves the socket from a file named after it
+	status, response = rename("socket=ci&name=deploy&move=true")
+	if status != http.StatusOK {
+		t.Fatalf("move status = %d, want %d", status, http.StatusOK)
+	}
+	newPath := filepath.Join(tmpDir, "deploy.sock")
+	if response.Socket != newPath {
+		t.Errorf("move response socket = %q, want %q", response.Socket, newPath)
+	}
+	if _, ok := configs[socketPath]; ok {
+		t.Error("config of the old path is still present")
+	}
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("old socket file still exists: %v", err)
+	}
+	if _, err := store.LoadConfig(socketPath); err == nil {
+		t.Error("stored config of the old path is still present")
+	}
+	if storedName(newPath) != "deploy" {
+		t.Errorf("stored name = %q, want deploy", storedName(newPath))
+	}
+	if got := get(newPath); got != http.StatusOK {
+		t.Errorf("request after move = %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestManagementHandler_UpdateSocket(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	store := storage.NewFileStore(tmpDir + "/")
+	configs := make(map[string]*config.SocketConfig)
+	mockServer := &Server{
+		socketDir:     tmpDir,
+		store:         store,
+		socketConfigs: configs,
+		proxyServers:  make(map[string]*http.Server),
+	}
+	handler := NewManagementHandler(upstream, configs, &mockServer.configMu, store)
+	defer func() {
+		mockServer.configMu.Lock()
+		for _, server := range mockServer.proxyServers {
+			if err := server.Close(); err != nil {
+				t.Errorf("Failed to close proxy server: %v", err)
+			}
+		}
+		mockServer.configMu.Unlock()
+	}()
+
+	socketPath := filepath.Join(tmpDir, "ci.sock")
+	allowAll := &config.SocketConfig{
+		Rules: []config.Rule{{Match: config.Match{Path: "/.*"}, Actions: []config.Action{{Action: "allow"}}}},
+	}
+	if err := handler.createSocket(mockServer, socketPath, allowAll); err != nil {
+		t.Fatalf("createSocket() error = %v", err)
+	}
+	proxyServer := mockServer.proxyServers[socketPath]
+
+	// A client that keeps its connection open across the update
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", socketPath)
+			},
+		},
+	}
+	get := func() int {
+		t.Helper()
+		resp, err := client.Get("http://docker/v1.42/info")
+		if err != nil {
+			t.Fatalf("request through proxy socket failed: %v", err)
+		}
+		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+			t.Fatal(err)
+		}
+		if err := resp.Body.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+	if status := get(); status != http.StatusOK {
+		t.Fatalf("status before update = %d, want 200", status)
+	}
+
+	update := func(socket, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/socket/update?socket="+socket, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(context.WithValue(req.Context(), serverContextKey, mockServer))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name       string
+		socket     string
+		body       string
+		wantStatus int
+		wantRules  int
+	}{
+		{
+			name:       "invalid config keeps the old one",
+			socket:     "ci.sock",
+			body:       `{"rules":[{"match":{"path":"/.*"},"actions":[{"action":"deny"}]}]}`,
+			wantStatus: http.StatusBadRequest,
+			wantRules:  1,
+		},
+		{
+			name:       "unknown socket",
+			socket:     "other.sock",
+			body:       `{"rules":[{"match":{"path":"/.*"},"actions":[{"action":"allow"}]}]}`,
+			wantStatus: http.StatusNotFound,
+			wantRules:  1,
+		},
+		{
+			name:       "valid config is swapped in",
+			socket:     "ci.sock",
+			body:       `{"rules":[{"match":{"path":"/v1.*/version"},"actions":[{"action":"allow"}]},{"match":{"path":"/.*"},"actions":[{"action":"deny","reason":"read only"}]}]}`,
+			wantStatus: http.StatusOK,
+			wantRules:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := update(tt.socket, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			mockServer.configMu.RLock()
+			rules := len(configs[socketPath].Rules)
+			mockServer.configMu.RUnlock()
+			if rules != tt.wantRules {
+				t.Errorf("in-memory config has %d rules, want %d", rules, tt.wantRules)
+			}
+
+			saved, err := store.LoadConfig(socketPath)
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			if len(saved.Rules) != tt.wantRules {
+				t.Errorf("persisted config has %d rules, want %d", len(saved.Rules), tt.wantRules)
+			}
+		})
+	}
+
+	// The same server keeps serving, and the open connection sees the new rules
+	if mockServer.proxyServers[socketPath] != proxyServer {
+		t.Error("proxy server was replaced by the update")
+	}
+	if status := get(); status != http.StatusForbidden {
+		t.Errorf("status after update = %d, want 403", status)
+	}
+
+	// Only PUT is accepted
+	req := httptest.NewRequest("POST", "/socket/update?socket=ci.sock", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestManagementHandler_ResetStats(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	socketA := filepath.Join(tmpDir, "a.sock")
+	socketB := filepath.Join(tmpDir, "b.sock")
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{
+				Match:   config.Match{Path: "/.*"},
+				Actions: []config.Action{{Action: "allow"}},
+			},
+		},
+	}
+	configs := map[string]*config.SocketConfig{socketA: cfg, socketB: cfg}
+	store := storage.NewFileStore(tmpDir + "/")
+	srv := &Server{
+		socketDir:     tmpDir,
+		store:         store,
+		socketConfigs: configs,
+		proxyServers:  make(map[string]*http.Server),
+	}
+
+	handler := NewManagementHandler("/tmp/docker.sock", configs, &sync.RWMutex{}, store)
+	record := func(socketPath string) {
+		handler.proxyHandler.recordDecision(socketPath, ruleDecision{allowed: true, rewritten: true})
+		handler.proxyHandler.recordDecision(socketPath, ruleDecision{})
+		handler.proxyHandler.recordRuleHits(socketPath, cfg, []int{0})
+	}
+	// assertCounts checks every counter of a socket holds the same value
+	assertCounts := func(t *testing.T, socketPath string, want uint64) {
+		t.Helper()
+		stats := handler.proxyHandler.snapshotStats(socketPath)
+		hits, _ := handler.proxyHandler.snapshotRuleHits(socketPath, cfg)
+		if stats.Allowed != want || stats.Denied != want || stats.Rewritten != want || hits[0] != want {
+			t.Errorf("%s counters = allowed %d, denied %d, rewritten %d, hits %d, want all %d",
+				filepath.Base(socketPath), stats.Allowed, stats.Denied, stats.Rewritten, hits[0], want)
+		}
+	}
+	reset := func(t *testing.T, query string) (int, []string) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/socket/stats/reset"+query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			return w.Code, nil
+		}
+
+		var response management.Response[management.StatsResetResponse]
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return w.Code, response.Response.Sockets
+	}
+
+	record(socketA)
+	record(socketA)
+	record(socketB)
+
+	// Resetting one socket leaves the others alone
+	if code, sockets := reset(t, "?socket=a.sock"); code != http.StatusOK || len(sockets) != 1 || sockets[0] != socketA {
+		t.Fatalf("reset a.sock = %d %v, want 200 [%s]", code, sockets, socketA)
+	}
+	assertCounts(t, socketA, 0)
+	assertCounts(t, socketB, 1)
+
+	// Counting resumes after a reset
+	record(socketA)
+	assertCounts(t, socketA, 1)
+
+	// Without a socket every socket is reset
+	if code, sockets := reset(t, ""); code != http.StatusOK || len(sockets) != 2 {
+		t.Fatalf("reset all = %d %v, want 200 with 2 sockets", code, sockets)
+	}
+	assertCounts(t, socketA, 0)
+	assertCounts(t, socketB, 0)
+
+	record(socketB)
+	assertCounts(t, socketB, 1)
+
+	if code, _ := reset(t, "?socket=missing.sock"); code != http.StatusNotFound {
+		t.Errorf("reset missing.sock = %d, want %d", code, http.StatusNotFound)
+	}
+
+	req := httptest.NewRequest("GET", "/socket/stats/reset", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET reset = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestManagementHandler_SocketStats(t *testing.T) {
+	socketA := "/tmp/docker-proxy/a.sock"
+	socketB := "/tmp/docker-proxy/b.sock"
+	cfg := &config.SocketConfig{Rules: []config.Rule{{Match: config.Match{Path: "/.*"}, Actions: []config.Action{{Action: "allow"}}}}}
+	configs := map[string]*config.SocketConfig{socketB: cfg, socketA: cfg}
+	srv := &Server{socketDir: "/tmp/docker-proxy", socketConfigs: configs}
+
+	handler := NewManagementHandler("/tmp/docker.sock", configs, &sync.RWMutex{}, nil)
+	handler.proxyHandler.recordDecision(socketA, ruleDecision{allowed: true, rewritten: true})
+	handler.proxyHandler.recordDecision(socketA, ruleDecision{allowed: true})
+	handler.proxyHandler.recordDecision(socketA, ruleDecision{})
+
+	tests := []struct {
+		name       string
+		method     string
+		query      string
+		wantStatus int
+		want       []management.SocketCounters
+	}{
+		{
+			name:       "every socket sorted by name",
+			method:     "GET",
+			wantStatus: http.StatusOK,
+			want: []management.SocketCounters{
+				{Name: "a.sock", Requests: 3, Allowed: 2, Denied: 1, Rewritten: 1},
+				{Name: "b.sock"},
+			},
+		},
+		{
+			name:       "a single socket",
+			method:     "GET",
+			query:      "?socket=b.sock",
+			wantStatus: http.StatusOK,
+			want:       []management.SocketCounters{{Name: "b.sock"}},
+		},
+		{name: "unknown socket", method: "GET", query: "?socket=missing.sock", wantStatus: http.StatusNotFound},
+		{name: "wrong method", method: "POST", wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/socket/stats"+tt.query, nil)
+			req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var response management.Response[management.StatsResponse]
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			got := response.Response.Sockets
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d sockets, want %d", len(got), len(tt.want))
+			}
+			for i, want := range tt.want {
+				// Only sockets that made a decision have a last request time
+				if (got[i].LastRequest != nil) != (want.Requests > 0) {
+					t.Errorf("%s last_request = %v, want set %v", want.Name, got[i].LastRequest, want.Requests > 0)
+				}
+				got[i].LastRequest = nil
+				if got[i] != want {
+					t.Errorf("socket %d = %+v, want %+v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestManagementHandler_TestSocket(t *testing.T) {
+	socketPath := "/tmp/docker-proxy/ci.sock"
+	cfg := &config.SocketConfig{
+		Config: config.ConfigSet{DefaultAction: config.DefaultActionDeny},
+		Rules: []config.Rule{
+			{
+				Match:   config.Match{Path: "/containers/create", Method: "POST", Contains: map[string]any{"HostConfig": map[string]any{"Privileged": true}}},
+				Actions: []config.Action{{Action: "deny", Reason: "no privileged containers"}},
+			},
+			{
+				Match:   config.Match{Path: "/containers/create", Method: "POST"},
+				Actions: []config.Action{{Action: "upsert", Update: map[string]any{"Labels": map[string]any{"proxied": "true"}}}, {Action: "allow"}},
+			},
+			{
+				Match:   config.Match{Path: "^/v1.42/old$"},
+				Actions: []config.Action{{Action: "rewrite-path", Pattern: "old", Replacement: "new"}, {Action: "allow"}},
+			},
+			{
+				Match:   config.Match{Path: "/_ping"},
+				Actions: []config.Action{{Action: "allow"}},
+			},
+		},
+	}
+	configs := map[string]*config.SocketConfig{socketPath: cfg}
+	srv := &Server{socketDir: "/tmp/docker-proxy", socketConfigs: configs}
+	handler := NewManagementHandler("/tmp/docker.sock", configs, &sync.RWMutex{}, nil)
+
+	tests := []struct {
+		name       string
+		query      string
+		body       string
+		wantStatus int
+		want       management.TestResponse
+	}{
+		{
+			name:       "denied by a rule",
+			query:      "?socket=ci.sock",
+			body:       `{"method":"POST","path":"/v1.42/containers/create","body":"{\"HostConfig\":{\"Privileged\":true}}"}`,
+			wantStatus: http.StatusOK,
+			want:       management.TestResponse{Status: http.StatusForbidden, Reason: "no privileged containers", Rule: 0, Matched: []int{0}},
+		},
+		{
+			name:       "allowed with a rewritten body",
+			query:      "?socket=ci.sock",
+			body:       `{"method":"post","path":"/v1.42/containers/create","body":"{\"Image\":\"alpine\"}"}`,
+			wantStatus: http.StatusOK,
+			want:       management.TestResponse{Allowed: true, Status: http.StatusOK, Rule: 1, Matched: []int{1}, Body: `{"Image":"alpine","Labels":{"proxied":"true"}}`},
+		},
+		{
+			name:       "allowed with a rewritten path",
+			query:      "?socket=ci.sock",
+			body:       `{"method":"GET","path":"/v1.42/old?all=1"}`,
+			wantStatus: http.StatusOK,
+			want:       management.TestResponse{Allowed: true, Status: http.StatusOK, Rule: 2, Matched: []int{2}, Path: "/v1.42/new"},
+		},
+		{
+			name:       "denied by default",
+			query:      "?socket=ci.sock",
+			body:       `{"method":"GET","path":"/v1.42/info"}`,
+			wantStatus: http.StatusOK,
+			want:       management.TestResponse{Status: http.StatusForbidden, Reason: "no matching allow rule", Rule: -1, Matched: []int{}},
+		},
+		{name: "missing socket parameter", body: `{"method":"GET","path":"/_ping"}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown socket", query: "?socket=missing.sock", body: `{"method":"GET","path":"/_ping"}`, wantStatus: http.StatusNotFound},
+		{name: "missing path", query: "?socket=ci.sock", body: `{"method":"GET"}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid JSON", query: "?socket=ci.sock", body: `{`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/socket/test"+tt.query, strings.NewReader(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var response management.Response[management.TestResponse]
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if !reflect.DeepEqual(response.Response, tt.want) {
+				t.Errorf("response = %+v, want %+v", response.Response, tt.want)
+			}
+		})
+	}
+}
+
+func TestManagementHandler_TestSocketRateLimit(t *testing.T) {
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	socketPath := "/tmp/docker-proxy/limited.sock"
+	configs := map[string]*config.SocketConfig{
+		socketPath: {Rules: []config.Rule{
+			{
+				Match:   config.Match{Path: "^/v1.42/images/json$"},
+				Actions: []config.Action{{Action: "ratelimit", Limit: 1, Window: "1h", Reason: "slow down"}, {Action: "allow"}},
+			},
+		}},
+	}
+	srv := &Server{socketDir: "/tmp/docker-proxy", socketConfigs: configs}
+	handler := NewManagementHandler(upstream, configs, &sync.RWMutex{}, nil)
+
+	dryRun := func(t *testing.T) management.TestResponse {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/socket/test?socket=limited.sock", strings.NewReader(`{"method":"GET","path":"/v1.42/images/json"}`))
+		req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		var response management.Response[management.TestResponse]
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return response.Response
+	}
+	proxy := func() int {
+		w := httptest.NewRecorder()
+		handler.proxyHandler.ServeHTTPWithSocket(w, httptest.NewRequest("GET", "/v1.42/images/json", nil), socketPath)
+		return w.Code
+	}
+
+	// Dry runs take none of the socket's tokens
+	for i := 0; i < 3; i++ {
+		if result := dryRun(t); !result.Allowed {
+			t.Fatalf("dry run %d denied: %+v", i, result)
+		}
+	}
+	if code := proxy(); code != http.StatusOK {
+		t.Fatalf("first request status = %d, want %d", code, http.StatusOK)
+	}
+
+	// Once the socket's own traffic used up the limit, dry runs see it
+	if result := dryRun(t); result.Allowed || result.Reason != "slow down" {
+		t.Errorf("dry run after the limit = %+v, want rate limited", result)
+	}
+	if code := proxy(); code != http.StatusTooManyRequests {
+		t.Errorf("second request status = %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestManagementHandler_SocketLogs(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	socketPath := filepath.Join(tmpDir, "ci.sock")
+	configs := map[string]*config.SocketConfig{socketPath: {}}
+	store := storage.NewFileStore(tmpDir + "/")
+	srv := &Server{
+		socketDir:     tmpDir,
+		store:         store,
+		socketConfigs: configs,
+		proxyServers:  make(map[string]*http.Server),
+	}
+
+	handler := NewManagementHandler("/tmp/docker.sock", configs, &sync.RWMutex{}, store)
+	logRequest := func(path string, allowed bool) {
+		req := httptest.NewRequest("GET", path, nil)
+		handler.proxyHandler.logDecision(req, socketPath, ruleDecision{allowed: allowed, reason: "because"})
+	}
+	logRequest("/v1.42/containers/json", true)
+	logRequest("/v1.42/images/json", false)
+	logRequest("/v1.42/volumes", true)
+
+	decode := func(t *testing.T, body io.Reader) []management.LogEntry {
+		t.Helper()
+		var entries []management.LogEntry
+		decoder := json.NewDecoder(body)
+		for {
+			var entry management.LogEntry
+			if err := decoder.Decode(&entry); err == io.EOF {
+				return entries
+			} else if err != nil {
+				t.Fatalf("Failed to decode entry: %v", err)
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantPaths  []string
+	}{
+		{name: "all entries", query: "?socket=ci.sock", wantStatus: http.StatusOK, wantPaths: []string{"/v1.42/containers/json", "/v1.42/images/json", "/v1.42/volumes"}},
+		{name: "tail", query: "?socket=ci.sock&tail=2", wantStatus: http.StatusOK, wantPaths: []string{"/v1.42/images/json", "/v1.42/volumes"}},
+		{name: "tail of zero", query: "?socket=ci.sock&tail=0", wantStatus: http.StatusOK},
+		{name: "invalid tail", query: "?socket=ci.sock&tail=-1", wantStatus: http.StatusBadRequest},
+		{name: "missing socket", query: "", wantStatus: http.StatusBadRequest},
+		{name: "unknown socket", query: "?socket=other.sock", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/socket/logs"+tt.query, nil)
+			req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			entries := decode(t, w.Body)
+			if len(entries) != len(tt.wantPaths) {
+				t.Fatalf("got %d entries, want %d", len(entries), len(tt.wantPaths))
+			}
+			for i, entry := range entries {
+				if entry.Path != tt.wantPaths[i] {
+					t.Errorf("entry %d path = %q, want %q", i, entry.Path, tt.wantPaths[i])
+				}
+			}
+			if len(entries) == 3 && (entries[1].Decision != "deny" || entries[1].Reason != "because" || entries[1].Method != "GET") {
+				t.Errorf("entry = %+v, want a GET denied because", entries[1])
+			}
+		})
+	}
+
+	t.Run("follow", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), serverContextKey, srv)))
+		}))
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/socket/logs?socket=ci.sock&tail=1&follow=true")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				t.Errorf("Failed to close response body: %v", err)
+			}
+		}()
+
+		decoder := json.NewDecoder(resp.Body)
+		var entry management.LogEntry
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatalf("Failed to decode tail entry: %v", err)
+		}
+		if entry.Path != "/v1.42/volumes" {
+			t.Errorf("tail entry path = %q, want /v1.42/volumes", entry.Path)
+		}
+
+		logRequest("/v1.42/networks", false)
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatalf("Failed to decode followed entry: %v", err)
+		}
+		if entry.Path != "/v1.42/networks" || entry.Decision != "deny" {
+			t.Errorf("followed entry = %+v, want a denied /v1.42/networks", entry)
+		}
+
+		// Deleting the socket ends the stream
+		handler.proxyHandler.forgetDecisionLog(socketPath)
+		if err := decoder.Decode(&entry); err != io.EOF {
+			t.Errorf("Decode() after delete error = %v, want EOF", err)
+		}
+	})
+}
+
+func TestManagementHandler_ErrorEnvelope(t *testing.T) {
+	handler := NewManagementHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{}, nil)
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		wantError  string
+	}{
+		{name: "wrong method", method: "GET", target: "/socket/create", wantStatus: http.StatusMethodNotAllowed, wantError: "Method not allowed"},
+		{name: "missing socket", method: "GET", target: "/socket/describe", wantStatus: http.StatusBadRequest, wantError: "socket parameter is required"},
+		{name: "unknown socket", method: "GET", target: "/socket/describe?socket=missing.sock", wantStatus: http.StatusNotFound, wantError: "socket not found"},
+		{name: "delete unknown socket", method: "DELETE", target: "/socket/delete?socket=missing.sock", wantStatus: http.StatusNotFound, wantError: "socket not found: missing.sock"},
+		{name: "update unknown socket", method: "PUT", target: "/socket/update?socket=missing.sock", body: `{"rules":[{"match":{"path":"/_ping"},"actions":[{"action":"allow"}]}]}`, wantStatus: http.StatusNotFound, wantError: "Failed to update socket: socket not found: missing.sock"},
+		{name: "rename unknown socket", method: "POST", target: "/socket/rename?socket=missing.sock&name=build", wantStatus: http.StatusNotFound, wantError: "Failed to rename socket: socket not found: missing.sock"},
+		{name: "test unknown socket", method: "POST", target: "/socket/test?socket=missing.sock", body: `{"method":"GET","path":"/_ping"}`, wantStatus: http.StatusNotFound, wantError: "socket not found"},
+		{name: "reset stats of unknown socket", method: "POST", target: "/socket/stats/reset?socket=missing.sock", wantStatus: http.StatusNotFound, wantError: "socket not found"},
+		{name: "logs of unknown socket", method: "GET", target: "/socket/logs?socket=missing.sock", wantStatus: http.StatusNotFound, wantError: "socket not found"},
+		{name: "list without a server", method: "GET", target: "/socket/list", wantStatus: http.StatusInternalServerError, wantError: "Internal server error"},
+		{name: "clean without a server", method: "POST", target: "/socket/clean", wantStatus: http.StatusInternalServerError, wantError: "Internal server error"},
+		{name: "invalid log level", method: "POST", target: "/loglevel?level=loud", wantStatus: http.StatusBadRequest, wantError: `invalid log level "loud"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var response management.Response[management.ErrorResponse]
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("body %q is not JSON: %v", w.Body.String(), err)
+			}
+			if response.Status != "error" || response.Response.Error != tt.wantError {
+				t.Errorf("response = %+v, want error %q", response, tt.wantError)
+			}
+			if response.Response.Code != tt.wantStatus {
+				t.Errorf("code = %d, want %d", response.Response.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestManagementHandler_ExportSockets(t *testing.T) {
+	configs := map[string]*config.SocketConfig{
+		"/tmp/b.sock": {
+			Config: config.ConfigSet{DefaultAction: config.DefaultActionDeny},
+			Rules: []config.Rule{
+				{Match: config.Match{Path: "^/_ping$"}, Actions: []config.Action{{Action: "allow"}}},
+			},
+		},
+		"/tmp/a.sock": {
+			Rules: []config.Rule{
+				{Match: config.Match{Path: "^/", Method: "^POST$"}, Actions: []config.Action{{Action: "deny", Reason: "read only"}}},
+			},
+		},
+	}
+	handler := NewManagementHandler("/tmp/docker.sock", configs, &sync.RWMutex{}, nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/socket/export", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	// Decode the configs as an importer would
+	var response management.Response[struct {
+		Sockets []struct {
+			Name   string              `json:"name"`
+			Config config.SocketConfig `json:"config"`
+		} `json:"sockets"`
+	}]
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode export: %v", err)
+	}
+
+	sockets := response.Response.Sockets
+	if len(sockets) != 2 || sockets[0].Name != "a.sock" || sockets[1].Name != "b.sock" {
+		t.Fatalf("exported sockets = %+v, want a.sock and b.sock in order", sockets)
+	}
+	if got := sockets[0].Config.Rules[0].Actions[0].Reason; got != "read only" {
+		t.Errorf("a.sock rule reason = %q, want %q", got, "read only")
+	}
+	if !sockets[1].Config.DeniesByDefault() {
+		t.Error("b.sock lost its default action")
+	}
+
+	// Only GET is allowed
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/socket/export", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestManagementHandler_Health(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	reachable := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_ping" {
+			t.Errorf("Expected /_ping, got %s", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	failing := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	tests := []struct {
+		name       string
+		upstream   string
+		method     string
+		wantStatus int
+	}{
+		{name: "reachable daemon", upstream: reachable, method: "GET", wantStatus: http.StatusOK},
+		{name: "head request", upstream: reachable, method: "HEAD", wantStatus: http.StatusOK},
+		{name: "daemon not listening", upstream: filepath.Join(tmpDir, "missing.sock"), method: "GET", wantStatus: http.StatusServiceUnavailable},
+		{name: "daemon failing its ping", upstream: failing, method: "GET", wantStatus: http.StatusServiceUnavailable},
+		{name: "wrong method", upstream: reachable, method: "POST", wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewManagementHandler(tt.upstream, make(map[string]*config.SocketConfig), &sync.RWMutex{}, nil)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, "/health", nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+
+			if tt.wantStatus == http.StatusServiceUnavailable {
+				var response management.Response[management.ErrorResponse]
+				if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+					t.Fatalf("failed to decode error: %v", err)
+				}
+				if !strings.Contains(response.Response.Error, "not reachable") {
+					t.Errorf("error = %q, want it to say the daemon is not reachable", response.Response.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestManagementHandler_HealthTimeout(t *testing.T) {
+	// A daemon that accepts connections but never answers
+	hung := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	handler := NewManagementHandler(hung, make(map[string]*config.SocketConfig), &sync.RWMutex{}, nil)
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if elapsed := time.Since(start); elapsed > healthCheckTimeout+time.Second {
+		t.Errorf("health check took %v, want it bounded by %v", elapsed, healthCheckTimeout)
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"docker-socket-proxy/internal/logging"
+)
+
+// WithManagementListen serves the management API on address instead of the
+// management socket. A unix:// address names another socket path, and a
+// tcp:// address is served over TLS with tlsConfig, which is required for it.
+func WithManagementListen(address string, tlsConfig *tls.Config) Option {
+	return func(s *Server) {
+		s.managementListen = address
+		s.managementTLS = tlsConfig
+	}
+}
+
+// ManagementTLSConfig builds the server TLS config for a TCP management
+// listener. Clients must present a certificate signed by the client CA.
+func ManagementTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" && clientCAFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both a management TLS certificate and key are required")
+	}
+	if clientCAFile == "" {
+		return nil, fmt.Errorf("a management TLS client CA is required to verify clients with")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load management TLS certificate: %w", err)
+	}
+
+	ca, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read management client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in management client CA %s", clientCAFile)
+	}
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// parseManagementListen resolves the management listen address, falling back
+// to the management socket when none is given
+func (s *Server) parseManagementListen() error {
+	if s.managementTLS != nil && !strings.HasPrefix(s.managementListen, "tcp://") {
+		return fmt.Errorf("management TLS is only used with a tcp:// management listen address")
+	}
+
+	switch {
+	case s.managementListen == "":
+		return nil
+	case strings.HasPrefix(s.managementListen, "unix://"):
+		s.managementSocket = strings.TrimPrefix(s.managementListen, "unix://")
+		s.managementListen = ""
+		if s.managementSocket == "" {
+			return fmt.Errorf("invalid management listen address %q, the socket path is missing", "unix://")
+		}
+		return nil
+	case strings.HasPrefix(s.managementListen, "tcp://"):
+		if s.managementTLS == nil {
+			return fmt.Errorf("management listen address %s requires a TLS certificate, key and client CA", s.managementListen)
+		}
+		if _, _, err := net.SplitHostPort(strings.TrimPrefix(s.managementListen, "tcp://")); err != nil {
+			return fmt.Errorf("invalid management listen address %q: %w", s.managementListen, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid management listen address %q, expected unix:// or tcp://", s.managementListen)
+	}
+}
+
+// managementOverTCP reports whether the management API is served over TCP
+// rather than the management socket
+func (s *Server) managementOverTCP() bool {
+	return s.managementListen != ""
+}
+
+// listenManagement creates the listener for the management API. The socket
+// is only reachable by its owner and group, and a TCP listener only accepts
+// clients with a certificate signed by the client CA.
+func (s *Server) listenManagement() (net.Listener, error) {
+	log := logging.GetLogger()
+
+	if s.managementOverTCP() {
+		address := strings.TrimPrefix(s.managementListen, "tcp://")
+		listener, err := tls.Listen("tcp", address, s.managementTLS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create listener: %w", err)
+		}
+		log.Info("Management server listening on TCP", "address", listener.Addr().String())
+		return listener, nil
+	}
+
+	listener, err := net.Listen("unix", s.managementSocket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create listener: %w", err)
+	}
+
+	// Set socket permissions
+	if err := os.Chmod(s.managementSocket, 0660); err != nil {
+		log.Warn("Failed to set socket permissions", "error", err)
+	}
+
+	log.Info("Management server listening on socket", "path", s.managementSocket)
+	return listener, nil
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA is a certificate authority that issues certificates for tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newTestCA creates a self-signed certificate authority
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key signed by the CA for a server on
+// localhost or for a client
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestManagementTLSConfig(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	ca := newTestCA(t, "clients")
+	certPEM, keyPEM := ca.issue(t, "daemon", x509.ExtKeyUsageServerAuth)
+	write := func(name string, data []byte) string {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	certFile := write("cert.pem", certPEM)
+	keyFile := write("key.pem", keyPEM)
+	caFile := write("ca.pem", ca.pem)
+	emptyFile := write("empty.pem", nil)
+
+	tests := []struct {
+		name    string
+		cert    string
+		key     string
+		ca      string
+		wantNil bool
+		wantErr string
+	}{
+		{name: "not configured", wantNil: true},
+		{name: "complete", cert: certFile, key: keyFile, ca: caFile},
+		{name: "missing key", cert: certFile, ca: caFile, wantErr: "both a management TLS certificate and key are required"},
+		{name: "missing client CA", cert: certFile, key: keyFile, wantErr: "client CA is required"},
+		{name: "client CA without certificates", cert: certFile, key: keyFile, ca: emptyFile, wantErr: "no certificates found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, err := ManagementTLSConfig(tt.cert, tt.key, tt.ca)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ManagementTLSConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ManagementTLSConfig() error = %v", err)
+			}
+			if (tlsConfig == nil) != tt.wantNil {
+				t.Fatalf("ManagementTLSConfig() = %v, want nil %v", tlsConfig, tt.wantNil)
+			}
+			if tlsConfig != nil && tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+				t.Errorf("ClientAuth = %v, want RequireAndVerifyClientCert", tlsConfig.ClientAuth)
+			}
+		})
+	}
+}
+
+func TestNewServerManagementListen(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	tests := []struct {
+		name       string
+		listen     string
+		tlsConfig  *tls.Config
+		wantSocket string
+		wantErr    bool
+	}{
+		{name: "management socket", wantSocket: filepath.Join(tmpDir, "mgmt.sock")},
+		{name: "unix address", listen: "unix://" + filepath.Join(tmpDir, "other.sock"), wantSocket: filepath.Join(tmpDir, "other.sock")},
+		{name: "tcp with TLS", listen: "tcp://127.0.0.1:2377", tlsConfig: tlsConfig},
+		{name: "tcp without TLS", listen: "tcp://127.0.0.1:2377", wantErr: true},
+		{name: "tcp without port", listen: "tcp://127.0.0.1", tlsConfig: tlsConfig, wantErr: true},
+		{name: "TLS on a unix socket", tlsConfig: tlsConfig, wantErr: true},
+		{name: "unknown scheme", listen: "http://127.0.0.1:2377", wantErr: true},
+		{name: "unix without a path", listen: "unix://", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServer(filepath.Join(tmpDir, "mgmt.sock"), "/var/run/docker.sock", tmpDir+"/",
+				WithManagementListen(tt.listen, tt.tlsConfig))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tt.wantSocket != "" && srv.managementSocket != tt.wantSocket {
+				t.Errorf("management socket = %s, want %s", srv.managementSocket, tt.wantSocket)
+			}
+			if srv.managementOverTCP() != (tt.wantSocket == "") {
+				t.Errorf("managementOverTCP() = %v, want %v", srv.managementOverTCP(), tt.wantSocket == "")
+			}
+		})
+	}
+}
+
+func TestManagementListenerRequiresClientCert(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	serverCA := newTestCA(t, "server")
+	clientCA := newTestCA(t, "clients")
+	otherCA := newTestCA(t, "other")
+
+	serverCert, serverKey := serverCA.issue(t, "daemon", x509.ExtKeyUsageServerAuth)
+	write := func(name string, data []byte) string {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tlsConfig, err := ManagementTLSConfig(write("cert.pem", serverCert), write("key.pem", serverKey), write("ca.pem", clientCA.pem))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := NewServer(filepath.Join(tmpDir, "mgmt.sock"), "/var/run/docker.sock", tmpDir+"/",
+		WithManagementListen("tcp://127.0.0.1:0", tlsConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := srv.listenManagement()
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), serverContextKey, srv)))
+	})}
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			t.Errorf("Serve() error = %v", err)
+		}
+	}()
+	defer func() {
+		if err := httpServer.Close(); err != nil {
+			t.Errorf("Failed to close management server: %v", err)
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.cert)
+	clientFor := func(ca *testCA) *http.Client {
+		tlsClient := &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: roots}
+		if ca != nil {
+			certPEM, keyPEM := ca.issue(t, "operator", x509.ExtKeyUsageClientAuth)
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Always present the certificate, even when the server does not
+			// ask for its issuer
+			tlsClient.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return &cert, nil
+			}
+		}
+		return &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{TLSClientConfig: tlsClient}}
+	}
+
+	tests := []struct {
+		name     string
+		clientCA *testCA
+		wantOK   bool
+	}{
+		{name: "certificate signed by the client CA", clientCA: clientCA, wantOK: true},
+		{name: "certificate signed by another CA", clientCA: otherCA},
+		{name: "no certificate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := clientFor(tt.clientCA)
+			defer client.CloseIdleConnections()
+
+			resp, err := client.Get("https://" + listener.Addr().String() + "/socket/list")
+			if !tt.wantOK {
+				if err == nil {
+					if err := resp.Body.Close(); err != nil {
+						t.Errorf("Failed to close response body: %v", err)
+					}
+					t.Fatalf("request succeeded with status %d, want the handshake to fail", resp.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			if err := resp.Body.Close(); err != nil {
+				t.Errorf("Failed to close response body: %v", err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+		})
+	}
+}
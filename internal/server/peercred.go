@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"syscall"
+
+	"docker-socket-proxy/internal/logging"
+	"docker-socket-proxy/internal/proxy/config"
+)
+
+// peerCredContext is used as an http.Server ConnContext hook. For unix socket
+// connections it reads SO_PEERCRED and stores the caller's identity in the
+// connection context so rules can match on it. A connection wrapped by a
+// listener, such as the max_connections limit, is unwrapped first.
+func peerCredContext(ctx context.Context, c net.Conn) context.Context {
+	if wrapped, ok := c.(interface{ NetConn() net.Conn }); ok {
+		c = wrapped.NetConn()
+	}
+	unixConn, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		logging.GetLogger().Debug("Failed to access raw connection", "error", err)
+		return ctx
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil || credErr != nil {
+		logging.GetLogger().Debug("Failed to read peer credentials", "error", err, "cred_error", credErr)
+		return ctx
+	}
+
+	return config.ContextWithIdentity(ctx, &config.Identity{
+		UID: cred.Uid,
+		GID: cred.Gid,
+		PID: cred.Pid,
+	})
+}
+
+// peerCredAttrs returns the caller's peer credentials as log attributes, or
+// nothing when the connection did not carry any
+func peerCredAttrs(r *http.Request) []any {
+	id, ok := config.IdentityFromContext(r.Context())
+	if !ok {
+		return nil
+	}
+	return []any{"peer_uid", id.UID, "peer_gid", id.GID, "peer_pid", id.PID}
+}
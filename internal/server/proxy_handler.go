@@ -2,34 +2,91 @@ package server
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"net"
+	"math"
 	"net/http"
 	"net/http/httputil"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"docker-socket-proxy/internal/audit"
 	"docker-socket-proxy/internal/logging"
 	"docker-socket-proxy/internal/proxy/config"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ProxyHandler handles proxying requests to the Docker socket
 type ProxyHandler struct {
 	dockerSocket  string
+	upstream      *upstream
 	socketConfigs map[string]*config.SocketConfig
 	configMu      *sync.RWMutex
+	samplers      map[string]*logSampler
+	samplerMu     sync.Mutex
+	stats         map[string]*socketStats
+	statsMu       sync.Mutex
+	// auditLogs holds the open audit log files by path
+	auditLogs     map[string]*audit.Logger
+	auditFailures map[string]time.Time
+	auditMu       sync.Mutex
+	// rateLimiters holds a token bucket per ratelimit action of each socket
+	rateLimiters map[rateLimitKey]*rateLimiter
+	rateLimitMu  sync.Mutex
+	// decisionLogs holds the recent decisions of each socket
+	decisionLogs       map[string]*decisionLog
+	decisionLogsClosed bool
+	decisionLogMu      sync.Mutex
+	// tracerProvider records a span per proxied request, nil disables tracing
+	tracerProvider trace.TracerProvider
+	// onError is the policy for requests whose rules fail to evaluate, any
+	// value other than OnErrorAllow denies them
+	onError string
+	// baseConfig holds the rules evaluated ahead of every socket's own, nil
+	// when the daemon has no base config
+	baseConfig *config.SocketConfig
+	// bodyNeeds caches whether each socket's config reads request bodies
+	bodyNeeds  map[string]bodyNeed
+	bodyNeedMu sync.Mutex
+}
+
+// bodyNeed is whether the rules of config read request bodies, recomputed
+// whenever the socket's config is replaced
+type bodyNeed struct {
+	config *config.SocketConfig
+	needed bool
 }
 
+// Policies for requests whose rules fail to evaluate
+const (
+	OnErrorDeny  = "deny"
+	OnErrorAllow = "allow"
+)
+
 // NewProxyHandler creates a new proxy handler
 func NewProxyHandler(dockerSocket string, configs map[string]*config.SocketConfig, mu *sync.RWMutex) *ProxyHandler {
+	// NewServer rejects addresses that do not parse before getting here
+	up, err := parseUpstream(dockerSocket, nil)
+	if err != nil {
+		up = unixUpstream(dockerSocket)
+	}
+
 	return &ProxyHandler{
 		dockerSocket:  dockerSocket,
+		upstream:      up,
 		socketConfigs: configs,
 		configMu:      mu,
+		samplers:      make(map[string]*logSampler),
+		stats:         make(map[string]*socketStats),
 	}
 }
 
@@ -53,74 +110,440 @@ func (h *ProxyHandler) ServeHTTPWithSocket(w http.ResponseWriter, r *http.Reques
 
 	if !ok {
 		log.Error("Socket configuration not found", "socket", socketPath)
-		http.Error(w, "Socket configuration not found", http.StatusInternalServerError)
+		writeDockerError(w, http.StatusInternalServerError, "socket configuration not found")
 		return
 	}
+	defer h.trackRequest(socketPath)()
+
+	// Log every response once it is complete, denials and upstream errors
+	// included, with the rule that decided it
+	decision := ruleDecision{rule: -1, action: -1}
+	if socketConfig.Config.AccessLog {
+		start := time.Now()
+		writer := &accessLogWriter{ResponseWriter: w}
+		method, path := r.Method, r.URL.Path
+		defer func() { logAccess(r, method, path, socketPath, writer, start, decision) }()
+		w = writer
+	}
+
+	// Trace the request, continuing the caller's trace if it sent one
+	r, span := h.startProxySpan(r, socketPath)
+	defer span.End()
+
+	// Let source_cidr read the client address that the socket's trusted
+	// proxies forwarded
+	if len(socketConfig.Config.TrustedProxies) > 0 {
+		trusted, err := config.ParseCIDRs(socketConfig.Config.TrustedProxies)
+		if err != nil {
+			log.Error("Invalid trusted proxies, ignoring forwarded addresses", "socket", socketPath, "error", err)
+		} else {
+			r = r.WithContext(config.ContextWithTrustedProxies(r.Context(), trusted))
+		}
+	}
+
+	// A request denied in shadow mode, or allowed after an evaluation error,
+	// is forwarded without the path rewrites of the rules evaluated before
+	originalURL := *r.URL
 
 	// Process rules and apply rewrites in a single pass
-	allowed, reason, err := h.processRules(r, socketConfig)
-	if err != nil {
-		log.Error("Error processing rules", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	decision, err := h.evaluateRules(r, socketPath, socketConfig)
+	if errors.Is(err, errBodyTooLarge) {
+		log.Warn("Request body too large, denying request", append([]any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"socket", socketPath,
+			"limit", socketConfig.Config.MaxBodySize(),
+		}, peerCredAttrs(r)...)...)
+		denied := ruleDecision{rule: -1, reason: bodyTooLargeReason}
+		h.recordDecision(socketPath, denied)
+		h.auditDecision(r, socketPath, socketConfig, denied)
+		h.logDecision(r, socketPath, denied)
+		recordSpanDecision(span, "deny", bodyTooLargeReason)
+		setDenialHeaders(w, socketConfig, denied)
+		writeDockerError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request denied: %s", bodyTooLargeReason))
 		return
 	}
+	if err != nil {
+		if h.onError != OnErrorAllow || errors.Is(err, errReadBody) {
+			log.Error("Error processing rules, denying request", "error", err, "socket", socketPath)
+			denied := ruleDecision{rule: -1, action: -1, reason: evaluationErrorDenyReason}
+			h.recordDecision(socketPath, denied)
+			h.auditDecision(r, socketPath, socketConfig, denied)
+			h.logDecision(r, socketPath, denied)
+			recordSpanDecision(span, "error", "")
+			setDenialHeaders(w, socketConfig, ruleDecision{rule: -1})
+			writeDockerError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
 
-	if !allowed {
-		log.Warn("Request denied by ACL",
+		// The request is forwarded unmodified, evaluateRules restored its
+		// original body and the path goes back to the one the client sent
+		r.URL = &originalURL
+		log.Error("Error processing rules, allowing request as the error policy is allow",
+			"error", err,
 			"method", r.Method,
 			"path", r.URL.Path,
 			"socket", socketPath,
-			"reason", reason,
 		)
-		http.Error(w, fmt.Sprintf("Request denied: %s", reason), http.StatusForbidden)
+		decision = ruleDecision{allowed: true, reason: evaluationErrorReason, rule: -1, action: -1}
+	}
+	allowed, reason := decision.allowed, decision.reason
+	if !allowed && socketConfig.Config.Shadow() {
+		decision.shadow = true
+	}
+
+	h.recordDecision(socketPath, decision)
+	h.auditDecision(r, socketPath, socketConfig, decision)
+	h.logDecision(r, socketPath, decision)
+	h.recordRuleHits(socketPath, socketConfig, decision.matched)
+
+	if !allowed && !decision.shadow {
+		log.Warn("Request denied by ACL", append([]any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"socket", socketPath,
+			"reason", reason,
+		}, peerCredAttrs(r)...)...)
+		recordSpanDecision(span, "deny", reason)
+		setDenialHeaders(w, socketConfig, decision)
+		if decision.rateLimited {
+			retryAfter := int(math.Ceil(decision.retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+			writeDockerError(w, http.StatusTooManyRequests, fmt.Sprintf("Request denied: %s", reason))
+			return
+		}
+		status := http.StatusForbidden
+		if decision.statusCode != 0 {
+			status = decision.statusCode
+		}
+		writeDockerErrorCode(w, status, fmt.Sprintf("Request denied: %s", reason), decision.code)
 		return
 	}
 
-	// Create a reverse proxy
+	// In shadow mode the rules only observe, the request reaches the daemon
+	// as the client sent it and without the response actions of the rules
+	responseActions := decision.responseActions
+	if decision.shadow {
+		log.Warn("Request would be denied by ACL, forwarding it in shadow mode", append([]any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"socket", socketPath,
+			"reason", reason,
+			"shadow", true,
+		}, peerCredAttrs(r)...)...)
+		recordSpanDecision(span, "deny", reason)
+		recordSpanShadow(span)
+		r.URL = &originalURL
+		responseActions = nil
+	} else {
+		recordSpanDecision(span, "allow", reason)
+		h.logAllowed(r, socketPath, socketConfig, decision)
+	}
+
+	timeout := config.DefaultUpstreamTimeout
+	retries := 0
+	if socketConfig != nil {
+		timeout = socketConfig.Config.UpstreamTimeoutDuration()
+		retries = socketConfig.Config.UpstreamRetries
+	}
+
+	// Streams are flushed as they arrive and never held back for rewriting
+	streaming := isStreamingRequest(r)
+	var flushInterval time.Duration
+	if streaming {
+		flushInterval = -1
+		responseActions = nil
+	}
+
+	// Create a reverse proxy, which also hijacks upgraded connections
 	proxy := &httputil.ReverseProxy{
 		Director: func(req *http.Request) {
-			req.URL.Scheme = "http"
-			req.URL.Host = "docker"
+			req.URL.Scheme = h.upstream.scheme
+			req.URL.Host = h.upstream.host
+			// The daemon sees its own address as the host, not the name the
+			// client reached the proxy by. The reverse proxy strips the
+			// hop-by-hop headers of RFC 7230 once this returns, and leaves
+			// the end-to-end ones, such as X-Registry-Auth, as they were.
+			req.Host = h.upstream.host
+			if socketConfig != nil && socketConfig.Config.InjectSocketHeader {
+				setSocketHeader(req, socketConfig.Config, socketPath)
+			}
+			tracePropagator.Inject(req.Context(), propagation.HeaderCarrier(req.Header))
 		},
-		Transport: &http.Transport{
-			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
-				return net.Dial("unix", h.dockerSocket)
-			},
+		Transport:     withRetries(h.upstream.transport(timeout), retries),
+		FlushInterval: flushInterval,
+		ModifyResponse: func(resp *http.Response) error {
+			recordSpanStatus(span, resp.StatusCode)
+			if decision.allowed {
+				setRuleHeaders(resp.Header, socketConfig, decision)
+			}
+			return rewriteResponse(resp, responseActions)
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			if isTimeout(err) {
+				log.Error("Upstream request timed out", "error", err, "socket", socketPath)
+				recordSpanStatus(span, http.StatusGatewayTimeout)
+				writeDockerError(w, http.StatusGatewayTimeout, "Docker daemon did not respond in time")
+				return
+			}
+			log.Error("Upstream request failed", "error", err, "socket", socketPath)
+			recordSpanStatus(span, http.StatusBadGateway)
+			writeDockerError(w, http.StatusBadGateway, "Docker daemon is not reachable")
 		},
 	}
 
 	proxy.ServeHTTP(w, r)
 }
 
+// setSocketHeader names the proxy socket a request came through, keeping a
+// value the client sent unless the socket is configured to overwrite it
+func setSocketHeader(req *http.Request, cfg config.ConfigSet, socketPath string) {
+	header := cfg.SocketHeader()
+	if req.Header.Get(header) != "" && !cfg.OverwriteSocketHeader {
+		return
+	}
+	req.Header.Set(header, filepath.Base(socketPath))
+}
+
+// Headers that mark a response as a denial by the proxy rather than an error
+// from the Docker daemon
+const (
+	deniedHeader     = "X-Docker-Proxy-Denied"
+	ruleHeader       = "X-Docker-Proxy-Rule"
+	actionHeader     = "X-Docker-Proxy-Action-Index"
+	reasonCodeHeader = "X-Docker-Proxy-Reason-Code"
+)
+
+// setDenialHeaders marks a response as denied by the proxy, with the rule
+// that denied it as in setRuleHeaders. The reason code of the deny is always
+// sent when it has one.
+func setDenialHeaders(w http.ResponseWriter, socketConfig *config.SocketConfig, decision ruleDecision) {
+	w.Header().Set(deniedHeader, "true")
+	if decision.code != "" {
+		w.Header().Set(reasonCodeHeader, decision.code)
+	}
+	setRuleHeaders(w.Header(), socketConfig, decision)
+}
+
+// setRuleHeaders names the rule and action that decided a request. They are
+// only sent when the socket has debug headers enabled, and not for requests
+// that no rule decided. A base config rule is sent as base:<index>.
+func setRuleHeaders(header http.Header, socketConfig *config.SocketConfig, decision ruleDecision) {
+	if socketConfig == nil || !socketConfig.Config.DebugHeaders || decision.rule < 0 {
+		return
+	}
+	rule := strconv.Itoa(decision.rule)
+	if decision.base {
+		rule = "base:" + rule
+	}
+	header.Set(ruleHeader, rule)
+	header.Set(actionHeader, strconv.Itoa(decision.action))
+}
+
+// decisionAttrs are the log attributes of the rule and action that decided
+// a request, none when no rule did. "action" names an action elsewhere, so
+// the index of the deciding one is "action_index".
+func decisionAttrs(decision ruleDecision) []any {
+	if decision.rule < 0 {
+		return nil
+	}
+	attrs := []any{"rule", decision.rule, "action_index", decision.action}
+	if decision.base {
+		attrs = append(attrs, "base", true)
+	}
+	return attrs
+}
+
+// dockerError is the error body the Docker daemon returns, which Docker
+// clients show to the user. Code is only set on denials with a reason code,
+// Docker clients ignore it.
+type dockerError struct {
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+}
+
+// writeDockerError writes an error response in the Docker daemon's format
+func writeDockerError(w http.ResponseWriter, status int, message string) {
+	writeDockerErrorCode(w, status, message, "")
+}
+
+// writeDockerErrorCode writes an error response in the Docker daemon's
+// format, with the reason code of a denial
+func writeDockerErrorCode(w http.ResponseWriter, status int, message, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(dockerError{Message: message, Code: code}); err != nil {
+		logging.GetLogger().Debug("Failed to write error response", "error", err)
+	}
+}
+
+// logAllowed logs an allowed request. Sockets with an allow_log_sample_rate
+// log allows at info, sampled to avoid floods on hot paths, the rest at debug.
+func (h *ProxyHandler) logAllowed(r *http.Request, socketPath string, socketConfig *config.SocketConfig, decision ruleDecision) {
+	log := logging.GetLogger()
+	attrs := append(append([]any{
+		"method", r.Method,
+		"path", r.URL.Path,
+		"socket", socketPath,
+		"reason", decision.reason,
+	}, decisionAttrs(decision)...), peerCredAttrs(r)...)
+
+	rate := 0
+	if socketConfig != nil {
+		rate = socketConfig.Config.AllowLogSampleRate
+	}
+	if rate <= 0 {
+		log.Debug("Request allowed", attrs...)
+		return
+	}
+
+	h.samplerMu.Lock()
+	if h.samplers == nil {
+		h.samplers = make(map[string]*logSampler)
+	}
+	sampler, ok := h.samplers[socketPath]
+	if !ok {
+		sampler = &logSampler{}
+		h.samplers[socketPath] = sampler
+	}
+	h.samplerMu.Unlock()
+
+	if sampler.allow(time.Now(), rate) {
+		log.Info("Request allowed", attrs...)
+	}
+}
+
+// ruleDecision is the outcome of evaluating a socket's rules against a request
+type ruleDecision struct {
+	allowed bool
+	reason  string
+	// rule is the index of the rule whose action decided, -1 for the default,
+	// and action the index of that action in the rule
+	rule   int
+	action int
+	// base is set when the deciding rule is one of the base config's, rule
+	// is then its index there
+	base bool
+	// matched holds the indexes of every rule of the socket's config that
+	// matched, in evaluation order
+	matched []int
+	// responseActions are the response phase actions of the matched rules,
+	// applied to the upstream response body in order
+	responseActions []config.Action
+	// rewritten is set when an allowed request is forwarded with a modified
+	// body or path
+	rewritten bool
+	// shadow is set when the rules denied the request but the socket is in
+	// shadow mode, so it is forwarded anyway
+	shadow bool
+	// rateLimited is set when a ratelimit action denied the request, and
+	// retryAfter to how long until it would have been allowed
+	rateLimited bool
+	retryAfter  time.Duration
+	// statusCode is the status a deny action responds with, 0 for the default 403
+	statusCode int
+	// code is the reason code of the deny action that decided, if it has one
+	code string
+}
+
+// noMatchingAllowReason is the deny reason for sockets that deny by default
+const noMatchingAllowReason = "no matching allow rule"
+
+// errReadBody is returned when the request body cannot be read. The request
+// cannot be forwarded either, so it fails whatever the error policy.
+var errReadBody = errors.New("failed to read request body")
+
+// errBodyTooLarge is returned when a request body that has to be inspected
+// is larger than the socket's limit
+var errBodyTooLarge = errors.New("request body too large")
+
+// bodyTooLargeReason is the deny reason for bodies over the limit
+const bodyTooLargeReason = "request body too large"
+
+// bodyNotInspectedReason is the deny reason for streaming requests whose
+// body a matching rule needs
+const bodyNotInspectedReason = "request body cannot be inspected"
+
+// Reasons recorded for requests whose rules failed to evaluate
+const (
+	evaluationErrorReason     = "allowed after evaluation error"
+	evaluationErrorDenyReason = "denied after evaluation error"
+)
+
+// rateLimitedReason is the deny reason for ratelimit actions without a reason
+const rateLimitedReason = "rate limit exceeded"
+
 // processRules handles both ACL checks and rewrites in a single pass
-func (h *ProxyHandler) processRules(r *http.Request, socketConfig *config.SocketConfig) (allowed bool, reason string, err error) {
+func (h *ProxyHandler) processRules(r *http.Request, socketConfig *config.SocketConfig) (ruleDecision, error) {
+	return h.evaluateRules(r, "", socketConfig)
+}
+
+// evaluateRules decides whether a request to a socket is allowed, applying
+// request phase rewrites and collecting response phase actions along the way
+func (h *ProxyHandler) evaluateRules(r *http.Request, socketPath string, socketConfig *config.SocketConfig) (decision ruleDecision, err error) {
 	log := logging.GetLogger()
+	decision.rule, decision.action = -1, -1
 
 	// Handle nil config - allow by default
 	if socketConfig == nil {
-		return true, "", nil
+		decision.allowed = true
+		return decision, nil
+	}
+
+	// Requests outside the socket's API version range are refused whatever
+	// the rules say
+	if reason := socketConfig.Config.CheckAPIVersion(r.URL.Path); reason != "" {
+		decision.reason = reason
+		decision.statusCode = http.StatusBadRequest
+		return decision, nil
 	}
 
-	// If there are no rules, allow by default
-	if len(socketConfig.Rules) == 0 {
-		return true, "", nil
+	// If there are no rules, fall back to the default action
+	if len(socketConfig.Rules) == 0 && h.baseConfig == nil {
+		decision.allowed = !socketConfig.DeniesByDefault()
+		if !decision.allowed {
+			decision.reason = noMatchingAllowReason
+		}
+		return decision, nil
 	}
 
-	// For POST/PUT requests that might need rewrites
-	var bodyBytes []byte
+	// For requests with a body method that might need rewrites. bodyBytes
+	// is the body as the rules see it, encodedBody as the client sent it.
+	var bodyBytes, encodedBody []byte
 	var body map[string]any
 	modified := false
 
-	if r.Method == "POST" || r.Method == "PUT" && r.Body != nil {
-		// Read the body
-		bodyBytes, err = io.ReadAll(r.Body)
+	if socketConfig.Config.ReadsBody(r.Method) && r.Body != nil && h.socketNeedsBody(socketPath, socketConfig) && needsBody(r, socketConfig, h.baseConfig) {
+		// Read the body, up to the socket's limit
+		limit := socketConfig.Config.MaxBodySize()
+		bodyBytes, err = io.ReadAll(io.LimitReader(r.Body, limit+1))
 		if err != nil {
-			return false, "", fmt.Errorf("failed to read request body: %w", err)
+			return decision, fmt.Errorf("%w: %v", errReadBody, err)
+		}
+		if int64(len(bodyBytes)) > limit {
+			return decision, fmt.Errorf("%w: more than %d bytes", errBodyTooLarge, limit)
 		}
 
 		// Create a new reader for the body immediately
 		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 
+		// A request whose rules fail to evaluate may still be forwarded, with
+		// the body the client sent
+		defer func() {
+			if err != nil {
+				r.Body = io.NopCloser(bytes.NewReader(encodedBody))
+			}
+		}()
+
+		// A compressed body is matched as it reads decompressed
+		encodedBody = bodyBytes
+		if encoding := contentEncoding(r); encoding != "" {
+			if bodyBytes, err = decodeBody(encoding, encodedBody, limit); err != nil {
+				return decision, err
+			}
+		}
+
 		// Try to parse JSON body
 		if err := json.Unmarshal(bodyBytes, &body); err != nil {
 			// If we can't parse JSON, that's ok - we'll just use the original body
@@ -128,93 +551,165 @@ func (h *ProxyHandler) processRules(r *http.Request, socketConfig *config.Socket
 		}
 	}
 
-	// Process each rule in order
-	for _, rule := range socketConfig.Rules {
-		// Check path and method matches
-		pathMatches := true
-		if rule.Match.Path != "" {
-			pathMatches, err = regexp.MatchString(rule.Match.Path, r.URL.Path)
+	// The body of a streaming request is left to the daemon, so rules that
+	// match on it cannot be evaluated
+	bodyUnread := isStreamingRequest(r) && socketConfig.Config.ReadsBody(r.Method) && r.Body != nil && r.Body != http.NoBody
+
+	// Process each rule in the order the proxy evaluates them, i stays the
+	// rule's position in its config
+	for _, rule := range socketConfig.EffectiveRulesWithBase(h.baseConfig) {
+		i := rule.Index
+		label := ruleLabel(rule)
+
+		// Propagate the socket into created containers before the rules run,
+		// so that their rewrites apply to the result whichever rule decides
+		if i < 0 {
+			if body == nil {
+				continue
+			}
+			if err := rule.Match.CheckPatterns(); err != nil {
+				return decision, fmt.Errorf("propagation rule: %w", err)
+			}
+			matched, err := config.MatchRequest(r, bodyBytes, body, rule.Match)
 			if err != nil {
-				return false, "", fmt.Errorf("invalid path pattern: %w", err)
+				return decision, fmt.Errorf("propagation rule: %w", err)
+			}
+			if !matched {
+				continue
+			}
+			for _, action := range rule.Actions {
+				if config.ApplyRewriteAction(body, action) {
+					modified = true
+				}
 			}
-		}
-		if !pathMatches {
-			log.Debug("Path does not match", "path", r.URL.Path, "pattern", rule.Match.Path)
 			continue
 		}
 
-		methodMatches := true
-		if rule.Match.Method != "" {
-			methodMatches, err = regexp.MatchString(rule.Match.Method, r.Method)
+		// A pattern that does not compile is an error rather than a rule
+		// that never matches, so a broken deny rule cannot let requests by
+		if err := rule.Match.CheckPatterns(); err != nil {
+			return decision, fmt.Errorf("%s: %w", label, err)
+		}
+
+		// Rather than skip a rule that matches on a body it cannot see, which
+		// would let a denied body through, the request is denied
+		if bodyUnread && rule.Match.NeedsBody() {
+			routeMatched, err := matchesRoute(r, rule.Match)
 			if err != nil {
-				return false, "", fmt.Errorf("invalid method pattern: %w", err)
+				return decision, fmt.Errorf("%s: %w", label, err)
+			}
+			if routeMatched {
+				decision.reason = bodyNotInspectedReason
+				decision.rule, decision.base = i, rule.Base
+				return decision, nil
 			}
 		}
-		if !methodMatches {
-			log.Debug("Method does not match", "method", r.Method, "pattern", rule.Match.Method)
+
+		matched, err := config.MatchRequest(r, bodyBytes, body, rule.Match)
+		if err != nil {
+			return decision, fmt.Errorf("%s: %w", label, err)
+		}
+		if !matched {
+			log.Debug("Rule does not match", "rule", i, "base", rule.Base, "path", r.URL.Path, "method", r.Method)
 			continue
 		}
 
-		// Check rule's Contains condition
-		if len(rule.Match.Contains) > 0 {
-			if body == nil {
-				log.Debug("No body available for Contains check")
-				continue
-			}
-			if !config.MatchValue(rule.Match.Contains, body) {
-				log.Debug("Body does not match Contains condition", "contains", rule.Match.Contains)
-				continue
-			}
+		log.Debug("Rule matched", "rule", i, "base", rule.Base, "path", r.URL.Path, "method", r.Method)
+		// Base rules are counted by no socket's rule hits
+		if !rule.Base {
+			decision.matched = append(decision.matched, i)
 		}
 
-		log.Debug("Rule matched", "path", r.URL.Path, "method", r.Method)
+		// Response phase actions run later, against the upstream response
+		for _, action := range rule.Actions {
+			if action.Phase == config.PhaseResponse {
+				decision.responseActions = append(decision.responseActions, action)
+			}
+		}
 
 		// Rule matches, now process its actions
-		for _, action := range rule.Actions {
+		for j, action := range rule.Actions {
+			if action.Phase == config.PhaseResponse {
+				continue
+			}
+			// A deny that only applies to some bodies logs once it does
+			if action.Log && action.Action != "deny" {
+				logAction(r, socketPath, rule, action)
+			}
+
 			switch action.Action {
+			case "ratelimit":
+				// Within the limit the next action decides. A dry run
+				// looks at the socket's bucket without taking a token.
+				key := rateLimitKey{socket: socketPath, base: rule.Base, rule: i, action: j}
+				now := time.Now()
+				var within bool
+				var wait time.Duration
+				if dryRunSocket, ok := r.Context().Value(dryRunContextKey).(string); ok {
+					key.socket = dryRunSocket
+					within, wait = h.peekRateLimiter(key, action, now)
+				} else {
+					within, wait = h.rateLimiterFor(key, action, now).allow(now)
+				}
+				if !within {
+					decision.reason = action.Reason
+					if decision.reason == "" {
+						decision.reason = rateLimitedReason
+					}
+					decision.rateLimited = true
+					decision.retryAfter = wait
+					decision.rule, decision.action, decision.base = i, j, rule.Base
+					return decision, nil
+				}
+
 			case "deny":
 				if len(action.Contains) > 0 && body != nil {
 					if !config.MatchValue(action.Contains, body) {
 						continue
 					}
 				}
-				return false, action.Reason, nil
+				if action.Log {
+					logAction(r, socketPath, rule, action)
+				}
+				decision.reason = action.Reason
+				decision.rule, decision.action, decision.base = i, j, rule.Base
+				decision.statusCode = action.StatusCode
+				decision.code = action.Code
+				return decision, nil
 
 			case "allow":
+				// The rewrites of every rule evaluated so far are forwarded
+				if err := setForwardedBody(r, encodedBody, body, modified); err != nil {
+					return decision, err
+				}
 				if modified && body != nil {
-					// Update the body if it was modified
-					newBodyBytes, err := json.Marshal(body)
-					if err != nil {
-						return false, "", fmt.Errorf("failed to marshal modified body: %w", err)
-					}
-					r.Body = io.NopCloser(bytes.NewBuffer(newBodyBytes))
-					r.ContentLength = int64(len(newBodyBytes))
-					r.Header.Set("Content-Length", strconv.Itoa(len(newBodyBytes)))
-				} else {
-					// Restore original body
-					r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-					r.ContentLength = int64(len(bodyBytes))
-					r.Header.Set("Content-Length", strconv.Itoa(len(bodyBytes)))
+					decision.rewritten = true
 				}
-				return true, action.Reason, nil
-
-			case "replace":
-				if body != nil && config.MatchesStructure(body, action.Contains) {
-					if config.MergeStructure(body, action.Update, true) {
-						modified = true
-					}
+				decision.allowed = true
+				decision.reason = action.Reason
+				decision.rule, decision.action, decision.base = i, j, rule.Base
+				return decision, nil
+
+			case "rewrite-path":
+				// Later rules match, and the daemon receives, the new path
+				rewritten, err := rewritePath(r, action)
+				if err != nil {
+					return decision, fmt.Errorf("%s: %w", label, err)
 				}
-
-			case "upsert":
-				if body != nil {
-					if config.MergeStructure(body, action.Update, false) {
-						modified = true
-					}
+				if rewritten {
+					decision.rewritten = true
 				}
 
-			case "delete":
-				if body != nil {
-					if config.DeleteMatchingFields(body, action.Contains) {
+			case "replace", "upsert", "delete":
+				if body != nil && config.ApplyRewriteAction(body, action) {
+					modified = true
+				}
+				if body != nil && len(action.Template) > 0 {
+					applied, err := config.ApplyTemplateAction(body, action, templateData(r, socketPath))
+					if err != nil {
+						return decision, fmt.Errorf("%s: %w", label, err)
+					}
+					if applied {
 						modified = true
 					}
 				}
@@ -223,84 +718,172 @@ func (h *ProxyHandler) processRules(r *http.Request, socketConfig *config.Socket
 	}
 
 	// If we get here, no explicit allow/deny was found
-	// Restore the body and allow by default
-	if modified && body != nil {
-		newBodyBytes, err := json.Marshal(body)
-		if err != nil {
-			return false, "", fmt.Errorf("failed to marshal modified body: %w", err)
-		}
-		r.Body = io.NopCloser(bytes.NewBuffer(newBodyBytes))
-		r.ContentLength = int64(len(newBodyBytes))
-		r.Header.Set("Content-Length", strconv.Itoa(len(newBodyBytes)))
-	} else if bodyBytes != nil {
-		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-		r.ContentLength = int64(len(bodyBytes))
-		r.Header.Set("Content-Length", strconv.Itoa(len(bodyBytes)))
+	if socketConfig.DeniesByDefault() {
+		decision.reason = noMatchingAllowReason
+		return decision, nil
 	}
 
-	return true, "", nil
+	// Allow by default, with the rewrites of every matching rule
+	if err := setForwardedBody(r, encodedBody, body, modified); err != nil {
+		return decision, err
+	}
+	if modified && body != nil {
+		decision.rewritten = true
+	}
+	decision.allowed = true
+	return decision, nil
 }
 
-// ruleMatches checks if a request matches a rule
-func (h *ProxyHandler) ruleMatches(r *http.Request, match config.Match) bool {
-	log := logging.GetLogger()
-	path := r.URL.Path
-	method := r.Method
+// ruleLabel names a rule in errors, base config rules apart from the socket's
+func ruleLabel(rule config.EffectiveRule) string {
+	if rule.Base {
+		return fmt.Sprintf("base rule %d", rule.Index)
+	}
+	return fmt.Sprintf("rule %d", rule.Index)
+}
 
-	// Check if the path matches
-	pathMatches := true
-	if match.Path != "" {
-		var err error
-		pathMatches, err = regexp.MatchString(match.Path, path)
+// setForwardedBody sets the body of an allowed request to the rewritten
+// body when a rewrite changed it, compressed again if the client compressed
+// it, or back to the body as it was read. The
+// whole body is in memory by then, so a request the client sent chunked is
+// forwarded with a Content-Length instead, rather than with both.
+func setForwardedBody(r *http.Request, bodyBytes []byte, body map[string]any, modified bool) error {
+	if modified && body != nil {
+		newBodyBytes, err := json.Marshal(body)
 		if err != nil {
-			log.Error("Error matching path pattern", "error", err)
-			return false
+			return fmt.Errorf("failed to marshal modified body: %w", err)
 		}
+		if bodyBytes, err = encodeBody(contentEncoding(r), newBodyBytes); err != nil {
+			return err
+		}
+	} else if bodyBytes == nil {
+		return nil
 	}
+	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	r.ContentLength = int64(len(bodyBytes))
+	r.TransferEncoding = nil
+	r.Header.Del("Transfer-Encoding")
+	r.Header.Set("Content-Length", strconv.Itoa(len(bodyBytes)))
+	return nil
+}
 
-	if !pathMatches {
-		return false
+// templateData returns the values that template placeholders resolve to for
+// a request to a socket
+func templateData(r *http.Request, socketPath string) config.TemplateData {
+	data := config.TemplateData{Header: r.Header, Now: time.Now()}
+	if socketPath != "" {
+		data.SocketName = filepath.Base(socketPath)
 	}
+	return data
+}
 
-	// Check if the method matches
-	methodMatches := true
-	if match.Method != "" {
-		var err error
-		methodMatches, err = regexp.MatchString(match.Method, method)
-		if err != nil {
-			log.Error("Error matching method pattern", "error", err)
-			return false
-		}
+// logAction writes the log line of an action that sets log, at the level
+// and with the message it asks for
+func logAction(r *http.Request, socketPath string, rule config.EffectiveRule, action config.Action) {
+	message := action.LogMessage
+	if message == "" {
+		message = config.DefaultActionLogMessage
 	}
-
-	if !methodMatches {
-		return false
+	attrs := append([]any{
+		"socket", socketPath,
+		"method", r.Method,
+		"path", r.URL.Path,
+		"rule", rule.Index,
+		"action", action.Action,
+	}, peerCredAttrs(r)...)
+	if rule.Base {
+		attrs = append(attrs, "base", true)
+	}
+	if action.Reason != "" {
+		attrs = append(attrs, "reason", action.Reason)
 	}
+	logging.GetLogger().Log(r.Context(), action.ActionLogLevel(), message, attrs...)
+}
 
-	// Check if the body matches (for POST/PUT requests)
-	if len(match.Contains) > 0 && (method == "POST" || method == "PUT") {
-		// Read the request body
-		bodyBytes, err := io.ReadAll(r.Body)
-		if err != nil {
-			log.Error("Error reading request body", "error", err)
-			return false
-		}
+// socketNeedsBody reports whether any rule of a socket reads request bodies.
+// Configs are replaced rather than changed in place, so the flag is cached
+// until the socket's config is a different one.
+func (h *ProxyHandler) socketNeedsBody(socketPath string, socketConfig *config.SocketConfig) bool {
+	h.bodyNeedMu.Lock()
+	defer h.bodyNeedMu.Unlock()
 
-		// Restore the request body
-		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	if need, ok := h.bodyNeeds[socketPath]; ok && need.config == socketConfig {
+		return need.needed
+	}
+	if h.bodyNeeds == nil {
+		h.bodyNeeds = make(map[string]bodyNeed)
+	}
+	need := bodyNeed{config: socketConfig, needed: socketConfig.NeedsBody() || (h.baseConfig != nil && h.baseConfig.NeedsBody())}
+	h.bodyNeeds[socketPath] = need
+	return need.needed
+}
 
-		// Parse the JSON body
-		var bodyJSON map[string]any
-		if err := json.Unmarshal(bodyBytes, &bodyJSON); err != nil {
-			log.Error("Error parsing request body", "error", err)
-			return false
+// needsBody reports whether any rule that may match the request reads its
+// body. Other requests are streamed to the daemon without being buffered, so
+// that builds and image loads are not held in memory. A path rewrite may make
+// later rules match, so a request that a rewrite-path rule matches is read.
+// Streaming requests are never read, see isStreamingRequest.
+func needsBody(r *http.Request, socketConfig, baseConfig *config.SocketConfig) bool {
+	if isStreamingRequest(r) {
+		return false
+	}
+	if socketConfig.Config.PropagateSocket != "" {
+		return true
+	}
+	rules := socketConfig.Rules
+	if baseConfig != nil {
+		rules = append(slices.Clip(baseConfig.Rules), rules...)
+	}
+	for _, rule := range rules {
+		rewritesPath := false
+		for _, action := range rule.Actions {
+			if action.Action == "rewrite-path" {
+				rewritesPath = true
+			}
+		}
+		if !rule.NeedsBody() && !rewritesPath {
+			continue
+		}
+		// A pattern that fails to compile is reported by the evaluation
+		if matched, err := matchesRoute(r, rule.Match); err != nil || matched {
+			return true
 		}
+	}
+	return false
+}
 
-		// Check if the body matches the contains criteria
-		if !config.MatchValue(match.Contains, bodyJSON) {
-			return false
+// matchesRoute checks only the path and method of a match
+func matchesRoute(r *http.Request, match config.Match) (bool, error) {
+	if match.Path != "" {
+		matched, err := match.MatchPattern(match.Path, r.URL.Path)
+		if err != nil || !matched {
+			return false, err
 		}
 	}
+	if match.Method != "" {
+		return match.MatchMethod(r.Method)
+	}
+	return true, nil
+}
+
+// rewritePath applies a rewrite-path action to the request path, reporting
+// whether the path changed
+func rewritePath(r *http.Request, action config.Action) (bool, error) {
+	re, err := regexp.Compile(action.Pattern)
+	if err != nil {
+		return false, fmt.Errorf("invalid rewrite-path pattern: %w", err)
+	}
+
+	path := re.ReplaceAllString(r.URL.Path, action.Replacement)
+	if path == r.URL.Path {
+		return false, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return false, fmt.Errorf("rewrite-path turned %q into %q, which is not an absolute path", r.URL.Path, path)
+	}
 
-	return true
+	// Clearing RawPath has the path escaped again from the new Path
+	r.URL.Path = path
+	r.URL.RawPath = ""
+	return true, nil
 }
@@ -1,18 +1,34 @@
 package server
 
 import (
+	"bufio"
+	"context"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
 	"sync"
 	"testing"
+	"time"
 
 	"bytes"
+	"docker-socket-proxy/internal/audit"
 	"docker-socket-proxy/internal/logging"
+	"docker-socket-proxy/internal/management"
 	"docker-socket-proxy/internal/proxy/config"
 	"encoding/json"
+	"errors"
 	"io"
+	"log/slog"
+	"strconv"
 	"strings"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 func TestProxyHandler_ProcessRules(t *testing.T) {
@@ -330,15 +346,15 @@ func TestProxyHandler_ProcessRules(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
 
-			got, reason, err := handler.processRules(tt.request, tt.config)
+			decision, err := handler.processRules(tt.request, tt.config)
 			if err != nil {
 				t.Fatalf("processRules() error = %v", err)
 			}
-			if got != tt.want {
-				t.Errorf("processRules() got = %v, want %v", got, tt.want)
+			if decision.allowed != tt.want {
+				t.Errorf("processRules() got = %v, want %v", decision.allowed, tt.want)
 			}
-			if reason != tt.reason {
-				t.Errorf("processRules() reason = %v, want %v", reason, tt.reason)
+			if decision.reason != tt.reason {
+				t.Errorf("processRules() reason = %v, want %v", decision.reason, tt.reason)
 			}
 		})
 	}
@@ -673,11 +689,18 @@ func TestRegexMatching(t *testing.T) {
 			// Create a request with the specified path and method
 			req := httptest.NewRequest(tt.method, tt.path, nil)
 
-			// Test the rule matching
-			got := handler.ruleMatches(req, tt.match)
+			// Rules are evaluated and rewrites matched by the same matcher
+			if got := config.MatchesRule(req, tt.match); got != tt.want {
+				t.Errorf("MatchesRule() = %v, want %v", got, tt.want)
+			}
 
-			if got != tt.want {
-				t.Errorf("ruleMatches() = %v, want %v", got, tt.want)
+			cfg := &config.SocketConfig{
+				Config: config.ConfigSet{DefaultAction: config.DefaultActionDeny},
+				Rules:  []config.Rule{{Match: tt.match, Actions: []config.Action{{Action: "allow"}}}},
+			}
+			decision, err := handler.processRules(req, cfg)
+			if got := decision.allowed && err == nil; got != tt.want {
+				t.Errorf("processRules() allowed = %v (error %v), want %v", decision.allowed, err, tt.want)
 			}
 		})
 	}
@@ -708,15 +731,15 @@ func TestProxyHandler_BodyRemains_Readable(t *testing.T) {
 	req := httptest.NewRequest("POST", "/v1.24/containers/create", bytes.NewReader(body))
 
 	// Process rules
-	allowed, reason, err := handler.processRules(req, cfg)
+	decision, err := handler.processRules(req, cfg)
 	if err != nil {
 		t.Fatalf("processRules() error = %v", err)
 	}
-	if !allowed {
-		t.Errorf("processRules() got = %v, want true", allowed)
+	if !decision.allowed {
+		t.Errorf("processRules() got = %v, want true", decision.allowed)
 	}
-	if reason != "test" {
-		t.Errorf("processRules() reason = %v, want test", reason)
+	if decision.reason != "test" {
+		t.Errorf("processRules() reason = %v, want test", decision.reason)
 	}
 
 	// Try to read the body again
@@ -728,3 +751,3013 @@ func TestProxyHandler_BodyRemains_Readable(t *testing.T) {
 		t.Errorf("Body was not preserved, got %v, want %v", string(bodyBytes), string(body))
 	}
 }
+
+// newUnixUpstream starts a mock Docker API listening on a unix socket and returns its path
+func newUnixUpstream(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-upstream-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	})
+
+	socketPath := filepath.Join(tmpDir, "docker.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return socketPath
+}
+
+func TestProxyHandler_AllowLogSampling(t *testing.T) {
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var logs bytes.Buffer
+	logging.SetOutput(&logs)
+	defer logging.SetOutput(os.Stdout)
+
+	socketPath := "/tmp/sampled.sock"
+	configs := map[string]*config.SocketConfig{
+		socketPath: {
+			Config: config.ConfigSet{AllowLogSampleRate: 3},
+			Rules: []config.Rule{
+				{
+					Match:   config.Match{Path: "/.*", Method: "GET"},
+					Actions: []config.Action{{Action: "allow"}},
+				},
+			},
+		},
+	}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+
+	for i := 0; i < 20; i++ {
+		req := httptest.NewRequest("GET", "/v1.42/containers/json", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTPWithSocket(w, req, socketPath)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+	}
+
+	if got := strings.Count(logs.String(), `"msg":"Request allowed"`); got != 3 {
+		t.Errorf("expected 3 allow log lines, got %d", got)
+	}
+
+	// The suppressed allows are summarised once flushed
+	handler.flushAllowLogs()
+	if !strings.Contains(logs.String(), `"msg":"Suppressed allow logs","socket":"/tmp/sampled.sock","count":17`) {
+		t.Errorf("expected a summary of 17 suppressed allows, got %s", logs.String())
+	}
+
+	// Without a sample rate allows are only logged at debug
+	configs[socketPath].Config.AllowLogSampleRate = 0
+	logs.Reset()
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest("GET", "/v1.42/containers/json", nil)
+		handler.ServeHTTPWithSocket(httptest.NewRecorder(), req, socketPath)
+	}
+	if got := strings.Count(logs.String(), `"msg":"Request allowed"`); got != 0 {
+		t.Errorf("expected no allow log lines at info without sampling, got %d", got)
+	}
+
+	logging.SetLevel(slog.LevelDebug)
+	defer logging.SetLevel(slog.LevelInfo)
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest("GET", "/v1.42/containers/json", nil)
+		handler.ServeHTTPWithSocket(httptest.NewRecorder(), req, socketPath)
+	}
+	if got := strings.Count(logs.String(), `"level":"DEBUG","msg":"Request allowed"`); got != 5 {
+		t.Errorf("expected 5 debug allow log lines without sampling, got %d", got)
+	}
+}
+
+func TestProxyHandler_AllowLogSummaryAfterTrafficStops(t *testing.T) {
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var logs bytes.Buffer
+	logging.SetOutput(&logs)
+	defer logging.SetOutput(os.Stdout)
+
+	socketPath := "/tmp/sampled.sock"
+	configs := map[string]*config.SocketConfig{
+		socketPath: {
+			Config: config.ConfigSet{AllowLogSampleRate: 1},
+			Rules: []config.Rule{
+				{
+					Match:   config.Match{Path: "/.*", Method: "GET"},
+					Actions: []config.Action{{Action: "allow"}},
+				},
+			},
+		},
+	}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		handler.runAllowLogFlusher(10*time.Millisecond, done)
+		close(stopped)
+	}()
+
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest("GET", "/v1.42/containers/json", nil)
+		handler.ServeHTTPWithSocket(httptest.NewRecorder(), req, socketPath)
+	}
+
+	// No further requests arrive, the flusher alone reports the suppressed allows
+	time.Sleep(100 * time.Millisecond)
+	close(done)
+	<-stopped
+
+	if !strings.Contains(logs.String(), `"msg":"Suppressed allow logs","socket":"/tmp/sampled.sock","count":3`) {
+		t.Errorf("expected a summary of 3 suppressed allows after traffic stopped, got %s", logs.String())
+	}
+}
+
+func TestProxyHandler_AllowLogSummaryOnSocketDelete(t *testing.T) {
+	var logs bytes.Buffer
+	logging.SetOutput(&logs)
+	defer logging.SetOutput(os.Stdout)
+
+	handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+	sampler := &logSampler{}
+	for i := 0; i < 3; i++ {
+		sampler.allow(time.Now(), 1)
+	}
+	handler.samplers["/tmp/deleted.sock"] = sampler
+
+	handler.forgetSocket("/tmp/deleted.sock")
+
+	if !strings.Contains(logs.String(), `"msg":"Suppressed allow logs","socket":"/tmp/deleted.sock","count":2`) {
+		t.Errorf("expected a summary of 2 suppressed allows on delete, got %s", logs.String())
+	}
+}
+
+func TestLogSampler_SummarisesSuppressed(t *testing.T) {
+	sampler := &logSampler{}
+	start := time.Now()
+
+	for i := 0; i < 5; i++ {
+		sampler.allow(start, 2)
+	}
+	if !sampler.allow(start.Add(time.Second), 2) {
+		t.Error("expected first log of a new window to be allowed")
+	}
+
+	if suppressed := sampler.flush(); suppressed != 3 {
+		t.Errorf("expected 3 suppressed logs to be reported, got %d", suppressed)
+	}
+	if suppressed := sampler.flush(); suppressed != 0 {
+		t.Errorf("expected the count to reset after a flush, got %d", suppressed)
+	}
+}
+
+func TestProxyHandler_RequireIdentity(t *testing.T) {
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{
+				Match: config.Match{
+					Path:            "/v1.*/containers/create",
+					Method:          "POST",
+					RequireIdentity: true,
+				},
+				Actions: []config.Action{
+					{
+						Action: "deny",
+						Reason: "Anonymous mutations are not allowed",
+					},
+				},
+			},
+		},
+	}
+
+	handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+
+	anonymous := httptest.NewRequest("POST", "/v1.42/containers/create", nil)
+	decision, err := handler.processRules(anonymous, cfg)
+	if err != nil {
+		t.Fatalf("processRules() error = %v", err)
+	}
+	if decision.allowed {
+		t.Error("expected anonymous request to be denied")
+	}
+	if decision.reason != "Anonymous mutations are not allowed" {
+		t.Errorf("unexpected reason %q", decision.reason)
+	}
+
+	identified := httptest.NewRequest("POST", "/v1.42/containers/create", nil)
+	identified = identified.WithContext(config.ContextWithIdentity(identified.Context(), &config.Identity{UID: 1000}))
+	decision, err = handler.processRules(identified, cfg)
+	if err != nil {
+		t.Fatalf("processRules() error = %v", err)
+	}
+	if !decision.allowed {
+		t.Error("expected identified request to be allowed")
+	}
+}
+
+func TestProxyHandler_PeerCredentials(t *testing.T) {
+	ciUID := uint32(1000)
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{
+				Match:   config.Match{Path: "/v1.*/containers/create", Method: "POST", PeerUID: &ciUID},
+				Actions: []config.Action{{Action: "allow", Reason: "CI may create containers"}},
+			},
+			{
+				Match:   config.Match{Path: "/v1.*/containers/create", Method: "POST"},
+				Actions: []config.Action{{Action: "deny", Reason: "Only CI may create containers"}},
+			},
+		},
+	}
+
+	socketPath := "/tmp/test-socket.sock"
+	configs := map[string]*config.SocketConfig{socketPath: cfg}
+	handler := NewProxyHandler("/tmp/docker.sock", configs, &sync.RWMutex{})
+
+	// Unsampled allows are logged at debug
+	var logs bytes.Buffer
+	logging.SetOutput(&logs)
+	defer logging.SetOutput(os.Stdout)
+	logging.SetLevel(slog.LevelDebug)
+	defer logging.SetLevel(slog.LevelInfo)
+
+	tests := []struct {
+		name       string
+		identity   *config.Identity
+		wantStatus int
+		wantLog    string
+	}{
+		{
+			name:       "matching uid is allowed",
+			identity:   &config.Identity{UID: 1000, GID: 1000, PID: 42},
+			wantStatus: http.StatusBadGateway,
+			wantLog:    `"peer_uid":1000,"peer_gid":1000,"peer_pid":42`,
+		},
+		{
+			name:       "other uid is denied",
+			identity:   &config.Identity{UID: 1001, GID: 1001, PID: 43},
+			wantStatus: http.StatusForbidden,
+			wantLog:    `"peer_uid":1001,"peer_gid":1001,"peer_pid":43`,
+		},
+		{
+			name:       "anonymous is denied",
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+
+			req := httptest.NewRequest("POST", "/v1.42/containers/create", strings.NewReader(`{"Image":"alpine"}`))
+			if tt.identity != nil {
+				req = req.WithContext(config.ContextWithIdentity(req.Context(), tt.identity))
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(rr, req, socketPath)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if tt.wantLog != "" && !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("logs = %s, want them to contain %s", logs.String(), tt.wantLog)
+			}
+			if tt.identity == nil && strings.Contains(logs.String(), "peer_uid") {
+				t.Errorf("logs = %s, want no peer credentials", logs.String())
+			}
+		})
+	}
+}
+
+func TestPeerCredContext(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	socketPath := filepath.Join(tmpDir, "peer.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identities := make(chan *config.Identity, 1)
+	server := &http.Server{
+		ConnContext: peerCredContext,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, _ := config.IdentityFromContext(r.Context())
+			identities <- id
+		}),
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			t.Errorf("Serve() error = %v", err)
+		}
+	}()
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", socketPath)
+			},
+		},
+	}
+	resp, err := client.Get("http://docker/_ping")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		t.Errorf("Failed to close response body: %v", err)
+	}
+
+	id := <-identities
+	if id == nil {
+		t.Fatal("expected peer credentials in the request context")
+	}
+	if id.UID != uint32(os.Getuid()) || id.GID != uint32(os.Getgid()) {
+		t.Errorf("unexpected identity %+v", id)
+	}
+	if id.PID != int32(os.Getpid()) {
+		t.Errorf("expected pid %d, got %d", os.Getpid(), id.PID)
+	}
+}
+
+func TestProxyHandler_ImageRegistry(t *testing.T) {
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{
+				Match: config.Match{
+					Path:  "/v1.*/(containers|images)/create",
+					Image: `^registry\.example\.com/`,
+				},
+				Actions: []config.Action{{Action: "allow"}},
+			},
+			{
+				Match: config.Match{Path: "/v1.*/(containers|images)/create"},
+				Actions: []config.Action{
+					{
+						Action: "deny",
+						Reason: "Only images from registry.example.com are allowed",
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		want   bool
+	}{
+		{
+			name:   "create from allowed registry",
+			target: "/v1.42/containers/create",
+			body:   `{"Image":"registry.example.com/team/app:1.0"}`,
+			want:   true,
+		},
+		{
+			name:   "create from disallowed registry",
+			target: "/v1.42/containers/create",
+			body:   `{"Image":"nginx:latest"}`,
+			want:   false,
+		},
+		{
+			name:   "pull from allowed registry",
+			target: "/v1.42/images/create?fromImage=registry.example.com/team/app&tag=1.0",
+			want:   true,
+		},
+		{
+			name:   "pull from disallowed registry",
+			target: "/v1.42/images/create?fromImage=docker.io/library/nginx&tag=latest",
+			want:   false,
+		},
+	}
+
+	handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.target, strings.NewReader(tt.body))
+			decision, err := handler.processRules(req, cfg)
+			if err != nil {
+				t.Fatalf("processRules() error = %v", err)
+			}
+			if decision.allowed != tt.want {
+				t.Errorf("processRules() allowed = %v, want %v", decision.allowed, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_Headers(t *testing.T) {
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{
+				Match: config.Match{
+					Path:    "/v1.*/images/create",
+					Headers: map[string]string{"X-Registry-Auth": "^$"},
+				},
+				Actions: []config.Action{{Action: "deny", Reason: "Pulls must be authenticated"}},
+			},
+			{
+				Match: config.Match{
+					Path:    "/.*",
+					Headers: map[string]string{"X-Sidecar": "^trusted$"},
+				},
+				Actions: []config.Action{{Action: "allow"}},
+			},
+			{
+				Match:   config.Match{Path: "/.*"},
+				Actions: []config.Action{{Action: "deny", Reason: "Not from the sidecar"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		want    bool
+	}{
+		{
+			name:    "pull without registry auth",
+			target:  "/v1.42/images/create?fromImage=nginx",
+			headers: map[string]string{"X-Sidecar": "trusted"},
+			want:    false,
+		},
+		{
+			name:    "pull with registry auth",
+			target:  "/v1.42/images/create?fromImage=nginx",
+			headers: map[string]string{"X-Sidecar": "trusted", "X-Registry-Auth": "e30="},
+			want:    true,
+		},
+		{
+			name:    "untrusted sidecar",
+			target:  "/v1.42/containers/json",
+			headers: map[string]string{"X-Sidecar": "other"},
+			want:    false,
+		},
+	}
+
+	handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.target, nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			decision, err := handler.processRules(req, cfg)
+			if err != nil {
+				t.Fatalf("processRules() error = %v", err)
+			}
+			if decision.allowed != tt.want {
+				t.Errorf("processRules() allowed = %v, want %v", decision.allowed, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_BuildQuery(t *testing.T) {
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{
+				Match: config.Match{
+					Path:   "/v1.*/build",
+					Method: "POST",
+					Query: map[string]any{
+						"buildargs": map[string]any{"NPM_TOKEN": ".*"},
+					},
+				},
+				Actions: []config.Action{{Action: "deny", Reason: "Secrets must not be passed as build args"}},
+			},
+			{
+				Match: config.Match{
+					Path:   "/v1.*/build",
+					Method: "POST",
+					Query:  map[string]any{"dockerfile": "^(Dockerfile)?$"},
+				},
+				Actions: []config.Action{{Action: "allow"}},
+			},
+			{
+				Match:   config.Match{Path: "/v1.*/build"},
+				Actions: []config.Action{{Action: "deny", Reason: "Only the default Dockerfile may be built"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		query      url.Values
+		want       bool
+		wantReason string
+	}{
+		{
+			name:  "allowed build args",
+			query: url.Values{"t": {"app:1.0"}, "buildargs": {`{"HTTP_PROXY":"http://proxy:3128"}`}},
+			want:  true,
+		},
+		{
+			name:       "disallowed build arg",
+			query:      url.Values{"t": {"app:1.0"}, "buildargs": {`{"HTTP_PROXY":"http://proxy:3128","NPM_TOKEN":"abc"}`}},
+			want:       false,
+			wantReason: "Secrets must not be passed as build args",
+		},
+		{
+			name:       "other dockerfile",
+			query:      url.Values{"dockerfile": {"ci/Dockerfile.debug"}},
+			want:       false,
+			wantReason: "Only the default Dockerfile may be built",
+		},
+	}
+
+	handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The build context is a tar stream, not JSON
+			req := httptest.NewRequest("POST", "/v1.42/build?"+tt.query.Encode(), strings.NewReader("Dockerfile\x00\x00\x00"))
+			req.Header.Set("Content-Type", "application/x-tar")
+
+			decision, err := handler.processRules(req, cfg)
+			if err != nil {
+				t.Fatalf("processRules() error = %v", err)
+			}
+			if decision.allowed != tt.want || decision.reason != tt.wantReason {
+				t.Errorf("processRules() = %v, %q, want %v, %q", decision.allowed, decision.reason, tt.want, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_ContainerName(t *testing.T) {
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{
+				Match: config.Match{
+					Path:   "/v1.*/containers/create",
+					Method: "POST",
+					Query:  map[string]any{"name": "^prod-"},
+				},
+				Actions: []config.Action{{Action: "deny", Reason: "Production container names are reserved"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   bool
+	}{
+		{name: "production name", target: "/v1.42/containers/create?name=prod-api", want: false},
+		{name: "encoded production name", target: "/v1.42/containers/create?name=%70rod-api", want: false},
+		{name: "production name among other parameters", target: "/v1.42/containers/create?platform=linux&name=prod-db", want: false},
+		{name: "other name", target: "/v1.42/containers/create?name=dev-api", want: true},
+		{name: "name containing prod", target: "/v1.42/containers/create?name=api-prod-1", want: true},
+		{name: "generated name", target: "/v1.42/containers/create", want: true},
+	}
+
+	handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The name is only in the query, the body does not change the outcome
+			req := httptest.NewRequest("POST", tt.target, strings.NewReader(`{"Image":"nginx"}`))
+			// The deny rule matches exactly the requests that are not allowed
+			if got := config.MatchesRule(req, cfg.Rules[0].Match); got == tt.want {
+				t.Errorf("MatchesRule() = %v for %s, want %v", got, tt.target, !tt.want)
+			}
+
+			decision, err := handler.processRules(req, cfg)
+			if err != nil {
+				t.Fatalf("processRules() error = %v", err)
+			}
+			if decision.allowed != tt.want {
+				t.Errorf("processRules() allowed = %v, want %v", decision.allowed, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_DefaultDeny(t *testing.T) {
+	rules := []config.Rule{
+		{
+			Match:   config.Match{Path: "/v1.*/containers/json", Method: "GET"},
+			Actions: []config.Action{{Action: "allow"}},
+		},
+		{
+			Match:   config.Match{Path: "/v1.*/containers/create", Method: "POST"},
+			Actions: []config.Action{{Action: "allow"}},
+		},
+		{
+			Match:   config.Match{Path: "/v1.*/volumes/create", Method: "POST"},
+			Actions: []config.Action{{Action: "upsert", Update: map[string]any{"Labels": map[string]any{"proxied": "true"}}}},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		defaultAction string
+		method        string
+		path          string
+		body          string
+		want          bool
+		wantReason    string
+	}{
+		{name: "allowed in deny mode", defaultAction: "deny", method: "GET", path: "/v1.42/containers/json", want: true},
+		{name: "unmatched in deny mode", defaultAction: "deny", method: "GET", path: "/v1.42/images/json", want: false, wantReason: "no matching allow rule"},
+		{name: "rewrite only in deny mode", defaultAction: "deny", method: "POST", path: "/v1.42/volumes/create", body: `{}`, want: false, wantReason: "no matching allow rule"},
+		{name: "unmatched in allow mode", defaultAction: "allow", method: "GET", path: "/v1.42/images/json", want: true},
+		{name: "unmatched with no default", method: "GET", path: "/v1.42/images/json", want: true},
+	}
+
+	handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.SocketConfig{
+				Config: config.ConfigSet{DefaultAction: tt.defaultAction},
+				Rules:  rules,
+			}
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			decision, err := handler.processRules(req, cfg)
+			if err != nil {
+				t.Fatalf("processRules() error = %v", err)
+			}
+			if decision.allowed != tt.want || decision.reason != tt.wantReason {
+				t.Errorf("processRules() = %v, %q, want %v, %q", decision.allowed, decision.reason, tt.want, tt.wantReason)
+			}
+		})
+	}
+
+	// An empty rule set denies everything in deny mode
+	cfg := &config.SocketConfig{Config: config.ConfigSet{DefaultAction: "deny"}}
+	if decision, _ := handler.processRules(httptest.NewRequest("GET", "/_ping", nil), cfg); decision.allowed {
+		t.Error("expected an empty rule set to deny in deny mode")
+	}
+}
+
+func TestProxyHandler_PropagateSocket(t *testing.T) {
+	for _, defaultAction := range []string{"allow", "deny"} {
+		t.Run(defaultAction, func(t *testing.T) {
+			cfg := &config.SocketConfig{
+				Config: config.ConfigSet{
+					PropagateSocket: "/var/run/docker-proxy/ci.sock",
+					DefaultAction:   defaultAction,
+				},
+				Rules: []config.Rule{
+					{
+						Match:   config.Match{Path: "/v1.*/containers/create", Method: "POST"},
+						Actions: []config.Action{{Action: "allow"}},
+					},
+				},
+			}
+
+			handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+			req := httptest.NewRequest("POST", "/v1.42/containers/create", strings.NewReader(`{"Image":"nginx"}`))
+			decision, err := handler.processRules(req, cfg)
+			if err != nil {
+				t.Fatalf("processRules() error = %v", err)
+			}
+			if !decision.allowed {
+				t.Fatalf("expected create to be allowed, got reason %q", decision.reason)
+			}
+
+			var body map[string]any
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode rewritten body: %v", err)
+			}
+			hostConfig, _ := body["HostConfig"].(map[string]any)
+			binds, _ := hostConfig["Binds"].([]any)
+			want := "/var/run/docker-proxy/ci.sock:/var/run/docker-proxy/ci.sock:ro"
+			if len(binds) != 1 || binds[0] != want {
+				t.Errorf("Binds = %v, want [%s]", binds, want)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_RawQuery(t *testing.T) {
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{
+				Match: config.Match{
+					Path:     "/v1.*/containers/json",
+					Method:   "GET",
+					RawQuery: `"label":\[[^\]]*"env=prod"`,
+				},
+				Actions: []config.Action{{Action: "deny", Reason: "Production containers are hidden"}},
+			},
+		},
+	}
+
+	handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+
+	tests := []struct {
+		name    string
+		filters string
+		want    bool
+	}{
+		{name: "prod label filter", filters: `{"label":["team=payments","env=prod"]}`, want: false},
+		{name: "other label filter", filters: `{"label":["env=dev"],"status":["exited"]}`, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "/v1.42/containers/json?" + url.Values{"filters": {tt.filters}}.Encode()
+			decision, err := handler.processRules(httptest.NewRequest("GET", target, nil), cfg)
+			if err != nil {
+				t.Fatalf("processRules() error = %v", err)
+			}
+			if decision.allowed != tt.want {
+				t.Errorf("processRules() allowed = %v, want %v", decision.allowed, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_Tracing(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("traceparent"), traceID) {
+			t.Errorf("expected upstream traceparent to continue trace %s, got %q", traceID, r.Header.Get("traceparent"))
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	socketPath := "/tmp/traced.sock"
+	configs := map[string]*config.SocketConfig{
+		socketPath: {
+			Rules: []config.Rule{
+				{
+					Match:   config.Match{Path: "/containers/create", Method: "POST"},
+					Actions: []config.Action{{Action: "allow", Reason: "creates are fine"}},
+				},
+			},
+		},
+	}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+	handler.tracerProvider = tp
+
+	req := httptest.NewRequest("POST", "/containers/create", strings.NewReader(`{}`))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	handler.ServeHTTPWithSocket(w, req, socketPath)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", w.Code)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.SpanContext.TraceID().String() != traceID {
+		t.Errorf("expected span to continue trace %s, got %s", traceID, span.SpanContext.TraceID())
+	}
+
+	attrs := make(map[string]string)
+	for _, kv := range span.Attributes {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	want := map[string]string{
+		"proxy.socket":              socketPath,
+		"http.request.method":       "POST",
+		"url.path":                  "/containers/create",
+		"proxy.decision":            "allow",
+		"http.response.status_code": "201",
+	}
+	for key, value := range want {
+		if attrs[key] != value {
+			t.Errorf("attribute %s = %q, want %q", key, attrs[key], value)
+		}
+	}
+}
+
+func TestProxyHandler_TracingDisabled(t *testing.T) {
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	socketPath := "/tmp/untraced.sock"
+	configs := map[string]*config.SocketConfig{socketPath: {}}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+
+	if _, ok := handler.tracer().(noop.Tracer); !ok {
+		t.Errorf("expected a no-op tracer when tracing is disabled, got %T", handler.tracer())
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTPWithSocket(w, httptest.NewRequest("GET", "/_ping", nil), socketPath)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+}
+
+func TestProxyHandler_ResponseRewrite(t *testing.T) {
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/containers/abc/json":
+			_, _ = w.Write([]byte(`{"Id":"abc","Config":{"Env":["SECRET=1","PATH=/bin"],"Image":"nginx"}}`))
+		case "/containers/json":
+			_, _ = w.Write([]byte(`[{"Id":"a","Labels":{"secret":"x","team":"ci"}},{"Id":"b","Labels":{"team":"ops"}}]`))
+		case "/images/json":
+			_, _ = w.Write([]byte(`[{"Id":"sha256:1"}]`))
+		}
+	}))
+
+	stripEnv := config.Action{
+		Action:   "delete",
+		Phase:    config.PhaseResponse,
+		Contains: map[string]any{"Config": map[string]any{"Env": []any{map[string]any{"$env": "*"}}}},
+	}
+	stripLabel := config.Action{
+		Action:   "delete",
+		Phase:    config.PhaseResponse,
+		Contains: map[string]any{"Labels": map[string]any{"secret": ".*"}},
+	}
+
+	socketPath := "/tmp/response-rewrite.sock"
+	configs := map[string]*config.SocketConfig{
+		socketPath: {
+			Rules: []config.Rule{
+				{
+					Match:   config.Match{Path: "^/containers/[^/]+/json$", Method: "GET"},
+					Actions: []config.Action{{Action: "allow"}, stripEnv},
+				},
+				{
+					Match:   config.Match{Path: "^/containers/json$", Method: "GET"},
+					Actions: []config.Action{stripLabel, {Action: "allow"}},
+				},
+			},
+		},
+	}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{
+			name: "object response",
+			path: "/containers/abc/json",
+			want: `{"Config":{"Image":"nginx"},"Id":"abc"}`,
+		},
+		{
+			name: "array response",
+			path: "/containers/json",
+			want: `[{"Id":"a","Labels":{"team":"ci"}},{"Id":"b","Labels":{"team":"ops"}}]`,
+		},
+		{
+			name: "no response actions",
+			path: "/images/json",
+			want: `[{"Id":"sha256:1"}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, httptest.NewRequest("GET", tt.path, nil), socketPath)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", w.Code)
+			}
+			got := strings.TrimSpace(w.Body.String())
+			if got != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+			if cl := w.Header().Get("Content-Length"); cl != "" && cl != strconv.Itoa(w.Body.Len()) {
+				t.Errorf("Content-Length = %s, body length %d", cl, w.Body.Len())
+			}
+		})
+	}
+}
+
+func TestProxyHandler_RuleHits(t *testing.T) {
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	socketPath := "/tmp/rule-hits.sock"
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{
+				Match:   config.Match{Path: "^/containers/create$", Method: "POST"},
+				Actions: []config.Action{{Action: "upsert", Update: map[string]any{"Labels": map[string]any{"proxied": "true"}}}},
+			},
+			{
+				Match:   config.Match{Path: "^/containers/", Method: "GET|POST"},
+				Actions: []config.Action{{Action: "allow"}},
+			},
+			{
+				Match:   config.Match{Path: "^/images/"},
+				Actions: []config.Action{{Action: "deny", Reason: "no images"}},
+			},
+			{
+				Match:   config.Match{Path: "^/never$"},
+				Actions: []config.Action{{Action: "allow"}},
+			},
+		},
+	}
+	configs := map[string]*config.SocketConfig{socketPath: cfg}
+	configMu := &sync.RWMutex{}
+	handler := NewProxyHandler(upstream, configs, configMu)
+
+	requests := []*http.Request{
+		httptest.NewRequest("GET", "/containers/json", nil),
+		httptest.NewRequest("POST", "/containers/create", strings.NewReader(`{"Image":"nginx"}`)),
+		httptest.NewRequest("GET", "/images/json", nil),
+		httptest.NewRequest("DELETE", "/images/abc", nil),
+		httptest.NewRequest("GET", "/info", nil),
+	}
+	for _, req := range requests {
+		handler.ServeHTTPWithSocket(httptest.NewRecorder(), req, socketPath)
+	}
+
+	// The rewrite rule and the allow rule both match the create, while the
+	// unmatched /info request counts against no rule
+	hits, _ := handler.snapshotRuleHits(socketPath, cfg)
+	want := []uint64{1, 2, 2, 0}
+	if len(hits) != len(want) {
+		t.Fatalf("got %d counters, want %d", len(hits), len(want))
+	}
+	for i := range want {
+		if hits[i] != want[i] {
+			t.Errorf("rule %d hits = %d, want %d", i, hits[i], want[i])
+		}
+	}
+
+	// Replacing the config starts the counters again
+	updated := &config.SocketConfig{Rules: cfg.Rules[1:]}
+	configMu.Lock()
+	configs[socketPath] = updated
+	configMu.Unlock()
+
+	handler.ServeHTTPWithSocket(httptest.NewRecorder(), httptest.NewRequest("GET", "/images/json", nil), socketPath)
+
+	hits, _ = handler.snapshotRuleHits(socketPath, updated)
+	want = []uint64{0, 1, 0}
+	for i := range want {
+		if hits[i] != want[i] {
+			t.Errorf("after update rule %d hits = %d, want %d", i, hits[i], want[i])
+		}
+	}
+}
+
+func TestProxyHandler_RateLimit(t *testing.T) {
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{
+				Match: config.Match{Path: "/v1.*/containers/create", Method: "POST"},
+				Actions: []config.Action{
+					{Action: "ratelimit", Limit: 2, Window: "1m"},
+					{Action: "allow"},
+				},
+			},
+		},
+	}
+	socketA := "/tmp/limited-a.sock"
+	socketB := "/tmp/limited-b.sock"
+	configs := map[string]*config.SocketConfig{socketA: cfg, socketB: cfg}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+
+	send := func(socketPath string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1.42/containers/create", strings.NewReader(`{"Image":"alpine"}`))
+		w := httptest.NewRecorder()
+		handler.ServeHTTPWithSocket(w, req, socketPath)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := send(socketA); w.Code != http.StatusCreated {
+			t.Fatalf("request %d status = %d, want %d", i, w.Code, http.StatusCreated)
+		}
+	}
+
+	w := send(socketA)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "30" {
+		t.Errorf("Retry-After = %q, want %q", retryAfter, "30")
+	}
+	if !strings.Contains(w.Body.String(), rateLimitedReason) {
+		t.Errorf("body = %q, want it to contain %q", w.Body.String(), rateLimitedReason)
+	}
+
+	// Each socket has its own budget
+	if w := send(socketB); w.Code != http.StatusCreated {
+		t.Errorf("other socket status = %d, want %d", w.Code, http.StatusCreated)
+	}
+
+	// Changing the limit starts a new bucket
+	configs[socketA] = &config.SocketConfig{
+		Rules: []config.Rule{
+			{
+				Match: config.Match{Path: "/v1.*/containers/create", Method: "POST"},
+				Actions: []config.Action{
+					{Action: "ratelimit", Limit: 3, Window: "1m", Reason: "slow down"},
+					{Action: "allow"},
+				},
+			},
+		},
+	}
+	if w := send(socketA); w.Code != http.StatusCreated {
+		t.Errorf("after raising the limit status = %d, want %d", w.Code, http.StatusCreated)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Now()
+	limiter := newRateLimiter(2, time.Minute, start)
+
+	steps := []struct {
+		at        time.Duration
+		wantOK    bool
+		wantAfter time.Duration
+	}{
+		{at: 0, wantOK: true},
+		{at: 0, wantOK: true},
+		{at: 0, wantOK: false, wantAfter: 30 * time.Second},
+		{at: 10 * time.Second, wantOK: false, wantAfter: 20 * time.Second},
+		{at: 30 * time.Second, wantOK: true},
+		{at: 30 * time.Second, wantOK: false, wantAfter: 30 * time.Second},
+		// A long idle period refills the bucket but not beyond the limit
+		{at: 10 * time.Minute, wantOK: true},
+		{at: 10 * time.Minute, wantOK: true},
+		{at: 10 * time.Minute, wantOK: false, wantAfter: 30 * time.Second},
+	}
+
+	for i, step := range steps {
+		ok, wait := limiter.allow(start.Add(step.at))
+		if ok != step.wantOK {
+			t.Fatalf("step %d allow() = %v, want %v", i, ok, step.wantOK)
+		}
+		if wait.Round(time.Millisecond) != step.wantAfter {
+			t.Errorf("step %d wait = %v, want %v", i, wait, step.wantAfter)
+		}
+	}
+}
+
+// failingBody is a request body whose reads always fail
+type failingBody struct{}
+
+func (failingBody) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
+func (failingBody) Close() error             { return nil }
+
+func TestProxyHandler_OnError(t *testing.T) {
+	var forwarded int
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded++
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read forwarded body: %v", err)
+		}
+		if r.Method == "POST" && string(body) != `{"Image":"alpine"}` {
+			t.Errorf("forwarded body = %q, want the original body", body)
+		}
+		if r.URL.Path != "/v1.42/containers/json" {
+			t.Errorf("forwarded path = %q, want the original path", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// Configs that bypassed validation, each with a deny rule that cannot be evaluated
+	brokenConfigs := map[string]*config.SocketConfig{
+		"path": {Rules: []config.Rule{
+			{Match: config.Match{Path: "/containers/(json"}, Actions: []config.Action{{Action: "deny", Reason: "no"}}},
+		}},
+		"header": {Rules: []config.Rule{
+			{Match: config.Match{Path: "/", Headers: map[string]string{"User-Agent": "curl/[0-9"}}, Actions: []config.Action{{Action: "deny", Reason: "no"}}},
+		}},
+		"query": {Rules: []config.Rule{
+			{Match: config.Match{Path: "/", Query: map[string]any{"all": "(1"}}, Actions: []config.Action{{Action: "deny", Reason: "no"}}},
+		}},
+		"raw query": {Rules: []config.Rule{
+			{Match: config.Match{Path: "/", RawQuery: "*"}, Actions: []config.Action{{Action: "deny", Reason: "no"}}},
+		}},
+		"image": {Rules: []config.Rule{
+			{Match: config.Match{Path: "/", Image: "alpine[:"}, Actions: []config.Action{{Action: "deny", Reason: "no"}}},
+		}},
+		"method": {Rules: []config.Rule{
+			{Match: config.Match{Path: "/", Method: "(POST"}, Actions: []config.Action{{Action: "deny", Reason: "no"}}},
+		}},
+		"after rewrites": {Rules: []config.Rule{
+			{
+				Match: config.Match{Path: "/containers/json$", Contains: map[string]any{"Image": "alpine"}},
+				Actions: []config.Action{
+					{Action: "rewrite-path", Pattern: "json", Replacement: "other"},
+					{Action: "upsert", Update: map[string]any{"Labels": map[string]any{"proxied": "true"}}},
+				},
+			},
+			{Match: config.Match{Path: "/containers/(other"}, Actions: []config.Action{{Action: "deny", Reason: "no"}}},
+		}},
+	}
+
+	for _, policy := range []string{OnErrorDeny, OnErrorAllow} {
+		for name, cfg := range brokenConfigs {
+			t.Run(policy+"/"+name, func(t *testing.T) {
+				socketPath := "/tmp/on-error.sock"
+				handler := NewProxyHandler(upstream, map[string]*config.SocketConfig{socketPath: cfg}, &sync.RWMutex{})
+				handler.onError = policy
+				forwarded = 0
+
+				req := httptest.NewRequest("POST", "/v1.42/containers/json", strings.NewReader(`{"Image":"alpine"}`))
+				w := httptest.NewRecorder()
+				handler.ServeHTTPWithSocket(w, req, socketPath)
+
+				wantStatus, wantForwarded := http.StatusInternalServerError, 0
+				if policy == OnErrorAllow {
+					wantStatus, wantForwarded = http.StatusOK, 1
+				}
+				if w.Code != wantStatus {
+					t.Errorf("status = %d, want %d", w.Code, wantStatus)
+				}
+				if forwarded != wantForwarded {
+					t.Errorf("forwarded %d requests, want %d", forwarded, wantForwarded)
+				}
+
+				// Either way the decision is counted
+				stats := handler.snapshotStats(socketPath)
+				if policy == OnErrorAllow && (stats.Allowed != 1 || stats.Denied != 0) {
+					t.Errorf("stats = %+v, want one allowed request", stats)
+				}
+				if policy == OnErrorDeny && (stats.Allowed != 0 || stats.Denied != 1) {
+					t.Errorf("stats = %+v, want one denied request", stats)
+				}
+			})
+		}
+
+		t.Run(policy+"/unreadable body", func(t *testing.T) {
+			socketPath := "/tmp/on-error.sock"
+			// The rule inspects the body, so the proxy has to read it
+			cfg := &config.SocketConfig{Rules: []config.Rule{
+				{Match: config.Match{Path: "/", Contains: map[string]any{"Image": "alpine"}}, Actions: []config.Action{{Action: "allow"}}},
+			}}
+			handler := NewProxyHandler(upstream, map[string]*config.SocketConfig{socketPath: cfg}, &sync.RWMutex{})
+			handler.onError = policy
+			forwarded = 0
+
+			req := httptest.NewRequest("POST", "/v1.42/containers/create", nil)
+			req.Body = failingBody{}
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, req, socketPath)
+
+			if w.Code != http.StatusInternalServerError {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+			}
+			if forwarded != 0 {
+				t.Errorf("forwarded %d requests, want none", forwarded)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_AuditLog(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	auditPath := filepath.Join(tmpDir, "audit.jsonl")
+	socketPath := filepath.Join(tmpDir, "ci.sock")
+	cfg := &config.SocketConfig{
+		Config: config.ConfigSet{AuditLog: auditPath},
+		Rules: []config.Rule{
+			{
+				Match:   config.Match{Path: "/v1.*/containers/create", Method: "POST"},
+				Actions: []config.Action{{Action: "deny", Reason: "creates are not allowed"}},
+			},
+			{
+				Match:   config.Match{Path: "/v1.*/containers/json", Method: "GET"},
+				Actions: []config.Action{{Action: "allow", Reason: "listing is fine"}},
+			},
+		},
+	}
+	handler := NewProxyHandler(upstream, map[string]*config.SocketConfig{socketPath: cfg}, &sync.RWMutex{})
+
+	requests := []struct {
+		method string
+		path   string
+	}{
+		{"GET", "/v1.42/containers/json"},
+		{"POST", "/v1.42/containers/create"},
+		{"GET", "/v1.42/info"},
+	}
+	for _, request := range requests {
+		req := httptest.NewRequest(request.method, request.path, strings.NewReader(`{}`))
+		req = req.WithContext(config.ContextWithIdentity(req.Context(), &config.Identity{UID: 1000, GID: 1000, PID: 42}))
+		handler.ServeHTTPWithSocket(httptest.NewRecorder(), req, socketPath)
+	}
+	handler.closeAuditLogs()
+
+	content, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != len(requests) {
+		t.Fatalf("audit log has %d lines, want %d: %s", len(lines), len(requests), content)
+	}
+
+	want := []audit.Entry{
+		{Socket: "ci.sock", Method: "GET", Path: "/v1.42/containers/json", Rule: 1, ActionIndex: 0, Action: "allow", Reason: "listing is fine"},
+		{Socket: "ci.sock", Method: "POST", Path: "/v1.42/containers/create", Rule: 0, ActionIndex: 0, Action: "deny", Reason: "creates are not allowed"},
+		{Socket: "ci.sock", Method: "GET", Path: "/v1.42/info", Rule: -1, ActionIndex: -1, Action: "allow"},
+	}
+	for i, line := range lines {
+		var got audit.Entry
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("line %d is not JSON: %v", i, err)
+		}
+		if got.Time.IsZero() {
+			t.Errorf("line %d has no timestamp", i)
+		}
+		if got.Peer.UID == nil || *got.Peer.UID != 1000 || got.Peer.PID == nil || *got.Peer.PID != 42 {
+			t.Errorf("line %d peer = %+v, want uid 1000 and pid 42", i, got.Peer)
+		}
+		got.Time, got.Peer = time.Time{}, audit.Peer{}
+		if got != want[i] {
+			t.Errorf("line %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestProxyHandler_Profiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		method  string
+		path    string
+		body    string
+		want    bool
+	}{
+		{name: "read-only allows listing", profile: "read-only", method: "GET", path: "/v1.42/containers/json", want: true},
+		{name: "read-only denies create", profile: "read-only", method: "POST", path: "/v1.42/containers/create", body: `{"Image":"alpine"}`, want: false},
+		{name: "read-only denies delete", profile: "read-only", method: "DELETE", path: "/v1.42/containers/abc", want: false},
+		{name: "no-privileged allows plain create", profile: "no-privileged", method: "POST", path: "/v1.42/containers/create", body: `{"Image":"alpine"}`, want: true},
+		{name: "no-privileged denies privileged create", profile: "no-privileged", method: "POST", path: "/v1.42/containers/create", body: `{"Image":"alpine","HostConfig":{"Privileged":true}}`, want: false},
+		{name: "no-privileged denies unversioned privileged create", profile: "no-privileged", method: "POST", path: "/containers/create", body: `{"HostConfig":{"Privileged":true}}`, want: false},
+		{name: "no-privileged denies privileged exec", profile: "no-privileged", method: "POST", path: "/v1.42/containers/abc/exec", body: `{"Cmd":["sh"],"Privileged":true}`, want: false},
+		{name: "no-privileged allows exec", profile: "no-privileged", method: "POST", path: "/v1.42/containers/abc/exec", body: `{"Cmd":["sh"]}`, want: true},
+		{name: "no-host-namespaces denies host network", profile: "no-host-namespaces", method: "POST", path: "/v1.42/containers/create", body: `{"HostConfig":{"NetworkMode":"host"}}`, want: false},
+		{name: "no-host-namespaces denies host pid", profile: "no-host-namespaces", method: "POST", path: "/v1.42/containers/create", body: `{"HostConfig":{"PidMode":"host"}}`, want: false},
+		{name: "no-host-namespaces allows bridge network", profile: "no-host-namespaces", method: "POST", path: "/v1.42/containers/create", body: `{"HostConfig":{"NetworkMode":"bridge"}}`, want: true},
+		{name: "no-docker-socket denies socket bind", profile: "no-docker-socket", method: "POST", path: "/v1.42/containers/create", body: `{"HostConfig":{"Binds":["/data:/data","/var/run/docker.sock:/var/run/docker.sock:ro"]}}`, want: false},
+		{name: "no-docker-socket allows other binds", profile: "no-docker-socket", method: "POST", path: "/v1.42/containers/create", body: `{"HostConfig":{"Binds":["/data:/data"]}}`, want: true},
+		{name: "no-exec denies exec", profile: "no-exec", method: "POST", path: "/v1.42/containers/abc/exec", body: `{"Cmd":["sh"]}`, want: false},
+		{name: "no-exec allows starting an exec", profile: "no-exec", method: "POST", path: "/v1.42/exec/abc/start", body: `{}`, want: true},
+	}
+
+	handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.SocketConfig{
+				Profiles: []string{tt.profile},
+				Rules: []config.Rule{
+					{Match: config.Match{Path: "^/"}, Actions: []config.Action{{Action: "allow", Reason: "user rule"}}},
+				},
+			}
+			if err := config.ExpandProfiles(cfg); err != nil {
+				t.Fatalf("ExpandProfiles() error = %v", err)
+			}
+
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			decision, err := handler.processRules(httptest.NewRequest(tt.method, tt.path, body), cfg)
+			if err != nil {
+				t.Fatalf("processRules() error = %v", err)
+			}
+			if decision.allowed != tt.want {
+				t.Errorf("processRules() allowed = %v (%s), want %v", decision.allowed, decision.reason, tt.want)
+			}
+			if !decision.allowed && !strings.HasPrefix(decision.reason, tt.profile+" profile:") {
+				t.Errorf("reason = %q, want it to name the %s profile", decision.reason, tt.profile)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_RulePriority(t *testing.T) {
+	denyAll := config.Rule{
+		Match:   config.Match{Path: "^/v1.42/containers/"},
+		Actions: []config.Action{{Action: "deny", Reason: "deny containers"}},
+	}
+	allowCreate := config.Rule{
+		Match:   config.Match{Path: "^/v1.42/containers/create$", Method: "^POST$"},
+		Actions: []config.Action{{Action: "allow", Reason: "allow create"}},
+	}
+
+	tests := []struct {
+		name       string
+		rules      []config.Rule
+		wantAllow  bool
+		wantReason string
+		wantRule   int
+	}{
+		{
+			name:       "without priorities the first match decides",
+			rules:      []config.Rule{denyAll, allowCreate},
+			wantReason: "deny containers",
+			wantRule:   0,
+		},
+		{
+			name:       "higher priority decides regardless of position",
+			rules:      []config.Rule{denyAll, withPriority(allowCreate, 10)},
+			wantAllow:  true,
+			wantReason: "allow create",
+			wantRule:   1,
+		},
+		{
+			name:       "equal priorities fall back to position",
+			rules:      []config.Rule{withPriority(denyAll, 5), withPriority(allowCreate, 5)},
+			wantReason: "deny containers",
+			wantRule:   0,
+		},
+		{
+			name:       "negative priority is evaluated after default rules",
+			rules:      []config.Rule{withPriority(denyAll, -1), allowCreate},
+			wantAllow:  true,
+			wantReason: "allow create",
+			wantRule:   1,
+		},
+	}
+
+	handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1.42/containers/create", strings.NewReader(`{"Image":"alpine"}`))
+			decision, err := handler.evaluateRules(req, "", &config.SocketConfig{Rules: tt.rules})
+			if err != nil {
+				t.Fatalf("evaluateRules() error = %v", err)
+			}
+			if decision.allowed != tt.wantAllow || decision.reason != tt.wantReason {
+				t.Errorf("decision = %v (%s), want %v (%s)", decision.allowed, decision.reason, tt.wantAllow, tt.wantReason)
+			}
+			// Decisions report the rule's position in the config, not its rank
+			if decision.rule != tt.wantRule {
+				t.Errorf("decision rule = %d, want %d", decision.rule, tt.wantRule)
+			}
+		})
+	}
+}
+
+// withPriority returns a copy of a rule with the given priority
+func withPriority(rule config.Rule, priority int) config.Rule {
+	rule.Priority = priority
+	return rule
+}
+
+func TestProxyHandler_AccessProfiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		method  string
+		path    string
+		body    string
+		want    bool
+	}{
+		{name: "read-only-access allows ping", profile: "read-only-access", method: "GET", path: "/_ping", want: true},
+		{name: "read-only-access allows listing containers", profile: "read-only-access", method: "GET", path: "/v1.42/containers/json", want: true},
+		{name: "read-only-access allows inspecting images", profile: "read-only-access", method: "GET", path: "/v1.42/images/alpine/json", want: true},
+		{name: "read-only-access allows listing networks", profile: "read-only-access", method: "GET", path: "/v1.42/networks", want: true},
+		{name: "read-only-access denies secrets", profile: "read-only-access", method: "GET", path: "/v1.42/secrets", want: false},
+		{name: "read-only-access denies path prefixes", profile: "read-only-access", method: "GET", path: "/v1.42/containersx", want: false},
+		{name: "read-only-access denies create", profile: "read-only-access", method: "POST", path: "/v1.42/containers/create", body: `{"Image":"alpine"}`, want: false},
+		{name: "ci-builder allows build", profile: "ci-builder", method: "POST", path: "/v1.42/build", want: true},
+		{name: "ci-builder allows push", profile: "ci-builder", method: "POST", path: "/v1.42/images/registry.example.com/app/push", want: true},
+		{name: "ci-builder allows running a container", profile: "ci-builder", method: "POST", path: "/v1.42/containers/abc/start", want: true},
+		{name: "ci-builder denies exec", profile: "ci-builder", method: "POST", path: "/v1.42/containers/abc/exec", body: `{"Cmd":["sh"]}`, want: false},
+		{name: "ci-builder denies swarm", profile: "ci-builder", method: "POST", path: "/v1.42/swarm/init", body: `{}`, want: false},
+		{name: "watchtower allows pulling", profile: "watchtower", method: "POST", path: "/v1.42/images/create", want: true},
+		{name: "watchtower allows removing old containers", profile: "watchtower", method: "DELETE", path: "/v1.42/containers/abc", want: true},
+		{name: "watchtower denies build", profile: "watchtower", method: "POST", path: "/v1.42/build", want: false},
+		{name: "config rule extends the profile", profile: "read-only-access", method: "GET", path: "/v1.42/volumes", want: true},
+	}
+
+	handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.SocketConfig{
+				Config: config.ConfigSet{Profile: tt.profile},
+				Rules: []config.Rule{
+					{Match: config.Match{Path: "^/v1.42/volumes$", Method: "^GET$"}, Actions: []config.Action{{Action: "allow"}}},
+				},
+			}
+			if err := config.ExpandProfiles(cfg); err != nil {
+				t.Fatalf("ExpandProfiles() error = %v", err)
+			}
+
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			decision, err := handler.processRules(httptest.NewRequest(tt.method, tt.path, body), cfg)
+			if err != nil {
+				t.Fatalf("processRules() error = %v", err)
+			}
+			if decision.allowed != tt.want {
+				t.Errorf("processRules() allowed = %v (%s), want %v", decision.allowed, decision.reason, tt.want)
+			}
+		})
+	}
+
+	// A config rule with a priority can deny what the profile allows
+	cfg := &config.SocketConfig{
+		Config: config.ConfigSet{Profile: "read-only-access"},
+		Rules: []config.Rule{
+			{Match: config.Match{Path: "/containers/[^/]+/logs$"}, Actions: []config.Action{{Action: "deny", Reason: "logs may hold secrets"}}, Priority: 1},
+		},
+	}
+	if err := config.ExpandProfiles(cfg); err != nil {
+		t.Fatalf("ExpandProfiles() error = %v", err)
+	}
+	decision, err := handler.processRules(httptest.NewRequest("GET", "/v1.42/containers/abc/logs", nil), cfg)
+	if err != nil {
+		t.Fatalf("processRules() error = %v", err)
+	}
+	if decision.allowed || decision.reason != "logs may hold secrets" {
+		t.Errorf("processRules() = %v (%s), want the priority rule to deny", decision.allowed, decision.reason)
+	}
+}
+
+func TestDecisionLog(t *testing.T) {
+	decisions := newDecisionLog()
+	for i := 0; i < decisionLogSize+5; i++ {
+		decisions.add(management.LogEntry{Path: strconv.Itoa(i)})
+	}
+
+	all := decisions.tail(-1)
+	if len(all) != decisionLogSize {
+		t.Fatalf("tail(-1) returned %d entries, want %d", len(all), decisionLogSize)
+	}
+	if all[0].Path != "5" || all[len(all)-1].Path != strconv.Itoa(decisionLogSize+4) {
+		t.Errorf("tail(-1) = %s..%s, want the newest entries oldest first", all[0].Path, all[len(all)-1].Path)
+	}
+
+	last := decisions.tail(2)
+	if len(last) != 2 || last[0].Path != strconv.Itoa(decisionLogSize+3) || last[1].Path != strconv.Itoa(decisionLogSize+4) {
+		t.Errorf("tail(2) = %+v, want the two newest entries", last)
+	}
+
+	// A follower that falls behind misses entries instead of blocking
+	_, updates, stop := decisions.follow(0)
+	for i := 0; i < decisionFollowerBuffer+10; i++ {
+		decisions.add(management.LogEntry{Path: "followed"})
+	}
+	if len(updates) != decisionFollowerBuffer {
+		t.Errorf("follower has %d entries queued, want %d", len(updates), decisionFollowerBuffer)
+	}
+	stop()
+	stop()
+
+	decisions.close()
+	_, updates, _ = decisions.follow(0)
+	if _, ok := <-updates; ok {
+		t.Error("expected following a closed log to end immediately")
+	}
+}
+
+func TestProxyHandler_NotContains(t *testing.T) {
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{
+				Match: config.Match{
+					Path:     "/v1.*/containers/create",
+					Method:   "POST",
+					Contains: map[string]any{"$not": map[string]any{"Labels": map[string]any{"team": ".+"}}},
+				},
+				Actions: []config.Action{{Action: "deny", Reason: "containers need a team label"}},
+			},
+		},
+	}
+
+	handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{name: "labelled create is allowed", body: `{"Image":"alpine","Labels":{"team":"payments"}}`, want: true},
+		{name: "create without labels is denied", body: `{"Image":"alpine"}`, want: false},
+		{name: "create with other labels is denied", body: `{"Image":"alpine","Labels":{"owner":"ci"}}`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1.42/containers/create", strings.NewReader(tt.body))
+			decision, err := handler.processRules(req, cfg)
+			if err != nil {
+				t.Fatalf("processRules() error = %v", err)
+			}
+			if decision.allowed != tt.want {
+				t.Errorf("processRules() allowed = %v, want %v", decision.allowed, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_RewritePath(t *testing.T) {
+	paths := make(chan string, 1)
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.EscapedPath()
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	pinVersion := config.Action{Action: "rewrite-path", Pattern: `^/v[0-9.]+/`, Replacement: "/v1.41/"}
+	socketPath := "/tmp/rewrite-path.sock"
+	configs := map[string]*config.SocketConfig{
+		socketPath: {
+			Rules: []config.Rule{
+				{
+					Match:   config.Match{Path: "^/v[0-9.]+/"},
+					Actions: []config.Action{pinVersion},
+				},
+				{
+					// Only matches once the version has been pinned
+					Match:   config.Match{Path: "^/v1\\.41/containers/json$", Method: "GET"},
+					Actions: []config.Action{{Action: "allow"}},
+				},
+				{
+					Match:   config.Match{Path: "^/v1\\.41/volumes", Method: "GET"},
+					Actions: []config.Action{{Action: "deny", Reason: "no volumes"}},
+				},
+				{
+					Match:   config.Match{Path: "^/_ping$"},
+					Actions: []config.Action{{Action: "rewrite-path", Pattern: "^/", Replacement: ""}},
+				},
+			},
+		},
+	}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantPath   string
+	}{
+		{name: "version is pinned before allow", target: "/v1.44/containers/json", wantStatus: http.StatusOK, wantPath: "/v1.41/containers/json"},
+		{name: "escaped characters survive", target: "/v1.44/images/my%20image/json", wantStatus: http.StatusOK, wantPath: "/v1.41/images/my%20image/json"},
+		{name: "rewritten path is denied", target: "/v1.44/volumes", wantStatus: http.StatusForbidden},
+		{name: "unversioned path is untouched", target: "/info", wantStatus: http.StatusOK, wantPath: "/info"},
+		{name: "relative result is an error", target: "/_ping", wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, httptest.NewRequest("GET", tt.target, nil), socketPath)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantPath == "" {
+				select {
+				case path := <-paths:
+					t.Errorf("request reached the daemon at %s", path)
+				default:
+				}
+				return
+			}
+			if path := <-paths; path != tt.wantPath {
+				t.Errorf("daemon received %s, want %s", path, tt.wantPath)
+			}
+		})
+	}
+
+	if stats := handler.snapshotStats(socketPath); stats.Rewritten != 2 {
+		t.Errorf("rewritten = %d, want 2", stats.Rewritten)
+	}
+}
+
+func TestProxyHandler_ErrorBodies(t *testing.T) {
+	socketPath := "/tmp/error-bodies.sock"
+	configs := map[string]*config.SocketConfig{
+		socketPath: {
+			Rules: []config.Rule{
+				{
+					Match:   config.Match{Path: "^/v1.42/volumes$"},
+					Actions: []config.Action{{Action: "deny", Reason: "volumes are off limits"}},
+				},
+				{
+					Match:   config.Match{Path: "^/v1.42/images/json$"},
+					Actions: []config.Action{{Action: "ratelimit", Limit: 1, Window: "1h", Reason: "slow down"}, {Action: "allow"}},
+				},
+				{
+					Match:   config.Match{Path: "^/v1.42/info$"},
+					Actions: []config.Action{{Action: "allow"}},
+				},
+			},
+		},
+	}
+	handler := NewProxyHandler(filepath.Join(t.TempDir(), "missing.sock"), configs, &sync.RWMutex{})
+
+	// Use up the rate limit first
+	handler.ServeHTTPWithSocket(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1.42/images/json", nil), socketPath)
+
+	tests := []struct {
+		name        string
+		socket      string
+		target      string
+		wantStatus  int
+		wantMessage string
+	}{
+		{name: "deny", socket: socketPath, target: "/v1.42/volumes", wantStatus: http.StatusForbidden, wantMessage: "Request denied: volumes are off limits"},
+		{name: "rate limited", socket: socketPath, target: "/v1.42/images/json", wantStatus: http.StatusTooManyRequests, wantMessage: "Request denied: slow down"},
+		{name: "daemon unreachable", socket: socketPath, target: "/v1.42/info", wantStatus: http.StatusBadGateway, wantMessage: "Docker daemon is not reachable"},
+		{name: "unknown socket", socket: "/tmp/unknown.sock", target: "/v1.42/info", wantStatus: http.StatusInternalServerError, wantMessage: "socket configuration not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, httptest.NewRequest("GET", tt.target, nil), tt.socket)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var body struct {
+				Message string `json:"message"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %q is not JSON: %v", w.Body.String(), err)
+			}
+			if body.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", body.Message, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_UpstreamTimeout(t *testing.T) {
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1.42/slow" {
+			// Hang like a stuck daemon until the proxy gives up
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	socketPath := "/tmp/upstream-timeout.sock"
+	configs := map[string]*config.SocketConfig{
+		socketPath: {Config: config.ConfigSet{UpstreamTimeout: "100ms"}},
+	}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+
+	w := httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTPWithSocket(w, httptest.NewRequest("GET", "/v1.42/slow", nil), socketPath)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusGatewayTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("request took %v, want it to give up after the timeout", elapsed)
+	}
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Message == "" {
+		t.Errorf("body = %q, want a JSON message", w.Body.String())
+	}
+
+	// A daemon that answers in time is unaffected
+	w = httptest.NewRecorder()
+	handler.ServeHTTPWithSocket(w, httptest.NewRequest("GET", "/v1.42/info", nil), socketPath)
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestProxyHandler_NumericComparison(t *testing.T) {
+	// Loaded from YAML, so the bounds are ints while request bodies hold float64s
+	cfg, err := config.ParseSocketConfig([]byte(`
+rules:
+  - match:
+      path: "/containers/create$"
+      method: "^POST$"
+      contains:
+        HostConfig:
+          Memory:
+            $gt: 2147483648
+    actions:
+      - action: deny
+        reason: "containers are limited to 2GB of memory"
+  - match:
+      path: "/containers/create$"
+      method: "^POST$"
+      contains:
+        HostConfig:
+          Binds:
+            $gt: 4
+    actions:
+      - action: deny
+        reason: "containers are limited to 4 mounts"
+`))
+	if err != nil {
+		t.Fatalf("ParseSocketConfig() error = %v", err)
+	}
+	if err := config.ValidateConfig(cfg); err != nil {
+		t.Fatalf("ValidateConfig() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantAllow  bool
+		wantReason string
+	}{
+		{name: "within the memory limit", body: `{"HostConfig":{"Memory":1073741824}}`, wantAllow: true},
+		{name: "at the memory limit", body: `{"HostConfig":{"Memory":2147483648}}`, wantAllow: true},
+		{name: "over the memory limit", body: `{"HostConfig":{"Memory":4294967296}}`, wantReason: "containers are limited to 2GB of memory"},
+		{name: "without a memory limit", body: `{"HostConfig":{}}`, wantAllow: true},
+		{name: "four mounts", body: `{"HostConfig":{"Binds":["/a:/a","/b:/b","/c:/c","/d:/d"]}}`, wantAllow: true},
+		{name: "five mounts", body: `{"HostConfig":{"Binds":["/a:/a","/b:/b","/c:/c","/d:/d","/e:/e"]}}`, wantReason: "containers are limited to 4 mounts"},
+	}
+
+	handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1.42/containers/create", strings.NewReader(tt.body))
+			decision, err := handler.processRules(req, cfg)
+			if err != nil {
+				t.Fatalf("processRules() error = %v", err)
+			}
+			if decision.allowed != tt.wantAllow || (!decision.allowed && decision.reason != tt.wantReason) {
+				t.Errorf("processRules() = %v (%s), want %v (%s)", decision.allowed, decision.reason, tt.wantAllow, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_DecisionRule(t *testing.T) {
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{Match: config.Match{Path: "/_ping"}, Actions: []config.Action{{Action: "allow"}}},
+			{
+				Match: config.Match{Path: "/containers/create", Method: "POST"},
+				Actions: []config.Action{
+					{Action: "upsert", Update: map[string]any{"Labels": map[string]any{"proxied": "true"}}},
+					{Action: "deny", Reason: "privileged", Contains: map[string]any{"Privileged": true}},
+					{Action: "allow", Reason: "create"},
+				},
+			},
+		},
+	}
+	handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantAllow  bool
+		wantRule   int
+		wantAction int
+	}{
+		{name: "first action of a rule", method: "GET", target: "/v1.42/_ping", wantAllow: true, wantRule: 0, wantAction: 0},
+		{name: "allow after a rewrite", method: "POST", target: "/v1.42/containers/create", body: `{"Image":"alpine"}`, wantAllow: true, wantRule: 1, wantAction: 2},
+		{name: "deny by body", method: "POST", target: "/v1.42/containers/create", body: `{"Privileged":true}`, wantRule: 1, wantAction: 1},
+		{name: "default action", method: "GET", target: "/v1.42/info", wantAllow: true, wantRule: -1, wantAction: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, err := handler.processRules(httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)), cfg)
+			if err != nil {
+				t.Fatalf("processRules() error = %v", err)
+			}
+			if decision.allowed != tt.wantAllow || decision.rule != tt.wantRule || decision.action != tt.wantAction {
+				t.Errorf("processRules() = allowed %v, rule %d, action %d, want %v, %d, %d",
+					decision.allowed, decision.rule, decision.action, tt.wantAllow, tt.wantRule, tt.wantAction)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_DenialHeaders(t *testing.T) {
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The daemon's own 403s must not look like proxy denials
+		w.WriteHeader(http.StatusForbidden)
+	}))
+
+	rules := []config.Rule{
+		{Match: config.Match{Path: "^/v1.42/info$"}, Actions: []config.Action{{Action: "allow"}}},
+		{Match: config.Match{Path: "^/v1.42/volumes$"}, Actions: []config.Action{{Action: "deny", Reason: "volumes are off limits"}}},
+	}
+	debugSocket, quietSocket := "/tmp/debug-headers.sock", "/tmp/quiet-headers.sock"
+	configs := map[string]*config.SocketConfig{
+		debugSocket: {Config: config.ConfigSet{DebugHeaders: true, DefaultAction: config.DefaultActionDeny}, Rules: rules},
+		quietSocket: {Config: config.ConfigSet{DefaultAction: config.DefaultActionDeny}, Rules: rules},
+	}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+
+	tests := []struct {
+		name       string
+		socket     string
+		target     string
+		wantStatus int
+		wantDenied string
+		wantRule   string
+		wantAction string
+	}{
+		{name: "rule denial with debug headers", socket: debugSocket, target: "/v1.42/volumes", wantStatus: http.StatusForbidden, wantDenied: "true", wantRule: "1", wantAction: "0"},
+		{name: "rule denial without debug headers", socket: quietSocket, target: "/v1.42/volumes", wantStatus: http.StatusForbidden, wantDenied: "true"},
+		{name: "default denial names no rule", socket: debugSocket, target: "/v1.42/secrets", wantStatus: http.StatusForbidden, wantDenied: "true"},
+		{name: "daemon 403 is not marked as denied", socket: debugSocket, target: "/v1.42/info", wantStatus: http.StatusForbidden, wantRule: "0", wantAction: "0"},
+		{name: "allow without debug headers names no rule", socket: quietSocket, target: "/v1.42/info", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, httptest.NewRequest("GET", tt.target, nil), tt.socket)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get(deniedHeader); got != tt.wantDenied {
+				t.Errorf("%s = %q, want %q", deniedHeader, got, tt.wantDenied)
+			}
+			if got := w.Header().Get(ruleHeader); got != tt.wantRule {
+				t.Errorf("%s = %q, want %q", ruleHeader, got, tt.wantRule)
+			}
+			if got := w.Header().Get(actionHeader); got != tt.wantAction {
+				t.Errorf("%s = %q, want %q", actionHeader, got, tt.wantAction)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_GlobMatchMode(t *testing.T) {
+	cfg := &config.SocketConfig{
+		Config: config.ConfigSet{DefaultAction: config.DefaultActionDeny},
+		Rules: []config.Rule{
+			{
+				Match:   config.Match{Path: "/v1.*/containers/*/json", Method: "GET", MatchMode: config.MatchModeGlob},
+				Actions: []config.Action{{Action: "allow"}},
+			},
+		},
+	}
+	if err := config.ValidateConfig(cfg); err != nil {
+		t.Fatalf("ValidateConfig() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		wantAllow bool
+	}{
+		{name: "whole path matches", method: "GET", path: "/v1.42/containers/abc/json", wantAllow: true},
+		{name: "star crosses slashes", method: "GET", path: "/v1.42/containers/abc/def/json", wantAllow: true},
+		{name: "glob is anchored", method: "GET", path: "/v1.42/containers/abc/json/extra"},
+		{name: "method is matched whole", method: "GETX", path: "/v1.42/containers/abc/json"},
+	}
+
+	handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			decision, err := handler.processRules(req, cfg)
+			if err != nil {
+				t.Fatalf("processRules() error = %v", err)
+			}
+			if decision.allowed != tt.wantAllow {
+				t.Errorf("processRules() allowed = %v, want %v", decision.allowed, tt.wantAllow)
+			}
+			if got := config.MatchesRule(req, cfg.Rules[0].Match); got != tt.wantAllow {
+				t.Errorf("MatchesRule() = %v, want %v", got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_DenyStatusCode(t *testing.T) {
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{Match: config.Match{Path: "^/v1.42/swarm"}, Actions: []config.Action{{Action: "deny", Reason: "hidden", StatusCode: http.StatusNotFound}}},
+			{Match: config.Match{Path: "^/v1.42/secrets"}, Actions: []config.Action{{Action: "deny", Reason: "secrets are off limits"}}},
+		},
+	}
+	socketPath := "/tmp/status-code.sock"
+	handler := NewProxyHandler("/tmp/docker.sock", map[string]*config.SocketConfig{socketPath: cfg}, &sync.RWMutex{})
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+	}{
+		{name: "overridden status", target: "/v1.42/swarm", wantStatus: http.StatusNotFound},
+		{name: "default status", target: "/v1.42/secrets", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, httptest.NewRequest("GET", tt.target, nil), socketPath)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get(deniedHeader); got != "true" {
+				t.Errorf("%s = %q, want %q", deniedHeader, got, "true")
+			}
+		})
+	}
+}
+
+func TestProxyHandler_DenyCode(t *testing.T) {
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{Match: config.Match{Path: "^/v1.42/swarm"}, Actions: []config.Action{{Action: "deny", Reason: "swarm is off limits", Code: "SWARM_DENIED"}}},
+			{Match: config.Match{Path: "^/v1.42/secrets"}, Actions: []config.Action{{Action: "deny", Reason: "secrets are off limits"}}},
+		},
+	}
+	socketPath := "/tmp/deny-code.sock"
+	handler := NewProxyHandler("/tmp/docker.sock", map[string]*config.SocketConfig{socketPath: cfg}, &sync.RWMutex{})
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode string
+	}{
+		{name: "with a code", target: "/v1.42/swarm", wantCode: "SWARM_DENIED"},
+		{name: "without a code", target: "/v1.42/secrets"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, httptest.NewRequest("GET", tt.target, nil), socketPath)
+			if w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+			if got := w.Header().Get(reasonCodeHeader); got != tt.wantCode {
+				t.Errorf("%s = %q, want %q", reasonCodeHeader, got, tt.wantCode)
+			}
+
+			var body map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body is not JSON: %v", err)
+			}
+			code, hasCode := body["code"]
+			if tt.wantCode == "" && hasCode {
+				t.Errorf("body = %v, want no code", body)
+			}
+			if tt.wantCode != "" && code != tt.wantCode {
+				t.Errorf("body code = %v, want %q", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_NilBody(t *testing.T) {
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{
+				Match:   config.Match{Path: "/containers/create", Method: "POST", Contains: map[string]any{"HostConfig": map[string]any{"Privileged": true}}},
+				Actions: []config.Action{{Action: "deny", Reason: "privileged containers are not allowed"}},
+			},
+			{
+				Match:   config.Match{Path: "/containers/create", Method: "POST"},
+				Actions: []config.Action{{Action: "upsert", Update: map[string]any{"Labels": map[string]any{"proxied": "true"}}}, {Action: "allow"}},
+			},
+		},
+	}
+	handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+
+	for _, method := range []string{"POST", "PUT"} {
+		t.Run(method, func(t *testing.T) {
+			// A request built by hand rather than by the server may have no body at all
+			req := httptest.NewRequest(method, "/v1.42/containers/create", nil)
+			req.Body = nil
+
+			decision, err := handler.processRules(req, cfg)
+			if err != nil {
+				t.Fatalf("processRules() error = %v", err)
+			}
+			if method == "POST" && !decision.allowed {
+				t.Errorf("processRules() denied a request without a body: %s", decision.reason)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_SocketHeader(t *testing.T) {
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Socket", r.Header.Get("X-Docker-Proxy-Socket"))
+		w.Header().Set("X-Seen-Custom", r.Header.Get("X-Proxy-Via"))
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		config     config.ConfigSet
+		sent       map[string]string
+		wantSocket string
+		wantCustom string
+	}{
+		{name: "disabled", config: config.ConfigSet{}},
+		{name: "default header", config: config.ConfigSet{InjectSocketHeader: true}, wantSocket: "ci.sock"},
+		{name: "custom header", config: config.ConfigSet{InjectSocketHeader: true, SocketHeaderName: "X-Proxy-Via"}, wantCustom: "ci.sock"},
+		{
+			name:       "client value is kept",
+			config:     config.ConfigSet{InjectSocketHeader: true},
+			sent:       map[string]string{"X-Docker-Proxy-Socket": "outer.sock"},
+			wantSocket: "outer.sock",
+		},
+		{
+			name:       "client value is overwritten",
+			config:     config.ConfigSet{InjectSocketHeader: true, OverwriteSocketHeader: true},
+			sent:       map[string]string{"X-Docker-Proxy-Socket": "outer.sock"},
+			wantSocket: "ci.sock",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			socketPath := "/tmp/sockets/ci.sock"
+			configs := map[string]*config.SocketConfig{socketPath: {Config: tt.config}}
+			handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+
+			req := httptest.NewRequest("GET", "/v1.42/info", nil)
+			for name, value := range tt.sent {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, req, socketPath)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if got := w.Header().Get("X-Seen-Socket"); got != tt.wantSocket {
+				t.Errorf("X-Docker-Proxy-Socket = %q, want %q", got, tt.wantSocket)
+			}
+			if got := w.Header().Get("X-Seen-Custom"); got != tt.wantCustom {
+				t.Errorf("X-Proxy-Via = %q, want %q", got, tt.wantCustom)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_Template(t *testing.T) {
+	received := make(chan map[string]any, 1)
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Failed to decode forwarded body: %v", err)
+		}
+		received <- body
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	socketPath := "/tmp/sockets/ci.sock"
+	configs := map[string]*config.SocketConfig{
+		socketPath: {
+			Rules: []config.Rule{
+				{
+					Match: config.Match{Path: "/containers/create", Method: "POST"},
+					Actions: []config.Action{
+						{Action: "upsert", Template: map[string]string{
+							"Labels.created_by": "{{.Header.X-User}}",
+							"Labels.socket":     "{{.SocketName}}",
+							"Labels.created_at": "{{.Now}}",
+						}},
+						{Action: "allow"},
+					},
+				},
+			},
+		},
+	}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+
+	req := httptest.NewRequest("POST", "/v1.42/containers/create", strings.NewReader(`{"Image":"alpine"}`))
+	req.Header.Set("X-User", "alice")
+	w := httptest.NewRecorder()
+	handler.ServeHTTPWithSocket(w, req, socketPath)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	labels, _ := (<-received)["Labels"].(map[string]any)
+	if labels["created_by"] != "alice" || labels["socket"] != "ci.sock" {
+		t.Errorf("Labels = %v, want created_by alice and socket ci.sock", labels)
+	}
+	if createdAt, _ := labels["created_at"].(string); createdAt == "" {
+		t.Errorf("Labels = %v, want a created_at timestamp", labels)
+	} else if _, err := time.Parse(time.RFC3339, createdAt); err != nil {
+		t.Errorf("created_at = %q is not RFC 3339: %v", createdAt, err)
+	}
+}
+
+func TestProxyHandler_MaxBodyBytes(t *testing.T) {
+	var forwarded []string
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read forwarded body: %v", err)
+		}
+		forwarded = append(forwarded, string(body))
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cfg := &config.SocketConfig{
+		Config: config.ConfigSet{MaxBodyBytes: 16},
+		Rules: []config.Rule{
+			{
+				Match:   config.Match{Path: "/containers/create", Method: "POST", Contains: map[string]any{"Privileged": true}},
+				Actions: []config.Action{{Action: "deny", Reason: "privileged"}},
+			},
+		},
+	}
+	socketPath := "/tmp/max-body.sock"
+	handler := NewProxyHandler(upstream, map[string]*config.SocketConfig{socketPath: cfg}, &sync.RWMutex{})
+
+	large := strings.Repeat("x", 64)
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{name: "inspected body within the limit", path: "/v1.42/containers/create", body: `{"Image":"a"}`, wantStatus: http.StatusOK},
+		{name: "inspected body over the limit", path: "/v1.42/containers/create", body: `{"Image":"` + large + `"}`, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "uninspected body over the limit", path: "/v1.42/build", body: large, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded = nil
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, req, socketPath)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if len(forwarded) != 0 {
+					t.Errorf("forwarded %d requests, want none", len(forwarded))
+				}
+				return
+			}
+			if len(forwarded) != 1 || forwarded[0] != tt.body {
+				t.Errorf("forwarded %q, want the original body", forwarded)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_BodyMethods(t *testing.T) {
+	var forwarded []string
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read forwarded body: %v", err)
+		}
+		forwarded = append(forwarded, string(body))
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rules := []config.Rule{
+		{
+			Match:   config.Match{Path: "/plugins/.*", Contains: map[string]any{"Privileged": true}},
+			Actions: []config.Action{{Action: "deny", Reason: "privileged"}},
+		},
+		{
+			Match:   config.Match{Path: "/plugins/.*"},
+			Actions: []config.Action{{Action: "upsert", Update: map[string]any{"Labels": map[string]any{"proxied": "true"}}}},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		bodyMethods   []string
+		method        string
+		body          string
+		wantStatus    int
+		wantForwarded string
+	}{
+		{name: "patch is not read by default", method: "PATCH", body: `{"Privileged":true}`, wantStatus: http.StatusOK, wantForwarded: `{"Privileged":true}`},
+		{name: "post is read by default", method: "POST", body: `{"Privileged":true}`, wantStatus: http.StatusForbidden},
+		{name: "configured patch is denied", bodyMethods: []string{"POST", "PUT", "PATCH"}, method: "PATCH", body: `{"Privileged":true}`, wantStatus: http.StatusForbidden},
+		{name: "configured patch is rewritten", bodyMethods: []string{"POST", "PUT", "PATCH"}, method: "PATCH", body: `{"Privileged":false}`, wantStatus: http.StatusOK, wantForwarded: `{"Labels":{"proxied":"true"},"Privileged":false}`},
+		{name: "configured methods replace the defaults", bodyMethods: []string{"PATCH"}, method: "POST", body: `{"Privileged":true}`, wantStatus: http.StatusOK, wantForwarded: `{"Privileged":true}`},
+		{name: "other methods still bypass the body", bodyMethods: []string{"PATCH"}, method: "DELETE", body: `{"Privileged":true}`, wantStatus: http.StatusOK, wantForwarded: `{"Privileged":true}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded = nil
+			socketPath := "/tmp/body-methods.sock"
+			cfg := &config.SocketConfig{Config: config.ConfigSet{BodyMethods: tt.bodyMethods}, Rules: rules}
+			handler := NewProxyHandler(upstream, map[string]*config.SocketConfig{socketPath: cfg}, &sync.RWMutex{})
+
+			req := httptest.NewRequest(tt.method, "/v1.42/plugins/sshfs/set", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, req, socketPath)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if len(forwarded) != 0 {
+					t.Errorf("forwarded %d requests, want none", len(forwarded))
+				}
+				return
+			}
+			if len(forwarded) != 1 || forwarded[0] != tt.wantForwarded {
+				t.Errorf("forwarded %q, want %q", forwarded, tt.wantForwarded)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_BodyRegex(t *testing.T) {
+	var forwarded []string
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read forwarded body: %v", err)
+		}
+		forwarded = append(forwarded, string(body))
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cfg := &config.SocketConfig{
+		Config: config.ConfigSet{MaxBodyBytes: 256},
+		Rules: []config.Rule{
+			{
+				Match:   config.Match{Path: "/build$", Method: "POST", BodyRegex: `(?m)^RUN .*\| *(ba)?sh`},
+				Actions: []config.Action{{Action: "deny", Reason: "piping downloads into a shell"}},
+			},
+		},
+	}
+	socketPath := "/tmp/body-regex.sock"
+	handler := NewProxyHandler(upstream, map[string]*config.SocketConfig{socketPath: cfg}, &sync.RWMutex{})
+
+	// A build context is a tar stream holding the Dockerfile
+	buildContext := "Dockerfile\x00\x00\x00\x00FROM alpine\n"
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "matching instruction", body: buildContext + "RUN curl -s https://example.com/install | sh\n", wantStatus: http.StatusForbidden},
+		{name: "other instructions", body: buildContext + "RUN apk add curl\n", wantStatus: http.StatusOK},
+		{name: "context over the limit", body: buildContext + strings.Repeat("#", 512), wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded = nil
+			req := httptest.NewRequest("POST", "/v1.43/build", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/x-tar")
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, req, socketPath)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if len(forwarded) != 0 {
+					t.Errorf("forwarded %d requests, want none", len(forwarded))
+				}
+				return
+			}
+			if len(forwarded) != 1 || forwarded[0] != tt.body {
+				t.Errorf("forwarded %q, want the original body", forwarded)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_StreamsUninspectedBody(t *testing.T) {
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{
+				Match:   config.Match{Path: "/containers/create", Method: "POST", Contains: map[string]any{"Privileged": true}},
+				Actions: []config.Action{{Action: "deny", Reason: "privileged"}},
+			},
+			{
+				Match:   config.Match{Path: "/build", Method: "POST"},
+				Actions: []config.Action{{Action: "allow"}},
+			},
+			{
+				Match:   config.Match{Path: "/exec/.*/start", Method: "POST", Contains: map[string]any{"Tty": true}},
+				Actions: []config.Action{{Action: "allow"}},
+			},
+		},
+	}
+	handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+
+	tests := []struct {
+		path         string
+		wantBuffered bool
+	}{
+		{path: "/v1.42/build", wantBuffered: false},
+		{path: "/v1.42/containers/create", wantBuffered: true},
+		{path: "/v1.42/exec/abc/start", wantBuffered: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			body := io.NopCloser(strings.NewReader(`{"Image":"alpine"}`))
+			req := httptest.NewRequest("POST", tt.path, nil)
+			req.Body = body
+
+			if _, err := handler.processRules(req, cfg); err != nil {
+				t.Fatalf("processRules() error = %v", err)
+			}
+			if buffered := req.Body != body; buffered != tt.wantBuffered {
+				t.Errorf("body buffered = %v, want %v", buffered, tt.wantBuffered)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_BodyNeedFollowsConfig(t *testing.T) {
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	socketPath := "/tmp/body-need.sock"
+	configs := map[string]*config.SocketConfig{socketPath: {Rules: []config.Rule{
+		{Match: config.Match{Path: "/containers/create"}, Actions: []config.Action{{Action: "allow"}}},
+	}}}
+	configMu := &sync.RWMutex{}
+	handler := NewProxyHandler(upstream, configs, configMu)
+
+	send := func() int {
+		req := httptest.NewRequest("POST", "/v1.42/containers/create", strings.NewReader(`{"HostConfig":{"Privileged":true}}`))
+		w := httptest.NewRecorder()
+		handler.ServeHTTPWithSocket(w, req, socketPath)
+		return w.Code
+	}
+
+	if code := send(); code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", code, http.StatusOK)
+	}
+
+	// A replaced config that inspects bodies takes effect straight away
+	configMu.Lock()
+	configs[socketPath] = &config.SocketConfig{Rules: []config.Rule{
+		{
+			Match:   config.Match{Path: "/containers/create", Contains: map[string]any{"HostConfig": map[string]any{"Privileged": true}}},
+			Actions: []config.Action{{Action: "deny", Reason: "privileged"}},
+		},
+	}}
+	configMu.Unlock()
+
+	if code := send(); code != http.StatusForbidden {
+		t.Errorf("status after config change = %d, want %d", code, http.StatusForbidden)
+	}
+}
+
+func TestProxyHandler_APIVersion(t *testing.T) {
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	socketPath := "/tmp/api-version.sock"
+	cfg := &config.SocketConfig{
+		Config: config.ConfigSet{MinAPIVersion: "1.41"},
+		Rules: []config.Rule{
+			{Match: config.Match{Path: "/"}, Actions: []config.Action{{Action: "allow"}}},
+		},
+	}
+	handler := NewProxyHandler(upstream, map[string]*config.SocketConfig{socketPath: cfg}, &sync.RWMutex{})
+
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{path: "/v1.43/containers/json", wantStatus: http.StatusOK},
+		{path: "/v1.24/containers/json", wantStatus: http.StatusBadRequest},
+		{path: "/_ping", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, req, socketPath)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusBadRequest && !strings.Contains(w.Body.String(), "older than the minimum 1.41") {
+				t.Errorf("body = %q, want the version range in the message", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestProxyHandler_ActionLog(t *testing.T) {
+	var logs bytes.Buffer
+	logging.SetOutput(&logs)
+	defer logging.SetOutput(os.Stdout)
+
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{
+				Match: config.Match{Path: "/containers/create", Method: "POST"},
+				Actions: []config.Action{{
+					Action:     "deny",
+					Reason:     "privileged containers are not allowed",
+					Contains:   map[string]any{"HostConfig": map[string]any{"Privileged": true}},
+					Log:        true,
+					LogMessage: "Privileged container refused",
+					LogLevel:   "warn",
+				}},
+			},
+			{
+				Match:   config.Match{Path: "/containers/create", Method: "POST"},
+				Actions: []config.Action{{Action: "allow"}},
+			},
+		},
+	}
+	handler := NewProxyHandler("/tmp/docker.sock", make(map[string]*config.SocketConfig), &sync.RWMutex{})
+
+	// The deny does not apply to this body, so it does not log
+	req := httptest.NewRequest("POST", "/v1.42/containers/create", strings.NewReader(`{"Image":"alpine"}`))
+	if _, err := handler.processRules(req, cfg); err != nil {
+		t.Fatalf("processRules() error = %v", err)
+	}
+	if strings.Contains(logs.String(), "Privileged container refused") {
+		t.Errorf("an action that did not apply was logged:\n%s", logs.String())
+	}
+
+	req = httptest.NewRequest("POST", "/v1.42/containers/create", strings.NewReader(`{"HostConfig":{"Privileged":true}}`))
+	if _, err := handler.processRules(req, cfg); err != nil {
+		t.Fatalf("processRules() error = %v", err)
+	}
+
+	var entry map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		if strings.Contains(line, "Privileged container refused") {
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("log line is not JSON: %v\n%s", err, line)
+			}
+		}
+	}
+	if entry == nil {
+		t.Fatalf("no log line for the action:\n%s", logs.String())
+	}
+	if entry["level"] != "WARN" || entry["rule"] != float64(0) || entry["path"] != "/v1.42/containers/create" || entry["method"] != "POST" {
+		t.Errorf("unexpected log line: %v", entry)
+	}
+	if entry["reason"] != "privileged containers are not allowed" {
+		t.Errorf("reason = %v", entry["reason"])
+	}
+}
+
+func TestProxyHandler_RewritesAcrossRules(t *testing.T) {
+	forwarded := make(chan map[string]any, 1)
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Failed to decode forwarded body: %v", err)
+		}
+		forwarded <- body
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	create := config.Match{Path: "/containers/create$", Method: "POST"}
+	managed := config.Action{Action: "upsert", Update: map[string]any{"Labels": map[string]any{"managed": "true"}}}
+	readOnly := config.Action{Action: "upsert", Update: map[string]any{"HostConfig": map[string]any{"ReadonlyRootfs": true}}}
+	late := config.Action{Action: "upsert", Update: map[string]any{"Labels": map[string]any{"late": "true"}}}
+
+	decided := &config.SocketConfig{Rules: []config.Rule{
+		{Match: create, Actions: []config.Action{managed}},
+		{
+			Match:   config.Match{Path: create.Path, Method: create.Method, Contains: map[string]any{"HostConfig": map[string]any{"Privileged": true}}},
+			Actions: []config.Action{{Action: "deny", Reason: "no privileged containers"}},
+		},
+		{Match: create, Actions: []config.Action{readOnly}},
+		// Only matches once the first rule has labelled the container
+		{
+			Match:   config.Match{Path: create.Path, Method: create.Method, Contains: map[string]any{"Labels": map[string]any{"managed": "true"}}},
+			Actions: []config.Action{{Action: "allow"}, late},
+		},
+		// Evaluation stopped at the allow above
+		{Match: create, Actions: []config.Action{late}},
+	}}
+	undecided := &config.SocketConfig{Rules: []config.Rule{
+		{Match: create, Actions: []config.Action{managed}},
+		{Match: create, Actions: []config.Action{readOnly}},
+	}}
+	denyByDefault := &config.SocketConfig{
+		Config: config.ConfigSet{DefaultAction: config.DefaultActionDeny},
+		Rules:  undecided.Rules,
+	}
+
+	configs := map[string]*config.SocketConfig{
+		"/tmp/decided.sock":   decided,
+		"/tmp/undecided.sock": undecided,
+		"/tmp/deny.sock":      denyByDefault,
+	}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+
+	tests := []struct {
+		name       string
+		socket     string
+		body       string
+		wantStatus int
+	}{
+		{name: "rewrites of earlier rules apply to the allow", socket: "/tmp/decided.sock", body: `{"Image":"alpine"}`, wantStatus: http.StatusCreated},
+		{name: "a deny after a rewrite", socket: "/tmp/decided.sock", body: `{"Image":"alpine","HostConfig":{"Privileged":true}}`, wantStatus: http.StatusForbidden},
+		{name: "rewrites apply to the default allow", socket: "/tmp/undecided.sock", body: `{"Image":"alpine"}`, wantStatus: http.StatusCreated},
+		{name: "rewrites do not allow", socket: "/tmp/deny.sock", body: `{"Image":"alpine"}`, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1.43/containers/create", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, req, tt.socket)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusCreated {
+				select {
+				case body := <-forwarded:
+					t.Errorf("denied request reached the daemon with %v", body)
+				default:
+				}
+				return
+			}
+
+			body := <-forwarded
+			labels, _ := body["Labels"].(map[string]any)
+			hostConfig, _ := body["HostConfig"].(map[string]any)
+			if labels["managed"] != "true" || hostConfig["ReadonlyRootfs"] != true {
+				t.Errorf("forwarded body %v is missing the rewrites of both rules", body)
+			}
+			if _, ok := labels["late"]; ok {
+				t.Errorf("forwarded body %v has a rewrite from after the allow", body)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_BaseConfig(t *testing.T) {
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	create := config.Match{Path: "/containers/create$", Method: "POST"}
+	privileged := config.Match{Path: create.Path, Method: create.Method, Contains: map[string]any{"HostConfig": map[string]any{"Privileged": true}}}
+	base := &config.SocketConfig{Rules: []config.Rule{
+		{Match: privileged, Actions: []config.Action{{Action: "deny", Reason: "privileged containers are not allowed"}}},
+		{Match: config.Match{Path: "/_ping"}, Actions: []config.Action{{Action: "allow"}}},
+	}}
+
+	configs := map[string]*config.SocketConfig{
+		// Explicitly allows every create, privileged ones included
+		"/tmp/permissive.sock": {Rules: []config.Rule{
+			{Match: create, Actions: []config.Action{{Action: "allow"}}, Priority: 100},
+		}},
+		// Adds a restriction of its own and denies the ping the base allows
+		"/tmp/strict.sock": {Rules: []config.Rule{
+			{Match: config.Match{Path: create.Path, Method: create.Method, Contains: map[string]any{"HostConfig": map[string]any{"NetworkMode": "host"}}}, Actions: []config.Action{{Action: "deny", Reason: "host network is not allowed"}}},
+			{Match: config.Match{Path: "/.*"}, Actions: []config.Action{{Action: "deny", Reason: "not allowed"}}},
+		}},
+		"/tmp/empty.sock": {},
+	}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+	handler.baseConfig = base
+
+	tests := []struct {
+		name       string
+		socket     string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		wantReason string
+	}{
+		{name: "base deny wins over a socket allow", socket: "/tmp/permissive.sock", method: "POST", target: "/v1.43/containers/create", body: `{"HostConfig":{"Privileged":true}}`, wantStatus: http.StatusForbidden, wantReason: "privileged containers are not allowed"},
+		{name: "socket allow once the base does not decide", socket: "/tmp/permissive.sock", method: "POST", target: "/v1.43/containers/create", body: `{"Image":"alpine"}`, wantStatus: http.StatusOK},
+		{name: "socket adds a restriction", socket: "/tmp/strict.sock", method: "POST", target: "/v1.43/containers/create", body: `{"HostConfig":{"NetworkMode":"host"}}`, wantStatus: http.StatusForbidden, wantReason: "host network is not allowed"},
+		{name: "base allow wins over a socket deny", socket: "/tmp/strict.sock", method: "GET", target: "/_ping", wantStatus: http.StatusOK},
+		{name: "base rules apply to a socket without rules", socket: "/tmp/empty.sock", method: "POST", target: "/v1.43/containers/create", body: `{"HostConfig":{"Privileged":true}}`, wantStatus: http.StatusForbidden, wantReason: "privileged containers are not allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, req, tt.socket)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantReason != "" && !strings.Contains(w.Body.String(), tt.wantReason) {
+				t.Errorf("body = %s, want reason %q", w.Body.String(), tt.wantReason)
+			}
+		})
+	}
+
+	// Base rules decide, but are not counted as hits of the socket's rules
+	decision, err := handler.evaluateRules(httptest.NewRequest("GET", "/_ping", nil), "/tmp/strict.sock", configs["/tmp/strict.sock"])
+	if err != nil {
+		t.Fatalf("evaluateRules() error = %v", err)
+	}
+	if !decision.base || decision.rule != 1 || len(decision.matched) != 0 {
+		t.Errorf("decision = %+v, want base rule 1 with no socket rules matched", decision)
+	}
+}
+
+func TestProxyHandler_ChunkedBodies(t *testing.T) {
+	type forwardedRequest struct {
+		contentLength    int64
+		transferEncoding []string
+		body             string
+	}
+	forwarded := make(chan forwardedRequest, 1)
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read forwarded body: %v", err)
+		}
+		forwarded <- forwardedRequest{contentLength: r.ContentLength, transferEncoding: r.TransferEncoding, body: string(body)}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	create := config.Match{Path: "/containers/create$", Method: "POST"}
+	configs := map[string]*config.SocketConfig{
+		"/tmp/rewrite.sock": {Rules: []config.Rule{
+			{Match: create, Actions: []config.Action{{Action: "upsert", Update: map[string]any{"Labels": map[string]any{"managed": "true"}}}, {Action: "allow"}}},
+		}},
+		"/tmp/inspect.sock": {Rules: []config.Rule{
+			{Match: config.Match{Path: create.Path, Method: create.Method, Contains: map[string]any{"Image": "alpine"}}, Actions: []config.Action{{Action: "allow"}}},
+		}},
+		"/tmp/stream.sock": {Rules: []config.Rule{
+			{Match: create, Actions: []config.Action{{Action: "allow"}}},
+		}},
+	}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+
+	tests := []struct {
+		name        string
+		socket      string
+		wantBody    string
+		wantChunked bool
+	}{
+		{name: "rewritten body", socket: "/tmp/rewrite.sock", wantBody: `{"Image":"alpine","Labels":{"managed":"true"}}`},
+		{name: "inspected body", socket: "/tmp/inspect.sock", wantBody: `{"Image":"alpine"}`},
+		{name: "body that is not read stays chunked", socket: "/tmp/stream.sock", wantBody: `{"Image":"alpine"}`, wantChunked: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handler.ServeHTTPWithSocket(w, r, tt.socket)
+			}))
+			defer proxy.Close()
+
+			conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+			if err != nil {
+				t.Fatalf("Failed to connect to proxy: %v", err)
+			}
+			defer func() {
+				if err := conn.Close(); err != nil {
+					t.Errorf("Failed to close connection: %v", err)
+				}
+			}()
+			if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+				t.Fatal(err)
+			}
+
+			// The body arrives in two chunks, without a Content-Length
+			request := "POST /v1.43/containers/create HTTP/1.1\r\n" +
+				"Host: docker\r\nContent-Type: application/json\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"a\r\n{\"Image\":\"\r\n8\r\nalpine\"}\r\n0\r\n\r\n"
+			if _, err := io.WriteString(conn, request); err != nil {
+				t.Fatalf("Failed to write request: %v", err)
+			}
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatalf("Failed to read response: %v", err)
+			}
+			if err := resp.Body.Close(); err != nil {
+				t.Errorf("Failed to close response body: %v", err)
+			}
+			if resp.StatusCode != http.StatusCreated {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusCreated)
+			}
+
+			got := <-forwarded
+			if got.body != tt.wantBody {
+				t.Errorf("forwarded body = %s, want %s", got.body, tt.wantBody)
+			}
+			if tt.wantChunked {
+				if len(got.transferEncoding) != 1 || got.transferEncoding[0] != "chunked" || got.contentLength != -1 {
+					t.Errorf("forwarded with Content-Length %d and Transfer-Encoding %v, want chunked", got.contentLength, got.transferEncoding)
+				}
+				return
+			}
+			if got.contentLength != int64(len(tt.wantBody)) || len(got.transferEncoding) != 0 {
+				t.Errorf("forwarded with Content-Length %d and Transfer-Encoding %v, want a Content-Length of %d only", got.contentLength, got.transferEncoding, len(tt.wantBody))
+			}
+		})
+	}
+}
+
+func TestProxyHandler_ShadowMode(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	forwarded := make(chan string, 1)
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded <- r.URL.Path
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	var logs bytes.Buffer
+	logging.SetOutput(&logs)
+	defer logging.SetOutput(os.Stdout)
+
+	auditPath := filepath.Join(tmpDir, "audit.jsonl")
+	socketPath := filepath.Join(tmpDir, "ci.sock")
+	cfg := &config.SocketConfig{
+		Config: config.ConfigSet{Mode: config.ModeShadow, AuditLog: auditPath, DefaultAction: config.DefaultActionDeny},
+		Rules: []config.Rule{
+			{Match: config.Match{Path: "^/v1.43/containers/json$"}, Actions: []config.Action{{Action: "allow"}}},
+			{Match: config.Match{Path: "^/v1.43/containers/abc/kill$"}, Actions: []config.Action{{Action: "rewrite-path", Pattern: "/kill$", Replacement: "/stop"}}},
+			{Match: config.Match{Path: "/stop$"}, Actions: []config.Action{{Action: "deny", Reason: "containers are not stopped from here"}}},
+		},
+	}
+	handler := NewProxyHandler(upstream, map[string]*config.SocketConfig{socketPath: cfg}, &sync.RWMutex{})
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantPath   string
+		wantShadow bool
+	}{
+		{name: "allowed", method: "GET", target: "/v1.43/containers/json", wantPath: "/v1.43/containers/json"},
+		{name: "denied by a rule after a rewrite", method: "POST", target: "/v1.43/containers/abc/kill", wantPath: "/v1.43/containers/abc/kill", wantShadow: true},
+		{name: "denied by default", method: "GET", target: "/v1.43/info", wantPath: "/v1.43/info", wantShadow: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, httptest.NewRequest(tt.method, tt.target, nil), socketPath)
+
+			if w.Code != http.StatusNoContent || w.Header().Get(deniedHeader) != "" {
+				t.Fatalf("status = %d, denied header %q, want the daemon's %d", w.Code, w.Header().Get(deniedHeader), http.StatusNoContent)
+			}
+			select {
+			case path := <-forwarded:
+				if path != tt.wantPath {
+					t.Errorf("forwarded path = %s, want %s", path, tt.wantPath)
+				}
+			default:
+				t.Fatal("request did not reach the daemon")
+			}
+			if got := strings.Contains(logs.String(), "forwarding it in shadow mode"); got != tt.wantShadow {
+				t.Errorf("shadow deny logged = %v, want %v: %s", got, tt.wantShadow, logs.String())
+			}
+			entries := handler.decisionLogFor(socketPath).tail(1)
+			if len(entries) != 1 || entries[0].Shadow != tt.wantShadow {
+				t.Errorf("decision log = %+v, want shadow %v", entries, tt.wantShadow)
+			}
+		})
+	}
+
+	stats := handler.snapshotStats(socketPath)
+	if stats.Allowed != 1 || stats.Denied != 0 || stats.ShadowDenied != 2 {
+		t.Errorf("stats = %+v, want 1 allowed and 2 shadow denied", stats)
+	}
+
+	handler.closeAuditLogs()
+	content, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	var shadowed []string
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var entry audit.Entry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("audit line is not JSON: %v", err)
+		}
+		if entry.Shadow {
+			if entry.Action != "deny" {
+				t.Errorf("shadow audit entry %+v is not a deny", entry)
+			}
+			shadowed = append(shadowed, entry.Reason)
+		}
+	}
+	if want := []string{"containers are not stopped from here", noMatchingAllowReason}; !reflect.DeepEqual(shadowed, want) {
+		t.Errorf("shadow audit reasons = %q, want %q", shadowed, want)
+	}
+}
+
+func TestProxyHandler_ForwardedHeaders(t *testing.T) {
+	type forwardedRequest struct {
+		host   string
+		header http.Header
+	}
+	forwarded := make(chan forwardedRequest, 1)
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded <- forwardedRequest{host: r.Host, header: r.Header.Clone()}
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("Api-Version", "1.43")
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	configs := map[string]*config.SocketConfig{
+		"/tmp/headers.sock": {Rules: []config.Rule{
+			{
+				Match:   config.Match{Path: "/containers/create$", Method: "POST"},
+				Actions: []config.Action{{Action: "upsert", Update: map[string]any{"Labels": map[string]any{"managed": "true"}}}, {Action: "allow"}},
+			},
+			{Match: config.Match{Path: "/.*"}, Actions: []config.Action{{Action: "allow"}}},
+		}},
+	}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{name: "image pull", method: "POST", target: "/v1.43/images/create?fromImage=alpine"},
+		{name: "rewritten create", method: "POST", target: "/v1.43/containers/create", body: `{"Image":"alpine"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.Host = "proxy.example:2375"
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Registry-Auth", "eyJ1c2VybmFtZSI6ImNpIn0=")
+			req.Header.Set("User-Agent", "Docker-Client/24.0.7")
+			req.Header.Set("Connection", "keep-alive, X-Client-Hop")
+			req.Header.Set("X-Client-Hop", "1")
+			req.Header.Set("Keep-Alive", "timeout=5")
+			req.Header.Set("Proxy-Authorization", "Basic Y2k6c2VjcmV0")
+			req.Header.Set("Proxy-Connection", "keep-alive")
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, req, "/tmp/headers.sock")
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+			}
+			got := <-forwarded
+			if got.host != "docker" {
+				t.Errorf("upstream Host = %q, want docker", got.host)
+			}
+			for name, want := range map[string]string{
+				"Content-Type":    "application/json",
+				"X-Registry-Auth": "eyJ1c2VybmFtZSI6ImNpIn0=",
+				"User-Agent":      "Docker-Client/24.0.7",
+			} {
+				if value := got.header.Get(name); value != want {
+					t.Errorf("upstream %s = %q, want %q", name, value, want)
+				}
+			}
+			for _, name := range []string{"Connection", "X-Client-Hop", "Keep-Alive", "Proxy-Authorization", "Proxy-Connection"} {
+				if value := got.header.Get(name); value != "" {
+					t.Errorf("hop-by-hop header %s = %q reached the upstream", name, value)
+				}
+			}
+
+			// The same goes for the response
+			if value := w.Header().Get("Keep-Alive"); value != "" {
+				t.Errorf("response Keep-Alive = %q, want it stripped", value)
+			}
+			if value := w.Header().Get("Api-Version"); value != "1.43" {
+				t.Errorf("response Api-Version = %q, want 1.43", value)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_TrustedProxies(t *testing.T) {
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rules := []config.Rule{
+		{Match: config.Match{Path: "/.*", SourceCIDR: []string{"10.0.0.0/8"}}, Actions: []config.Action{{Action: "allow"}}},
+	}
+	configs := map[string]*config.SocketConfig{
+		"/tmp/trusted.sock": {
+			Config: config.ConfigSet{DefaultAction: config.DefaultActionDeny, TrustedProxies: []string{"192.0.2.0/24"}},
+			Rules:  rules,
+		},
+		"/tmp/untrusted.sock": {
+			Config: config.ConfigSet{DefaultAction: config.DefaultActionDeny},
+			Rules:  rules,
+		},
+	}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+
+	tests := []struct {
+		name       string
+		socket     string
+		remoteAddr string
+		forwarded  string
+		wantStatus int
+	}{
+		{name: "forwarded by a trusted hop", socket: "/tmp/trusted.sock", remoteAddr: "192.0.2.1:1234", forwarded: "10.1.2.3", wantStatus: http.StatusOK},
+		{name: "forwarded by an untrusted hop", socket: "/tmp/trusted.sock", remoteAddr: "198.51.100.1:1234", forwarded: "10.1.2.3", wantStatus: http.StatusForbidden},
+		{name: "client behind a trusted hop outside the cidr", socket: "/tmp/trusted.sock", remoteAddr: "192.0.2.1:1234", forwarded: "203.0.113.9", wantStatus: http.StatusForbidden},
+		{name: "socket without trusted proxies", socket: "/tmp/untrusted.sock", remoteAddr: "192.0.2.1:1234", forwarded: "10.1.2.3", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/v1.43/_ping", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, req, tt.socket)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"docker-socket-proxy/internal/proxy/config"
+)
+
+// rateLimitKey identifies the bucket of one ratelimit action of a socket, so
+// that two rate limited rules on the same socket do not share a budget
+type rateLimitKey struct {
+	socket string
+	base   bool
+	rule   int
+	action int
+}
+
+// rateLimiter is a token bucket holding up to limit tokens, refilled at
+// limit tokens per window
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a full bucket
+func newRateLimiter(limit int, window time.Duration, now time.Time) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		tokens: float64(limit),
+		last:   now,
+	}
+}
+
+// allow takes a token if one is available. When the bucket is empty it
+// returns how long until the next token is added.
+func (l *rateLimiter) allow(now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens = l.refilled(now)
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, l.wait(l.tokens)
+}
+
+// peek reports what allow would return without taking a token
+func (l *rateLimiter) peek(now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if tokens := l.refilled(now); tokens < 1 {
+		return false, l.wait(tokens)
+	}
+	return true, 0
+}
+
+// refilled returns the tokens in the bucket at now, l.mu must be held
+func (l *rateLimiter) refilled(now time.Time) float64 {
+	tokens := l.tokens
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		tokens += float64(elapsed) / float64(l.window/time.Duration(l.limit))
+	}
+	return min(tokens, float64(l.limit))
+}
+
+// wait returns how long until a bucket holding tokens has a whole one
+func (l *rateLimiter) wait(tokens float64) time.Duration {
+	return time.Duration((1 - tokens) * float64(l.window/time.Duration(l.limit)))
+}
+
+// rateLimiterFor returns the bucket for a ratelimit action, starting a new
+// one when the action's limit or window has changed since it was created
+func (h *ProxyHandler) rateLimiterFor(key rateLimitKey, action config.Action, now time.Time) *rateLimiter {
+	h.rateLimitMu.Lock()
+	defer h.rateLimitMu.Unlock()
+
+	if h.rateLimiters == nil {
+		h.rateLimiters = make(map[rateLimitKey]*rateLimiter)
+	}
+	window := action.RateWindow()
+	limiter, ok := h.rateLimiters[key]
+	if !ok || limiter.limit != action.Limit || limiter.window != window {
+		limiter = newRateLimiter(action.Limit, window, now)
+		h.rateLimiters[key] = limiter
+	}
+	return limiter
+}
+
+// peekRateLimiter reports whether a ratelimit action would let a request
+// through without taking a token, nor starting a bucket when there is none
+func (h *ProxyHandler) peekRateLimiter(key rateLimitKey, action config.Action, now time.Time) (bool, time.Duration) {
+	h.rateLimitMu.Lock()
+	limiter, ok := h.rateLimiters[key]
+	h.rateLimitMu.Unlock()
+
+	// A bucket that would be started afresh is full
+	if !ok || limiter.limit != action.Limit || limiter.window != action.RateWindow() {
+		return true, 0
+	}
+	return limiter.peek(now)
+}
+
+// forgetRateLimiters drops the buckets of a deleted socket
+func (h *ProxyHandler) forgetRateLimiters(socketPath string) {
+	h.rateLimitMu.Lock()
+	defer h.rateLimitMu.Unlock()
+
+	for key := range h.rateLimiters {
+		if key.socket == socketPath {
+			delete(h.rateLimiters, key)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"docker-socket-proxy/internal/logging"
+	"docker-socket-proxy/internal/proxy/config"
+)
+
+// rewriteResponse applies response phase actions to a JSON upstream response.
+// Objects are rewritten directly, arrays of objects element by element, and
+// any other response is passed through untouched.
+func rewriteResponse(resp *http.Response, actions []config.Action) error {
+	if len(actions) == 0 || resp.Body == nil {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "application/json" || resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		return fmt.Errorf("failed to close response body: %w", err)
+	}
+
+	var body any
+	if err := json.Unmarshal(bodyBytes, &body); err != nil {
+		// Not valid JSON after all, pass it through unchanged
+		setResponseBody(resp, bodyBytes)
+		return nil
+	}
+
+	if !rewriteResponseValue(body, actions) {
+		setResponseBody(resp, bodyBytes)
+		return nil
+	}
+
+	newBodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal modified response body: %w", err)
+	}
+
+	logging.GetLogger().Debug("Rewrote response body", "actions", len(actions))
+	setResponseBody(resp, newBodyBytes)
+	return nil
+}
+
+// rewriteResponseValue applies the actions to an object or array of objects
+func rewriteResponseValue(value any, actions []config.Action) bool {
+	modified := false
+
+	switch v := value.(type) {
+	case map[string]any:
+		for _, action := range actions {
+			if config.ApplyRewriteAction(v, action) {
+				modified = true
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if obj, ok := item.(map[string]any); ok && rewriteResponseValue(obj, actions) {
+				modified = true
+			}
+		}
+	}
+
+	return modified
+}
+
+// setResponseBody replaces the response body and fixes up its length
+func setResponseBody(resp *http.Response, body []byte) {
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.TransferEncoding = nil
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
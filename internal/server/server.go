@@ -2,20 +2,24 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
 	"sync"
 	"syscall"
 	"time"
 
 	"docker-socket-proxy/internal/logging"
-	"docker-socket-proxy/internal/management"
 	"docker-socket-proxy/internal/proxy/config"
 	"docker-socket-proxy/internal/storage"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Server represents the Docker socket proxy server
@@ -23,39 +27,200 @@ type Server struct {
 	managementSocket string
 	dockerSocket     string
 	socketDir        string
+	configDir        string
 	server           *http.Server
+	handler          *ManagementHandler
 	socketConfigs    map[string]*config.SocketConfig
 	proxyServers     map[string]*http.Server
 	createdSockets   []string
 	store            *storage.FileStore
+	// configMu guards both socketConfigs and proxyServers, so that a socket
+	// is never seen with a config but no server or the other way round
 	configMu         sync.RWMutex
-	proxyMu          sync.RWMutex
 	socketMu         sync.Mutex
+	watchdogInterval time.Duration
+	watchConfigs     bool
+	basePath         string
+	managementToken  string
+	enforceStorePerm bool
+	allowInsecureDir bool
+	baseConfigPath   string
+	dockerTLS        *tls.Config
+	managementListen string
+	managementTLS    *tls.Config
+	onError          string
+	drainTimeout     time.Duration
+	tracerProvider   trace.TracerProvider
+	done             chan struct{}
+	stopOnce         sync.Once
+}
+
+// Option configures optional server behaviour
+type Option func(*Server)
+
+// WithManagementBasePath serves the management API routes under a path prefix
+func WithManagementBasePath(basePath string) Option {
+	return func(s *Server) {
+		s.basePath = basePath
+	}
+}
+
+// WithManagementToken requires management API requests to carry the token
+// as a bearer token, an empty token leaves the API open
+func WithManagementToken(token string) Option {
+	return func(s *Server) {
+		s.managementToken = token
+	}
+}
+
+// WithEnforcedStoragePermissions restricts the config storage directory to
+// its owner at startup, rather than only warning when it is broader
+func WithEnforcedStoragePermissions(enforce bool) Option {
+	return func(s *Server) {
+		s.enforceStorePerm = enforce
+	}
+}
+
+// WithAllowInsecureDir starts the daemon even when the socket or config
+// directory is world-writable or owned by another user, with a warning
+// rather than an error
+func WithAllowInsecureDir(allow bool) Option {
+	return func(s *Server) {
+		s.allowInsecureDir = allow
+	}
+}
+
+// WithBaseConfig evaluates the rules of a config file ahead of every
+// socket's own rules, an empty path leaves sockets to their own rules
+func WithBaseConfig(path string) Option {
+	return func(s *Server) {
+		s.baseConfigPath = path
+	}
+}
+
+// WithOnError sets the policy for requests whose rules fail to evaluate,
+// OnErrorDeny or OnErrorAllow
+func WithOnError(policy string) Option {
+	return func(s *Server) {
+		s.onError = policy
+	}
+}
+
+// WithDockerTLSConfig sets the TLS config used to reach a TCP Docker daemon,
+// a tcp:// daemon address is only dialed over TLS when one is given
+func WithDockerTLSConfig(tlsConfig *tls.Config) Option {
+	return func(s *Server) {
+		s.dockerTLS = tlsConfig
+	}
+}
+
+// WithConfigDir stores socket configs in their own directory rather than
+// alongside the sockets in the socket directory
+func WithConfigDir(dir string) Option {
+	return func(s *Server) {
+		if dir != "" {
+			s.configDir = dir
+		}
+	}
+}
+
+// DefaultDrainTimeout is how long a deleted socket's in-flight requests are
+// given to finish before their connections are closed
+const DefaultDrainTimeout = 10 * time.Second
+
+// WithDrainTimeout sets how long a deleted socket's in-flight requests are
+// given to finish, 0 closes their connections straight away
+func WithDrainTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.drainTimeout = timeout
+	}
 }
 
 type contextKey string
 
 const serverContextKey contextKey = "server"
 
+// dryRunContextKey marks a request that is only evaluated, never forwarded,
+// with the path of the socket whose rules it is evaluated against
+const dryRunContextKey contextKey = "dry-run"
+
 // NewServer creates a new server instance
-func NewServer(managementSocket, dockerSocket, socketDir string) (*Server, error) {
+func NewServer(managementSocket, dockerSocket, socketDir string, opts ...Option) (*Server, error) {
 	// Create socket directory if it doesn't exist
 	if err := os.MkdirAll(socketDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create socket directory: %w", err)
 	}
 
-	// Create the file store
-	store := storage.NewFileStore(socketDir)
-
-	return &Server{
+	srv := &Server{
 		managementSocket: managementSocket,
 		dockerSocket:     dockerSocket,
 		socketDir:        socketDir,
 		socketConfigs:    make(map[string]*config.SocketConfig),
 		proxyServers:     make(map[string]*http.Server),
 		createdSockets:   make([]string, 0),
-		store:            store,
-	}, nil
+		configDir:        socketDir,
+		drainTimeout:     DefaultDrainTimeout,
+		done:             make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(srv)
+	}
+
+	// Create the file store, its directory is only for the daemon
+	if err := os.MkdirAll(srv.configDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	// Anyone who can write to these directories could plant their own sockets
+	// or configs for the daemon to serve
+	for _, dir := range []string{socketDir, srv.configDir} {
+		if err := srv.checkDirectoryOwnership(dir); err != nil {
+			return nil, err
+		}
+	}
+	store := storage.NewFileStore(srv.configDir)
+	srv.store = store
+
+	switch srv.onError {
+	case "":
+		srv.onError = OnErrorDeny
+	case OnErrorDeny, OnErrorAllow:
+	default:
+		return nil, fmt.Errorf("invalid on-error policy %q, expected %s or %s", srv.onError, OnErrorDeny, OnErrorAllow)
+	}
+	if srv.drainTimeout < 0 {
+		return nil, fmt.Errorf("invalid drain timeout %v, it must not be negative", srv.drainTimeout)
+	}
+	if err := srv.parseManagementListen(); err != nil {
+		return nil, err
+	}
+
+	// The Docker daemon may be a unix socket or a TCP address
+	up, err := parseUpstream(dockerSocket, srv.dockerTLS)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the management handler, its proxy handler is shared by every proxy socket
+	srv.handler = newManagementHandler(dockerSocket, srv.socketConfigs, &srv.configMu, store, srv.basePath)
+	srv.handler.token = srv.managementToken
+	srv.handler.proxyHandler.tracerProvider = srv.tracerProvider
+	srv.handler.proxyHandler.upstream = up
+	srv.handler.proxyHandler.onError = srv.onError
+
+	// The base config's rules come first for every socket, a config that
+	// does not load is not left out silently
+	if srv.baseConfigPath != "" {
+		baseConfig, err := config.LoadSocketConfig(srv.baseConfigPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load base config %s: %w", srv.baseConfigPath, err)
+		}
+		srv.handler.proxyHandler.baseConfig = baseConfig
+		logging.GetLogger().Info("Loaded base config", "path", srv.baseConfigPath, "rules", len(baseConfig.Rules))
+	}
+
+	return srv, nil
 }
 
 // TrackSocket adds a socket to the list of created sockets
@@ -86,6 +251,9 @@ func (s *Server) Start() error {
 		return err
 	}
 
+	// Persisted configs may hold sensitive patterns
+	s.checkStoragePermissions()
+
 	// Set up signal handling
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
@@ -96,49 +264,166 @@ func (s *Server) Start() error {
 		os.Exit(0)
 	}()
 
+	// SIGUSR2 toggles debug logging on and off
+	usr2Chan := make(chan os.Signal, 1)
+	signal.Notify(usr2Chan, syscall.SIGUSR2)
+	go func() {
+		for range usr2Chan {
+			level := logging.ToggleDebug()
+			logging.GetLogger().Info("Log level toggled", "level", level.String())
+		}
+	}()
+
 	// Load existing socket configurations
-	if err := s.loadExistingConfigs(); err != nil {
+	summary, err := s.loadExistingConfigs()
+	if err != nil {
 		log.Error("Failed to load existing configurations", "error", err)
 		// Continue anyway - we can still serve new sockets
 	}
+	summary.log()
 
-	// Create the listener
-	listener, err := net.Listen("unix", s.managementSocket)
-	if err != nil {
-		return fmt.Errorf("failed to create listener: %w", err)
+	// Reload configs edited on disk, if enabled
+	if s.watchConfigs {
+		if err := s.startConfigWatcher(); err != nil {
+			return err
+		}
 	}
 
-	// Set socket permissions
-	if err := os.Chmod(s.managementSocket, 0660); err != nil {
-		log.Warn("Failed to set socket permissions", "error", err)
+	// Watch for proxy socket files disappearing, if enabled
+	if s.watchdogInterval > 0 {
+		go s.runWatchdog(s.watchdogInterval)
 	}
 
-	// Create the management handler
-	handler := NewManagementHandler(s.dockerSocket, s.socketConfigs, &s.configMu, s.store)
+	// Delete sockets that outlive their ttl or idle_timeout
+	go s.runReaper(reapInterval)
+
+	// Summarise sampled allow logs, even once a socket's traffic stops
+	go s.handler.proxyHandler.runAllowLogFlusher(allowLogFlushInterval, s.done)
+
+	// Create the listener
+	listener, err := s.listenManagement()
+	if err != nil {
+		return err
+	}
 
 	// Create the server
 	s.server = &http.Server{
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := context.WithValue(r.Context(), serverContextKey, s)
-			handler.ServeHTTP(w, r.WithContext(ctx))
+			s.handler.ServeHTTP(w, r.WithContext(ctx))
 		}),
 	}
 
-	log.Info("Management server listening on socket", "path", s.managementSocket)
 	log.Debug("Active proxy sockets", "count", len(s.createdSockets))
 
 	return s.server.Serve(listener)
 }
 
+// reapInterval is how often sockets are checked against their ttl and idle_timeout
+var reapInterval = 10 * time.Second
+
+// runReaper periodically deletes expired sockets until the server stops
+func (s *Server) runReaper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.reapSockets(now)
+		}
+	}
+}
+
+// reapSockets deletes every socket that has outlived its ttl or gone unused
+// for longer than its idle_timeout at now
+func (s *Server) reapSockets(now time.Time) {
+	s.configMu.RLock()
+	var expired []string
+	for socketPath, cfg := range s.socketConfigs {
+		if s.socketExpiry(socketPath, cfg, now) != "" {
+			expired = append(expired, socketPath)
+		}
+	}
+	s.configMu.RUnlock()
+
+	sort.Strings(expired)
+	for _, socketPath := range expired {
+		s.reapSocket(socketPath, now)
+	}
+}
+
+// reapSocket deletes a socket if it is still expired. It holds the
+// management handler's create lock, so that a socket replaced or used since
+// it was found to be expired is checked again before it goes.
+func (s *Server) reapSocket(socketPath string, now time.Time) {
+	log := logging.GetLogger()
+
+	s.handler.createMu.Lock()
+	defer s.handler.createMu.Unlock()
+
+	s.configMu.RLock()
+	cfg, ok := s.socketConfigs[socketPath]
+	reason := ""
+	if ok {
+		reason = s.socketExpiry(socketPath, cfg, now)
+	}
+	s.configMu.RUnlock()
+	if reason == "" {
+		return
+	}
+
+	ctx, cancel := drainContext(s)
+	defer cancel()
+	if err := s.handler.deleteSocket(ctx, socketPath, s); err != nil {
+		log.Error("Failed to reap expired socket", "path", socketPath, "reason", reason, "error", err)
+		return
+	}
+	log.Info("Reaped expired socket", "path", socketPath, "reason", reason)
+}
+
+// socketExpiry returns why a socket has expired at now, or an empty string
+// when it has not
+func (s *Server) socketExpiry(socketPath string, cfg *config.SocketConfig, now time.Time) string {
+	ttl := cfg.Config.TTLDuration()
+	idleTimeout := cfg.Config.IdleTimeoutDuration()
+	if ttl == 0 && idleTimeout == 0 {
+		return ""
+	}
+
+	createdAt, lastActivity := s.handler.proxyHandler.socketActivity(socketPath)
+	if ttl > 0 && now.Sub(createdAt) >= ttl {
+		return fmt.Sprintf("ttl of %s elapsed", ttl)
+	}
+	if idleTimeout > 0 && !lastActivity.IsZero() && now.Sub(lastActivity) >= idleTimeout {
+		return fmt.Sprintf("idle for longer than %s", idleTimeout)
+	}
+	return ""
+}
+
 // Stop stops the server
 func (s *Server) Stop() {
 	log := logging.GetLogger()
 	log.Debug("Stopping server")
 
+	// Stop background tasks
+	s.stopOnce.Do(func() {
+		if s.done != nil {
+			close(s.done)
+		}
+	})
+
 	// Create a context with a timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// End log followers, which would otherwise keep their requests open
+	if s.handler != nil {
+		s.handler.proxyHandler.closeDecisionLogs()
+	}
+
 	// Shutdown the management server
 	if s.server != nil {
 		if err := s.server.Shutdown(ctx); err != nil {
@@ -146,14 +431,30 @@ func (s *Server) Stop() {
 		}
 	}
 
-	// Shutdown all proxy servers
-	s.proxyMu.Lock()
-	for path, server := range s.proxyServers {
+	// Shutdown all proxy servers. They are shut down outside the lock, as
+	// their in-flight requests need it to read their socket's config.
+	s.configMu.Lock()
+	servers := s.proxyServers
+	s.proxyServers = make(map[string]*http.Server)
+	s.configMu.Unlock()
+	for path, server := range servers {
 		if err := server.Shutdown(ctx); err != nil {
 			log.Error("Error shutting down proxy server", "error", err, "path", path)
 		}
 	}
-	s.proxyMu.Unlock()
+
+	// Write out decisions made before the proxies stopped
+	if s.handler != nil {
+		s.handler.proxyHandler.flushAllowLogs()
+		s.handler.proxyHandler.closeAuditLogs()
+	}
+
+	// Flush any buffered spans
+	if tp, ok := s.tracerProvider.(interface{ Shutdown(context.Context) error }); ok {
+		if err := tp.Shutdown(ctx); err != nil {
+			log.Error("Error shutting down tracer provider", "error", err)
+		}
+	}
 
 	// Clean up resources
 	s.cleanup()
@@ -161,6 +462,10 @@ func (s *Server) Stop() {
 
 // prepareSocket prepares the management socket
 func (s *Server) prepareSocket() error {
+	if s.managementOverTCP() {
+		return nil
+	}
+
 	// Remove existing socket if it exists
 	if err := os.Remove(s.managementSocket); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to remove existing socket: %w", err)
@@ -168,92 +473,324 @@ func (s *Server) prepareSocket() error {
 	return nil
 }
 
-// loadExistingConfigs loads existing socket configurations and restarts their servers
-func (s *Server) loadExistingConfigs() error {
+// checkStoragePermissions warns when the config storage directory is
+// accessible by anyone but its owner, or restricts it if enforcement is enabled
+func (s *Server) checkStoragePermissions() {
 	log := logging.GetLogger()
 
+	err := s.store.CheckPermissions(s.enforceStorePerm)
+	switch {
+	case errors.Is(err, storage.ErrLoosePermissions):
+		log.Warn("Storage directory permissions are too broad, persisted socket configs may be readable by other users",
+			"path", s.store.Dir(),
+			"error", err,
+			"hint", "chmod 0700 the directory or run the daemon with --enforce-storage-permissions",
+		)
+	case err != nil:
+		log.Error("Failed to check storage directory permissions", "path", s.store.Dir(), "error", err)
+	}
+}
+
+// checkDirectoryOwnership refuses a directory that is world-writable or
+// owned by someone other than the daemon's user or root, or only warns
+// about it when insecure directories are allowed
+func (s *Server) checkDirectoryOwnership(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to check directory %s: %w", dir, err)
+	}
+
+	var problem string
+	if info.Mode().Perm()&0002 != 0 {
+		problem = fmt.Sprintf("directory %s is world-writable (mode %04o)", dir, info.Mode().Perm())
+	} else if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Uid != 0 && int(stat.Uid) != os.Geteuid() {
+		problem = fmt.Sprintf("directory %s is owned by uid %d, not the daemon's uid %d", dir, stat.Uid, os.Geteuid())
+	}
+	if problem == "" {
+		return nil
+	}
+
+	if !s.allowInsecureDir {
+		return fmt.Errorf("%s, other users could plant sockets or configs in it; fix its permissions or start with --allow-insecure-dir", problem)
+	}
+	logging.GetLogger().Warn("INSECURE DIRECTORY: other users could plant sockets or configs the daemon serves",
+		"path", dir,
+		"problem", problem,
+		"hint", "chmod o-w the directory and chown it to the daemon's user",
+	)
+	return nil
+}
+
+// startupSummary describes the outcome of restoring persisted sockets.
+// DefaultPolicy is the default_action of sockets that do not set one, and
+// DenyByDefault counts the restored sockets that deny by default.
+type startupSummary struct {
+	Restored      int
+	Rules         int
+	Failed        map[string]string
+	DefaultPolicy string
+	DenyByDefault int
+}
+
+// log emits the summary as a single structured line
+func (s startupSummary) log() {
+	failed := make([]string, 0, len(s.Failed))
+	for path := range s.Failed {
+		failed = append(failed, path)
+	}
+	sort.Strings(failed)
+
+	reasons := make([]string, 0, len(failed))
+	for _, path := range failed {
+		reasons = append(reasons, path+": "+s.Failed[path])
+	}
+
+	logging.GetLogger().Info("Startup configuration summary",
+		"sockets_restored", s.Restored,
+		"total_rules", s.Rules,
+		"sockets_failed", len(failed),
+		"failures", reasons,
+		"default_policy", s.DefaultPolicy,
+		"sockets_default_deny", s.DenyByDefault,
+	)
+}
+
+// loadExistingConfigs loads existing socket configurations and restarts their servers
+func (s *Server) loadExistingConfigs() (startupSummary, error) {
+	summary := startupSummary{
+		Failed:        make(map[string]string),
+		DefaultPolicy: config.DefaultActionAllow,
+	}
+
 	// Get all socket config files
-	configs, err := s.store.LoadExistingConfigs()
+	configs, failures, err := s.store.LoadAllConfigs()
 	if err != nil {
-		return fmt.Errorf("failed to list configs: %w", err)
+		return summary, fmt.Errorf("failed to list configs: %w", err)
+	}
+
+	// The store knows sockets by name, they are served from the socket directory
+	for name, err := range failures {
+		summary.Failed[filepath.Join(s.socketDir, name)] = err.Error()
 	}
 
 	// Load each config
-	for path, cfg := range configs {
-		// Ensure the socket path is in the correct directory
-		socketName := filepath.Base(path)
-		socketPath := filepath.Join(management.DefaultSocketDir, socketName)
-
-		// Check if the socket file exists and remove it if it does
-		// (we'll recreate it with the listener)
-		if _, err := os.Stat(socketPath); err == nil {
-			if err := os.Remove(socketPath); err != nil {
-				log.Warn("Failed to remove existing socket file", "path", socketPath, "error", err)
-				continue
-			}
+	for name, cfg := range configs {
+		socketPath := filepath.Join(s.socketDir, name)
+
+		if err := s.restoreSocket(socketPath, cfg); err != nil {
+			summary.Failed[socketPath] = err.Error()
+			continue
 		}
 
-		// Add the config to the map
-		s.configMu.Lock()
-		s.socketConfigs[socketPath] = cfg
-		s.configMu.Unlock()
+		summary.Restored++
+		summary.Rules += len(cfg.Rules)
+		if cfg.DeniesByDefault() {
+			summary.DenyByDefault++
+		}
+	}
 
-		// Create a new listener for the socket
-		listener, err := net.Listen("unix", socketPath)
-		if err != nil {
-			log.Error("Failed to create listener for existing socket", "path", socketPath, "error", err)
-			continue
+	return summary, nil
+}
+
+// restoreSocket starts serving a proxy socket for a config loaded from the
+// store, replacing a stale socket file left behind by a previous run
+func (s *Server) restoreSocket(socketPath string, cfg *config.SocketConfig) error {
+	log := logging.GetLogger()
+
+	// Check if the socket file exists and remove it if it does
+	// (we'll recreate it with the listener)
+	if _, err := os.Stat(socketPath); err == nil {
+		if err := os.Remove(socketPath); err != nil {
+			log.Warn("Failed to remove existing socket file", "path", socketPath, "error", err)
+			return err
+		}
+	}
+
+	// Create a new listener for the socket
+	listener, err := listenProxySocket(socketPath, cfg)
+	if err != nil {
+		log.Error("Failed to create listener for existing socket", "path", socketPath, "error", err)
+		return err
+	}
+
+	// Start tracking runtime stats for the socket
+	s.handler.proxyHandler.registerSocket(socketPath)
+
+	// Create a server for the socket
+	server := newProxyServer(s.handler.proxyHandler, socketPath)
+
+	// Add the config and the server to the maps together
+	s.configMu.Lock()
+	s.socketConfigs[socketPath] = cfg
+	s.proxyServers[socketPath] = server
+	s.configMu.Unlock()
+
+	// Track the socket
+	s.TrackSocket(socketPath)
+
+	// Start the server in a goroutine
+	go func() {
+		log.Info("Restored proxy socket", "path", socketPath)
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Error("Proxy server error", "error", err, "path", socketPath)
 		}
+	}()
+
+	return nil
+}
+
+// listenProxySocket creates the listener for a proxy socket, a unix socket
+// at socketPath unless its config gives a TCP listen address
+func listenProxySocket(socketPath string, cfg *config.SocketConfig) (net.Listener, error) {
+	log := logging.GetLogger()
 
-		// Set socket permissions
-		if err := os.Chmod(socketPath, 0660); err != nil {
-			log.Warn("Failed to set socket permissions", "path", socketPath, "error", err)
+	if cfg != nil && cfg.Config.Listen != "" {
+		address, err := cfg.Config.ListenAddress()
+		if err != nil {
+			return nil, err
+		}
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, err
 		}
+		return limitListener(listener, cfg), nil
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Socket files are removed explicitly, so closing a replaced listener
+	// must not unlink a socket that has since been recreated at the same path
+	if unixListener, ok := listener.(*net.UnixListener); ok {
+		unixListener.SetUnlinkOnClose(false)
+	}
 
-		// Create a proxy handler for the socket
-		proxyHandler := NewProxyHandler(s.dockerSocket, s.socketConfigs, &s.configMu)
-
-		// Create a server for the socket
-		server := &http.Server{
-			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				s.configMu.RLock()
-				socketConfig, ok := s.socketConfigs[socketPath]
-				s.configMu.RUnlock()
-
-				if ok && socketConfig != nil {
-					// Serve the request
-					proxyHandler.ServeHTTPWithSocket(w, r, socketPath)
-				}
-			}),
+	if err := setSocketPermissions(socketPath, cfg); err != nil {
+		if closeErr := listener.Close(); closeErr != nil {
+			log.Error("Failed to close listener", "path", socketPath, "error", closeErr)
 		}
+		if removeErr := os.Remove(socketPath); removeErr != nil && !os.IsNotExist(removeErr) {
+			log.Error("Failed to remove socket file", "path", socketPath, "error", removeErr)
+		}
+		return nil, err
+	}
 
-		// Add the server to the map
-		s.proxyMu.Lock()
-		s.proxyServers[socketPath] = server
-		s.proxyMu.Unlock()
+	return limitListener(listener, cfg), nil
+}
 
-		// Track the socket
-		s.TrackSocket(socketPath)
+// setSocketPermissions applies the socket_mode and socket_group of a config
+// to its socket file
+func setSocketPermissions(socketPath string, cfg *config.SocketConfig) error {
+	var configSet config.ConfigSet
+	if cfg != nil {
+		configSet = cfg.Config
+	}
 
-		// Start the server in a goroutine
-		go func(p string, l net.Listener) {
-			log.Info("Restored proxy socket", "path", p)
-			if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
-				log.Error("Proxy server error", "error", err, "path", p)
-			}
-		}(socketPath, listener)
+	mode, err := configSet.SocketFileMode()
+	if err != nil {
+		return err
+	}
+	gid, ok, err := configSet.SocketGroupID()
+	if err != nil {
+		return err
 	}
 
+	if ok {
+		if err := os.Chown(socketPath, -1, gid); err != nil {
+			return fmt.Errorf("failed to set socket group: %w", err)
+		}
+	}
+	if err := os.Chmod(socketPath, mode); err != nil {
+		return fmt.Errorf("failed to set socket permissions: %w", err)
+	}
 	return nil
 }
 
+// newProxyServer creates the HTTP server that serves a proxy socket
+func newProxyServer(proxyHandler *ProxyHandler, socketPath string) *http.Server {
+	return &http.Server{
+		ConnContext: peerCredContext,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxyHandler.ServeHTTPWithSocket(w, r, socketPath)
+		}),
+	}
+}
+
+// limitListener caps the number of simultaneously open connections when the
+// socket config sets max_connections. Accepts beyond the limit block until an
+// existing connection closes.
+func limitListener(listener net.Listener, cfg *config.SocketConfig) net.Listener {
+	if cfg == nil || cfg.Config.MaxConnections <= 0 {
+		return listener
+	}
+	return &limitedListener{
+		Listener: listener,
+		slots:    make(chan struct{}, cfg.Config.MaxConnections),
+		done:     make(chan struct{}),
+	}
+}
+
+// limitedListener holds a slot for every connection it accepted until the
+// connection closes
+type limitedListener struct {
+	net.Listener
+	slots     chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Accept waits for a free slot, then for a connection
+func (l *limitedListener) Accept() (net.Conn, error) {
+	select {
+	case l.slots <- struct{}{}:
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	return &limitedConn{Conn: conn, release: func() { <-l.slots }}, nil
+}
+
+// Close stops the listener and any Accept waiting for a slot
+func (l *limitedListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// limitedConn frees its listener slot once closed. NetConn returns the
+// accepted connection, so that peerCredContext can read its credentials.
+type limitedConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
+
+// NetConn returns the connection the listener accepted
+func (c *limitedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
 // cleanup cleans up resources
 func (s *Server) cleanup() {
 	log := logging.GetLogger()
 
 	// Remove the management socket
-	if err := os.Remove(s.managementSocket); err != nil && !os.IsNotExist(err) {
-		log.Error("Failed to remove management socket", "error", err)
+	if !s.managementOverTCP() {
+		if err := os.Remove(s.managementSocket); err != nil && !os.IsNotExist(err) {
+			log.Error("Failed to remove management socket", "error", err)
+		}
 	}
 
 	// Remove all created sockets
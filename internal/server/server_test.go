@@ -1,13 +1,20 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -155,3 +162,1114 @@ func (s *Server) startWithContext(ctx context.Context) error {
 
 	return s.server.Serve(listener)
 }
+
+func TestLimitListener(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	socketPath := filepath.Join(tmpDir, "limited.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.SocketConfig{Config: config.ConfigSet{MaxConnections: 2}}
+	listener = limitListener(listener, cfg)
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			t.Errorf("Serve() error = %v", err)
+		}
+	}()
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	// request sends a GET over the connection and reports whether a response arrived in time
+	request := func(conn net.Conn) bool {
+		if err := conn.SetDeadline(time.Now().Add(200 * time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write([]byte("GET /_ping HTTP/1.1\r\nHost: docker\r\n\r\n")); err != nil {
+			return false
+		}
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		return err == nil && strings.HasPrefix(string(buf[:n]), "HTTP/1.1 200")
+	}
+
+	// Open connections up to the limit, both are served
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+		if !request(conn) {
+			t.Fatalf("connection %d within the limit was not served", i)
+		}
+	}
+
+	// The next connection is not accepted while the others stay open
+	extra, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := extra.Close(); err != nil {
+			t.Errorf("Failed to close connection: %v", err)
+		}
+	}()
+	if request(extra) {
+		t.Fatal("connection beyond the limit should block until another closes")
+	}
+
+	// Closing a connection frees a slot and the waiting connection is served
+	if err := conns[0].Close(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := conns[1].Close(); err != nil {
+			t.Errorf("Failed to close connection: %v", err)
+		}
+	}()
+
+	if err := extra.SetDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := extra.Read(buf)
+	if err != nil || !strings.HasPrefix(string(buf[:n]), "HTTP/1.1 200") {
+		t.Errorf("expected waiting connection to be served after one closed, got %q (err %v)", buf[:n], err)
+	}
+}
+
+func TestLimitListener_PeerCredentials(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "limited.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A peer_uid deny still sees the caller through the connection limit
+	uid := uint32(os.Getuid())
+	cfg := &config.SocketConfig{
+		Config: config.ConfigSet{MaxConnections: 2},
+		Rules: []config.Rule{
+			{Match: config.Match{Path: "/.*", PeerUID: &uid}, Actions: []config.Action{{Action: "deny", Reason: "not this user"}}},
+		},
+	}
+	proxyHandler := NewProxyHandler("/tmp/docker.sock", map[string]*config.SocketConfig{socketPath: cfg}, &sync.RWMutex{})
+	server := newProxyServer(proxyHandler, socketPath)
+	go func() {
+		if err := server.Serve(limitListener(listener, cfg)); err != nil && err != http.ErrServerClosed {
+			t.Errorf("Serve() error = %v", err)
+		}
+	}()
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", socketPath)
+			},
+		},
+	}
+	resp, err := client.Get("http://docker/v1.42/_ping")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response body: %v", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		t.Errorf("Failed to close response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(string(body), "not this user") {
+		t.Errorf("status = %d, body = %s, want the peer_uid deny", resp.StatusCode, body)
+	}
+}
+
+func TestLimitListener_Unlimited(t *testing.T) {
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "unlimited.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := listener.Close(); err != nil {
+			t.Errorf("Failed to close listener: %v", err)
+		}
+	}()
+
+	if got := limitListener(listener, &config.SocketConfig{}); got != listener {
+		t.Error("expected listener to be returned unchanged without max_connections")
+	}
+}
+
+func TestListenProxySocketPermissions(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	gid := strconv.Itoa(os.Getgid())
+	tests := []struct {
+		name     string
+		config   config.ConfigSet
+		wantMode os.FileMode
+		wantErr  bool
+	}{
+		{name: "default mode", wantMode: 0660},
+		{name: "configured mode", config: config.ConfigSet{SocketMode: "0600"}, wantMode: 0600},
+		{name: "configured group", config: config.ConfigSet{SocketMode: "660", SocketGroup: gid}, wantMode: 0660},
+		{name: "unknown group", config: config.ConfigSet{SocketGroup: "no-such-group-docker-socket-proxy"}, wantErr: true},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			socketPath := filepath.Join(tmpDir, fmt.Sprintf("perm-%d.sock", i))
+			listener, err := listenProxySocket(socketPath, &config.SocketConfig{Config: tt.config})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if _, statErr := os.Stat(socketPath); !os.IsNotExist(statErr) {
+					t.Errorf("expected the socket file to be removed, stat error = %v", statErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("listenProxySocket() error = %v", err)
+			}
+			defer func() {
+				if err := listener.Close(); err != nil {
+					t.Errorf("Failed to close listener: %v", err)
+				}
+			}()
+
+			info, err := os.Stat(socketPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.Mode().Perm(); got != tt.wantMode {
+				t.Errorf("mode = %o, want %o", got, tt.wantMode)
+			}
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok && strconv.Itoa(int(stat.Gid)) != gid {
+				t.Errorf("gid = %d, want %s", stat.Gid, gid)
+			}
+		})
+	}
+}
+
+func TestWatchdogRecreatesMissingSocket(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	srv, err := NewServer(filepath.Join(tmpDir, "mgmt.sock"), upstream, tmpDir+"/", WithWatchdog(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	// Create a proxy socket through the management API
+	body := strings.NewReader(`{"rules":[{"match":{"path":"/.*"},"actions":[{"action":"allow"}]}]}`)
+	req := httptest.NewRequest("POST", "/socket/create", body)
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+	w := httptest.NewRecorder()
+	srv.handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create failed: %d %s", w.Code, w.Body.String())
+	}
+
+	var response management.Response[management.CreateResponse]
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	socketPath := response.Response.Socket
+
+	// Delete the socket file out from under the daemon
+	if err := os.Remove(socketPath); err != nil {
+		t.Fatal(err)
+	}
+
+	go srv.runWatchdog(srv.watchdogInterval)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(socketPath); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("socket file was not recreated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The recreated socket serves requests again
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", socketPath)
+			},
+		},
+	}
+	resp, err := client.Get("http://docker/_ping")
+	if err != nil {
+		t.Fatalf("request through recreated socket failed: %v", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		t.Errorf("Failed to close response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestWatchdogSkipsTCPSockets(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	srv, err := NewServer(filepath.Join(tmpDir, "mgmt.sock"), upstream, tmpDir+"/", WithWatchdog(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	body := strings.NewReader(`{"config":{"listen":"tcp://127.0.0.1:0","default_action":"deny"},"rules":[{"match":{"path":"/_ping"},"actions":[{"action":"allow"}]}]}`)
+	req := httptest.NewRequest("POST", "/socket/create", body)
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+	w := httptest.NewRecorder()
+	srv.handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create failed: %d %s", w.Code, w.Body.String())
+	}
+
+	var response management.Response[management.CreateResponse]
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	socketPath := response.Response.Socket
+
+	var logs bytes.Buffer
+	logging.SetOutput(&logs)
+	defer logging.SetOutput(os.Stdout)
+
+	srv.configMu.RLock()
+	server := srv.proxyServers[socketPath]
+	srv.configMu.RUnlock()
+
+	// The socket has no file, which must not read as a missing one
+	for i := 0; i < 3; i++ {
+		srv.checkSockets()
+	}
+
+	srv.configMu.RLock()
+	current := srv.proxyServers[socketPath]
+	srv.configMu.RUnlock()
+	if current != server {
+		t.Error("watchdog replaced the proxy server of a TCP socket")
+	}
+	if strings.Contains(logs.String(), "proxy socket") {
+		t.Errorf("watchdog tried to recreate a TCP socket: %s", logs.String())
+	}
+}
+
+func TestReapExpiredSockets(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	srv, err := NewServer(filepath.Join(tmpDir, "mgmt.sock"), upstream, tmpDir+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	create := func(name, settings string) string {
+		body := strings.NewReader(`{"config":{` + settings + `},"rules":[{"match":{"path":"/.*"},"actions":[{"action":"allow"}]}]}`)
+		req := httptest.NewRequest("POST", "/socket/create?name="+name, body)
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+		w := httptest.NewRecorder()
+		srv.handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("create %s failed: %d %s", name, w.Code, w.Body.String())
+		}
+		return filepath.Join(tmpDir, name+".sock")
+	}
+
+	ttl := create("ttl", `"ttl":"1h"`)
+	idle := create("idle", `"idle_timeout":"10m"`)
+	busy := create("busy", `"idle_timeout":"10m"`)
+	forever := create("forever", "")
+
+	// A request that is still being served keeps its socket from going idle
+	done := srv.handler.proxyHandler.trackRequest(busy)
+	defer done()
+
+	exists := func(socketPath string) bool {
+		srv.configMu.RLock()
+		defer srv.configMu.RUnlock()
+		_, ok := srv.socketConfigs[socketPath]
+		return ok
+	}
+
+	srv.reapSockets(time.Now().Add(30 * time.Minute))
+	for socketPath, want := range map[string]bool{ttl: true, idle: false, busy: true, forever: true} {
+		if got := exists(socketPath); got != want {
+			t.Errorf("after 30m, %s exists = %v, want %v", filepath.Base(socketPath), got, want)
+		}
+	}
+	if _, err := os.Stat(idle); !os.IsNotExist(err) {
+		t.Errorf("reaped socket file still exists: %v", err)
+	}
+
+	srv.reapSockets(time.Now().Add(2 * time.Hour))
+	for socketPath, want := range map[string]bool{ttl: false, busy: true, forever: true} {
+		if got := exists(socketPath); got != want {
+			t.Errorf("after 2h, %s exists = %v, want %v", filepath.Base(socketPath), got, want)
+		}
+	}
+}
+
+func TestLoadExistingConfigs_Summary(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	socketDir := tmpDir
+	store := storage.NewFileStore(socketDir)
+
+	allowRule := config.Rule{
+		Match:   config.Match{Path: "/.*"},
+		Actions: []config.Action{{Action: "allow"}},
+	}
+	if err := store.SaveConfig("one.sock", &config.SocketConfig{Rules: []config.Rule{allowRule}}); err != nil {
+		t.Fatal(err)
+	}
+	denyByDefault := &config.SocketConfig{
+		Config: config.ConfigSet{DefaultAction: config.DefaultActionDeny},
+		Rules:  []config.Rule{allowRule, allowRule},
+	}
+	if err := store.SaveConfig("two.sock", denyByDefault); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "broken.sock.json"), []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := NewServer(filepath.Join(tmpDir, "mgmt.sock"), filepath.Join(tmpDir, "docker.sock"), socketDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	summary, err := srv.loadExistingConfigs()
+	if err != nil {
+		t.Fatalf("loadExistingConfigs() error = %v", err)
+	}
+
+	if summary.Restored != 2 {
+		t.Errorf("Restored = %d, want 2", summary.Restored)
+	}
+	if summary.Rules != 3 {
+		t.Errorf("Rules = %d, want 3", summary.Rules)
+	}
+	if summary.DefaultPolicy != "allow" {
+		t.Errorf("DefaultPolicy = %q, want %q", summary.DefaultPolicy, "allow")
+	}
+	brokenPath := filepath.Join(tmpDir, "broken.sock")
+	if len(summary.Failed) != 1 || summary.Failed[brokenPath] == "" {
+		t.Errorf("Failed = %v, want a reason for %s", summary.Failed, brokenPath)
+	}
+
+	// The summary is emitted as one structured log line
+	var logs bytes.Buffer
+	logging.SetOutput(&logs)
+	defer logging.SetOutput(os.Stdout)
+
+	summary.log()
+
+	var entry map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("summary is not a single JSON log line: %v\n%s", err, logs.String())
+	}
+	if entry["msg"] != "Startup configuration summary" {
+		t.Errorf("msg = %v", entry["msg"])
+	}
+	if entry["sockets_restored"] != float64(2) || entry["total_rules"] != float64(3) || entry["sockets_failed"] != float64(1) {
+		t.Errorf("unexpected summary counts: %v", entry)
+	}
+	if entry["default_policy"] != "allow" {
+		t.Errorf("default_policy = %v, want allow", entry["default_policy"])
+	}
+	if entry["sockets_default_deny"] != float64(1) {
+		t.Errorf("sockets_default_deny = %v, want 1", entry["sockets_default_deny"])
+	}
+	failures, ok := entry["failures"].([]any)
+	if !ok || len(failures) != 1 || !strings.HasPrefix(failures[0].(string), brokenPath+": ") {
+		t.Errorf("failures = %v", entry["failures"])
+	}
+}
+
+func TestLoadExistingConfigs_ConfigDir(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	socketDir := filepath.Join(tmpDir, "sockets")
+	configDir := filepath.Join(tmpDir, "configs")
+	srv, err := NewServer(filepath.Join(tmpDir, "mgmt.sock"), filepath.Join(tmpDir, "docker.sock"), socketDir, WithConfigDir(configDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	// The config directory is created for the daemon alone
+	info, err := os.Stat(configDir)
+	if err != nil {
+		t.Fatalf("config directory was not created: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		t.Errorf("config directory mode = %04o, want 0700", perm)
+	}
+
+	cfg := &config.SocketConfig{Rules: []config.Rule{{
+		Match:   config.Match{Path: "/.*"},
+		Actions: []config.Action{{Action: "allow"}},
+	}}}
+	if err := srv.store.SaveConfig(filepath.Join(socketDir, "ci.sock"), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(configDir, "ci.sock.json")); err != nil {
+		t.Errorf("config was not stored in the config directory: %v", err)
+	}
+	entries, err := os.ReadDir(socketDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("socket directory has %d entries before restoring, want none", len(entries))
+	}
+
+	summary, err := srv.loadExistingConfigs()
+	if err != nil {
+		t.Fatalf("loadExistingConfigs() error = %v", err)
+	}
+	if summary.Restored != 1 || len(summary.Failed) != 0 {
+		t.Fatalf("summary = %+v, want one restored socket", summary)
+	}
+	if _, ok := srv.socketConfigs[filepath.Join(socketDir, "ci.sock")]; !ok {
+		t.Errorf("socket was not restored in the socket directory, configs = %v", srv.socketConfigs)
+	}
+}
+
+func TestRestoreSocketName(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	socketDir := tmpDir + "/"
+	store := storage.NewFileStore(socketDir)
+	cfg := &config.SocketConfig{
+		Name:  "ci",
+		Rules: []config.Rule{{Match: config.Match{Path: "/_ping"}, Actions: []config.Action{{Action: "allow"}}}},
+	}
+	if err := store.SaveConfig("ci.sock", cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := NewServer(filepath.Join(tmpDir, "mgmt.sock"), filepath.Join(tmpDir, "docker.sock"), socketDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	if _, err := srv.loadExistingConfigs(); err != nil {
+		t.Fatalf("loadExistingConfigs() error = %v", err)
+	}
+
+	socketPath := filepath.Join(tmpDir, "ci.sock")
+	srv.configMu.RLock()
+	restored := srv.socketConfigs[socketPath]
+	srv.configMu.RUnlock()
+	if restored == nil {
+		t.Fatalf("socket %s was not restored", socketPath)
+	}
+	if entry := srv.handler.socketEntry(socketPath, restored); entry.DisplayName != "ci" {
+		t.Errorf("DisplayName = %q, want %q", entry.DisplayName, "ci")
+	}
+
+	// An update without a name keeps the one the socket was created with
+	updated := &config.SocketConfig{Rules: cfg.Rules}
+	if err := srv.handler.updateSocket(socketPath, updated); err != nil {
+		t.Fatalf("updateSocket() error = %v", err)
+	}
+	if updated.Name != "ci" {
+		t.Errorf("name after update = %q, want %q", updated.Name, "ci")
+	}
+}
+
+func TestConfigWatcher(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	srv, err := NewServer(filepath.Join(tmpDir, "mgmt.sock"), filepath.Join(tmpDir, "docker.sock"), tmpDir+"/", WithConfigWatch(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	if err := srv.startConfigWatcher(); err != nil {
+		t.Fatalf("startConfigWatcher() error = %v", err)
+	}
+
+	socketPath := filepath.Join(tmpDir, "edited.sock")
+	configFile := filepath.Join(srv.store.Dir(), "edited.sock.json")
+	rulesFor := func(path string) string {
+		return `{"rules":[{"match":{"path":"` + path + `"},"actions":[{"action":"allow"}]}]}`
+	}
+	waitFor := func(what string, done func(cfg *config.SocketConfig) bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			srv.configMu.RLock()
+			cfg := srv.socketConfigs[socketPath]
+			srv.configMu.RUnlock()
+			if done(cfg) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	pathOf := func(cfg *config.SocketConfig) string {
+		if cfg == nil || len(cfg.Rules) == 0 {
+			return ""
+		}
+		return cfg.Rules[0].Match.Path
+	}
+
+	// A new config file starts serving its socket
+	if err := os.WriteFile(configFile, []byte(rulesFor("/_ping")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("the new socket", func(cfg *config.SocketConfig) bool { return pathOf(cfg) == "/_ping" })
+	if _, err := os.Stat(socketPath); err != nil {
+		t.Errorf("socket file was not created: %v", err)
+	}
+
+	// An edit replaces the config in place
+	if err := os.WriteFile(configFile, []byte(rulesFor("/version")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("the edited config", func(cfg *config.SocketConfig) bool { return pathOf(cfg) == "/version" })
+
+	// An invalid edit is rejected and the socket keeps its config
+	if err := os.WriteFile(configFile, []byte(rulesFor("(")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * configWatchDelay)
+	waitFor("the config to be kept", func(cfg *config.SocketConfig) bool { return pathOf(cfg) == "/version" })
+}
+
+func TestCheckStoragePermissions(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	tests := []struct {
+		name     string
+		enforce  bool
+		wantWarn bool
+		wantMode os.FileMode
+	}{
+		{name: "warns on loose mode", wantWarn: true, wantMode: 0755},
+		{name: "enforces owner only mode", enforce: true, wantMode: 0700},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.Chmod(tmpDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			srv, err := NewServer(filepath.Join(tmpDir, "mgmt.sock"), "/tmp/docker.sock", tmpDir+"/",
+				WithEnforcedStoragePermissions(tt.enforce))
+			if err != nil {
+				t.Fatalf("NewServer() error = %v", err)
+			}
+
+			var logs bytes.Buffer
+			logging.SetOutput(&logs)
+			defer logging.SetOutput(os.Stdout)
+
+			srv.checkStoragePermissions()
+
+			if got := strings.Contains(logs.String(), "Storage directory permissions are too broad"); got != tt.wantWarn {
+				t.Errorf("warning logged = %v, want %v: %s", got, tt.wantWarn, logs.String())
+			}
+
+			info, err := os.Stat(tmpDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if perm := info.Mode().Perm(); perm != tt.wantMode {
+				t.Errorf("directory mode = %04o, want %04o", perm, tt.wantMode)
+			}
+		})
+	}
+}
+
+func TestNewServerOnError(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		want    string
+		wantErr bool
+	}{
+		{name: "defaults to deny", want: OnErrorDeny},
+		{name: "deny", opts: []Option{WithOnError(OnErrorDeny)}, want: OnErrorDeny},
+		{name: "allow", opts: []Option{WithOnError(OnErrorAllow)}, want: OnErrorAllow},
+		{name: "invalid", opts: []Option{WithOnError("ignore")}, wantErr: true},
+		{name: "negative drain timeout", opts: []Option{WithDrainTimeout(-time.Second)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServer(filepath.Join(tmpDir, "mgmt.sock"), "/tmp/docker.sock", tmpDir+"/", tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := srv.handler.proxyHandler.onError; got != tt.want {
+				t.Errorf("proxy handler on error policy = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewServerInsecureDirectory(t *testing.T) {
+	tests := []struct {
+		name      string
+		socketDir os.FileMode
+		configDir os.FileMode
+		opts      []Option
+		wantErr   bool
+	}{
+		{name: "private directories", socketDir: 0755, configDir: 0700},
+		{name: "world-writable socket directory", socketDir: 0777, configDir: 0700, wantErr: true},
+		{name: "world-writable sticky socket directory", socketDir: 0777 | os.ModeSticky, configDir: 0700, wantErr: true},
+		{name: "world-writable config directory", socketDir: 0755, configDir: 0733, wantErr: true},
+		{name: "allowed insecure directory", socketDir: 0777, configDir: 0733, opts: []Option{WithAllowInsecureDir(true)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := os.RemoveAll(tmpDir); err != nil {
+					t.Errorf("Failed to remove temporary directory: %v", err)
+				}
+			}()
+
+			socketDir := filepath.Join(tmpDir, "sockets")
+			configDir := filepath.Join(tmpDir, "configs")
+			for dir, mode := range map[string]os.FileMode{socketDir: tt.socketDir, configDir: tt.configDir} {
+				if err := os.Mkdir(dir, 0700); err != nil {
+					t.Fatal(err)
+				}
+				// Chmod rather than Mkdir, which the umask would narrow
+				if err := os.Chmod(dir, mode); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var logs bytes.Buffer
+			logging.SetOutput(&logs)
+			defer logging.SetOutput(os.Stdout)
+
+			opts := append([]Option{WithConfigDir(configDir)}, tt.opts...)
+			_, err = NewServer(filepath.Join(tmpDir, "mgmt.sock"), "/tmp/docker.sock", socketDir, opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), "--allow-insecure-dir") {
+				t.Errorf("error = %v, want it to mention --allow-insecure-dir", err)
+			}
+			if insecure := tt.socketDir&0002 != 0 || tt.configDir&0002 != 0; !tt.wantErr && insecure != strings.Contains(logs.String(), "INSECURE DIRECTORY") {
+				t.Errorf("insecure directory warning logged = %v, want %v: %s", !insecure, insecure, logs.String())
+			}
+		})
+	}
+}
+
+func TestNewServerBaseConfig(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	valid := filepath.Join(tmpDir, "base.yaml")
+	if err := os.WriteFile(valid, []byte(`rules:
+  - match:
+      path: "/.*/containers/create"
+      contains:
+        HostConfig:
+          Privileged: true
+    actions:
+      - action: deny
+        reason: privileged containers are not allowed
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(tmpDir, "invalid.yaml")
+	if err := os.WriteFile(invalid, []byte("rules:\n  - match:\n      path: \"/(\"\n    actions:\n      - action: deny\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		wantRules int
+		wantErr   bool
+	}{
+		{name: "no base config"},
+		{name: "base config", path: valid, wantRules: 1},
+		{name: "missing file", path: filepath.Join(tmpDir, "missing.yaml"), wantErr: true},
+		{name: "invalid rules", path: invalid, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServer(filepath.Join(tmpDir, "mgmt.sock"), "/tmp/docker.sock", tmpDir, WithBaseConfig(tt.path))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			base := srv.handler.proxyHandler.baseConfig
+			if tt.wantRules == 0 && base != nil {
+				t.Errorf("base config = %+v, want none", base)
+			}
+			if tt.wantRules > 0 && (base == nil || len(base.Rules) != tt.wantRules) {
+				t.Errorf("base config = %+v, want %d rules", base, tt.wantRules)
+			}
+		})
+	}
+}
+
+func TestDeleteSocketDrainsInFlightRequests(t *testing.T) {
+	tests := []struct {
+		name         string
+		drainTimeout time.Duration
+		wantFinished bool
+	}{
+		{name: "in-flight request finishes within the grace period", drainTimeout: 5 * time.Second, wantFinished: true},
+		{name: "connections are closed once the grace period ends", drainTimeout: 50 * time.Millisecond, wantFinished: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := os.RemoveAll(tmpDir); err != nil {
+					t.Errorf("Failed to remove temporary directory: %v", err)
+				}
+			}()
+
+			// The upstream holds the request until it is released
+			started := make(chan struct{})
+			release := make(chan struct{})
+			upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				select {
+				case <-release:
+				case <-r.Context().Done():
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer close(release)
+
+			srv, err := NewServer(filepath.Join(tmpDir, "mgmt.sock"), upstream, tmpDir+"/", WithDrainTimeout(tt.drainTimeout))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer srv.Stop()
+
+			body := strings.NewReader(`{"rules":[{"match":{"path":"/.*"},"actions":[{"action":"allow"}]}]}`)
+			req := httptest.NewRequest("POST", "/socket/create", body)
+			req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+			w := httptest.NewRecorder()
+			srv.handler.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("create failed: %d %s", w.Code, w.Body.String())
+			}
+			var response management.Response[management.CreateResponse]
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			socketPath := response.Response.Socket
+
+			// Start a request through the socket and wait for it to reach the daemon
+			client := &http.Client{
+				Transport: &http.Transport{
+					DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+						return net.Dial("unix", socketPath)
+					},
+				},
+			}
+			result := make(chan error, 1)
+			go func() {
+				resp, err := client.Get("http://docker/v1.42/containers/json")
+				if err != nil {
+					result <- err
+					return
+				}
+				if err := resp.Body.Close(); err != nil {
+					result <- err
+					return
+				}
+				if resp.StatusCode != http.StatusOK {
+					result <- fmt.Errorf("status %d", resp.StatusCode)
+					return
+				}
+				result <- nil
+			}()
+			<-started
+
+			// Delete the socket while the request is in flight
+			deleted := make(chan int, 1)
+			go func() {
+				req := httptest.NewRequest("DELETE", "/socket/delete?socket="+socketPath, nil)
+				req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+				w := httptest.NewRecorder()
+				srv.handler.ServeHTTP(w, req)
+				deleted <- w.Code
+			}()
+
+			if tt.wantFinished {
+				// The delete waits for the request rather than cutting it off
+				select {
+				case <-deleted:
+					t.Fatal("delete returned before the in-flight request finished")
+				case <-time.After(100 * time.Millisecond):
+				}
+				release <- struct{}{}
+			}
+
+			select {
+			case err := <-result:
+				if tt.wantFinished && err != nil {
+					t.Errorf("in-flight request failed: %v", err)
+				}
+				if !tt.wantFinished && err == nil {
+					t.Error("in-flight request succeeded, want its connection closed")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("in-flight request did not end")
+			}
+
+			if code := <-deleted; code != http.StatusOK {
+				t.Errorf("delete status = %d, want %d", code, http.StatusOK)
+			}
+			if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+				t.Errorf("socket file still exists after delete: %v", err)
+			}
+		})
+	}
+}
+
+func TestConcurrentCreateAndClean(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-proxy-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	srv, err := NewServer(filepath.Join(tmpDir, "mgmt.sock"), filepath.Join(tmpDir, "docker.sock"), tmpDir+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	send := func(method, target, body string) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), serverContextKey, srv))
+		w := httptest.NewRecorder()
+		srv.handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s %s failed: %d %s", method, target, w.Code, w.Body.String())
+		}
+	}
+
+	// Hammer creates and cleans at the same time
+	const creators, creates, cleans = 4, 10, 10
+	var wg sync.WaitGroup
+	for i := 0; i < creators; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < creates; j++ {
+				send("POST", "/socket/create", `{"rules":[{"match":{"path":"/.*"},"actions":[{"action":"allow"}]}]}`)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < cleans; j++ {
+			send("POST", "/socket/clean", "")
+		}
+	}()
+	wg.Wait()
+
+	// Every socket left over must have its config, its server and its file
+	srv.configMu.RLock()
+	defer srv.configMu.RUnlock()
+	if len(srv.socketConfigs) != len(srv.proxyServers) {
+		t.Fatalf("got %d configs but %d servers", len(srv.socketConfigs), len(srv.proxyServers))
+	}
+	for socketPath := range srv.socketConfigs {
+		if _, ok := srv.proxyServers[socketPath]; !ok {
+			t.Errorf("socket %s has a config but no server", socketPath)
+		}
+		if _, err := os.Stat(socketPath); err != nil {
+			t.Errorf("socket %s has a config but no file: %v", socketPath, err)
+		}
+	}
+
+	sockets, err := filepath.Glob(filepath.Join(tmpDir, "*.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, socketPath := range sockets {
+		if socketPath == filepath.Join(tmpDir, "mgmt.sock") {
+			continue
+		}
+		if _, ok := srv.socketConfigs[socketPath]; !ok {
+			t.Errorf("socket file %s has no config", socketPath)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"docker-socket-proxy/internal/proxy/config"
+)
+
+// socketStats holds runtime information about a proxy socket
+type socketStats struct {
+	mu           sync.Mutex
+	createdAt    time.Time
+	lastDecision time.Time
+	// lastActivity is when a request last started or finished, and inFlight
+	// counts those still being served, so a long stream is never idle
+	lastActivity time.Time
+	inFlight     int
+	// allowed, denied and rewritten count decisions since the socket was
+	// created or its stats were last reset, shadowDenied the denies that
+	// were forwarded in shadow mode
+	allowed      uint64
+	denied       uint64
+	rewritten    uint64
+	shadowDenied uint64
+	// ruleHits counts matches per rule index of ruleConfig, and start again
+	// from zero whenever the socket's config is replaced
+	ruleConfig *config.SocketConfig
+	ruleHits   []uint64
+	hitsSince  time.Time
+}
+
+// socketStatsSnapshot is a point-in-time copy of a socket's stats
+type socketStatsSnapshot struct {
+	CreatedAt    time.Time
+	LastDecision time.Time
+	Allowed      uint64
+	Denied       uint64
+	Rewritten    uint64
+	ShadowDenied uint64
+}
+
+// statsFor returns the stats for a socket, creating them if needed
+func (h *ProxyHandler) statsFor(socketPath string) *socketStats {
+	h.statsMu.Lock()
+	defer h.statsMu.Unlock()
+
+	if h.stats == nil {
+		h.stats = make(map[string]*socketStats)
+	}
+	stats, ok := h.stats[socketPath]
+	if !ok {
+		now := time.Now()
+		stats = &socketStats{createdAt: now, lastActivity: now}
+		h.stats[socketPath] = stats
+	}
+	return stats
+}
+
+// registerSocket starts tracking stats for a newly created or restored socket
+func (h *ProxyHandler) registerSocket(socketPath string) {
+	h.statsFor(socketPath)
+}
+
+// forgetSocket drops all runtime state for a deleted socket
+func (h *ProxyHandler) forgetSocket(socketPath string) {
+	h.statsMu.Lock()
+	delete(h.stats, socketPath)
+	h.statsMu.Unlock()
+
+	h.samplerMu.Lock()
+	sampler, ok := h.samplers[socketPath]
+	delete(h.samplers, socketPath)
+	h.samplerMu.Unlock()
+	if ok {
+		logSuppressedAllows(socketPath, sampler)
+	}
+
+	h.bodyNeedMu.Lock()
+	delete(h.bodyNeeds, socketPath)
+	h.bodyNeedMu.Unlock()
+
+	h.forgetRateLimiters(socketPath)
+	h.forgetDecisionLog(socketPath)
+}
+
+// moveSocket carries a socket's stats over to its new path when its file is
+// moved, the rest of its runtime state starts again
+func (h *ProxyHandler) moveSocket(socketPath, newPath string) {
+	h.statsMu.Lock()
+	stats, ok := h.stats[socketPath]
+	h.statsMu.Unlock()
+
+	h.forgetSocket(socketPath)
+
+	if ok {
+		h.statsMu.Lock()
+		h.stats[newPath] = stats
+		h.statsMu.Unlock()
+	}
+}
+
+// recordDecision records that the socket made an allow or deny decision
+func (h *ProxyHandler) recordDecision(socketPath string, decision ruleDecision) {
+	stats := h.statsFor(socketPath)
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.lastDecision = time.Now()
+	if decision.shadow {
+		stats.shadowDenied++
+		return
+	}
+	if !decision.allowed {
+		stats.denied++
+		return
+	}
+	stats.allowed++
+	if decision.rewritten {
+		stats.rewritten++
+	}
+}
+
+// trackRequest marks a request to a socket as in flight, and returns the
+// function that marks it finished
+func (h *ProxyHandler) trackRequest(socketPath string) func() {
+	stats := h.statsFor(socketPath)
+	stats.mu.Lock()
+	stats.inFlight++
+	stats.lastActivity = time.Now()
+	stats.mu.Unlock()
+
+	return func() {
+		stats.mu.Lock()
+		stats.inFlight--
+		stats.lastActivity = time.Now()
+		stats.mu.Unlock()
+	}
+}
+
+// socketActivity returns when a socket was created, and when it was last
+// used, which is the zero time while it is serving a request
+func (h *ProxyHandler) socketActivity(socketPath string) (createdAt, lastActivity time.Time) {
+	stats := h.statsFor(socketPath)
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	if stats.inFlight > 0 {
+		return stats.createdAt, time.Time{}
+	}
+	return stats.createdAt, stats.lastActivity
+}
+
+// snapshotStats returns a copy of the stats for a socket
+func (h *ProxyHandler) snapshotStats(socketPath string) socketStatsSnapshot {
+	stats := h.statsFor(socketPath)
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	return socketStatsSnapshot{
+		CreatedAt:    stats.createdAt,
+		LastDecision: stats.lastDecision,
+		Allowed:      stats.allowed,
+		Denied:       stats.denied,
+		Rewritten:    stats.rewritten,
+		ShadowDenied: stats.shadowDenied,
+	}
+}
+
+// resetStats zeroes the decision and rule hit counters of the given sockets.
+// The locks of all the sockets are held together, so a request is counted
+// either before or after the reset on every socket, never part way through.
+func (h *ProxyHandler) resetStats(socketPaths []string) {
+	all := make([]*socketStats, 0, len(socketPaths))
+	for _, socketPath := range socketPaths {
+		stats := h.statsFor(socketPath)
+		stats.mu.Lock()
+		defer stats.mu.Unlock()
+		all = append(all, stats)
+	}
+
+	now := time.Now()
+	for _, stats := range all {
+		stats.allowed = 0
+		stats.denied = 0
+		stats.rewritten = 0
+		stats.shadowDenied = 0
+		for i := range stats.ruleHits {
+			stats.ruleHits[i] = 0
+		}
+		stats.hitsSince = now
+	}
+}
+
+// recordRuleHits increments the hit counters of the rules that matched a request
+func (h *ProxyHandler) recordRuleHits(socketPath string, socketConfig *config.SocketConfig, matched []int) {
+	if socketConfig == nil {
+		return
+	}
+
+	stats := h.statsFor(socketPath)
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.resetRuleHitsIfStale(socketConfig)
+	for _, index := range matched {
+		if index >= 0 && index < len(stats.ruleHits) {
+			stats.ruleHits[index]++
+		}
+	}
+}
+
+// snapshotRuleHits returns a copy of the rule hit counters for a socket's
+// current config, along with the time counting started
+func (h *ProxyHandler) snapshotRuleHits(socketPath string, socketConfig *config.SocketConfig) ([]uint64, time.Time) {
+	stats := h.statsFor(socketPath)
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.resetRuleHitsIfStale(socketConfig)
+	hits := make([]uint64, len(stats.ruleHits))
+	copy(hits, stats.ruleHits)
+	return hits, stats.hitsSince
+}
+
+// resetRuleHitsIfStale starts the counters again when the config they were
+// counted against is no longer the socket's config. Callers hold s.mu.
+func (s *socketStats) resetRuleHitsIfStale(socketConfig *config.SocketConfig) {
+	if s.ruleConfig == socketConfig && s.ruleHits != nil {
+		return
+	}
+
+	rules := 0
+	if socketConfig != nil {
+		rules = len(socketConfig.Rules)
+	}
+	s.ruleConfig = socketConfig
+	s.ruleHits = make([]uint64, rules)
+	s.hitsSince = time.Now()
+}
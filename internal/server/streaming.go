@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Docker endpoints that hold the connection open, with an optional API version prefix
+var (
+	attachPath  = regexp.MustCompile(`^(/v[0-9.]+)?/containers/[^/]+/attach(/ws)?$`)
+	execPath    = regexp.MustCompile(`^(/v[0-9.]+)?/exec/[^/]+/start$`)
+	sessionPath = regexp.MustCompile(`^(/v[0-9.]+)?/(session|grpc)$`)
+	logsPath    = regexp.MustCompile(`^(/v[0-9.]+)?/containers/[^/]+/logs$`)
+	statsPath   = regexp.MustCompile(`^(/v[0-9.]+)?/containers/[^/]+/stats$`)
+	eventsPath  = regexp.MustCompile(`^(/v[0-9.]+)?/events$`)
+)
+
+// isStreamingRequest reports whether a request upgrades its connection or
+// asks for a response that streams until the client goes away. Their bodies
+// are never buffered, since a client may keep writing to them, and their
+// responses are flushed as they arrive rather than rewritten. Upgrade
+// headers only count on the endpoints the daemon hijacks, so that sending
+// them with any other request does not keep its body from the rules.
+func isStreamingRequest(r *http.Request) bool {
+	path := r.URL.Path
+	switch {
+	case sessionPath.MatchString(path):
+		return isUpgradeRequest(r)
+	case attachPath.MatchString(path), execPath.MatchString(path), eventsPath.MatchString(path):
+		return true
+	case logsPath.MatchString(path):
+		return queryBool(r, "follow", false)
+	case statsPath.MatchString(path):
+		return queryBool(r, "stream", true)
+	}
+	return false
+}
+
+// isUpgradeRequest reports whether a request asks to switch protocols, as
+// the Docker CLI does to attach to a container or start an exec
+func isUpgradeRequest(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// queryBool reads a boolean query parameter the way the Docker daemon does,
+// where anything other than an empty value, 0, no, false or none is true
+func queryBool(r *http.Request, name string, missing bool) bool {
+	if !r.URL.Query().Has(name) {
+		return missing
+	}
+	switch strings.ToLower(strings.TrimSpace(r.URL.Query().Get(name))) {
+	case "", "0", "no", "false", "none":
+		return false
+	}
+	return true
+}
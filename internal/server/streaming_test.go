@@ -0,0 +1,286 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"docker-socket-proxy/internal/proxy/config"
+)
+
+func TestIsStreamingRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		upgrade bool
+		want    bool
+	}{
+		{name: "attach", method: "POST", target: "/v1.43/containers/abc/attach?stream=1&stdout=1", want: true},
+		{name: "attach websocket", method: "GET", target: "/containers/abc/attach/ws", want: true},
+		{name: "exec start", method: "POST", target: "/v1.43/exec/abc/start", want: true},
+		{name: "exec create", method: "POST", target: "/v1.43/containers/abc/exec", want: false},
+		{name: "followed logs", method: "GET", target: "/v1.43/containers/abc/logs?follow=1&stdout=1", want: true},
+		{name: "followed logs true", method: "GET", target: "/containers/abc/logs?follow=true", want: true},
+		{name: "logs", method: "GET", target: "/v1.43/containers/abc/logs?stdout=1", want: false},
+		{name: "logs without follow", method: "GET", target: "/v1.43/containers/abc/logs?follow=0", want: false},
+		{name: "stats stream by default", method: "GET", target: "/v1.43/containers/abc/stats", want: true},
+		{name: "single stats", method: "GET", target: "/v1.43/containers/abc/stats?stream=false", want: false},
+		{name: "events", method: "GET", target: "/v1.43/events", want: true},
+		{name: "container list", method: "GET", target: "/v1.43/containers/json", want: false},
+		{name: "session upgrade", method: "POST", target: "/v1.43/session", upgrade: true, want: true},
+		{name: "session without upgrade", method: "POST", target: "/v1.43/session", want: false},
+		{name: "upgrade headers on a create", method: "POST", target: "/v1.43/containers/create", upgrade: true, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.upgrade {
+				req.Header.Set("Connection", "keep-alive, Upgrade")
+				req.Header.Set("Upgrade", "h2c")
+			}
+			if got := isStreamingRequest(req); got != tt.want {
+				t.Errorf("isStreamingRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_StreamsResponses(t *testing.T) {
+	release := make(chan struct{})
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if _, err := io.WriteString(w, "{\"status\":\"start\"}\n"); err != nil {
+			t.Errorf("Failed to write event: %v", err)
+			return
+		}
+		w.(http.Flusher).Flush()
+		<-release
+		if _, err := io.WriteString(w, "{\"status\":\"die\"}\n"); err != nil {
+			t.Errorf("Failed to write event: %v", err)
+		}
+	}))
+
+	// The response action would read the whole response if it were applied
+	socketPath := "/tmp/streaming.sock"
+	configs := map[string]*config.SocketConfig{socketPath: {Rules: []config.Rule{{
+		Match: config.Match{Path: "^/v1.43/events$"},
+		Actions: []config.Action{
+			{Action: "upsert", Phase: config.PhaseResponse, Update: map[string]any{"proxied": true}},
+			{Action: "allow"},
+		},
+	}}}}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTPWithSocket(w, r, socketPath)
+	}))
+	defer proxy.Close()
+
+	// The upstream must finish before the servers can close
+	var releaseOnce sync.Once
+	finish := func() { releaseOnce.Do(func() { close(release) }) }
+	defer finish()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(proxy.URL + "/v1.43/events")
+	if err != nil {
+		t.Fatalf("GET /events error = %v", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			t.Errorf("Failed to close response body: %v", err)
+		}
+	}()
+
+	lines := make(chan string)
+	go func() {
+		reader := bufio.NewReader(resp.Body)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- line
+		}
+	}()
+
+	select {
+	case line := <-lines:
+		if line != "{\"status\":\"start\"}\n" {
+			t.Errorf("first event = %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("first event was not passed on before the stream ended")
+	}
+
+	finish()
+	if line := <-lines; line != "{\"status\":\"die\"}\n" {
+		t.Errorf("second event = %q", line)
+	}
+}
+
+func TestProxyHandler_HijacksAttach(t *testing.T) {
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "tcp" {
+			t.Errorf("Upgrade header = %q, want tcp", r.Header.Get("Upgrade"))
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Failed to hijack connection: %v", err)
+			return
+		}
+		defer func() {
+			if err := conn.Close(); err != nil {
+				t.Errorf("Failed to close connection: %v", err)
+			}
+		}()
+
+		if _, err := buf.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n"); err != nil {
+			t.Errorf("Failed to write upgrade: %v", err)
+			return
+		}
+		if err := buf.Flush(); err != nil {
+			t.Errorf("Failed to flush upgrade: %v", err)
+			return
+		}
+
+		// Echo stdin back as output, one line at a time
+		for {
+			line, err := buf.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if _, err := buf.WriteString("out: " + line); err != nil {
+				return
+			}
+			if err := buf.Flush(); err != nil {
+				return
+			}
+		}
+	}))
+
+	socketPath := "/tmp/attach.sock"
+	configs := map[string]*config.SocketConfig{socketPath: {Rules: []config.Rule{{
+		Match:   config.Match{Path: "/containers/.*/attach", Method: "POST"},
+		Actions: []config.Action{{Action: "allow"}},
+	}}}}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTPWithSocket(w, r, socketPath)
+	}))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			t.Errorf("Failed to close connection: %v", err)
+		}
+	}()
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	request := "POST /v1.43/containers/abc/attach?stream=1&stdin=1&stdout=1 HTTP/1.1\r\n" +
+		"Host: docker\r\nConnection: Upgrade\r\nUpgrade: tcp\r\nContent-Length: 0\r\n\r\n"
+	if _, err := io.WriteString(conn, request); err != nil {
+		t.Fatalf("Failed to write request: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	req, err := http.NewRequest("POST", "/v1.43/containers/abc/attach", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatalf("Failed to read upgrade response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+
+	for _, line := range []string{"hello\n", "world\n"} {
+		if _, err := io.WriteString(conn, line); err != nil {
+			t.Fatalf("Failed to write stdin: %v", err)
+		}
+		got, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read output: %v", err)
+		}
+		if got != "out: "+line {
+			t.Errorf("output = %q, want %q", got, "out: "+line)
+		}
+	}
+}
+
+func TestProxyHandler_UpgradeHeadersDoNotSkipBodyRules(t *testing.T) {
+	var forwarded int
+	upstream := newUnixUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded++
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	socketPath := "/tmp/upgrade-body.sock"
+	configs := map[string]*config.SocketConfig{socketPath: {Rules: []config.Rule{
+		{
+			Match:   config.Match{Path: "/containers/create", Method: "POST", Contains: map[string]any{"HostConfig": map[string]any{"Privileged": true}}},
+			Actions: []config.Action{{Action: "deny", Reason: "privileged"}},
+		},
+		{
+			Match:   config.Match{Path: "/exec/.*/start", Method: "POST", Contains: map[string]any{"Tty": true}},
+			Actions: []config.Action{{Action: "deny", Reason: "no ttys"}},
+		},
+	}}}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		wantStatus int
+		wantReason string
+	}{
+		{name: "privileged create with upgrade headers", target: "/v1.43/containers/create", body: `{"HostConfig":{"Privileged":true}}`, wantStatus: http.StatusForbidden, wantReason: "privileged"},
+		{name: "plain create with upgrade headers", target: "/v1.43/containers/create", body: `{"Image":"alpine"}`, wantStatus: http.StatusOK},
+		{name: "exec start body a rule needs", target: "/v1.43/exec/abc/start", body: `{"Tty":false}`, wantStatus: http.StatusForbidden, wantReason: bodyNotInspectedReason},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded = 0
+			req := httptest.NewRequest("POST", tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "tcp")
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, req, socketPath)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK {
+				if forwarded != 1 {
+					t.Errorf("forwarded %d requests, want 1", forwarded)
+				}
+				return
+			}
+			if forwarded != 0 {
+				t.Errorf("forwarded %d requests, want none", forwarded)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantReason) {
+				t.Errorf("body = %s, want the reason %q", w.Body.String(), tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// tracerName identifies the proxy's instrumentation scope
+const tracerName = "docker-socket-proxy"
+
+// tracePropagator continues W3C trace context from clients to the upstream
+var tracePropagator = propagation.TraceContext{}
+
+// NewOTLPTracerProvider creates a tracer provider that exports spans over
+// OTLP/HTTP to the given endpoint URL
+func NewOTLPTracerProvider(ctx context.Context, endpoint string) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(tracerName))),
+	), nil
+}
+
+// WithTracerProvider records a span for every proxied request using tp
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(s *Server) {
+		s.tracerProvider = tp
+	}
+}
+
+// tracer returns the tracer for proxied requests, a no-op when tracing is disabled
+func (h *ProxyHandler) tracer() trace.Tracer {
+	if h.tracerProvider == nil {
+		return noop.NewTracerProvider().Tracer(tracerName)
+	}
+	return h.tracerProvider.Tracer(tracerName)
+}
+
+// startProxySpan starts the span for a proxied request, continuing any
+// incoming traceparent, and returns the request carrying the span context
+func (h *ProxyHandler) startProxySpan(r *http.Request, socketPath string) (*http.Request, trace.Span) {
+	ctx := tracePropagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := h.tracer().Start(ctx, "proxy "+r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("proxy.socket", socketPath),
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+		),
+	)
+	return r.WithContext(ctx), span
+}
+
+// recordSpanDecision records the ACL decision on the request span
+func recordSpanDecision(span trace.Span, decision, reason string) {
+	span.SetAttributes(attribute.String("proxy.decision", decision))
+	if reason != "" {
+		span.SetAttributes(attribute.String("proxy.reason", reason))
+	}
+}
+
+// recordSpanShadow marks a request span as forwarded in shadow mode despite
+// the deny decision recorded on it
+func recordSpanShadow(span trace.Span) {
+	span.SetAttributes(attribute.Bool("proxy.shadow", true))
+}
+
+// recordSpanStatus records the upstream response status on the request span
+func recordSpanStatus(span trace.Span, status int) {
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
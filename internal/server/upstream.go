@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"docker-socket-proxy/internal/logging"
+)
+
+// upstreamPingTimeout bounds how long an upstream check may take
+const upstreamPingTimeout = 5 * time.Second
+
+// healthCheckTimeout bounds the upstream check of a health probe, which
+// should answer well within a probe's own timeout
+const healthCheckTimeout = 2 * time.Second
+
+// upstream is the Docker daemon endpoint that allowed requests are forwarded to
+type upstream struct {
+	// network and address are passed to the dialer, "unix" with a socket
+	// path or "tcp" with a host:port
+	network string
+	address string
+	// scheme and host are set on forwarded request URLs. Over TLS the host is
+	// the daemon's address, so that its certificate is verified against it.
+	scheme    string
+	host      string
+	tlsConfig *tls.Config
+}
+
+// parseUpstream parses a Docker daemon address. A plain path or unix:// URL is
+// a unix socket, tcp:// and http:// are plain TCP and https:// is TCP with TLS.
+// A tcp:// address also uses TLS when a TLS config is given, as Docker's own
+// tcp://host:2376 endpoints do.
+func parseUpstream(daemon string, tlsConfig *tls.Config) (*upstream, error) {
+	if !strings.Contains(daemon, "://") {
+		return unixUpstream(daemon), nil
+	}
+
+	u, err := url.Parse(daemon)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Docker daemon address %q: %w", daemon, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("invalid Docker daemon address %q: missing socket path", daemon)
+		}
+		return unixUpstream(u.Path), nil
+	case "tcp", "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported Docker daemon scheme %q, expected unix, tcp, http or https", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid Docker daemon address %q: missing host", daemon)
+	}
+	if u.Port() == "" {
+		return nil, fmt.Errorf("invalid Docker daemon address %q: missing port", daemon)
+	}
+
+	up := &upstream{
+		network: "tcp",
+		address: u.Host,
+		scheme:  "http",
+		host:    u.Host,
+	}
+	if u.Scheme == "https" || (u.Scheme == "tcp" && tlsConfig != nil) {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		up.scheme = "https"
+		up.tlsConfig = tlsConfig
+	}
+	return up, nil
+}
+
+// unixUpstream returns the upstream for a Docker daemon unix socket
+func unixUpstream(socketPath string) *upstream {
+	return &upstream{
+		network: "unix",
+		address: socketPath,
+		scheme:  "http",
+		host:    "docker",
+	}
+}
+
+// String returns the daemon address for logs and error messages
+func (u *upstream) String() string {
+	if u.network == "unix" {
+		return u.address
+	}
+	return u.scheme + "://" + u.address
+}
+
+// transport returns an HTTP transport that dials the daemon. The timeout
+// bounds connecting and waiting for response headers, but not reading the
+// body, so streaming responses such as logs and events can run for as long
+// as they need.
+func (u *upstream) transport(timeout time.Duration) *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: timeout}
+			return d.DialContext(ctx, u.network, u.address)
+		},
+		TLSClientConfig:       u.tlsConfig,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+	}
+}
+
+// Backoff between retries of a request the Docker daemon refused, doubled
+// after every attempt up to maxRetryBackoff. Variables so tests can shorten them.
+var (
+	retryBackoff    = 100 * time.Millisecond
+	maxRetryBackoff = 2 * time.Second
+)
+
+// retryTransport retries GET and HEAD requests that failed to connect to the
+// Docker daemon, as they do while it restarts. Nothing has reached the daemon
+// when dialing fails, but only safe methods are retried all the same, and
+// only without a body, which the first attempt may have consumed.
+type retryTransport struct {
+	next    http.RoundTripper
+	retries int
+}
+
+// withRetries wraps a transport to retry failed connections up to retries times
+func withRetries(next http.RoundTripper, retries int) http.RoundTripper {
+	if retries <= 0 {
+		return next
+	}
+	return &retryTransport{next: next, retries: retries}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if (req.Method != http.MethodGet && req.Method != http.MethodHead) || (req.Body != nil && req.Body != http.NoBody) {
+		return t.next.RoundTrip(req)
+	}
+
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if err == nil || attempt > t.retries || !isDialError(err) {
+			return resp, err
+		}
+
+		logging.GetLogger().Warn("Retrying upstream request", "method", req.Method, "path", req.URL.Path, "attempt", attempt, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, err
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
+
+// isDialError reports whether a request failed before reaching the Docker
+// daemon because it could not be connected to, timeouts aside, which have
+// already waited out the upstream timeout
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial" && !opErr.Timeout()
+}
+
+// isTimeout reports whether an upstream request failed by timing out
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// DockerTLSConfig builds the client TLS config for a TCP Docker daemon. The CA
+// replaces the system roots when given, and the certificate and key are only
+// needed when the daemon verifies clients.
+func DockerTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Docker CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in Docker CA %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("both a Docker client certificate and key are required")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Docker client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// pingUpstream checks that the Docker daemon answers /_ping within timeout
+func pingUpstream(u *upstream, timeout time.Duration) error {
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: u.transport(timeout),
+	}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Get(u.scheme + "://" + u.host + "/_ping")
+	if err != nil {
+		return err
+	}
+	if err := resp.Body.Close(); err != nil {
+		return fmt.Errorf("failed to close ping response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ping returned status %d", resp.StatusCode)
+	}
+	return nil
+}
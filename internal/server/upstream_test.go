@@ -0,0 +1,263 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"docker-socket-proxy/internal/logging"
+	"docker-socket-proxy/internal/proxy/config"
+)
+
+func TestParseUpstream(t *testing.T) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	tests := []struct {
+		name        string
+		daemon      string
+		tlsConfig   *tls.Config
+		wantNetwork string
+		wantAddress string
+		wantScheme  string
+		wantHost    string
+		wantErr     bool
+	}{
+		{name: "socket path", daemon: "/var/run/docker.sock", wantNetwork: "unix", wantAddress: "/var/run/docker.sock", wantScheme: "http", wantHost: "docker"},
+		{name: "unix url", daemon: "unix:///var/run/docker.sock", wantNetwork: "unix", wantAddress: "/var/run/docker.sock", wantScheme: "http", wantHost: "docker"},
+		{name: "plain tcp", daemon: "tcp://dockerd:2375", wantNetwork: "tcp", wantAddress: "dockerd:2375", wantScheme: "http", wantHost: "dockerd:2375"},
+		{name: "tcp with tls config", daemon: "tcp://dockerd:2376", tlsConfig: tlsConfig, wantNetwork: "tcp", wantAddress: "dockerd:2376", wantScheme: "https", wantHost: "dockerd:2376"},
+		{name: "http", daemon: "http://dockerd:2375", wantNetwork: "tcp", wantAddress: "dockerd:2375", wantScheme: "http", wantHost: "dockerd:2375"},
+		{name: "https", daemon: "https://dockerd:2376", wantNetwork: "tcp", wantAddress: "dockerd:2376", wantScheme: "https", wantHost: "dockerd:2376"},
+		{name: "ipv6 host", daemon: "tcp://[::1]:2375", wantNetwork: "tcp", wantAddress: "[::1]:2375", wantScheme: "http", wantHost: "[::1]:2375"},
+		{name: "missing port", daemon: "tcp://dockerd", wantErr: true},
+		{name: "missing socket path", daemon: "unix://", wantErr: true},
+		{name: "unsupported scheme", daemon: "ssh://user@dockerd", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up, err := parseUpstream(tt.daemon, tt.tlsConfig)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseUpstream() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if up.network != tt.wantNetwork || up.address != tt.wantAddress {
+				t.Errorf("dial = %s %s, want %s %s", up.network, up.address, tt.wantNetwork, tt.wantAddress)
+			}
+			if up.scheme != tt.wantScheme || up.host != tt.wantHost {
+				t.Errorf("url = %s://%s, want %s://%s", up.scheme, up.host, tt.wantScheme, tt.wantHost)
+			}
+			if (up.scheme == "https") != (up.tlsConfig != nil) {
+				t.Errorf("scheme %s with tls config %v", up.scheme, up.tlsConfig)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_TCPUpstream(t *testing.T) {
+	docker := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1.42/containers/json" && r.URL.Path != "/_ping" {
+			t.Errorf("unexpected upstream path %s", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	plain := httptest.NewServer(http.HandlerFunc(docker))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(docker))
+	defer secure.Close()
+
+	// Trust the test server's certificate the way --docker-tls-ca would
+	trusted := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    secure.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs,
+	}
+
+	tests := []struct {
+		name       string
+		daemon     string
+		tlsConfig  *tls.Config
+		wantStatus int
+	}{
+		{name: "tcp", daemon: "tcp://" + plain.Listener.Addr().String(), wantStatus: http.StatusOK},
+		{name: "tcp with tls", daemon: "tcp://" + secure.Listener.Addr().String(), tlsConfig: trusted, wantStatus: http.StatusOK},
+		{name: "https", daemon: secure.URL, tlsConfig: trusted, wantStatus: http.StatusOK},
+		{name: "untrusted certificate", daemon: secure.URL, wantStatus: http.StatusBadGateway},
+	}
+
+	socketPath := "/tmp/tcp-upstream.sock"
+	configs := map[string]*config.SocketConfig{socketPath: {}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up, err := parseUpstream(tt.daemon, tt.tlsConfig)
+			if err != nil {
+				t.Fatalf("parseUpstream() error = %v", err)
+			}
+			handler := NewProxyHandler(tt.daemon, configs, &sync.RWMutex{})
+			handler.upstream = up
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTPWithSocket(w, httptest.NewRequest("GET", "/v1.42/containers/json", nil), socketPath)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus == http.StatusOK {
+				if err := pingUpstream(up, upstreamPingTimeout); err != nil {
+					t.Errorf("pingUpstream() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+// flakyTransport fails the first failures round trips with err
+type flakyTransport struct {
+	failures int
+	err      error
+	calls    int
+}
+
+func (t *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.calls++
+	if t.calls <= t.failures {
+		return nil, t.err
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func TestRetryTransport(t *testing.T) {
+	defer func(backoff time.Duration) { retryBackoff = backoff }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	refused := &net.OpError{Op: "dial", Net: "unix", Err: syscall.ECONNREFUSED}
+	reset := &net.OpError{Op: "read", Net: "unix", Err: syscall.ECONNRESET}
+
+	tests := []struct {
+		name      string
+		method    string
+		retries   int
+		failures  int
+		err       error
+		wantErr   bool
+		wantCalls int
+	}{
+		{name: "recovers", method: "GET", retries: 3, failures: 2, err: refused, wantCalls: 3},
+		{name: "head recovers", method: "HEAD", retries: 1, failures: 1, err: refused, wantCalls: 2},
+		{name: "gives up", method: "GET", retries: 2, failures: 5, err: refused, wantErr: true, wantCalls: 3},
+		{name: "retries off", method: "GET", retries: 0, failures: 1, err: refused, wantErr: true, wantCalls: 1},
+		{name: "never retries post", method: "POST", retries: 3, failures: 1, err: refused, wantErr: true, wantCalls: 1},
+		{name: "never retries delete", method: "DELETE", retries: 3, failures: 1, err: refused, wantErr: true, wantCalls: 1},
+		{name: "not a dial error", method: "GET", retries: 3, failures: 1, err: reset, wantErr: true, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &flakyTransport{failures: tt.failures, err: tt.err}
+			req := httptest.NewRequest(tt.method, "http://docker/v1.43/containers/json", nil)
+
+			resp, err := withRetries(next, tt.retries).RoundTrip(req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RoundTrip() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if err := resp.Body.Close(); err != nil {
+					t.Errorf("Failed to close response body: %v", err)
+				}
+			}
+			if next.calls != tt.wantCalls {
+				t.Errorf("round trips = %d, want %d", next.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_UpstreamRetries(t *testing.T) {
+	defer func(backoff time.Duration) { retryBackoff = backoff }(retryBackoff)
+	retryBackoff = 50 * time.Millisecond
+
+	var logs bytes.Buffer
+	var logsMu sync.Mutex
+	logging.SetOutput(lockedWriter{w: &logs, mu: &logsMu})
+	defer logging.SetOutput(os.Stdout)
+
+	tmpDir, err := os.MkdirTemp("/tmp", "docker-upstream-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	// The daemon is down until the proxy has retried once
+	upstream := filepath.Join(tmpDir, "docker.sock")
+	socketPath := "/tmp/upstream-retries.sock"
+	configs := map[string]*config.SocketConfig{socketPath: {
+		Config: config.ConfigSet{UpstreamRetries: 5},
+		Rules:  []config.Rule{{Match: config.Match{Path: "/.*"}, Actions: []config.Action{{Action: "allow"}}}},
+	}}
+	handler := NewProxyHandler(upstream, configs, &sync.RWMutex{})
+
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		w := httptest.NewRecorder()
+		handler.ServeHTTPWithSocket(w, httptest.NewRequest("GET", "/v1.43/containers/json", nil), socketPath)
+		done <- w
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		logsMu.Lock()
+		retried := strings.Contains(logs.String(), "Retrying upstream request")
+		logsMu.Unlock()
+		if retried {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the proxy never retried the request")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	listener, err := net.Listen("unix", upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	select {
+	case w := <-done:
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d once the daemon is back", w.Code, http.StatusOK)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request did not finish")
+	}
+
+	// A POST fails straight away, it is never retried
+	server.Close()
+	w := httptest.NewRecorder()
+	handler.ServeHTTPWithSocket(w, httptest.NewRequest("POST", "/v1.43/containers/create", nil), socketPath)
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("POST status = %d, want %d", w.Code, http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"docker-socket-proxy/internal/logging"
+)
+
+// WithWatchdog enables a watchdog that recreates proxy sockets whose files
+// disappear, checking every interval. A zero interval disables it.
+func WithWatchdog(interval time.Duration) Option {
+	return func(s *Server) {
+		s.watchdogInterval = interval
+	}
+}
+
+// runWatchdog periodically checks proxy socket files until the server stops
+func (s *Server) runWatchdog(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.checkSockets()
+		}
+	}
+}
+
+// checkSockets recreates the listener for any proxy socket whose file is
+// missing while its configuration still exists
+func (s *Server) checkSockets() {
+	s.configMu.RLock()
+	paths := make([]string, 0, len(s.proxyServers))
+	for path := range s.proxyServers {
+		paths = append(paths, path)
+	}
+	s.configMu.RUnlock()
+
+	for _, socketPath := range paths {
+		s.recreateSocket(socketPath)
+	}
+}
+
+// recreateSocket recreates the listener for a proxy socket if its file is
+// missing. Sockets served on a TCP address have no file and are left alone.
+// It holds the management handler's create lock, so that a socket being
+// deleted is not brought back.
+func (s *Server) recreateSocket(socketPath string) {
+	log := logging.GetLogger()
+
+	s.handler.createMu.Lock()
+	defer s.handler.createMu.Unlock()
+
+	s.configMu.RLock()
+	cfg, ok := s.socketConfigs[socketPath]
+	s.configMu.RUnlock()
+	if !ok || cfg.Config.Listen != "" {
+		return
+	}
+
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		return
+	}
+
+	listener, err := listenProxySocket(socketPath, cfg)
+	if err != nil {
+		log.Error("Failed to recreate missing proxy socket", "path", socketPath, "error", err)
+		return
+	}
+
+	server := newProxyServer(s.handler.proxyHandler, socketPath)
+
+	s.configMu.Lock()
+	old := s.proxyServers[socketPath]
+	s.proxyServers[socketPath] = server
+	s.configMu.Unlock()
+
+	go func(p string) {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Error("Proxy server error", "error", err, "path", p)
+		}
+	}(socketPath)
+
+	// Let connections on the orphaned listener finish before closing it
+	if old != nil {
+		go func(p string, old *http.Server) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := old.Shutdown(ctx); err != nil {
+				log.Warn("Failed to shut down replaced proxy server", "path", p, "error", err)
+			}
+		}(socketPath, old)
+	}
+
+	log.Warn("Recreated missing proxy socket", "path", socketPath)
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -11,15 +12,27 @@ import (
 	"docker-socket-proxy/internal/proxy/config"
 )
 
+// Configs may hold sensitive patterns, so they are only readable by the daemon
+const (
+	dirPerm  os.FileMode = 0700
+	filePerm os.FileMode = 0600
+)
+
+// ErrLoosePermissions is returned when the storage directory can be accessed
+// by users other than its owner
+var ErrLoosePermissions = errors.New("storage directory is accessible by group or others")
+
+// FileStore keeps one JSON file per socket in its config directory, named
+// after the socket, e.g. ci.sock.json. It only knows socket names, so the
+// directory sockets are served from can be elsewhere.
 type FileStore struct {
 	baseDir string
 }
 
-func NewFileStore(managementSocket string) *FileStore {
-	// Store configs in the same directory as the management socket
-	baseDir := filepath.Dir(managementSocket)
+// NewFileStore returns a store that keeps configs in configDir
+func NewFileStore(configDir string) *FileStore {
 	return &FileStore{
-		baseDir: baseDir,
+		baseDir: filepath.Clean(configDir),
 	}
 }
 
@@ -32,7 +45,7 @@ func (s *FileStore) SaveConfig(socketPath string, cfg *config.SocketConfig) erro
 
 	// Create the directory if it doesn't exist
 	dir := filepath.Dir(filename)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
@@ -46,13 +59,62 @@ func (s *FileStore) SaveConfig(socketPath string, cfg *config.SocketConfig) erro
 	log.Debug("Marshaled config to JSON", "json", string(data))
 
 	// Write the file
-	if err := os.WriteFile(filename, data, 0644); err != nil {
+	if err := writeFile(filename, data); err != nil {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
 
 	return nil
 }
 
+// writeFile writes a config file readable only by its owner. The mode is set
+// before writing so that files created by older versions are tightened too.
+func writeFile(filename string, data []byte) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, filePerm)
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(filePerm); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Dir returns the directory configs are stored in
+func (s *FileStore) Dir() string {
+	return s.baseDir
+}
+
+// CheckPermissions verifies that only the owner can access the storage
+// directory. When the mode is broader it returns ErrLoosePermissions, or
+// with enforce set, restricts the directory to 0700 instead.
+func (s *FileStore) CheckPermissions(enforce bool) error {
+	info, err := os.Stat(s.baseDir)
+	if err != nil {
+		return fmt.Errorf("failed to stat storage directory: %w", err)
+	}
+
+	perm := info.Mode().Perm()
+	if perm&0077 == 0 {
+		return nil
+	}
+
+	if !enforce {
+		return fmt.Errorf("%w: %s has mode %04o", ErrLoosePermissions, s.baseDir, perm)
+	}
+
+	if err := os.Chmod(s.baseDir, dirPerm); err != nil {
+		return fmt.Errorf("failed to restrict storage directory permissions: %w", err)
+	}
+	logging.GetLogger().Warn("Restricted storage directory permissions",
+		"path", s.baseDir, "old_mode", fmt.Sprintf("%04o", perm), "new_mode", fmt.Sprintf("%04o", dirPerm))
+	return nil
+}
+
 // LoadConfig loads a socket configuration
 func (s *FileStore) LoadConfig(socketPath string) (*config.SocketConfig, error) {
 	log := logging.GetLogger()
@@ -93,16 +155,25 @@ func (s *FileStore) LoadConfig(socketPath string) (*config.SocketConfig, error)
 
 // LoadExistingConfigs loads all existing socket configurations
 func (s *FileStore) LoadExistingConfigs() (map[string]*config.SocketConfig, error) {
+	configs, _, err := s.LoadAllConfigs()
+	return configs, err
+}
+
+// LoadAllConfigs loads all existing socket configurations, keyed by socket
+// name, also returning the error for each socket whose config could not be
+// loaded
+func (s *FileStore) LoadAllConfigs() (map[string]*config.SocketConfig, map[string]error, error) {
 	log := logging.GetLogger()
 
 	// Get all files in the base directory
 	files, err := os.ReadDir(s.baseDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read directory: %w", err)
+		return nil, nil, fmt.Errorf("failed to read directory: %w", err)
 	}
 
-	// Create a map to store the configs
+	// Create maps to store the configs and any failures
 	configs := make(map[string]*config.SocketConfig)
+	failures := make(map[string]error)
 
 	// Load each config file
 	for _, file := range files {
@@ -111,54 +182,51 @@ func (s *FileStore) LoadExistingConfigs() (map[string]*config.SocketConfig, erro
 			continue
 		}
 
-		// Skip files that don't have the .json extension
-		if !strings.HasSuffix(file.Name(), ".json") {
+		// Skip files that aren't configs
+		socketName, ok := SocketNameForFile(file.Name())
+		if !ok {
 			continue
 		}
 
-		// Get the socket path from the filename
-		socketPath := strings.TrimSuffix(file.Name(), ".json")
-		socketPath = strings.ReplaceAll(socketPath, "_", "/")
-
-		// If the socket path doesn't end with .sock, add it
-		if !strings.HasSuffix(socketPath, ".sock") {
-			socketPath = socketPath + ".sock"
-		}
-
-		// If the socket path doesn't start with /, add it
-		if !strings.HasPrefix(socketPath, "/") {
-			socketPath = "/" + socketPath
-		}
-
 		// Load the config
-		config, err := s.LoadConfig(socketPath)
+		config, err := s.LoadConfig(socketName)
 		if err != nil {
-			log.Error("Failed to load config", "path", socketPath, "error", err)
+			log.Error("Failed to load config", "socket", socketName, "error", err)
+			failures[socketName] = err
 			continue
 		}
 
 		// Add the config to the map
-		configs[socketPath] = config
+		configs[socketName] = config
 	}
 
-	return configs, nil
+	return configs, failures, nil
 }
 
-// getFilename returns the filename for a socket path
-func (s *FileStore) getFilename(socketPath string) string {
-	// Extract just the socket name from the path
-	socketName := filepath.Base(socketPath)
+// SocketNameForFile returns the name of the socket that a config file in the
+// storage directory belongs to, or false when the file is not a config
+func SocketNameForFile(name string) (string, bool) {
+	// Skip files that don't have the .json extension
+	if !strings.HasSuffix(name, ".json") || name == ".json" {
+		return "", false
+	}
 
-	// Replace slashes with underscores (in case the name itself contains slashes)
-	filename := strings.ReplaceAll(socketName, "/", "_")
+	// If the socket name doesn't end with .sock, add it
+	socketName := strings.TrimSuffix(name, ".json")
+	if !strings.HasSuffix(socketName, ".sock") {
+		socketName = socketName + ".sock"
+	}
 
-	// Add the .json extension
-	filename = filename + ".json"
+	return socketName, true
+}
 
-	// Join with the base directory
-	return filepath.Join(s.baseDir, filename)
+// getFilename returns the filename for a socket, which only depends on the
+// socket's name and not on the directory it is served from
+func (s *FileStore) getFilename(socketPath string) string {
+	return filepath.Join(s.baseDir, filepath.Base(socketPath)+".json")
 }
 
+// DeleteConfig removes a socket configuration, a socket without one is not an error
 func (s *FileStore) DeleteConfig(socketPath string) error {
 	filename := s.getFilename(socketPath)
 	err := os.Remove(filename)
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
@@ -140,3 +141,156 @@ func TestFileStore(t *testing.T) {
 		}
 	})
 }
+
+func TestFileStorePermissions(t *testing.T) {
+	tempDir, err := os.MkdirTemp("/tmp", "filestore-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	store := NewFileStore(tempDir + "/")
+	cfg := &config.SocketConfig{
+		Rules: []config.Rule{
+			{
+				Match:   config.Match{Path: "/.*"},
+				Actions: []config.Action{{Action: "allow"}},
+			},
+		},
+	}
+
+	t.Run("config files are 0600", func(t *testing.T) {
+		socketPath := filepath.Join(tempDir, "new.sock")
+		if err := store.SaveConfig(socketPath, cfg); err != nil {
+			t.Fatalf("SaveConfig() error = %v", err)
+		}
+
+		info, err := os.Stat(store.getFilename(socketPath))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("config file mode = %04o, want 0600", perm)
+		}
+	})
+
+	t.Run("existing config files are tightened", func(t *testing.T) {
+		socketPath := filepath.Join(tempDir, "old.sock")
+		if err := os.WriteFile(store.getFilename(socketPath), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SaveConfig(socketPath, cfg); err != nil {
+			t.Fatalf("SaveConfig() error = %v", err)
+		}
+
+		info, err := os.Stat(store.getFilename(socketPath))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("config file mode = %04o, want 0600", perm)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		mode     os.FileMode
+		enforce  bool
+		wantErr  error
+		wantMode os.FileMode
+	}{
+		{name: "owner only", mode: 0700, wantMode: 0700},
+		{name: "loose mode warns", mode: 0755, wantErr: ErrLoosePermissions, wantMode: 0755},
+		{name: "group readable warns", mode: 0750, wantErr: ErrLoosePermissions, wantMode: 0750},
+		{name: "loose mode enforced", mode: 0755, enforce: true, wantMode: 0700},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.Chmod(tempDir, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+
+			err := store.CheckPermissions(tt.enforce)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckPermissions() error = %v, want %v", err, tt.wantErr)
+			}
+
+			info, err := os.Stat(tempDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if perm := info.Mode().Perm(); perm != tt.wantMode {
+				t.Errorf("directory mode = %04o, want %04o", perm, tt.wantMode)
+			}
+		})
+	}
+}
+
+func TestFileStoreConfigDir(t *testing.T) {
+	tempDir, err := os.MkdirTemp("/tmp", "filestore-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			t.Errorf("Failed to remove temporary directory: %v", err)
+		}
+	}()
+
+	// Configs are named after the socket wherever it is served from
+	configDir := filepath.Join(tempDir, "configs")
+	store := NewFileStore(configDir)
+	cfg := &config.SocketConfig{Rules: []config.Rule{{
+		Match:   config.Match{Path: "/.*"},
+		Actions: []config.Action{{Action: "allow"}},
+	}}}
+	if err := store.SaveConfig("/var/run/docker-proxy/ci.sock", cfg); err != nil {
+		t.Fatalf("SaveConfig() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(configDir, "ci.sock.json")); err != nil {
+		t.Fatalf("SaveConfig() did not write ci.sock.json: %v", err)
+	}
+	if store.Dir() != configDir {
+		t.Errorf("Dir() = %q, want %q", store.Dir(), configDir)
+	}
+
+	// Files that are not configs are left alone
+	if err := os.WriteFile(filepath.Join(configDir, "notes.txt"), []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	configs, err := store.LoadExistingConfigs()
+	if err != nil {
+		t.Fatalf("LoadExistingConfigs() error = %v", err)
+	}
+	if len(configs) != 1 || configs["ci.sock"] == nil {
+		t.Errorf("LoadExistingConfigs() = %v, want only ci.sock", configs)
+	}
+}
+
+func TestSocketNameForFile(t *testing.T) {
+	tests := []struct {
+		file   string
+		want   string
+		wantOK bool
+	}{
+		{file: "ci.sock.json", want: "ci.sock", wantOK: true},
+		{file: "docker-proxy-1234.sock.json", want: "docker-proxy-1234.sock", wantOK: true},
+		{file: "legacy.json", want: "legacy.sock", wantOK: true},
+		{file: "ci.sock", wantOK: false},
+		{file: ".json", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got, ok := SocketNameForFile(tt.file)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("SocketNameForFile(%q) = %q, %v, want %q, %v", tt.file, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}